@@ -0,0 +1,1436 @@
+package go8086
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AsmPasses is how many passes Assemble makes at most for the addresses
+// of the labels to settle.
+var AsmPasses = 32
+
+type asmClass int
+
+const (
+	asmR8 asmClass = iota
+	asmR16
+	asmAL
+	asmAX
+	asmCL
+	asmDX
+	asmSreg
+	asmRM8
+	asmRM16
+	asmMem
+	asmMemFar
+	asmMoffs8
+	asmMoffs16
+	asmImm8
+	asmImm16
+	asmSImm8
+	asmOne
+	asmRel8
+	asmRel16
+	asmFar
+)
+
+var asmClassNames = map[string]asmClass{
+	"r8":      asmR8,
+	"r16":     asmR16,
+	"al":      asmAL,
+	"ax":      asmAX,
+	"cl":      asmCL,
+	"dx":      asmDX,
+	"sreg":    asmSreg,
+	"rm8":     asmRM8,
+	"rm16":    asmRM16,
+	"m":       asmMem,
+	"mfar":    asmMemFar,
+	"moffs8":  asmMoffs8,
+	"moffs16": asmMoffs16,
+	"imm8":    asmImm8,
+	"imm16":   asmImm16,
+	"simm8":   asmSImm8,
+	"1":       asmOne,
+	"rel8":    asmRel8,
+	"rel16":   asmRel16,
+	"far":     asmFar,
+}
+
+// asmForm is one encoding of an instruction, written like the opcode
+// tables of the Intel manual: hex bytes, /r or /digit for the ModRM byte,
+// +r or +s to add the register number to the last byte, ib and iw for an
+// immediate, rb and rw for a relative target, addr for a direct address
+// and far for seg:off.
+type asmForm struct {
+	mn   Mnemonic
+	oprs []asmClass
+	code []string
+}
+
+var asmForms = map[Mnemonic][]*asmForm{}
+
+func addAsmForm(mn Mnemonic, oprs, code string) {
+	f := &asmForm{mn: mn, code: strings.Fields(code)}
+	if oprs != "" {
+		for _, o := range strings.Split(oprs, ",") {
+			f.oprs = append(f.oprs, asmClassNames[o])
+		}
+	}
+	asmForms[mn] = append(asmForms[mn], f)
+}
+
+// The forms of a mnemonic are in the order they are preferred, the
+// shorter ones first but ModRM before moffs, as in test/data.s.
+func init() {
+	for i, mn := range []Mnemonic{ADD, OR, ADC, SBB, AND, SUB, XOR, CMP} {
+		b := i * 8
+		addAsmForm(mn, "rm8,r8", fmt.Sprintf("%02x /r", b))
+		addAsmForm(mn, "rm16,r16", fmt.Sprintf("%02x /r", b+1))
+		addAsmForm(mn, "r8,rm8", fmt.Sprintf("%02x /r", b+2))
+		addAsmForm(mn, "r16,rm16", fmt.Sprintf("%02x /r", b+3))
+		addAsmForm(mn, "rm16,simm8", fmt.Sprintf("83 /%d ib", i))
+		addAsmForm(mn, "al,imm8", fmt.Sprintf("%02x ib", b+4))
+		addAsmForm(mn, "ax,imm16", fmt.Sprintf("%02x iw", b+5))
+		addAsmForm(mn, "rm8,imm8", fmt.Sprintf("80 /%d ib", i))
+		addAsmForm(mn, "rm16,imm16", fmt.Sprintf("81 /%d iw", i))
+	}
+
+	addAsmForm(MOV, "rm8,r8", "88 /r")
+	addAsmForm(MOV, "rm16,r16", "89 /r")
+	addAsmForm(MOV, "r8,rm8", "8a /r")
+	addAsmForm(MOV, "r16,rm16", "8b /r")
+	addAsmForm(MOV, "rm16,sreg", "8c /r")
+	addAsmForm(MOV, "sreg,rm16", "8e /r")
+	addAsmForm(MOV, "r8,imm8", "b0 +r ib")
+	addAsmForm(MOV, "r16,imm16", "b8 +r iw")
+	addAsmForm(MOV, "rm8,imm8", "c6 /0 ib")
+	addAsmForm(MOV, "rm16,imm16", "c7 /0 iw")
+	addAsmForm(MOV, "al,moffs8", "a0 addr")
+	addAsmForm(MOV, "ax,moffs16", "a1 addr")
+	addAsmForm(MOV, "moffs8,al", "a2 addr")
+	addAsmForm(MOV, "moffs16,ax", "a3 addr")
+
+	addAsmForm(PUSH, "r16", "50 +r")
+	addAsmForm(PUSH, "sreg", "06 +s")
+	addAsmForm(PUSH, "rm16", "ff /6")
+	addAsmForm(POP, "r16", "58 +r")
+	addAsmForm(POP, "sreg", "07 +s")
+	addAsmForm(POP, "rm16", "8f /0")
+
+	// 90+r disassembles as xchg ax,r16 only, so xchg r16,ax is 87 /r.
+	addAsmForm(XCHG, "ax,r16", "90 +r")
+	addAsmForm(XCHG, "r8,rm8", "86 /r")
+	addAsmForm(XCHG, "rm8,r8", "86 /r")
+	addAsmForm(XCHG, "r16,rm16", "87 /r")
+	addAsmForm(XCHG, "rm16,r16", "87 /r")
+
+	addAsmForm(IN, "al,imm8", "e4 ib")
+	addAsmForm(IN, "ax,imm8", "e5 ib")
+	addAsmForm(IN, "al,dx", "ec")
+	addAsmForm(IN, "ax,dx", "ed")
+	addAsmForm(OUT, "imm8,al", "e6 ib")
+	addAsmForm(OUT, "imm8,ax", "e7 ib")
+	addAsmForm(OUT, "dx,al", "ee")
+	addAsmForm(OUT, "dx,ax", "ef")
+
+	addAsmForm(LEA, "r16,m", "8d /r")
+	addAsmForm(LDS, "r16,m", "c5 /r")
+	addAsmForm(LES, "r16,m", "c4 /r")
+
+	addAsmForm(TEST, "rm8,r8", "84 /r")
+	addAsmForm(TEST, "rm16,r16", "85 /r")
+	addAsmForm(TEST, "al,imm8", "a8 ib")
+	addAsmForm(TEST, "ax,imm16", "a9 iw")
+	addAsmForm(TEST, "rm8,imm8", "f6 /0 ib")
+	addAsmForm(TEST, "rm16,imm16", "f7 /0 iw")
+	for i, mn := range []Mnemonic{NOT, NEG, MUL, IMUL, DIV, IDIV} {
+		addAsmForm(mn, "rm8", fmt.Sprintf("f6 /%d", i+2))
+		addAsmForm(mn, "rm16", fmt.Sprintf("f7 /%d", i+2))
+	}
+
+	addAsmForm(INC, "r16", "40 +r")
+	addAsmForm(INC, "rm8", "fe /0")
+	addAsmForm(INC, "rm16", "ff /0")
+	addAsmForm(DEC, "r16", "48 +r")
+	addAsmForm(DEC, "rm8", "fe /1")
+	addAsmForm(DEC, "rm16", "ff /1")
+
+	for i, mn := range []Mnemonic{ROL, ROR, RCL, RCR, SHL, SHR, NIL, SAR} {
+		if mn == NIL {
+			continue
+		}
+		addAsmForm(mn, "rm8,1", fmt.Sprintf("d0 /%d", i))
+		addAsmForm(mn, "rm16,1", fmt.Sprintf("d1 /%d", i))
+		addAsmForm(mn, "rm8,cl", fmt.Sprintf("d2 /%d", i))
+		addAsmForm(mn, "rm16,cl", fmt.Sprintf("d3 /%d", i))
+	}
+
+	addAsmForm(CALL, "rel16", "e8 rw")
+	addAsmForm(CALL, "rm16", "ff /2")
+	addAsmForm(CALL, "mfar", "ff /3")
+	addAsmForm(CALL, "far", "9a far")
+	addAsmForm(JMP, "rel8", "eb rb")
+	addAsmForm(JMP, "rel16", "e9 rw")
+	addAsmForm(JMP, "rm16", "ff /4")
+	addAsmForm(JMP, "mfar", "ff /5")
+	addAsmForm(JMP, "far", "ea far")
+	for i, mn := range []Mnemonic{JO, JNO, JC, JNC, JZ, JNZ, JNA, JA, JS, JNS, JPE, JPO, JL, JNL, JNG, JG} {
+		addAsmForm(mn, "rel8", fmt.Sprintf("%02x rb", 0x70+i))
+	}
+	for i, mn := range []Mnemonic{LOOPNE, LOOPE, LOOP, JCXZ} {
+		addAsmForm(mn, "rel8", fmt.Sprintf("%02x rb", 0xe0+i))
+	}
+	addAsmForm(RET, "", "c3")
+	addAsmForm(RET, "imm16", "c2 iw")
+	addAsmForm(RETF, "", "cb")
+	addAsmForm(RETF, "imm16", "ca iw")
+	addAsmForm(INT, "imm8", "cd ib")
+	addAsmForm(AAM, "", "d4 0a")
+	addAsmForm(AAM, "imm8", "d4 ib")
+	addAsmForm(AAD, "", "d5 0a")
+	addAsmForm(AAD, "imm8", "d5 ib")
+
+	for mn, b := range map[Mnemonic]byte{
+		XLAT: 0xd7, LAHF: 0x9f, SAHF: 0x9e, PUSHF: 0x9c, POPF: 0x9d,
+		AAA: 0x37, DAA: 0x27, AAS: 0x3f, DAS: 0x2f, CBW: 0x98, CWD: 0x99,
+		MOVSB: 0xa4, MOVSW: 0xa5, CMPSB: 0xa6, CMPSW: 0xa7, SCASB: 0xae, SCASW: 0xaf,
+		LODSB: 0xac, LODSW: 0xad, STOSB: 0xaa, STOSW: 0xab,
+		INT3: 0xcc, INTO: 0xce, IRET: 0xcf, CLC: 0xf8, CMC: 0xf5, STC: 0xf9,
+		CLD: 0xfc, STD: 0xfd, CLI: 0xfa, STI: 0xfb, HLT: 0xf4, NOP: 0x90,
+	} {
+		addAsmForm(mn, "", fmt.Sprintf("%02x", b))
+	}
+
+	for mn, s := range mnemonicString {
+		if _, ok := asmPrefixes[s]; !ok {
+			asmMnemonics[s] = mn
+		}
+	}
+}
+
+// asmMnemonics maps names to mnemonics: the ones Opcode.Disasm prints
+// and some common aliases.
+var asmMnemonics = map[string]Mnemonic{
+	"pushf":  PUSHF,
+	"popf":   POPF,
+	"iret":   IRET,
+	"xlat":   XLAT,
+	"sal":    SHL,
+	"je":     JZ,
+	"jne":    JNZ,
+	"jb":     JC,
+	"jnae":   JC,
+	"jae":    JNC,
+	"jnb":    JNC,
+	"jbe":    JNA,
+	"jnbe":   JA,
+	"jp":     JPE,
+	"jnp":    JPO,
+	"jnge":   JL,
+	"jge":    JNL,
+	"jle":    JNG,
+	"jnle":   JG,
+	"loopz":  LOOPE,
+	"loopnz": LOOPNE,
+}
+
+var asmPrefixes = map[string]byte{
+	"rep":   0xf3,
+	"repe":  0xf3,
+	"repz":  0xf3,
+	"repne": 0xf2,
+	"repnz": 0xf2,
+	"lock":  0xf0,
+	"wait":  0x9b,
+	"es":    0x26,
+	"cs":    0x2e,
+	"ss":    0x36,
+	"ds":    0x3e,
+}
+
+type asmKind int
+
+const (
+	asmRegister asmKind = iota
+	asmSegment
+	asmMemory
+	asmImmediate
+	asmFarPointer
+)
+
+type asmOperand struct {
+	kind     asmKind
+	w        Bit
+	sized    bool
+	short    bool
+	far      bool
+	signed   bool
+	reg      *Register
+	sreg     *SegmentRegister
+	rm       byte
+	direct   bool
+	disp     bool
+	value    int64
+	base     asmBase
+	seg      int64
+	known    bool
+	override *SegmentRegister
+}
+
+func asmRegNumber(r *Register) byte {
+	for i, reg := range regs[r.w] {
+		if reg == r {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+func asmSregNumber(r *SegmentRegister) byte {
+	for i, sreg := range sregs {
+		if sreg == r {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+// asmBase is what a value is relative to: nothing for a constant, a
+// section of the object being assembled, or an external symbol.
+type asmBase struct {
+	section SymbolSection
+	symbol  string
+}
+
+var asmAbsolute = asmBase{section: SectionAbsolute}
+
+type asmValue struct {
+	v    int64
+	base asmBase
+}
+
+func (o *asmOperand) absolute() bool {
+	return o.base == asmAbsolute
+}
+
+func fits(v, min, max int64) bool {
+	return v >= min && v <= max
+}
+
+func (o *asmOperand) is(c asmClass) bool {
+	switch c {
+	case asmR8, asmR16:
+		return o.kind == asmRegister && (o.reg.w == Bit8) == (c == asmR8)
+	case asmAL:
+		return o.kind == asmRegister && o.reg == AL
+	case asmAX:
+		return o.kind == asmRegister && o.reg == AX
+	case asmCL:
+		return o.kind == asmRegister && o.reg == CL
+	case asmDX:
+		return o.kind == asmRegister && o.reg == DX
+	case asmSreg:
+		return o.kind == asmSegment
+	case asmRM8:
+		return o.is(asmR8) || o.kind == asmMemory && !o.far && (!o.sized || o.w == Bit8)
+	case asmRM16:
+		return o.is(asmR16) || o.kind == asmMemory && !o.far && (!o.sized || o.w == Bit16)
+	case asmMem:
+		return o.kind == asmMemory && !o.far
+	case asmMemFar:
+		return o.kind == asmMemory && o.far
+	case asmMoffs8:
+		return o.kind == asmMemory && o.direct && !o.far && (!o.sized || o.w == Bit8)
+	case asmMoffs16:
+		return o.kind == asmMemory && o.direct && !o.far && (!o.sized || o.w == Bit16)
+	}
+	if o.kind != asmImmediate || o.far {
+		return c == asmFar && o.kind == asmFarPointer
+	}
+	byteSized, wordSized := o.sized && o.w == Bit8, o.sized && o.w == Bit16
+	switch c {
+	case asmImm8:
+		return !o.short && !wordSized && o.absolute() && (!o.known || fits(o.value, -0x80, 0xff))
+	case asmImm16:
+		return !o.short && !byteSized && (!o.known || fits(o.value, -0x8000, 0xffff))
+	case asmSImm8:
+		// Opcode.Disasm writes the sign extended byte as "byte +0x4" or
+		// "-0x4", while other immediates are unsigned.
+		return (o.signed || byteSized) && o.absolute() && (!o.known || fits(o.value, -0x80, 0x7f))
+	case asmOne:
+		return !o.sized && o.known && o.absolute() && o.value == 1
+	case asmRel8:
+		return !wordSized
+	case asmRel16:
+		return !o.short
+	}
+	return false
+}
+
+// match tells whether oprs fit the form. A memory operand without byte or
+// word takes the size of a register operand.
+func (f *asmForm) match(oprs []*asmOperand) bool {
+	if len(oprs) != len(f.oprs) {
+		return false
+	}
+	sized := false
+	for i, c := range f.oprs {
+		if !oprs[i].is(c) {
+			return false
+		}
+		switch c {
+		case asmR8, asmR16, asmAL, asmAX, asmSreg:
+			sized = true
+		}
+	}
+	for i, o := range oprs {
+		switch f.oprs[i] {
+		case asmRM8, asmRM16, asmMoffs8, asmMoffs16:
+			if o.kind == asmMemory && !o.sized && !sized {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// asmModRM returns the ModRM bytes, with their displacement, that address
+// o: a displacement fitting in a byte can be encoded in one or two.
+func asmModRM(o *asmOperand, reg byte) (variants []Bytes) {
+	reg <<= 3
+	switch {
+	case o.kind == asmRegister:
+		return []Bytes{{0xc0 | reg | asmRegNumber(o.reg)}}
+	case o.direct:
+		return []Bytes{{0x06 | reg, byte(o.value), byte(o.value >> 8)}}
+	case !o.disp && o.rm != 6:
+		return []Bytes{{reg | o.rm}}
+	}
+	if o.known && o.absolute() && fits(o.value, -0x80, 0x7f) {
+		variants = append(variants, Bytes{0x40 | reg | o.rm, byte(o.value)})
+	}
+	return append(variants, Bytes{0x80 | reg | o.rm, byte(o.value), byte(o.value >> 8)})
+}
+
+// asmCode is an encoding with the words in it that need a linked address.
+type asmCode struct {
+	bytes  Bytes
+	fixups []asmFixup
+}
+
+type asmFixup struct {
+	at       int
+	base     asmBase
+	relative bool
+}
+
+// encode returns the encodings of the form for oprs at here. A jump to a
+// label not known yet is taken to be short; it grows in a later pass if the
+// label is too far. A near jump out of the section of here is left to the
+// linker, a short one cannot be.
+func (f *asmForm) encode(oprs []*asmOperand, here asmValue, prefix Bytes) (cands []*asmCode) {
+	var rm, reg, imm *asmOperand
+	for i, c := range f.oprs {
+		switch c {
+		case asmRM8, asmRM16, asmMem, asmMemFar:
+			rm = oprs[i]
+		case asmR8, asmR16, asmSreg:
+			reg = oprs[i]
+		case asmMoffs8, asmMoffs16, asmImm8, asmImm16, asmSImm8, asmRel8, asmRel16, asmFar:
+			imm = oprs[i]
+		}
+	}
+	variants := []Bytes{nil}
+	for _, t := range f.code {
+		if t[0] != '/' {
+			continue
+		}
+		n := byte(0)
+		if t == "/r" && reg.kind == asmSegment {
+			n = asmSregNumber(reg.sreg)
+		} else if t == "/r" {
+			n = asmRegNumber(reg.reg)
+		} else {
+			n = t[1] - '0'
+		}
+		variants = asmModRM(rm, n)
+	}
+	for _, v := range variants {
+		c := &asmCode{bytes: append(Bytes{}, prefix...)}
+		word := func(o *asmOperand, v int64) {
+			if !o.absolute() {
+				c.fixups = append(c.fixups, asmFixup{at: len(c.bytes), base: o.base})
+			}
+			c.bytes = append(c.bytes, byte(v), byte(v>>8))
+		}
+		ok := true
+		for _, t := range f.code {
+			switch t {
+			case "/r", "/0", "/1", "/2", "/3", "/4", "/5", "/6", "/7":
+				if len(v) == 3 {
+					c.bytes = append(c.bytes, v[0])
+					word(rm, rm.value)
+				} else {
+					c.bytes = append(c.bytes, v...)
+				}
+			case "+r":
+				c.bytes[len(c.bytes)-1] += asmRegNumber(reg.reg)
+			case "+s":
+				c.bytes[len(c.bytes)-1] += asmSregNumber(reg.sreg) << 3
+			case "ib":
+				c.bytes = append(c.bytes, byte(imm.value))
+			case "iw", "addr":
+				word(imm, imm.value)
+			case "rb":
+				d := int16(uint16(imm.value - here.v - int64(len(c.bytes)) - 1))
+				if !imm.known {
+					d = 0
+				} else if imm.base != here.base {
+					ok = false
+				}
+				ok = ok && fits(int64(d), -0x80, 0x7f)
+				c.bytes = append(c.bytes, byte(d))
+			case "rw":
+				d := imm.value - here.v - int64(len(c.bytes)) - 2
+				if imm.known && imm.base != here.base {
+					c.fixups = append(c.fixups, asmFixup{at: len(c.bytes), base: imm.base, relative: true})
+				}
+				c.bytes = append(c.bytes, byte(d), byte(d>>8))
+			case "far":
+				word(imm, imm.value)
+				c.bytes = append(c.bytes, byte(imm.seg), byte(imm.seg>>8))
+			default:
+				b, _ := strconv.ParseUint(t, 16, 8)
+				c.bytes = append(c.bytes, byte(b))
+			}
+		}
+		if ok {
+			cands = append(cands, c)
+		}
+	}
+	return
+}
+
+type asmLine struct {
+	n       int
+	label   string
+	equ     string
+	times   string
+	stmt    string
+	hint    Bytes
+	address int64
+	listed  bool
+}
+
+type asmSection struct {
+	code Bytes
+	size int64 // len(code), or what is reserved in .bss
+	base int64 // the address of the section in a flat binary
+}
+
+type assembler struct {
+	lines    []*asmLine
+	values   map[string]asmValue
+	defined  map[string]bool
+	globals  map[string]bool
+	externs  map[string]bool
+	object   bool
+	symbols  *SymbolTable
+	origin   int64
+	sections map[SymbolSection]*asmSection
+	section  SymbolSection
+	relocs   []Relocation
+	final    bool
+	changed  bool
+}
+
+var (
+	asmListingLine = regexp.MustCompile(`^([0-9A-Fa-f]{8})  ([0-9A-Fa-f]+) +(.*)$`)
+	asmListingMore = regexp.MustCompile(`^ +-([0-9A-Fa-f]+)$`)
+	asmLabel       = regexp.MustCompile(`^([A-Za-z_.?@$][\w.?@$]*):\s*(.*)$`)
+	asmEqu         = regexp.MustCompile(`^([A-Za-z_.?@$][\w.?@$]*)\s+(?i:equ)\s+(.*)$`)
+)
+
+var asmSectionNames = map[string]SymbolSection{
+	".text": SectionText,
+	".data": SectionData,
+	".bss":  SectionBss,
+}
+
+// Assemble assembles 8086 source written in the syntax Opcode.Disasm
+// prints: byte, word, short and far keywords, [es:bx+si-0x12] memory and
+// seg:off far addresses. Lines can have a label, org, db, dw, resb, resw,
+// equ, times and section, and operands are expressions of numbers, labels,
+// $ and $$. The code is the .text section followed by .data, at org.
+//
+// src can also be a listing as WriteListing and ndisasm write it. The
+// instruction bytes of each line then pick among the encodings of the
+// same instruction, which the syntax does not tell apart (push cx is 51
+// or ff f1), so the listing assembles to exactly the bytes it shows.
+func Assemble(src io.Reader) (code Bytes, symbols *SymbolTable, err error) {
+	a := &assembler{}
+	if err = a.assemble(src); err != nil {
+		return
+	}
+	code = append(a.sections[SectionText].code, a.sections[SectionData].code...)
+	return code, a.symbols, nil
+}
+
+// AssembleObject assembles src like Assemble, but into an object for Link:
+// labels are relative to their section, global makes them visible to the
+// other objects and extern names the symbols they define.
+func AssembleObject(src io.Reader) (obj *Object, err error) {
+	a := &assembler{object: true}
+	if err = a.assemble(src); err != nil {
+		return
+	}
+	for _, section := range []SymbolSection{SectionText, SectionData, SectionBss} {
+		if a.sections[section].size > 0x10000 {
+			return nil, fmt.Errorf("%s section is larger than 64K", section)
+		}
+	}
+	return &Object{
+		Text:        a.sections[SectionText].code,
+		Data:        a.sections[SectionData].code,
+		Bss:         uint16(a.sections[SectionBss].size),
+		Symbols:     a.symbols,
+		Relocations: a.relocs,
+	}, nil
+}
+
+func (a *assembler) assemble(src io.Reader) (err error) {
+	a.values = make(map[string]asmValue)
+	if err = a.parse(src); err != nil {
+		return
+	}
+	for pass := 1; ; pass++ {
+		if pass > AsmPasses {
+			return fmt.Errorf("label addresses do not settle after %d passes", AsmPasses)
+		}
+		a.changed = false
+		if err = a.pass(); err != nil {
+			return
+		}
+		if !a.changed {
+			break
+		}
+	}
+	a.final = true
+	return a.pass()
+}
+
+func (a *assembler) parse(src io.Reader) error {
+	scanner := bufio.NewScanner(src)
+	var last *asmLine
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if m := asmListingMore.FindStringSubmatch(text); m != nil && last != nil {
+			last.hint = append(last.hint, asmHex(m[1])...)
+			continue
+		}
+		l := &asmLine{n: n}
+		if m := asmListingLine.FindStringSubmatch(text); m != nil {
+			l.address, _ = strconv.ParseInt(m[1], 16, 64)
+			l.hint, l.listed, text = asmHex(m[2]), true, m[3]
+		}
+		text = strings.TrimSpace(asmStripComment(text))
+		if m := asmEqu.FindStringSubmatch(text); m != nil {
+			l.label, l.equ = m[1], m[2]
+			a.lines = append(a.lines, l)
+			continue
+		}
+		if m := asmLabel.FindStringSubmatch(text); m != nil {
+			l.label, text = m[1], m[2]
+		}
+		if fields := strings.Fields(text); len(fields) > 1 && strings.ToLower(fields[0]) == "times" {
+			for i := 2; i < len(fields); i++ {
+				if asmStatementWord(fields[i]) {
+					l.times = strings.Join(fields[1:i], " ")
+					text = strings.Join(fields[i:], " ")
+					break
+				}
+			}
+			if l.times == "" {
+				return fmt.Errorf("asm line %d: times without an instruction", n)
+			}
+		}
+		l.stmt = text
+		a.lines = append(a.lines, l)
+		last = l
+	}
+	return scanner.Err()
+}
+
+func asmHex(s string) (bs Bytes) {
+	for i := 0; i+1 < len(s); i += 2 {
+		b, _ := strconv.ParseUint(s[i:i+2], 16, 8)
+		bs = append(bs, byte(b))
+	}
+	return
+}
+
+func asmStripComment(s string) string {
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ';':
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func asmStatementWord(s string) bool {
+	s = strings.ToLower(s)
+	if _, ok := asmMnemonics[s]; ok {
+		return true
+	}
+	if _, ok := asmPrefixes[s]; ok {
+		return true
+	}
+	switch s {
+	case "db", "dw", "resb", "resw", "org":
+		return true
+	}
+	return false
+}
+
+func (a *assembler) define(name string, v asmValue) error {
+	if a.defined[name] || a.externs[name] {
+		return fmt.Errorf("symbol %q redefined", name)
+	}
+	a.defined[name] = true
+	if old, ok := a.values[name]; !ok || old != v {
+		a.values[name] = v
+		a.changed = true
+	}
+	return nil
+}
+
+// here is the value of $: an address in a flat binary, an offset in the
+// current section of an object.
+func (a *assembler) here() asmValue {
+	s := a.sections[a.section]
+	if a.object {
+		return asmValue{s.size, asmBase{section: a.section}}
+	}
+	return asmValue{s.base + s.size, asmAbsolute}
+}
+
+// pass assembles all lines. A flat binary has .data right after .text and
+// .bss after that, as large as they came out in the last pass.
+func (a *assembler) pass() (err error) {
+	a.defined = make(map[string]bool)
+	a.globals = make(map[string]bool)
+	a.externs = make(map[string]bool)
+	a.symbols = NewSymbolTable()
+	a.relocs = nil
+	base := a.origin
+	sections := make(map[SymbolSection]*asmSection)
+	for _, section := range []SymbolSection{SectionText, SectionData, SectionBss} {
+		sections[section] = &asmSection{base: base}
+		if old, ok := a.sections[section]; ok {
+			base += old.size
+		}
+	}
+	a.sections, a.section = sections, SectionText
+	for _, l := range a.lines {
+		if err = a.line(l); err != nil {
+			return fmt.Errorf("asm line %d: %v", l.n, err)
+		}
+	}
+	for name := range a.globals {
+		if !a.defined[name] {
+			return fmt.Errorf("global symbol %q is not defined", name)
+		}
+	}
+	if !a.object {
+		return
+	}
+	for _, sym := range a.symbols.Symbols() {
+		sym.External = sym.Section == SectionUndefined || a.globals[sym.Name]
+	}
+	for name := range a.externs {
+		a.symbols.Add(&Symbol{Name: name, Section: SectionUndefined, External: true})
+	}
+	return
+}
+
+func (a *assembler) line(l *asmLine) (err error) {
+	if l.equ != "" {
+		v, _, err := a.evalValue(l.equ)
+		if err != nil {
+			return err
+		}
+		a.symbols.Add(&Symbol{Name: l.label, Value: uint16(v.v), Section: v.base.section, External: true})
+		return a.define(l.label, v)
+	}
+	s := a.sections[a.section]
+	start := len(s.code)
+	if l.listed && !a.object && a.section == SectionText && s.size == 0 && s.base == a.origin {
+		if s.base != l.address {
+			a.changed = true
+		}
+		a.origin, s.base = l.address, l.address
+	}
+	if l.label != "" {
+		here := a.here()
+		a.symbols.Add(&Symbol{Name: l.label, Value: uint16(here.v), Section: a.section, External: true})
+		if err = a.define(l.label, here); err != nil {
+			return
+		}
+	}
+	if a.final && l.listed && uint16(l.address) != uint16(a.here().v) {
+		return fmt.Errorf("listed at %08X but assembled at %04X", l.address, uint16(a.here().v))
+	}
+	n := int64(1)
+	if l.times != "" {
+		if n, _, err = a.eval(l.times); err != nil {
+			return
+		}
+		if n < 0 {
+			return fmt.Errorf("negative times %d", n)
+		}
+	}
+	for i := int64(0); i < n; i++ {
+		c, err := a.statement(l.stmt, l.hint)
+		if err != nil {
+			return err
+		}
+		// A section directive switches sections.
+		s = a.sections[a.section]
+		if c == nil {
+			continue
+		}
+		if a.section == SectionBss && len(c.bytes) > 0 {
+			return fmt.Errorf("only resb and resw can be in .bss")
+		}
+		if a.final {
+			for _, f := range c.fixups {
+				r := Relocation{Section: a.section, Offset: uint16(s.size) + uint16(f.at), Target: f.base.section, Symbol: f.base.symbol, Relative: f.relative}
+				a.relocs = append(a.relocs, r)
+			}
+		}
+		s.code = append(s.code, c.bytes...)
+		s.size += int64(len(c.bytes))
+	}
+	if a.final && l.hint != nil && !bytes.Equal(l.hint, s.code[start:]) {
+		return fmt.Errorf("%s does not assemble to the listed %X", l.stmt, []byte(l.hint))
+	}
+	return
+}
+
+func (a *assembler) statement(stmt string, hint Bytes) (code *asmCode, err error) {
+	word, rest := asmSplitWord(stmt)
+	switch strings.ToLower(word) {
+	case "":
+		return
+	case "org":
+		v, _, err := a.eval(rest)
+		if err != nil {
+			return nil, err
+		}
+		if a.object {
+			return nil, fmt.Errorf("org in an object")
+		}
+		for _, s := range a.sections {
+			if s.size > 0 {
+				return nil, fmt.Errorf("org after code")
+			}
+		}
+		if a.sections[SectionText].base != v {
+			a.changed = true
+		}
+		a.origin, a.sections[SectionText].base = v, v
+		return nil, nil
+	case "section", "segment":
+		section, ok := asmSectionNames[strings.ToLower(rest)]
+		if !ok {
+			return nil, fmt.Errorf("unknown section %q", rest)
+		}
+		a.section = section
+		return nil, nil
+	case "global", "extern":
+		for _, name := range asmSplitOperands(rest) {
+			if strings.ToLower(word) == "global" {
+				a.globals[name] = true
+			} else if a.object {
+				if a.defined[name] {
+					return nil, fmt.Errorf("symbol %q redefined", name)
+				}
+				v := asmValue{0, asmBase{SectionUndefined, name}}
+				if old, ok := a.values[name]; !ok || old != v {
+					a.values[name] = v
+					a.changed = true
+				}
+				a.externs[name] = true
+			}
+		}
+		return nil, nil
+	case "resb", "resw":
+		n, _, err := a.eval(rest)
+		if err != nil {
+			return nil, err
+		}
+		if strings.ToLower(word) == "resw" {
+			n *= 2
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("negative %s %d", word, n)
+		}
+		if a.section == SectionBss {
+			a.sections[SectionBss].size += n
+			return nil, nil
+		}
+		return &asmCode{bytes: make(Bytes, n)}, nil
+	case "db", "dw":
+		return a.data(strings.ToLower(word) == "dw", rest)
+	}
+	prefix := Bytes{}
+	for {
+		p, ok := asmPrefixes[strings.ToLower(word)]
+		if !ok {
+			break
+		}
+		prefix = append(prefix, p)
+		if word, rest = asmSplitWord(rest); word == "" {
+			return &asmCode{bytes: prefix}, nil
+		}
+	}
+	mn, ok := asmMnemonics[strings.ToLower(word)]
+	if !ok {
+		return nil, fmt.Errorf("unknown instruction %q", word)
+	}
+	oprs := []*asmOperand{}
+	for _, s := range asmSplitOperands(rest) {
+		o, err := a.operand(s)
+		if err != nil {
+			return nil, err
+		}
+		if o.override != nil {
+			prefix = append(prefix, asmPrefixes[o.override.name])
+		}
+		oprs = append(oprs, o)
+	}
+	cands := []*asmCode{}
+	for _, f := range asmForms[mn] {
+		if f.match(oprs) {
+			cands = append(cands, f.encode(oprs, a.here(), prefix)...)
+		}
+	}
+	for _, c := range cands {
+		if bytes.Equal(c.bytes, hint) {
+			return c, nil
+		}
+	}
+	if len(cands) > 0 {
+		return cands[0], nil
+	}
+	for _, o := range oprs {
+		if o.kind == asmMemory && !o.sized {
+			return nil, fmt.Errorf("operation size not specified: %s", stmt)
+		}
+	}
+	return nil, fmt.Errorf("invalid operands: %s", stmt)
+}
+
+func (a *assembler) data(words bool, s string) (code *asmCode, err error) {
+	code = &asmCode{}
+	for _, item := range asmSplitOperands(s) {
+		if q := item[0]; (q == '\'' || q == '"' || q == '`') && len(item) >= 2 && item[len(item)-1] == q {
+			str := Bytes(item[1 : len(item)-1])
+			if words && len(str)%2 == 1 {
+				str = append(str, 0)
+			}
+			code.bytes = append(code.bytes, str...)
+			continue
+		}
+		v, _, err := a.evalValue(item)
+		if err != nil {
+			return nil, err
+		}
+		if v.base != asmAbsolute && !words {
+			return nil, fmt.Errorf("address %s does not fit in a byte", item)
+		}
+		if v.base != asmAbsolute {
+			code.fixups = append(code.fixups, asmFixup{at: len(code.bytes), base: v.base})
+		}
+		if words {
+			if !fits(v.v, -0x8000, 0xffff) {
+				return nil, fmt.Errorf("%#x does not fit in a word", v.v)
+			}
+			code.bytes = append(code.bytes, byte(v.v), byte(v.v>>8))
+		} else {
+			if !fits(v.v, -0x80, 0xff) {
+				return nil, fmt.Errorf("%#x does not fit in a byte", v.v)
+			}
+			code.bytes = append(code.bytes, byte(v.v))
+		}
+	}
+	return
+}
+
+func asmSplitWord(s string) (word, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+// asmSplitOperands splits s at the commas outside brackets, parentheses
+// and quotes.
+func asmSplitOperands(s string) (oprs []string) {
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			oprs = append(oprs, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(oprs, strings.TrimSpace(s[start:]))
+}
+
+var asmMemoryRegisters = map[string]byte{
+	"bx+si": 0,
+	"bx+di": 1,
+	"bp+si": 2,
+	"bp+di": 3,
+	"si":    4,
+	"di":    5,
+	"bp":    6,
+	"bx":    7,
+}
+
+func (a *assembler) operand(s string) (o *asmOperand, err error) {
+	o = &asmOperand{known: true, base: asmAbsolute}
+	for {
+		word, rest := asmSplitWord(s)
+		if rest == "" || !o.keyword(strings.ToLower(word)) {
+			break
+		}
+		s = rest
+	}
+	lower := strings.ToLower(s)
+	if r := RegisterByName(lower); r != nil {
+		o.kind, o.reg = asmRegister, r
+		return
+	}
+	if r := SegmentRegisterByName(lower); r != nil {
+		o.kind, o.sreg = asmSegment, r
+		return
+	}
+	if strings.HasSuffix(s, "]") {
+		return o, a.memory(o, s)
+	}
+	if i := asmTopLevel(s, ':'); i >= 0 {
+		o.kind = asmFarPointer
+		if o.seg, o.known, err = a.evalKnown(s[:i], o.known); err != nil {
+			return
+		}
+		known := o.known
+		if err = a.operandValue(o, s[i+1:]); err != nil {
+			return
+		}
+		o.known = o.known && known
+		return
+	}
+	o.kind = asmImmediate
+	o.signed = strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-")
+	err = a.operandValue(o, s)
+	return
+}
+
+func (a *assembler) operandValue(o *asmOperand, s string) (err error) {
+	v, known, err := a.evalValue(s)
+	o.value, o.base, o.known = v.v, v.base, known
+	return
+}
+
+func (o *asmOperand) keyword(word string) bool {
+	switch word {
+	case "byte":
+		o.w, o.sized = Bit8, true
+	case "word":
+		o.w, o.sized = Bit16, true
+	case "short":
+		o.short = true
+	case "far":
+		o.far = true
+	case "near":
+	default:
+		return false
+	}
+	return true
+}
+
+func asmTopLevel(s string, c byte) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case c:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// memory parses [bx+si+disp], [es:bx] or es:[bx].
+func (a *assembler) memory(o *asmOperand, s string) (err error) {
+	o.kind = asmMemory
+	open := strings.IndexByte(s, '[')
+	if open < 0 {
+		return fmt.Errorf("bad memory operand %q", s)
+	}
+	ea := s[open+1 : len(s)-1]
+	if pre := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s[:open]), ":")); pre != "" {
+		if o.override = SegmentRegisterByName(strings.ToLower(pre)); o.override == nil {
+			return fmt.Errorf("bad memory operand %q", s)
+		}
+	}
+	if i := strings.IndexByte(ea, ':'); i >= 0 {
+		if o.override = SegmentRegisterByName(strings.ToLower(strings.TrimSpace(ea[:i]))); o.override == nil {
+			return fmt.Errorf("bad memory operand %q", s)
+		}
+		ea = ea[i+1:]
+	}
+	names, disp := []string{}, ""
+	for _, term := range asmTerms(ea) {
+		name := strings.ToLower(strings.TrimSpace(term[1:]))
+		if _, ok := asmMemoryRegisters[name]; ok && term[0] == '+' && len(names) < 2 {
+			names = append(names, name)
+		} else {
+			disp += term
+		}
+	}
+	if len(names) == 2 && (names[0] == "si" || names[0] == "di") {
+		names[0], names[1] = names[1], names[0]
+	}
+	if len(names) > 0 {
+		rm, ok := asmMemoryRegisters[strings.Join(names, "+")]
+		if !ok {
+			return fmt.Errorf("bad memory operand %q", s)
+		}
+		o.rm = rm
+	} else {
+		o.direct = true
+	}
+	if disp != "" {
+		o.disp = true
+		if err = a.operandValue(o, disp); err != nil {
+			return
+		}
+		if o.known && !fits(o.value, -0x8000, 0xffff) {
+			return fmt.Errorf("displacement %#x out of range", o.value)
+		}
+	} else if o.direct {
+		return fmt.Errorf("bad memory operand %q", s)
+	}
+	return
+}
+
+// asmTerms splits an address at the + and - outside parentheses, each
+// term starting with its sign.
+func asmTerms(s string) (terms []string) {
+	s = strings.TrimSpace(s)
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '+', '-':
+			prev := strings.TrimSpace(s[start:i])
+			if depth == 0 && prev != "" && prev != "+" && prev != "-" && !strings.ContainsAny(prev[len(prev)-1:], "*/%&|^~<>") {
+				terms = append(terms, s[start:i])
+				start = i
+			}
+		}
+	}
+	terms = append(terms, s[start:])
+	for i, t := range terms {
+		if t = strings.TrimSpace(t); t[0] != '+' && t[0] != '-' {
+			t = "+" + t
+		}
+		terms[i] = t
+	}
+	return
+}
+
+func (a *assembler) evalKnown(s string, known bool) (v int64, ok bool, err error) {
+	v, ok, err = a.eval(s)
+	return v, ok && known, err
+}
+
+// eval evaluates an expression that must be a constant.
+func (a *assembler) eval(s string) (v int64, known bool, err error) {
+	val, known, err := a.evalValue(s)
+	if err == nil && val.base != asmAbsolute {
+		return 0, false, fmt.Errorf("%q is not a constant", s)
+	}
+	return val.v, known, err
+}
+
+// evalValue evaluates an expression. A symbol not defined yet makes it
+// unknown until the final pass, where it is an error. In an object a label
+// is relative to its section, and only a constant can be added to it.
+func (a *assembler) evalValue(s string) (v asmValue, known bool, err error) {
+	e := &asmExpr{a: a, src: s, known: true}
+	if err = e.tokenize(); err != nil {
+		return
+	}
+	if v, err = e.binary(0); err != nil {
+		return
+	}
+	if e.pos < len(e.tokens) {
+		return v, false, fmt.Errorf("unexpected %q in %q", e.tokens[e.pos], s)
+	}
+	if !e.known {
+		v.base = asmAbsolute
+	}
+	return v, e.known, nil
+}
+
+type asmExpr struct {
+	a      *assembler
+	src    string
+	tokens []string
+	pos    int
+	known  bool
+}
+
+var asmOperators = []string{"<<", ">>", "+", "-", "*", "/", "%", "&", "|", "^", "~", "(", ")"}
+
+var asmBinaryLevels = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func isAsmIdent(c byte) bool {
+	return isExprIdent(c) || c == '.' || c == '?' || c == '@' || c == '$'
+}
+
+func (e *asmExpr) tokenize() error {
+	s := e.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return fmt.Errorf("unterminated string in %q", s)
+			}
+			e.tokens = append(e.tokens, s[i:i+j+2])
+			i += j + 2
+		case isAsmIdent(c):
+			j := i + 1
+			for j < len(s) && isAsmIdent(s[j]) {
+				j++
+			}
+			e.tokens = append(e.tokens, s[i:j])
+			i = j
+		default:
+			op := ""
+			for _, o := range asmOperators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected %q in %q", c, s)
+			}
+			e.tokens = append(e.tokens, op)
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func (e *asmExpr) next() string {
+	if e.pos < len(e.tokens) {
+		e.pos++
+		return e.tokens[e.pos-1]
+	}
+	e.pos++
+	return ""
+}
+
+// combine checks what the result of op is relative to.
+func (e *asmExpr) combine(op string, v, rhs asmValue) (base asmBase, err error) {
+	switch {
+	case !e.known || v.base == asmAbsolute && rhs.base == asmAbsolute:
+		return asmAbsolute, nil
+	case op == "+" && v.base == asmAbsolute:
+		return rhs.base, nil
+	case (op == "+" || op == "-") && rhs.base == asmAbsolute:
+		return v.base, nil
+	case op == "-" && v.base == rhs.base:
+		return asmAbsolute, nil
+	}
+	return base, fmt.Errorf("%q needs a constant or an address plus a constant", e.src)
+}
+
+func (e *asmExpr) binary(level int) (v asmValue, err error) {
+	if level == len(asmBinaryLevels) {
+		return e.unary()
+	}
+	if v, err = e.binary(level + 1); err != nil {
+		return
+	}
+	for e.pos < len(e.tokens) {
+		op := e.tokens[e.pos]
+		found := false
+		for _, o := range asmBinaryLevels[level] {
+			found = found || o == op
+		}
+		if !found {
+			return
+		}
+		e.pos++
+		rhs, err := e.binary(level + 1)
+		if err != nil {
+			return v, err
+		}
+		if v.base, err = e.combine(op, v, rhs); err != nil {
+			return v, err
+		}
+		switch op {
+		case "|":
+			v.v |= rhs.v
+		case "^":
+			v.v ^= rhs.v
+		case "&":
+			v.v &= rhs.v
+		case "<<":
+			v.v <<= uint64(rhs.v)
+		case ">>":
+			v.v >>= uint64(rhs.v)
+		case "+":
+			v.v += rhs.v
+		case "-":
+			v.v -= rhs.v
+		case "*":
+			v.v *= rhs.v
+		case "/", "%":
+			if rhs.v == 0 {
+				if e.known {
+					return v, ErrDivideByZero
+				}
+				rhs.v = 1
+			}
+			if op == "/" {
+				v.v /= rhs.v
+			} else {
+				v.v %= rhs.v
+			}
+		}
+	}
+	return
+}
+
+func (e *asmExpr) unary() (v asmValue, err error) {
+	switch op := e.next(); op {
+	case "-", "+", "~":
+		if v, err = e.unary(); err != nil {
+			return
+		}
+		if op != "+" && e.known && v.base != asmAbsolute {
+			return v, fmt.Errorf("%q needs a constant or an address plus a constant", e.src)
+		}
+		switch op {
+		case "-":
+			v.v = -v.v
+		case "~":
+			v.v = ^v.v
+		}
+		return
+	case "(":
+		if v, err = e.binary(0); err != nil {
+			return
+		}
+		if t := e.next(); t != ")" {
+			return v, fmt.Errorf("expected \")\", got %q in %q", t, e.src)
+		}
+		return
+	case "":
+		return v, fmt.Errorf("unexpected end of %q", e.src)
+	default:
+		return e.value(op)
+	}
+}
+
+func (e *asmExpr) value(t string) (v asmValue, err error) {
+	v.base = asmAbsolute
+	switch c := t[0]; {
+	case c == '\'' || c == '"' || c == '`':
+		for i := len(t) - 2; i >= 1; i-- {
+			v.v = v.v<<8 | int64(t[i])
+		}
+		return
+	case c >= '0' && c <= '9':
+		if last := t[len(t)-1]; last == 'h' || last == 'H' {
+			v.v, err = strconv.ParseInt(t[:len(t)-1], 16, 64)
+		} else {
+			v.v, err = strconv.ParseInt(t, 0, 64)
+		}
+		if err != nil {
+			return v, fmt.Errorf("bad number %q in %q", t, e.src)
+		}
+		return
+	case t == "$":
+		return e.a.here(), nil
+	case t == "$$":
+		start := e.a.here()
+		start.v -= e.a.sections[e.a.section].size
+		return start, nil
+	}
+	v, ok := e.a.values[t]
+	if !ok {
+		if e.a.final {
+			return v, fmt.Errorf("undefined symbol %q", t)
+		}
+		e.known = false
+		v.base = asmAbsolute
+	}
+	return
+}
@@ -0,0 +1,168 @@
+package go8086
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// test/data.s lists every opcode, so some instructions are there in an
+// encoding the assembler does not pick from the text, like push ax as ff f0
+// next to 50. Such a line has to assemble to bytes that disassemble to it.
+func TestAssembleData(t *testing.T) {
+	f, err := os.Open("test/data.s")
+	assert.Nil(t, err)
+	defer f.Close()
+	data, err := ioutil.ReadFile("test/data")
+	assert.Nil(t, err)
+	scanner := bufio.NewScanner(f)
+	size, exact := 0, 0
+	for scanner.Scan() {
+		m := asmListingLine.FindStringSubmatch(scanner.Text())
+		if !assert.NotNil(t, m, scanner.Text()) {
+			return
+		}
+		listed := asmHex(m[2])
+		assert.Equal(t, Bytes(data[size:size+len(listed)]), listed, m[3])
+		code, _, err := Assemble(strings.NewReader(fmt.Sprintf("org 0x%s\n%s", m[1], m[3])))
+		if !assert.Nil(t, err, m[3]) {
+			continue
+		}
+		if bytes.Equal(code, listed) {
+			exact++
+		} else {
+			padded := append(code, make(Bytes, ListingBytes)...)
+			op := getOpcode(nil, uint16(size), padded)
+			assert.Equal(t, m[3], op.Disasm(), "%s %X", m[1], []byte(code))
+			assert.Equal(t, len(code), len(op.bytes), m[3])
+		}
+		size += len(listed)
+	}
+	assert.Equal(t, len(data), size)
+	assert.Equal(t, 709, exact)
+}
+
+func TestAssembleListing(t *testing.T) {
+	src := "00000000  FFF0              push ax\n00000002  50                push ax\n"
+	code, _, err := Assemble(strings.NewReader(src))
+	assert.Nil(t, err)
+	assert.Equal(t, Bytes{0xff, 0xf0, 0x50}, code)
+}
+
+// Without the listed bytes the encodings can differ, but each must still
+// disassemble to the line it came from.
+func TestAssembleDisasm(t *testing.T) {
+	tests := disasmTests()
+	src := []string{}
+	for _, test := range tests {
+		src = append(src, test.out)
+	}
+	code, _, err := Assemble(strings.NewReader(strings.Join(src, "\n")))
+	assert.Nil(t, err)
+	padded := append(append(Bytes{}, code...), make(Bytes, ListingBytes)...)
+	addr := 0
+	for _, test := range tests {
+		if !assert.True(t, addr < len(code), test.out) {
+			return
+		}
+		op := getOpcode(nil, uint16(addr), padded[addr:])
+		if test.out == "db 0xc4" {
+			// c4 ff is no valid les, so the next line is db 0xff.
+			op = &Opcode{mn: DB, bytes: padded[addr : addr+1]}
+		}
+		assert.Equal(t, test.out, op.Disasm(), "%04x", addr)
+		addr += len(op.bytes)
+	}
+	assert.Equal(t, len(code), addr)
+}
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		src  string
+		code Bytes
+	}{
+		{"push cx", Bytes{0x51}},
+		{"push word [bx+di]", Bytes{0xff, 0x31}},
+		{"mov al,0x12", Bytes{0xb0, 0x12}},
+		{"mov ax,[0x1234]", Bytes{0x8b, 0x06, 0x34, 0x12}},
+		{"mov [bp+0x0],si", Bytes{0x89, 0x76, 0x00}},
+		{"mov [bp],si", Bytes{0x89, 0x76, 0x00}},
+		{"mov [di-0x6400],bp", Bytes{0x89, 0xad, 0x00, 0x9c}},
+		{"mov [es:bx+si],al", Bytes{0x26, 0x88, 0x00}},
+		{"mov al,es:[di]", Bytes{0x26, 0x8a, 0x05}},
+		{"add bx,0x5", Bytes{0x81, 0xc3, 0x05, 0x00}},
+		{"add bx,+0x5", Bytes{0x83, 0xc3, 0x05}},
+		{"add word [bx+si],byte -0x1", Bytes{0x83, 0x00, 0xff}},
+		{"add ax,0x5", Bytes{0x05, 0x05, 0x00}},
+		{"shl byte [bx+si],1", Bytes{0xd0, 0x20}},
+		{"sar word [bx+si],cl", Bytes{0xd3, 0x38}},
+		{"xchg ax,cx", Bytes{0x91}},
+		{"xchg bl,al", Bytes{0x86, 0xd8}},
+		{"xchg bx,ax", Bytes{0x87, 0xd8}},
+		{"call word far [bx+si]", Bytes{0xff, 0x18}},
+		{"jmp word 0x6745:0x2301", Bytes{0xea, 0x01, 0x23, 0x45, 0x67}},
+		{"es mov ax,ds", Bytes{0x26, 0x8c, 0xd8}},
+		{"lock add [bx+si],al", Bytes{0xf0, 0x00, 0x00}},
+		{"rep stosw", Bytes{0xf3, 0xab}},
+		{"wait", Bytes{0x9b}},
+		{"aam", Bytes{0xd4, 0x0a}},
+		{"ret 0x3412", Bytes{0xc2, 0x12, 0x34}},
+		{"org 0x100\nstart: jmp start", Bytes{0xeb, 0xfe}},
+		{"jmp end\ntimes 0x7f nop\nend:", append(Bytes{0xeb, 0x7f}, Bytes(strings.Repeat("\x90", 0x7f))...)},
+		{"jmp end\ntimes 0x80 nop\nend:", append(Bytes{0xe9, 0x80, 0x00}, Bytes(strings.Repeat("\x90", 0x80))...)},
+		{"end: jmp word end", Bytes{0xe9, 0xfd, 0xff}},
+		{"jmp short end\nend:", Bytes{0xeb, 0x00}},
+		{"call f\nf: ret", Bytes{0xe8, 0x00, 0x00, 0xc3}},
+		{"mov bx,msg+2*3\nmsg: db 'hi',0xa,-1", Bytes{0xbb, 0x09, 0x00, 'h', 'i', 0x0a, 0xff}},
+		{"len equ end-msg\nmov cx,len\nmsg: dw 'abc',0x1234\nend:", Bytes{0xb9, 0x06, 0x00, 'a', 'b', 'c', 0, 0x34, 0x12}},
+		{"org 0x10\ndb 1\ntimes 4-($-$$) db 0x90 ; pad", Bytes{0x01, 0x90, 0x90, 0x90}},
+		{"mov ax,'A' | 0x100\nmov dl,1fh", Bytes{0xb8, 0x41, 0x01, 0xb2, 0x1f}},
+		{"mov bx,buf\nsection .data\nmsg: dw msg\nsection .bss\nbuf: resw 2\nsection .text\nresb 2", Bytes{0xbb, 0x07, 0x00, 0x00, 0x00, 0x05, 0x00}},
+		{"00000000  51                push cx\n00000001  FFF1              push cx", Bytes{0x51, 0xff, 0xf1}},
+		{"00000100  C7061234FF        mov word [0x3412],0xff\n         -00", Bytes{0xc7, 0x06, 0x12, 0x34, 0xff, 0x00}},
+	}
+	for _, test := range tests {
+		code, _, err := Assemble(strings.NewReader(test.src))
+		assert.Nil(t, err, test.src)
+		assert.Equal(t, test.code, code, test.src)
+	}
+}
+
+func TestAssembleSymbols(t *testing.T) {
+	_, symbols, err := Assemble(strings.NewReader("org 0x100\nstart: nop\nsize equ 0x20\nloop: jmp loop"))
+	assert.Nil(t, err)
+	sym, ok := symbols.Lookup("loop")
+	assert.True(t, ok)
+	assert.Equal(t, &Symbol{Name: "loop", Value: 0x101, Section: SectionText, External: true}, sym)
+	sym, _ = symbols.Lookup("size")
+	assert.Equal(t, SectionAbsolute, sym.Section)
+	assert.Equal(t, uint16(0x20), sym.Value)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"jmp nowhere", `asm line 1: undefined symbol "nowhere"`},
+		{"push [bx]", "asm line 1: operation size not specified: push [bx]"},
+		{"mov al,bx", "asm line 1: invalid operands: mov al,bx"},
+		{"mov ax,[bx+bp]", `asm line 1: bad memory operand "[bx+bp]"`},
+		{"jz end\ntimes 0x80 nop\nend:", "asm line 1: invalid operands: jz end"},
+		{"foo ax", `asm line 1: unknown instruction "foo"`},
+		{"a: nop\na: nop", `asm line 2: symbol "a" redefined`},
+		{"nop\norg 0x100", "asm line 2: org after code"},
+		{"00000000  B012              mov al,0x13", "asm line 1: mov al,0x13 does not assemble to the listed B012"},
+	}
+	for _, test := range tests {
+		_, _, err := Assemble(strings.NewReader(test.src))
+		if assert.NotNil(t, err, test.src) {
+			assert.Equal(t, test.err, err.Error())
+		}
+	}
+}
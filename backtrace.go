@@ -0,0 +1,128 @@
+package go8086
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+var BacktraceArgs = 3
+
+const backtraceMaxFrames = 64
+const backtraceMaxScan = 4096
+
+// Frame is one level of a backtrace. IP is the current instruction for the
+// innermost frame and the return address for the others. Heuristic frames
+// were found by scanning the stack rather than by following BP.
+type Frame struct {
+	Segment   uint16
+	IP        uint16
+	BP        uint16
+	Function  string
+	Args      []uint16
+	Heuristic bool
+}
+
+func (f *Frame) String() string {
+	s := fmt.Sprintf("%04x:%04x", f.Segment, f.IP)
+	if f.Function != "" {
+		s += " in " + f.Function
+	}
+	args := []string{}
+	for _, a := range f.Args {
+		args = append(args, fmt.Sprintf("%04x", a))
+	}
+	s += " (" + strings.Join(args, ", ") + ")"
+	if f.Heuristic {
+		s += " ?"
+	}
+	return s
+}
+
+// isReturnAddress reports whether the bytes just before addr decode as a
+// near CALL ending at addr.
+func (vm *VM) isReturnAddress(addr uint16) bool {
+	cs := vm.sreg["cs"]
+	for n := uint16(2); n <= 4; n++ {
+		op := vm.OpcodeAt(cs, addr-n)
+		if op.mn == CALL && uint16(len(op.bytes)) == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (vm *VM) frameArgs(offset uint16, nargs int) (args []uint16) {
+	for i := 0; i < nargs; i++ {
+		args = append(args, vm.Read16(vm.sreg["ss"], offset+uint16(2*i)))
+	}
+	return
+}
+
+func (vm *VM) newFrame(ip, bp uint16, args []uint16, heuristic bool) *Frame {
+	return &Frame{
+		Segment:   vm.sreg["cs"],
+		IP:        ip,
+		BP:        bp,
+		Function:  vm.symbols.Describe(ip),
+		Args:      args,
+		Heuristic: heuristic,
+	}
+}
+
+// Backtrace walks the BP chain of ACK's calling convention: every function
+// starts with push bp; mov bp,sp, so [bp] is the caller's bp, [bp+2] the
+// return address and the arguments follow. When the chain ends early, the
+// rest of the stack is scanned for words that look like return addresses.
+func (vm *VM) Backtrace(nargs int) (frames []*Frame) {
+	ss, sp, bp := vm.sreg["ss"], vm.reg["sp"], vm.reg["bp"]
+	pc, pending := vm.ip, true
+	scan := sp
+	// Until the prologue has run, the return address is still near sp and
+	// bp belongs to the caller.
+	op := vm.getOpcode()
+	prologue := uint16(0)
+	if op.bytes[0] == 0x55 {
+		prologue = sp
+	} else if vm.Read8(vm.sreg["cs"], pc-1) == 0x55 && op.Disasm() == "mov bp,sp" {
+		prologue = sp + 2
+	}
+	if prologue != 0 {
+		frames = append(frames, vm.newFrame(pc, bp, vm.frameArgs(prologue+2, nargs), false))
+		pc, scan = vm.Read16(ss, prologue), prologue+2
+		pending = vm.isReturnAddress(pc)
+	}
+	for pending && len(frames) < backtraceMaxFrames && bp >= sp && bp <= 0xfffc {
+		frames = append(frames, vm.newFrame(pc, bp, vm.frameArgs(bp+4, nargs), false))
+		pending, scan = false, bp+4
+		next, ret := vm.Read16(ss, bp), vm.Read16(ss, bp+2)
+		if !vm.isReturnAddress(ret) {
+			break
+		}
+		pc, pending = ret, true
+		if next == 0 {
+			frames = append(frames, vm.newFrame(pc, 0, nil, false))
+			return
+		}
+		if next <= bp {
+			break
+		}
+		bp = next
+	}
+	if pending {
+		frames = append(frames, vm.newFrame(pc, 0, nil, false))
+	}
+	// The stack ends where offsets wrap around.
+	for offset, i := scan, 0; scan >= sp && offset >= scan && i < backtraceMaxScan && len(frames) < backtraceMaxFrames; offset, i = offset+2, i+1 {
+		if ret := vm.Read16(ss, offset); ret != 0 && vm.isReturnAddress(ret) {
+			frames = append(frames, vm.newFrame(ret, 0, vm.frameArgs(offset+2, nargs), true))
+		}
+	}
+	return
+}
+
+func (vm *VM) PrintBacktrace(w io.Writer, nargs int) {
+	for i, f := range vm.Backtrace(nargs) {
+		fmt.Fprintf(w, "#%-2d %s\n", i, f)
+	}
+}
@@ -0,0 +1,83 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var backtraceTestCode = Bytes{
+	0x55,       // 0000 _main: push bp
+	0x89, 0xe5, // 0001 mov bp,sp
+	0xb8, 0x07, 0x00, // 0003 mov ax,0x7
+	0x50,             // 0006 push ax
+	0xb8, 0x09, 0x00, // 0007 mov ax,0x9
+	0x50,             // 000a push ax
+	0xe8, 0x04, 0x00, // 000b call _f
+	0xf4,             // 000e hlt
+	0x90, 0x90, 0x90, // 000f
+	0x55,       // 0012 _f: push bp
+	0x89, 0xe5, // 0013 mov bp,sp
+	0xe8, 0x02, 0x00, // 0015 call _g
+	0xf4,       // 0018 hlt
+	0x90,       // 0019
+	0x55,       // 001a _g: push bp
+	0x89, 0xe5, // 001b mov bp,sp
+	0x90, // 001d nop
+	0xf4, // 001e hlt
+}
+
+var backtraceTestSymbols, _ = ReadSymbolMap(strings.NewReader("0000 T _main\n0012 T _f\n001a T _g\n"))
+
+func runUntil(vm *VM, ip uint16) {
+	for vm.IP() != ip {
+		vm.Step()
+	}
+}
+
+func TestBacktraceBPChain(t *testing.T) {
+	vm := newTestVM(backtraceTestCode)
+	vm.SetSymbols(backtraceTestSymbols)
+	runUntil(vm, 0x1d)
+	frames := vm.Backtrace(2)
+	assert.Equal(t, 3, len(frames))
+	assert.Equal(t, &Frame{Segment: 0x1000, IP: 0x001d, BP: 0xfff0, Function: "_g+0x3", Args: []uint16{0xfffc, 0x000e}}, frames[0])
+	assert.Equal(t, &Frame{Segment: 0x1000, IP: 0x0018, BP: 0xfff4, Function: "_f+0x6", Args: []uint16{0x0009, 0x0007}}, frames[1])
+	assert.Equal(t, uint16(0x000e), frames[2].IP)
+	assert.Equal(t, "_main+0xe", frames[2].Function)
+
+	out := new(bytes.Buffer)
+	vm.PrintBacktrace(out, 2)
+	assert.Contains(t, out.String(), "#1  1000:0018 in _f+0x6 (0009, 0007)\n")
+}
+
+func TestBacktracePrologue(t *testing.T) {
+	vm := newTestVM(backtraceTestCode)
+	vm.SetSymbols(backtraceTestSymbols)
+	runUntil(vm, 0x12)
+	frames := vm.Backtrace(2)
+	assert.Equal(t, 2, len(frames))
+	assert.Equal(t, "_f", frames[0].Function)
+	assert.Equal(t, []uint16{0x0009, 0x0007}, frames[0].Args)
+	assert.Equal(t, uint16(0x000e), frames[1].IP)
+
+	vm.Step()
+	frames = vm.Backtrace(2)
+	assert.Equal(t, 2, len(frames))
+	assert.Equal(t, "_f+0x1", frames[0].Function)
+	assert.Equal(t, []uint16{0x0009, 0x0007}, frames[0].Args)
+}
+
+func TestBacktraceHeuristic(t *testing.T) {
+	vm := newTestVM(backtraceTestCode)
+	vm.SetSymbols(backtraceTestSymbols)
+	runUntil(vm, 0x1d)
+	vm.SetReg(BP, 0)
+	frames := vm.Backtrace(1)
+	assert.Equal(t, 3, len(frames))
+	assert.False(t, frames[0].Heuristic)
+	assert.Equal(t, &Frame{Segment: 0x1000, IP: 0x0018, Function: "_f+0x6", Args: []uint16{0xfffc}, Heuristic: true}, frames[1])
+	assert.Equal(t, uint16(0x000e), frames[2].IP)
+	assert.True(t, strings.HasSuffix(frames[2].String(), " ?"))
+}
@@ -95,31 +95,31 @@ const (
 
 type Bytes []byte
 
-func (bs Bytes) read32() uint32 {
+func (bs Bytes) Read32() uint32 {
 	return uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)
 }
 
-func (bs Bytes) read16() uint16 {
+func (bs Bytes) Read16() uint16 {
 	return uint16(bs[0]) | (uint16(bs[1]) << 8)
 }
 
-func (bs Bytes) read8() uint16 {
+func (bs Bytes) Read8() uint16 {
 	return uint16(bs[0])
 }
 
-func (bs Bytes) write16(value uint16) {
+func (bs Bytes) Write16(value uint16) {
 	bs[0] = byte(value & 0x00ff)
 	bs[1] = byte(value >> 8)
 }
 
-func (bs Bytes) write32(value uint32) {
+func (bs Bytes) Write32(value uint32) {
 	bs[0] = byte(value & 0x00ff)
 	bs[1] = byte(value >> 8)
 	bs[2] = byte(value >> 16)
 	bs[3] = byte(value >> 24)
 }
 
-func (bs Bytes) write8(value uint16) {
+func (bs Bytes) Write8(value uint16) {
 	bs[0] = byte(value & 0x00ff)
 }
 
@@ -135,18 +135,18 @@ func (bs Bytes) modRM(mod Mod, reg Reg, rm RM, w Bit, sreg *SegmentRegister) (op
 	switch mod {
 	case Mod00:
 		if rm == RM110 {
-			imm := NewImmediate(bs[1:].read16(), Unsign, Bit16)
+			imm := NewImmediate(bs[1:].Read16(), Unsign, Bit16)
 			opr1 = NewMemory(RegAdd_Direct, imm, w, sreg)
 			readBytes = bs[0:3]
 		} else {
 			opr1 = NewMemory(RMRegAddressMap[rm], nil, w, sreg)
 		}
 	case Mod01:
-		imm := NewImmediate(bs[1:].read8(), Sign, Bit8)
+		imm := NewImmediate(bs[1:].Read8(), Sign, Bit8)
 		opr1 = NewMemory(RMRegAddressMap[rm], imm, w, sreg)
 		readBytes = bs[0:2]
 	case Mod10:
-		imm := NewImmediate(bs[1:].read16(), Sign, Bit16)
+		imm := NewImmediate(bs[1:].Read16(), Sign, Bit16)
 		opr1 = NewMemory(RMRegAddressMap[rm], imm, w, sreg)
 		readBytes = bs[0:3]
 	case Mod11:
@@ -172,14 +172,14 @@ func (bs Bytes) GetOperandByModRMData(w Bit, signed Signed, sreg *SegmentRegiste
 	data := bs[len(readBytes):]
 	switch w {
 	case Bit8:
-		opr2 = NewImmediate(data.read8(), Unsign, Bit8)
+		opr2 = NewImmediate(data.Read8(), Unsign, Bit8)
 		readBytes = append(readBytes, data[0:1]...)
 	case Bit16:
 		if signed {
-			opr2 = NewImmediate(data.read8(), Sign, Bit8)
+			opr2 = NewImmediate(data.Read8(), Sign, Bit8)
 			readBytes = append(readBytes, data[0:1]...)
 		} else {
-			opr2 = NewImmediate(data.read16(), Unsign, Bit16)
+			opr2 = NewImmediate(data.Read16(), Unsign, Bit16)
 			readBytes = append(readBytes, data[0:2]...)
 		}
 	}
@@ -190,9 +190,9 @@ func (bs Bytes) GetOperandOfAccImm(w Bit, wImm Bit) (opr1, opr2 Operand, readByt
 	var imm *Immediate
 	switch wImm {
 	case Bit8:
-		imm, readBytes = NewImmediate(bs.read8(), Unsign, Bit8), bs[0:1]
+		imm, readBytes = NewImmediate(bs.Read8(), Unsign, Bit8), bs[0:1]
 	case Bit16:
-		imm, readBytes = NewImmediate(bs.read16(), Unsign, Bit16), bs[0:2]
+		imm, readBytes = NewImmediate(bs.Read16(), Unsign, Bit16), bs[0:2]
 	}
 	switch w {
 	case Bit8:
@@ -204,7 +204,7 @@ func (bs Bytes) GetOperandOfAccImm(w Bit, wImm Bit) (opr1, opr2 Operand, readByt
 }
 
 func (bs Bytes) GetOperandOfAccMem(w Bit, sreg *SegmentRegister) (opr1, opr2 Operand, readBytes Bytes) {
-	imm := NewImmediate(bs.read16(), Unsign, Bit16)
+	imm := NewImmediate(bs.Read16(), Unsign, Bit16)
 	readBytes = bs[0:2]
 	switch w {
 	case Bit8:
@@ -218,9 +218,9 @@ func (bs Bytes) GetOperandOfAccMem(w Bit, sreg *SegmentRegister) (opr1, opr2 Ope
 func (bs Bytes) GetOperandOfRegImm(w Bit, reg Reg) (opr1, opr2 Operand, readBytes Bytes) {
 	switch w {
 	case Bit8:
-		opr1, opr2, readBytes = getRegister(Bit8, reg), NewImmediate(bs.read8(), Unsign, Bit8), bs[0:1]
+		opr1, opr2, readBytes = getRegister(Bit8, reg), NewImmediate(bs.Read8(), Unsign, Bit8), bs[0:1]
 	case Bit16:
-		opr1, opr2, readBytes = getRegister(Bit16, reg), NewImmediate(bs.read16(), Unsign, Bit16), bs[0:2]
+		opr1, opr2, readBytes = getRegister(Bit16, reg), NewImmediate(bs.Read16(), Unsign, Bit16), bs[0:2]
 	}
 	return
 }
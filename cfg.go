@@ -0,0 +1,481 @@
+package go8086
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Transfer is a jump of IP from the instruction at From to To.
+type Transfer struct {
+	From uint16
+	To   uint16
+}
+
+type EdgeKind int
+
+const (
+	EdgeFallthrough EdgeKind = iota
+	EdgeBranch
+	EdgeJump
+	EdgeTable
+	EdgeCall
+	EdgeReturn
+)
+
+var edgeKindNames = map[EdgeKind]string{
+	EdgeFallthrough: "fallthrough",
+	EdgeBranch:      "branch",
+	EdgeJump:        "jump",
+	EdgeTable:       "table",
+	EdgeCall:        "call",
+	EdgeReturn:      "return",
+}
+
+func (k EdgeKind) String() string {
+	return edgeKindNames[k]
+}
+
+func (k EdgeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// BasicBlock is a run of instructions entered only at Start and left only
+// after the last one, which ends before End.
+type BasicBlock struct {
+	Start        uint16
+	End          uint16
+	Label        string `json:",omitempty"`
+	Instructions []string
+	ops          []*Opcode
+}
+
+// CFGEdge goes from the block starting at From to the one starting at To.
+// Count is how often a recorded run took it: only instructions that
+// transfer control count, so the fall-through after a call stays 0.
+type CFGEdge struct {
+	From    uint16
+	To      uint16
+	Kind    EdgeKind
+	Dynamic bool `json:",omitempty"` // only seen at runtime
+	Count   uint64
+}
+
+// CFGFunction is the blocks reached from Entry without calls or returns.
+// A jump to the entry of another function is a tail call and ends there.
+type CFGFunction struct {
+	Name   string
+	Entry  uint16
+	Blocks []*BasicBlock
+	Edges  []*CFGEdge
+}
+
+type CFG struct {
+	Functions []*CFGFunction
+}
+
+// AddFlow adds the transfers a FlowRecorder saw: code only reached at
+// runtime is decoded, and jumps and calls to places the static analysis
+// did not know of become dynamic xrefs.
+func (m *CodeMap) AddFlow(transfers map[Transfer]uint64) {
+	if m.Flow == nil {
+		m.Flow = make(map[Transfer]uint64)
+	}
+	for t, n := range transfers {
+		m.Flow[t] += n
+		m.work = append(m.work, t.From, t.To)
+	}
+	m.analyze()
+	for t := range transfers {
+		op := m.Ops[t.From]
+		if op == nil || m.Ops[t.To] == nil || isReturn(op.mn) || t.To == t.From+uint16(len(op.bytes)) {
+			continue
+		}
+		known := false
+		for _, x := range m.Xrefs[t.To] {
+			known = known || x.From == t.From
+		}
+		if !known {
+			m.Xrefs[t.To] = append(m.Xrefs[t.To], Xref{t.From, XrefDynamic})
+		}
+	}
+	m.nameLabels()
+}
+
+func isReturn(mn Mnemonic) bool {
+	return mn == RET || mn == RETF || mn == IRET
+}
+
+// endsBlock tells whether control can leave op other than to the next
+// instruction.
+func (m *CodeMap) endsBlock(op *Opcode, jumps map[uint16][]uint16) bool {
+	switch {
+	case op.mn == CALL, op.mn == JMP, op.mn == HLT, isBranch(op.mn), isReturn(op.mn):
+		return true
+	}
+	return len(jumps[op.address]) > 0
+}
+
+// CFG splits the decoded code into basic blocks and groups them into
+// functions: the entry points, the targets of calls and the external text
+// symbols. With AddFlow, it includes what was seen at runtime.
+func (m *CodeMap) CFG() (g *CFG) {
+	// jumps are the transfers seen at runtime that leave an instruction
+	// other than to the next one.
+	jumps := map[uint16][]uint16{}
+	for t := range m.Flow {
+		if op := m.Ops[t.From]; op != nil && m.Ops[t.To] != nil && t.To != t.From+uint16(len(op.bytes)) {
+			jumps[t.From] = append(jumps[t.From], t.To)
+		}
+	}
+	for _, tos := range jumps {
+		sort.Slice(tos, func(i, j int) bool { return tos[i] < tos[j] })
+	}
+
+	entries := map[uint16]bool{}
+	for _, e := range m.Entries {
+		entries[e] = true
+	}
+	for to, xrefs := range m.Xrefs {
+		for _, x := range xrefs {
+			if m.isCall(x) {
+				entries[to] = true
+			}
+		}
+	}
+	for _, sym := range m.Symbols.Symbols() {
+		if sym.Section == SectionText && sym.External {
+			entries[sym.Value] = true
+		}
+	}
+	leaders := map[uint16]bool{}
+	for e := range entries {
+		leaders[e] = true
+	}
+	for to := range m.Xrefs {
+		leaders[to] = true
+	}
+	for addr, op := range m.Ops {
+		if m.endsBlock(op, jumps) {
+			leaders[addr+uint16(len(op.bytes))] = true
+		}
+		for _, to := range jumps[addr] {
+			leaders[to] = true
+		}
+	}
+
+	blocks := map[uint16]*BasicBlock{}
+	for start := range leaders {
+		if m.Ops[start] == nil {
+			continue
+		}
+		b := &BasicBlock{Start: start, Label: m.Labels[start]}
+		for addr := start; ; {
+			op := m.Ops[addr]
+			b.ops = append(b.ops, op)
+			b.Instructions = append(b.Instructions, op.WithSymbols(m.names).DisasmStyle(m.Style))
+			addr += uint16(len(op.bytes))
+			b.End = addr
+			if m.endsBlock(op, jumps) || leaders[addr] || m.Ops[addr] == nil {
+				break
+			}
+		}
+		blocks[start] = b
+	}
+
+	g = &CFG{}
+	starts := []uint16{}
+	for e := range entries {
+		if blocks[e] != nil {
+			starts = append(starts, e)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, e := range starts {
+		g.Functions = append(g.Functions, m.function(e, blocks, entries, jumps))
+	}
+	return
+}
+
+// returnSites are the addresses after the calls of the function at entry.
+func (m *CodeMap) returnSites(entry uint16) (sites []uint16) {
+	for _, x := range m.Xrefs[entry] {
+		if m.isCall(x) {
+			sites = append(sites, x.From+uint16(len(m.Ops[x.From].bytes)))
+		}
+	}
+	return
+}
+
+func (m *CodeMap) function(entry uint16, blocks map[uint16]*BasicBlock, entries map[uint16]bool, jumps map[uint16][]uint16) *CFGFunction {
+	f := &CFGFunction{Name: m.Labels[entry], Entry: entry}
+	if f.Name == "" {
+		f.Name = fmt.Sprintf("sub_%04x", entry)
+	}
+	seen := map[uint16]bool{entry: true}
+	work := []uint16{entry}
+	for len(work) > 0 {
+		b := blocks[work[0]]
+		work = work[1:]
+		f.Blocks = append(f.Blocks, b)
+		for _, e := range m.blockEdges(b, entry, jumps) {
+			f.Edges = append(f.Edges, e)
+			if e.Kind == EdgeCall || e.Kind == EdgeReturn || entries[e.To] || seen[e.To] || blocks[e.To] == nil {
+				continue
+			}
+			seen[e.To] = true
+			work = append(work, e.To)
+		}
+	}
+	sort.Slice(f.Blocks, func(i, j int) bool { return f.Blocks[i].Start < f.Blocks[j].Start })
+	sort.SliceStable(f.Edges, func(i, j int) bool { return f.Edges[i].From < f.Edges[j].From })
+	return f
+}
+
+// blockEdges returns where control goes from b in the function at entry.
+func (m *CodeMap) blockEdges(b *BasicBlock, entry uint16, jumps map[uint16][]uint16) (edges []*CFGEdge) {
+	op := b.ops[len(b.ops)-1]
+	add := func(to uint16, kind EdgeKind, dynamic bool) {
+		for _, e := range edges {
+			if e.To == to && e.Kind == kind {
+				return
+			}
+		}
+		edges = append(edges, &CFGEdge{From: b.Start, To: to, Kind: kind, Dynamic: dynamic, Count: m.Flow[Transfer{op.address, to}]})
+	}
+	next := b.End
+	target, direct := op.Target()
+	kind := EdgeJump
+	switch {
+	case op.mn == CALL || op.mn == JMP:
+		if op.mn == CALL {
+			kind = EdgeCall
+		}
+		if direct {
+			add(target, kind, false)
+		}
+		for _, t := range m.Tables {
+			if t.From != op.address {
+				continue
+			}
+			for _, to := range t.Targets {
+				if kind == EdgeCall {
+					add(to, kind, false)
+				} else {
+					add(to, EdgeTable, false)
+				}
+			}
+		}
+	case isBranch(op.mn):
+		kind = EdgeBranch
+		add(target, kind, false)
+	case isReturn(op.mn):
+		kind = EdgeReturn
+		for _, site := range m.returnSites(entry) {
+			add(site, kind, false)
+		}
+	}
+	for _, to := range jumps[op.address] {
+		known := false
+		for _, e := range edges {
+			known = known || e.To == to
+		}
+		if !known {
+			add(to, kind, true)
+		}
+	}
+	if op.mn != JMP && op.mn != HLT && !isReturn(op.mn) && m.Ops[next] != nil {
+		add(next, EdgeFallthrough, false)
+	}
+	return
+}
+
+// WriteJSON writes g as one JSON object.
+func (g *CFG) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+func dotString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+var dotEdgeStyles = map[EdgeKind]string{
+	EdgeBranch: `color="darkgreen"`,
+	EdgeTable:  `style="dotted"`,
+	EdgeCall:   `style="dashed"`,
+	EdgeReturn: `style="dashed", color="gray"`,
+}
+
+// WriteDOT writes g as a Graphviz digraph with a cluster for each
+// function. A block shared by functions is drawn in the first one. Edges
+// only seen at runtime are blue, and run counts are edge labels.
+func (g *CFG) WriteDOT(w io.Writer) (err error) {
+	lines := []string{"digraph cfg {", "\tnode [shape=box, fontname=\"monospace\"];"}
+	drawn := map[uint16]bool{}
+	edges := map[CFGEdge]bool{}
+	for _, f := range g.Functions {
+		lines = append(lines, fmt.Sprintf("\tsubgraph \"cluster_%04x\" {", f.Entry), fmt.Sprintf("\t\tlabel=\"%s\";", dotString(f.Name)))
+		for _, b := range f.Blocks {
+			if drawn[b.Start] {
+				continue
+			}
+			drawn[b.Start] = true
+			text := ""
+			if b.Label != "" {
+				text = dotString(b.Label) + ":\\l"
+			}
+			for i, ins := range b.Instructions {
+				text += fmt.Sprintf("%04x  %s\\l", b.ops[i].address, dotString(ins))
+			}
+			lines = append(lines, fmt.Sprintf("\t\tb%04x [label=\"%s\"];", b.Start, text))
+		}
+		lines = append(lines, "\t}")
+	}
+	for _, f := range g.Functions {
+		for _, e := range f.Edges {
+			if edges[*e] {
+				continue
+			}
+			edges[*e] = true
+			attrs := []string{}
+			if style, ok := dotEdgeStyles[e.Kind]; ok {
+				attrs = append(attrs, style)
+			}
+			if e.Dynamic {
+				attrs = append(attrs, `color="blue"`)
+			}
+			label := ""
+			if e.Kind == EdgeCall || e.Kind == EdgeReturn || e.Kind == EdgeTable {
+				label = e.Kind.String()
+			}
+			if e.Count > 0 {
+				label = strings.TrimSpace(fmt.Sprintf("%s %d", label, e.Count))
+			}
+			if label != "" {
+				attrs = append(attrs, fmt.Sprintf("label=\"%s\"", label))
+			}
+			line := fmt.Sprintf("\tb%04x -> b%04x", e.From, e.To)
+			if len(attrs) > 0 {
+				line += " [" + strings.Join(attrs, ", ") + "]"
+			}
+			lines = append(lines, line+";")
+		}
+	}
+	lines = append(lines, "}")
+	_, err = io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return
+}
+
+// WriteFile writes g to file, as JSON when its name ends in .json and as
+// DOT otherwise.
+func (g *CFG) WriteFile(file string) (err error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	if filepath.Ext(file) == ".json" {
+		err = g.WriteJSON(f)
+	} else {
+		err = g.WriteDOT(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+// FlowRecorder counts the control transfers of a VM for CodeMap.AddFlow:
+// every jump, branch, call and return, taken or not, and anything else
+// that does not go on to the next instruction.
+type FlowRecorder struct {
+	Transfers map[Transfer]uint64
+	Entry     uint16
+	file      string
+	vm        *VM
+	ids       []HookID
+	ip        uint16
+}
+
+func NewFlowRecorder() *FlowRecorder {
+	return &FlowRecorder{Transfers: make(map[Transfer]uint64)}
+}
+
+// FlowFile returns a recorder that writes the control-flow graph of the
+// program, with what it saw, to file on Close. After an exec it starts over
+// with the new program, and a forked child writes file with its pid before
+// the extension.
+func FlowFile(file string) *FlowRecorder {
+	r := NewFlowRecorder()
+	r.file = file
+	return r
+}
+
+func (r *FlowRecorder) Attach(vm *VM) {
+	r.vm = vm
+	r.Entry = vm.ip
+	r.ids = []HookID{
+		vm.AddBeforeInstructionHook(r.before),
+		vm.AddAfterInstructionHook(r.after),
+		vm.AddSyscallHook(r.syscall),
+	}
+}
+
+func (r *FlowRecorder) Detach() {
+	for _, id := range r.ids {
+		r.vm.RemoveHook(id)
+	}
+	r.ids = nil
+}
+
+func (r *FlowRecorder) before(vm *VM, op *Opcode) error {
+	r.ip = vm.ip
+	return nil
+}
+
+func (r *FlowRecorder) after(vm *VM, op *Opcode) error {
+	switch {
+	case op.mn == CALL, op.mn == JMP, isBranch(op.mn), isReturn(op.mn), vm.ip != r.ip+uint16(len(op.bytes)):
+		r.Transfers[Transfer{r.ip, vm.ip}]++
+	}
+	return nil
+}
+
+func (r *FlowRecorder) syscall(vm *VM, ev *SyscallEvent) (err error) {
+	switch {
+	case ev.Done && ev.Call == MINIX_fork && ev.Result == 0:
+		// The child graphs only itself, into a file of its own.
+		r.Transfers = make(map[Transfer]uint64)
+		if r.file != "" {
+			r.file = childFile(r.file)
+		}
+	case ev.Done && ev.Call == MINIX_exec && ev.Err == nil:
+		r.Transfers = make(map[Transfer]uint64)
+		r.Entry = vm.ip
+	}
+	return
+}
+
+// CodeMap disassembles the text of the program running in the VM from its
+// entry point and adds the transfers seen so far.
+func (r *FlowRecorder) CodeMap() *CodeMap {
+	vm := r.vm
+	text := append(Bytes{}, vm.CS(0)[:vm.minix.Text]...)
+	data := append(Bytes{}, vm.DS(0)[:vm.minix.Brk]...)
+	m := NewCodeMap(text, data, 0, []uint16{r.Entry}, vm.symbols)
+	m.AddFlow(r.Transfers)
+	return m
+}
+
+// Close writes the graph to the file, if there is one.
+func (r *FlowRecorder) Close() (err error) {
+	if r.file == "" {
+		return
+	}
+	return r.CodeMap().CFG().WriteFile(r.file)
+}
@@ -0,0 +1,128 @@
+package go8086
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func cfgSummary(g *CFG) (lines []string) {
+	for _, f := range g.Functions {
+		blocks := []string{}
+		for _, b := range f.Blocks {
+			blocks = append(blocks, fmt.Sprintf("%04x-%04x", b.Start, b.End))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", f.Name, strings.Join(blocks, " ")))
+		for _, e := range f.Edges {
+			line := fmt.Sprintf("  %04x -> %04x %s", e.From, e.To, e.Kind)
+			if e.Dynamic {
+				line += " dynamic"
+			}
+			if e.Count > 0 {
+				line += fmt.Sprintf(" %d", e.Count)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+func TestCFG(t *testing.T) {
+	m := NewCodeMap(codeMapTestText, nil, 0, []uint16{0}, nil)
+	assert.Equal(t, []string{
+		"start: 0000-0003 0003-0005 0008-0009",
+		"  0000 -> 000b call",
+		"  0000 -> 0003 fallthrough",
+		"  0003 -> 0008 jump",
+		"sub_000b: 000b-0010 0016-0017 0017-0019",
+		"  000b -> 0016 table",
+		"  000b -> 0017 table",
+		"  0016 -> 0003 return",
+		"  0017 -> 0003 return",
+	}, cfgSummary(m.CFG()))
+}
+
+func TestCFGDynamic(t *testing.T) {
+	code, _, err := Assemble(strings.NewReader(`
+	mov cx,2
+	jmp word [0x100]
+	nop
+there:	dec cx
+	jnz there
+	hlt`))
+	assert.Nil(t, err)
+	vm := NewVM()
+	vm.CS(0).write(code)
+	vm.DS(0x100).Write16(0x8)
+	r := NewFlowRecorder()
+	r.Attach(vm)
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, map[Transfer]uint64{{3, 8}: 1, {9, 8}: 1, {9, 0xb}: 1}, r.Transfers)
+
+	m := NewCodeMap(code, nil, 0, []uint16{0}, nil)
+	assert.Equal(t, []string{
+		"start: 0000-0007",
+	}, cfgSummary(m.CFG()))
+	m.AddFlow(r.Transfers)
+	assert.Equal(t, []Xref{{3, XrefDynamic}, {9, XrefBranch}}, m.Xrefs[8])
+	g := m.CFG()
+	assert.Equal(t, []string{
+		"start: 0000-0007 0008-000b 000b-000c",
+		"  0000 -> 0008 jump dynamic 1",
+		"  0008 -> 0008 branch 1",
+		"  0008 -> 000b fallthrough 1",
+	}, cfgSummary(g))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, g.WriteDOT(buf))
+	assert.Equal(t, `digraph cfg {
+	node [shape=box, fontname="monospace"];
+	subgraph "cluster_0000" {
+		label="start";
+		b0000 [label="start:\l0000  mov cx,0x2\l0003  jmp word [0x100]\l"];
+		b0008 [label="loc_0008:\l0008  dec cx\l0009  jnz loc_0008\l"];
+		b000b [label="000b  hlt\l"];
+	}
+	b0000 -> b0008 [color="blue", label="1"];
+	b0008 -> b0008 [color="darkgreen", label="1"];
+	b0008 -> b000b [label="1"];
+}
+`, buf.String())
+
+	buf.Reset()
+	assert.Nil(t, g.WriteJSON(buf))
+	var decoded struct {
+		Functions []struct {
+			Name   string
+			Blocks []struct{ Start, End uint16 }
+			Edges  []struct {
+				Kind    string
+				Dynamic bool
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, "start", decoded.Functions[0].Name)
+	assert.Equal(t, 3, len(decoded.Functions[0].Blocks))
+	assert.Equal(t, "jump", decoded.Functions[0].Edges[0].Kind)
+	assert.True(t, decoded.Functions[0].Edges[0].Dynamic)
+}
+
+func TestFlowRecorderFork(t *testing.T) {
+	r := FlowFile("cfg.json")
+	r.Transfers[Transfer{3, 8}] = 1
+	assert.Nil(t, r.syscall(NewVM(), &SyscallEvent{Call: MINIX_fork, Done: true, Result: 0}))
+	assert.Equal(t, fmt.Sprintf("cfg.%d.json", Pid()), r.file)
+	assert.Equal(t, 0, len(r.Transfers))
+
+	r = FlowFile("cfg.dot")
+	r.Transfers[Transfer{3, 8}] = 1
+	assert.Nil(t, r.syscall(NewVM(), &SyscallEvent{Call: MINIX_fork, Done: true, Result: 42}))
+	assert.Equal(t, "cfg.dot", r.file)
+	assert.Equal(t, 1, len(r.Transfers))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/riywo/go8086"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func assemble(args []string) {
+	fs := flag.NewFlagSet("asm", flag.ExitOnError)
+	out := fs.String("o", "", "write the binary to file (default FILE with the extension .bin)")
+	symbols := fs.String("m", "", "write a symbol map file (\"VALUE [T|D|B|A] NAME\" lines)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go8086 asm [options] FILE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	file := fs.Arg(0)
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	code, table, err := go8086.Assemble(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(file, filepath.Ext(file)) + ".bin"
+	}
+	if err := ioutil.WriteFile(*out, code, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *symbols != "" {
+		w, err := os.Create(*symbols)
+		if err == nil {
+			err = go8086.WriteSymbolMap(w, table)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
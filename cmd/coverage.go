@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/riywo/go8086"
+	"io"
+	"os"
+)
+
+func coverage(args []string) {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	html := fs.Bool("html", false, "write an HTML report")
+	out := fs.String("o", "", "write the report to file instead of stdout")
+	program := fs.String("p", "", "report only this program (default all recorded)")
+	binary := fs.String("b", "", "read the program text from this a.out instead of the recorded path")
+	symbols := fs.String("m", "", "symbol map file (\"VALUE [T|D|B|A] NAME\" lines)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go8086 coverage [options] COVERAGE-FILE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cp, err := go8086.LoadCoverageProfile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	programs := cp.Programs
+	if len(programs) > 1 && *program == "" && (*binary != "" || *html) {
+		fmt.Fprintln(os.Stderr, "several programs are recorded, choose one with -p")
+		os.Exit(2)
+	}
+	if *program != "" {
+		pc, ok := cp.Lookup(*program)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: no coverage of %s\n", fs.Arg(0), *program)
+			os.Exit(2)
+		}
+		programs = []*go8086.ProgramCoverage{pc}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		w = f
+	}
+	for _, pc := range programs {
+		file := pc.Program
+		if *binary != "" {
+			file = *binary
+		}
+		aout, err := go8086.LoadMinixAout(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if !pc.Matches(aout.Text()) {
+			fmt.Fprintf(os.Stderr, "warning: %s is not the program the counts were recorded from\n", file)
+		}
+		syms := aout.Symbols
+		if *symbols != "" {
+			if syms, err = go8086.LoadSymbolMap(*symbols); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+		r := go8086.NewCoverageReport(pc, aout.Text(), syms)
+		if *html {
+			err = r.WriteHTML(w)
+		} else {
+			err = r.WriteText(w)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
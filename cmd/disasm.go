@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/riywo/go8086"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func disasm(args []string) {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	raw := fs.Bool("raw", false, "treat the file as a raw binary even if it is an a.out")
+	start := fs.Uint64("s", 0, "start offset in the text segment or file")
+	end := fs.Uint64("e", 0, "end offset (default the end of the text segment or file)")
+	origin := fs.Uint64("o", 0, "origin address of the first byte, as ndisasm -o")
+	symbols := fs.String("m", "", "symbol map file (\"VALUE [T|D|B|A] NAME\" lines)")
+	plain := fs.Bool("n", false, "no symbols, exactly as ndisasm would print")
+	recursive := fs.Bool("R", false, "follow control flow from the entry points to tell code from data")
+	extra := fs.String("E", "", "more entry points for -R, comma separated")
+	cfg := fs.String("cfg", "", "write the control-flow graph of -R instead of a listing (dot or json)")
+	syntax := fs.String("syntax", "nasm", "syntax (nasm, masm or tasm, att or gas, as86 or ack) and options (upper, hexsuffix, decimal), comma separated")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go8086 disasm [options] FILE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	style, err := go8086.ParseDisasmStyle(*syntax)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	file := fs.Arg(0)
+	code, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var table *go8086.SymbolTable
+	var data go8086.Bytes
+	entries := []uint16{uint16(*origin + *start)}
+	if !*raw && len(code) >= 2 && code[0] == 0x01 && code[1] == 0x03 {
+		aout, err := go8086.LoadMinixAout(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		code, data, table = aout.Text(), aout.Data(), aout.Symbols
+		entries = []uint16{aout.Entry()}
+	}
+	if *symbols != "" {
+		t, err := go8086.LoadSymbolMap(*symbols)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if table == nil {
+			table = go8086.NewSymbolTable()
+		}
+		table.Merge(t)
+	}
+	if *plain {
+		table = nil
+	}
+	if *end == 0 || *end > uint64(len(code)) {
+		*end = uint64(len(code))
+	}
+	if *start > *end {
+		fmt.Fprintf(os.Stderr, "start %#x is past the end %#x\n", *start, *end)
+		os.Exit(2)
+	}
+
+	if *extra != "" {
+		for _, e := range strings.Split(*extra, ",") {
+			addr, err := strconv.ParseUint(e, 0, 16)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "bad entry point: %s\n", e)
+				os.Exit(2)
+			}
+			entries = append(entries, uint16(addr))
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if *cfg != "" && *cfg != "dot" && *cfg != "json" {
+		fmt.Fprintf(os.Stderr, "unknown graph format: %s\n", *cfg)
+		os.Exit(2)
+	}
+	if *recursive || *cfg != "" {
+		m := go8086.NewCodeMap(go8086.Bytes(code), data, uint16(*origin), entries, table)
+		m.Style = &style
+		if *cfg != "" {
+			g := m.CFG()
+			if *cfg == "json" {
+				err = g.WriteJSON(w)
+			} else {
+				err = g.WriteDOT(w)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+		if err := m.Write(w, int(*start), int(*end)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := go8086.WriteListing(w, go8086.Bytes(code), int(*start), int(*end), uint32(*origin), table, &style); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
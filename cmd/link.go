@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/riywo/go8086"
+	"os"
+	"path/filepath"
+)
+
+func link(args []string) {
+	fs := flag.NewFlagSet("link", flag.ExitOnError)
+	out := fs.String("o", "a.out", "write the executable to file")
+	entry := fs.String("e", "", "entry point symbol (default the start of text)")
+	combined := fs.Bool("combined", false, "put text and data in one segment instead of separate I&D")
+	symbols := fs.String("m", "", "write a symbol map file (\"VALUE [T|D|B|A] NAME\" lines)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go8086 link [options] FILE...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	objects := []*go8086.Object{}
+	for _, file := range fs.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		obj, err := go8086.AssembleObject(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			os.Exit(1)
+		}
+		obj.Name = filepath.Base(file)
+		objects = append(objects, obj)
+	}
+	aout, err := go8086.Link(objects, *entry, !*combined)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := aout.Save(*out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *symbols != "" {
+		w, err := os.Create(*symbols)
+		if err == nil {
+			err = go8086.WriteSymbolMap(w, aout.Symbols)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -2,18 +2,102 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/riywo/go8086"
+	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "tracediff" {
+		tracediff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "coverage" {
+		coverage(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		disasm(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "asm" {
+		assemble(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "link" {
+		link(os.Args[2:])
+		return
+	}
+
 	debug := flag.Bool("d", false, "debug")
-	trace := flag.Bool("t", false, "trace")
+	trace := flag.Bool("t", false, "trace syscalls")
 	prefix := flag.String("p", "", "path prefix")
+	snapshot := flag.String("s", "", "start from snapshot")
+	saveSnapshot := flag.String("S", "", "save snapshot")
+	saveAt := flag.Uint64("n", 0, "save snapshot before n-th instruction")
+	record := flag.String("r", "", "record nondeterministic inputs")
+	replay := flag.String("R", "", "replay recorded inputs")
+	debugger := flag.String("D", "", "interactive debugger on terminal (\"-\" for stdin)")
+	gdb := flag.String("g", "", "wait for gdb on host:port or unix:path")
+	symbols := flag.String("m", "", "symbol map file (\"VALUE [T|D|B|A] NAME\" lines)")
+	dap := flag.String("a", "", "serve Debug Adapter Protocol on host:port (\"-\" for stdio)")
+	traceOut := flag.String("T", "", "write a structured trace to file")
+	traceFormat := flag.String("f", "json", "structured trace format (json or binary)")
+	profile := flag.String("P", "", "write a pprof profile of the guest to file")
+	period := flag.Uint64("I", 1, "profile every n-th instruction")
+	cover := flag.String("C", "", "add code coverage counts to file")
+	straceOut := flag.String("o", "", "write the syscall trace to file (implies -t)")
+	straceFilter := flag.String("e", "", "syscalls to trace, comma separated (\"!\" to exclude)")
+	straceTime := flag.Bool("time", false, "show the time spent in each syscall")
+	crashReport := flag.String("crash", "", "also write crash reports to file")
+	core := flag.String("core", "", "write a core file on a crash")
+	memcheck := flag.Bool("memcheck", false, "check memory accesses of the guest")
+	taint := flag.String("taint", "", "taint sources, comma separated (read, read:FD, argv, in)")
+	taintTrace := flag.Bool("taint-trace", false, "show every instruction that moves tainted data")
+	cfg := flag.String("cfg", "", "write the control-flow graph with the jumps taken to file (.json for JSON, DOT otherwise)")
+	syntax := flag.String("syntax", "nasm", "disassembly syntax of -d and -D (nasm, masm, att, as86; upper, hexsuffix, decimal)")
 
 	flag.Parse()
 	go8086.Debug = *debug
 	go8086.Trace = *trace
 	go8086.MinixPathPrefix = *prefix
+	go8086.SnapshotFile = *saveSnapshot
+	go8086.SnapshotAt = *saveAt
+	go8086.RecordLog = *record
+	go8086.ReplayLog = *replay
+	go8086.DebuggerTerminal = *debugger
+	go8086.GDBAddress = *gdb
+	go8086.SymbolMap = *symbols
+	go8086.TraceOutput = *traceOut
+	go8086.TraceFormat = *traceFormat
+	go8086.ProfileOutput = *profile
+	go8086.ProfilePeriod = *period
+	go8086.CoverageOutput = *cover
+	go8086.StraceOutput = *straceOut
+	go8086.StraceFilter = *straceFilter
+	go8086.StraceTiming = *straceTime
+	go8086.CrashReportFile = *crashReport
+	go8086.CoreFile = *core
+	go8086.CheckMemory = *memcheck
+	go8086.TaintSources = *taint
+	go8086.TaintTrace = *taintTrace
+	go8086.CFGOutput = *cfg
+	style, err := go8086.ParseDisasmStyle(*syntax)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	go8086.TraceStyle = style
+
+	if *dap != "" {
+		go8086.RunDAP(*dap)
+		return
+	}
+
+	if *snapshot != "" {
+		go8086.RunSnapshot(*snapshot)
+		return
+	}
 
 	file := flag.Args()[0]
 	args := flag.Args()[0:]
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/riywo/go8086/trace"
+	"os"
+	"strings"
+)
+
+func tracediff(args []string) {
+	fs := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	formats := strings.Join(trace.Importers(), ", ")
+	formatA := fs.String("a", "go8086", "format of the first trace ("+formats+")")
+	formatB := fs.String("b", "go8086", "format of the second trace ("+formats+")")
+	align := fs.String("align", "count", "align by instruction \"count\" or by \"address\"")
+	offsets := fs.Bool("o", false, "compare offsets only, ignoring segments")
+	context := fs.Int("c", 5, "instructions of context around the first divergence")
+	max := fs.Int("max", 20, "later divergences to list")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go8086 tracediff [options] TRACE-A TRACE-B")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	impA, okA := trace.LookupImporter(*formatA)
+	impB, okB := trace.LookupImporter(*formatB)
+	if !okA || !okB {
+		fmt.Fprintf(os.Stderr, "unknown trace format, want one of %s\n", formats)
+		os.Exit(2)
+	}
+	opts := trace.DiffOptionsFor(impA, impB)
+	opts.IgnoreSegments = *offsets
+	switch *align {
+	case "count":
+		opts.Align = trace.AlignCount
+	case "address":
+		opts.Align = trace.AlignAddress
+	default:
+		fmt.Fprintf(os.Stderr, "unknown alignment %q\n", *align)
+		os.Exit(2)
+	}
+
+	a, err := impA.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	b, err := impB.ReadFile(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	report := trace.Diff(a, b, opts)
+	report.Print(os.Stdout, *context, *max)
+	if len(report.Divergences) > 0 {
+		os.Exit(1)
+	}
+}
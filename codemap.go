@@ -0,0 +1,333 @@
+package go8086
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// JumpTableMax is the most entries a jump table is read to.
+var JumpTableMax = 256
+
+type XrefKind int
+
+const (
+	XrefCall XrefKind = iota
+	XrefJump
+	XrefBranch
+	XrefTable
+	XrefDynamic
+)
+
+var xrefKindNames = map[XrefKind]string{
+	XrefCall:    "call",
+	XrefJump:    "jump",
+	XrefBranch:  "branch",
+	XrefTable:   "table",
+	XrefDynamic: "dynamic",
+}
+
+func (k XrefKind) String() string {
+	return xrefKindNames[k]
+}
+
+// Xref is an instruction that transfers control to an address.
+type Xref struct {
+	From uint16
+	Kind XrefKind
+}
+
+// JumpTable is a table of code addresses an indirect JMP or CALL goes
+// through, found in text or, with InData, in the data segment.
+type JumpTable struct {
+	From    uint16
+	Address uint16
+	InData  bool
+	Targets []uint16
+}
+
+// CodeMap is a recursive-descent disassembly: starting from the entry
+// points, it follows jumps, calls and loops, so only bytes that control
+// can reach are decoded as instructions and the rest is data.
+type CodeMap struct {
+	Text    Bytes
+	Data    Bytes
+	Origin  uint16
+	Entries []uint16
+	Ops     map[uint16]*Opcode
+	Labels  map[uint16]string
+	Xrefs   map[uint16][]Xref
+	Tables  []*JumpTable
+	Symbols *SymbolTable
+	Style   *DisasmStyle
+	Flow    map[Transfer]uint64
+	code    []bool
+	table   []bool
+	work    []uint16
+	names   *SymbolTable
+}
+
+// NewCodeMap disassembles text loaded at origin from entries and the text
+// symbols. data is the data segment of a separate I&D program, where jump
+// tables are looked up unless the jump goes through CS; nil means data and
+// text are the same bytes.
+func NewCodeMap(text, data Bytes, origin uint16, entries []uint16, symbols *SymbolTable) (m *CodeMap) {
+	m = &CodeMap{
+		Text:    text,
+		Data:    data,
+		Origin:  origin,
+		Entries: entries,
+		Ops:     make(map[uint16]*Opcode),
+		Labels:  make(map[uint16]string),
+		Xrefs:   make(map[uint16][]Xref),
+		Symbols: symbols,
+		code:    make([]bool, len(text)),
+		table:   make([]bool, len(text)),
+	}
+	for _, sym := range symbols.Symbols() {
+		if sym.Section == SectionText {
+			if _, ok := m.Labels[sym.Value]; !ok {
+				m.Labels[sym.Value] = sym.Name
+			}
+			m.work = append(m.work, sym.Value)
+		}
+	}
+	for i, e := range entries {
+		if _, ok := m.Labels[e]; !ok {
+			if i == 0 {
+				m.Labels[e] = "start"
+			} else {
+				m.Labels[e] = fmt.Sprintf("sub_%04x", e)
+			}
+		}
+		m.work = append(m.work, e)
+	}
+	m.analyze()
+	m.nameLabels()
+	return
+}
+
+// nameLabels names the addresses referred to that have no label yet.
+func (m *CodeMap) nameLabels() {
+	for to, xrefs := range m.Xrefs {
+		sort.Slice(xrefs, func(i, j int) bool { return xrefs[i].From < xrefs[j].From })
+		if _, ok := m.Labels[to]; ok {
+			continue
+		}
+		prefix := "loc"
+		for _, x := range xrefs {
+			if m.isCall(x) {
+				prefix = "sub"
+			}
+		}
+		m.Labels[to] = fmt.Sprintf("%s_%04x", prefix, to)
+	}
+	m.names = NewSymbolTable()
+	for addr, name := range m.Labels {
+		m.names.Add(&Symbol{Name: name, Value: addr, Section: SectionText})
+	}
+}
+
+func (m *CodeMap) isCall(x Xref) bool {
+	return x.Kind == XrefCall || x.Kind == XrefDynamic && m.Ops[x.From].mn == CALL
+}
+
+func (m *CodeMap) index(addr uint16) (i int, ok bool) {
+	i = int(addr - m.Origin)
+	return i, addr >= m.Origin && i < len(m.Text)
+}
+
+func (m *CodeMap) ref(to, from uint16, kind XrefKind) {
+	m.Xrefs[to] = append(m.Xrefs[to], Xref{from, kind})
+	m.work = append(m.work, to)
+}
+
+func (m *CodeMap) analyze() {
+	padded := append(append(Bytes{}, m.Text...), make(Bytes, 8)...)
+	for len(m.work) > 0 {
+		addr := m.work[len(m.work)-1]
+		m.work = m.work[:len(m.work)-1]
+		for m.Ops[addr] == nil {
+			i, ok := m.index(addr)
+			if !ok {
+				break
+			}
+			op := getOpcode(nil, addr, padded[i:])
+			if op.mn == DB || i+len(op.bytes) > len(m.Text) || m.claimed(i, len(op.bytes)) {
+				break
+			}
+			m.Ops[addr] = op
+			for j := i; j < i+len(op.bytes); j++ {
+				m.code[j] = true
+			}
+			if !m.follow(op) {
+				break
+			}
+			addr += uint16(len(op.bytes))
+		}
+	}
+}
+
+func (m *CodeMap) claimed(i, n int) bool {
+	for j := i; j < i+n; j++ {
+		if m.code[j] || m.table[j] {
+			return true
+		}
+	}
+	return false
+}
+
+// follow queues where op goes and tells whether control also falls
+// through to the next instruction.
+func (m *CodeMap) follow(op *Opcode) bool {
+	target, direct := op.Target()
+	switch {
+	case op.mn == CALL:
+		if direct {
+			m.ref(target, op.address, XrefCall)
+		} else {
+			m.jumpTable(op)
+		}
+	case op.mn == JMP:
+		if direct {
+			m.ref(target, op.address, XrefJump)
+		} else {
+			m.jumpTable(op)
+		}
+		return false
+	case isBranch(op.mn):
+		m.ref(target, op.address, XrefBranch)
+	case op.mn == RET || op.mn == RETF || op.mn == IRET || op.mn == HLT:
+		return false
+	}
+	return true
+}
+
+// jumpTable reads the table of an indirect jump through [reg+table]: the
+// words at table that are addresses in text, up to the first one that is
+// not.
+func (m *CodeMap) jumpTable(op *Opcode) {
+	mem, ok := op.opr1.(*Memory)
+	if !ok || mem.regad == RegAdd_Direct || mem.disp == nil || mem.disp.w != Bit16 || mem.w != Bit16 {
+		return
+	}
+	t := &JumpTable{From: op.address, Address: mem.disp.value, InData: m.Data != nil && mem.sreg != CS}
+	for n := 0; n < JumpTableMax; n++ {
+		offset := t.Address + uint16(2*n)
+		var word uint16
+		if t.InData {
+			if int(offset)+2 > len(m.Data) {
+				break
+			}
+			word = m.Data[offset:].Read16()
+		} else {
+			i, ok := m.index(offset)
+			if !ok || i+2 > len(m.Text) || m.claimed(i, 2) {
+				break
+			}
+			word = m.Text[i:].Read16()
+		}
+		if i, ok := m.index(word); !ok || m.table[i] {
+			break
+		}
+		t.Targets = append(t.Targets, word)
+	}
+	if len(t.Targets) < 2 {
+		return
+	}
+	if !t.InData {
+		i, _ := m.index(t.Address)
+		for j := i; j < i+2*len(t.Targets); j++ {
+			m.table[j] = true
+		}
+	}
+	m.Tables = append(m.Tables, t)
+	for _, target := range t.Targets {
+		m.ref(target, op.address, XrefTable)
+	}
+}
+
+// IsCode tells whether the byte at addr is part of a decoded instruction.
+func (m *CodeMap) IsCode(addr uint16) bool {
+	i, ok := m.index(addr)
+	return ok && m.code[i]
+}
+
+func (m *CodeMap) label(w io.Writer, addr uint16) (err error) {
+	name, ok := m.Labels[addr]
+	if !ok {
+		return
+	}
+	refs := []string{}
+	for _, x := range m.Xrefs[addr] {
+		refs = append(refs, fmt.Sprintf("%s %04x", x.Kind, x.From))
+	}
+	if len(refs) > 0 {
+		_, err = fmt.Fprintf(w, "%-26s; xref: %s\n", name+":", strings.Join(refs, ", "))
+	} else {
+		_, err = fmt.Fprintf(w, "%s:\n", name)
+	}
+	return
+}
+
+func (m *CodeMap) word(v uint16) string {
+	if name, ok := m.Labels[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#04x", v)
+}
+
+// Write lists text[start:end] in the layout of WriteListing, with labels,
+// cross-references, jump tables as dw and other data as db. Tables in the
+// data segment follow the text.
+func (m *CodeMap) Write(w io.Writer, start, end int) (err error) {
+	for i := start; i < end; {
+		addr := m.Origin + uint16(i)
+		if err = m.label(w, addr); err != nil {
+			return
+		}
+		line := ""
+		switch {
+		case m.Ops[addr] != nil:
+			op := m.Ops[addr].WithSymbols(m.names)
+			line = ListingLine(uint32(addr), op.bytes, op.DisasmStyle(m.Style))
+			i += len(op.bytes)
+		case m.table[i]:
+			line = ListingLine(uint32(addr), m.Text[i:i+2], "dw "+m.word(m.Text[i:].Read16()))
+			i += 2
+		default:
+			n := 1
+			for n < ListingBytes && i+n < end && !m.code[i+n] && !m.table[i+n] {
+				if _, ok := m.Labels[addr+uint16(n)]; ok {
+					break
+				}
+				n++
+			}
+			bs := []string{}
+			for _, b := range m.Text[i : i+n] {
+				bs = append(bs, fmt.Sprintf("%#02x", b))
+			}
+			line = ListingLine(uint32(addr), m.Text[i:i+n], "db "+strings.Join(bs, ","))
+			i += n
+		}
+		if _, err = io.WriteString(w, line); err != nil {
+			return
+		}
+	}
+	for _, t := range m.Tables {
+		if !t.InData {
+			continue
+		}
+		if _, err = fmt.Fprintf(w, "; jump table in data at %04x, used by %04x\n", t.Address, t.From); err != nil {
+			return
+		}
+		for n, target := range t.Targets {
+			offset := t.Address + uint16(2*n)
+			if _, err = io.WriteString(w, ListingLine(uint32(offset), m.Data[offset:offset+2], "dw "+m.word(target))); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var codeMapTestText = Bytes{
+	0xe8, 0x08, 0x00, // call sub_000b
+	0xeb, 0x03, // jmp short loc_0008
+	0x68, 0x69, 0x00, // data
+	0xf4,       // hlt
+	0x00, 0x00, // never reached
+	0x2e, 0xff, 0xa7, 0x12, 0x00, // jmp [cs:bx+0x12]
+	0x00, 0x00, // never reached
+	0x16, 0x00, 0x17, 0x00, // jump table
+	0xc3, // ret
+	0x40, // inc ax
+	0xc3, // ret
+}
+
+func TestCodeMap(t *testing.T) {
+	m := NewCodeMap(codeMapTestText, nil, 0, []uint16{0}, nil)
+	buf := new(bytes.Buffer)
+	assert.Nil(t, m.Write(buf, 0, len(codeMapTestText)))
+	assert.Equal(t, "start:\n"+
+		"00000000  E80800            call sub_000b\n"+
+		"00000003  EB03              jmp short loc_0008\n"+
+		"00000005  686900            db 0x68,0x69,0x00\n"+
+		"loc_0008:                 ; xref: jump 0003\n"+
+		"00000008  F4                hlt\n"+
+		"00000009  0000              db 0x00,0x00\n"+
+		"sub_000b:                 ; xref: call 0000\n"+
+		"0000000B  2EFFA71200        jmp word [cs:bx+0x12]\n"+
+		"00000010  0000              db 0x00,0x00\n"+
+		"00000012  1600              dw loc_0016\n"+
+		"00000014  1700              dw loc_0017\n"+
+		"loc_0016:                 ; xref: table 000b\n"+
+		"00000016  C3                ret\n"+
+		"loc_0017:                 ; xref: table 000b\n"+
+		"00000017  40                inc ax\n"+
+		"00000018  C3                ret\n", buf.String())
+	assert.True(t, m.IsCode(0x18))
+	assert.False(t, m.IsCode(0x5))
+	assert.Equal(t, []Xref{{0xb, XrefTable}}, m.Xrefs[0x17])
+}
+
+func TestCodeMapDataTable(t *testing.T) {
+	symbols := NewSymbolTable()
+	symbols.Add(&Symbol{Name: "_main", Value: 0x0, Section: SectionText})
+	text := Bytes{
+		0xff, 0xa7, 0x02, 0x00, // jmp [bx+0x2]
+		0x40, // inc ax
+		0xc3, // ret
+	}
+	data := Bytes{0xff, 0xff, 0x04, 0x00, 0x05, 0x00, 0x00, 0x10}
+	m := NewCodeMap(text, data, 0, nil, symbols)
+	assert.Equal(t, 1, len(m.Tables))
+	assert.Equal(t, &JumpTable{From: 0, Address: 2, InData: true, Targets: []uint16{4, 5}}, m.Tables[0])
+	assert.Equal(t, "_main", m.Labels[0])
+	assert.Equal(t, "loc_0004", m.Labels[4])
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, m.Write(buf, 4, len(text)))
+	assert.Equal(t, "loc_0004:                 ; xref: table 0000\n"+
+		"00000004  40                inc ax\n"+
+		"loc_0005:                 ; xref: table 0000\n"+
+		"00000005  C3                ret\n"+
+		"; jump table in data at 0002, used by 0000\n"+
+		"00000002  0400              dw loc_0004\n"+
+		"00000004  0500              dw loc_0005\n", buf.String())
+}
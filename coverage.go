@@ -0,0 +1,320 @@
+package go8086
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const coverageHeader = "go8086 coverage"
+
+var ErrCoverageFormat = errors.New("not a go8086 coverage file")
+
+// ProgramCoverage holds the execution counts of one program by text
+// offset. Taken and NotTaken count the directions of conditional jumps
+// and loops.
+type ProgramCoverage struct {
+	Program  string
+	Size     int
+	Checksum uint32
+	Hits     map[uint16]uint64
+	Taken    map[uint16]uint64
+	NotTaken map[uint16]uint64
+}
+
+func NewProgramCoverage(program string, text Bytes) *ProgramCoverage {
+	pc := &ProgramCoverage{
+		Program:  program,
+		Hits:     make(map[uint16]uint64),
+		Taken:    make(map[uint16]uint64),
+		NotTaken: make(map[uint16]uint64),
+	}
+	if text != nil {
+		pc.Size, pc.Checksum = len(text), crc32.ChecksumIEEE(text)
+	}
+	return pc
+}
+
+// Matches tells whether text is the text the counts were recorded from.
+func (pc *ProgramCoverage) Matches(text Bytes) bool {
+	return pc.Size == len(text) && pc.Checksum == crc32.ChecksumIEEE(text)
+}
+
+func (pc *ProgramCoverage) same(other *ProgramCoverage) bool {
+	return pc.Size == other.Size && pc.Checksum == other.Checksum
+}
+
+// Merge adds the counts of other, which must be of the same program.
+func (pc *ProgramCoverage) Merge(other *ProgramCoverage) {
+	for ip, n := range other.Hits {
+		pc.Hits[ip] += n
+	}
+	for ip, n := range other.Taken {
+		pc.Taken[ip] += n
+	}
+	for ip, n := range other.NotTaken {
+		pc.NotTaken[ip] += n
+	}
+}
+
+// CoverageProfile is the content of a coverage file: the counts of every
+// program run, including ones started by exec.
+type CoverageProfile struct {
+	Programs []*ProgramCoverage
+}
+
+func (cp *CoverageProfile) Lookup(program string) (*ProgramCoverage, bool) {
+	for _, pc := range cp.Programs {
+		if pc.Program == program {
+			return pc, true
+		}
+	}
+	return nil, false
+}
+
+// Merge adds the counts of other. A program whose text changed since it
+// was last recorded starts over, since old offsets no longer mean anything.
+func (cp *CoverageProfile) Merge(other *CoverageProfile) {
+	for _, o := range other.Programs {
+		pc, ok := cp.Lookup(o.Program)
+		switch {
+		case !ok:
+			pc = NewProgramCoverage(o.Program, nil)
+			pc.Size, pc.Checksum = o.Size, o.Checksum
+			cp.Programs = append(cp.Programs, pc)
+		case !pc.same(o):
+			*pc = *NewProgramCoverage(o.Program, nil)
+			pc.Size, pc.Checksum = o.Size, o.Checksum
+		}
+		pc.Merge(o)
+	}
+}
+
+// Write writes the profile as text:
+//
+//	go8086 coverage
+//	program CHECKSUM SIZE PATH
+//	OFFSET COUNT TAKEN NOT-TAKEN
+func (cp *CoverageProfile) Write(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, coverageHeader)
+	for _, pc := range cp.Programs {
+		fmt.Fprintf(bw, "program %08x %d %s\n", pc.Checksum, pc.Size, pc.Program)
+		ips := []int{}
+		for ip := range pc.Hits {
+			ips = append(ips, int(ip))
+		}
+		sort.Ints(ips)
+		for _, ip := range ips {
+			a := uint16(ip)
+			fmt.Fprintf(bw, "%04x %d %d %d\n", a, pc.Hits[a], pc.Taken[a], pc.NotTaken[a])
+		}
+	}
+	return bw.Flush()
+}
+
+func ReadCoverageProfile(r io.Reader) (cp *CoverageProfile, err error) {
+	cp = &CoverageProfile{}
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		return cp, s.Err()
+	}
+	if s.Text() != coverageHeader {
+		return nil, ErrCoverageFormat
+	}
+	var pc *ProgramCoverage
+	for line := 2; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "program" && len(fields) >= 4:
+			sum, err1 := strconv.ParseUint(fields[1], 16, 32)
+			size, err2 := strconv.Atoi(fields[2])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("line %d: %v", line, ErrCoverageFormat)
+			}
+			name := strings.SplitN(s.Text(), " ", 4)[3]
+			pc = NewProgramCoverage(name, nil)
+			pc.Size, pc.Checksum = size, uint32(sum)
+			cp.Programs = append(cp.Programs, pc)
+		case len(fields) == 4 && pc != nil:
+			ns := []uint64{}
+			for i, f := range fields {
+				n, err := strconv.ParseUint(f, 10, 64)
+				if i == 0 {
+					n, err = strconv.ParseUint(f, 16, 16)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, ErrCoverageFormat)
+				}
+				ns = append(ns, n)
+			}
+			ip := uint16(ns[0])
+			pc.Hits[ip] += ns[1]
+			if ns[2] > 0 {
+				pc.Taken[ip] += ns[2]
+			}
+			if ns[3] > 0 {
+				pc.NotTaken[ip] += ns[3]
+			}
+		default:
+			return nil, fmt.Errorf("line %d: %v", line, ErrCoverageFormat)
+		}
+	}
+	return cp, s.Err()
+}
+
+func LoadCoverageProfile(file string) (cp *CoverageProfile, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if cp, err = ReadCoverageProfile(f); err != nil {
+		err = fmt.Errorf("%s: %v", file, err)
+	}
+	return
+}
+
+// Coverage counts the instructions executed and the branch directions
+// taken by a guest, per program.
+type Coverage struct {
+	Profile *CoverageProfile
+	vm      *VM
+	ids     []HookID
+	current *ProgramCoverage
+	exec    string
+	ip      uint16
+	file    string
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{Profile: &CoverageProfile{}}
+}
+
+// CoverageFile returns a coverage recorder that adds its counts to file on
+// Close. Every process of a run, and every run, accumulates into the same
+// file.
+func CoverageFile(file string) *Coverage {
+	c := NewCoverage()
+	c.file = file
+	return c
+}
+
+// Start switches to counting program, an a.out whose text is used to tell
+// whether older counts of it are still valid.
+func (c *Coverage) Start(program string) {
+	var text Bytes
+	if aout, err := LoadMinixAout(program); err == nil {
+		text = aout.text
+	}
+	if abs, err := filepath.Abs(program); err == nil {
+		program = abs
+	}
+	pc, ok := c.Profile.Lookup(program)
+	if !ok {
+		pc = NewProgramCoverage(program, text)
+		c.Profile.Programs = append(c.Profile.Programs, pc)
+	}
+	c.current = pc
+}
+
+func (c *Coverage) Attach(vm *VM) {
+	c.vm = vm
+	if c.current == nil {
+		c.Start("guest")
+	}
+	c.ids = []HookID{
+		vm.AddBeforeInstructionHook(c.before),
+		vm.AddAfterInstructionHook(c.after),
+		vm.AddSyscallHook(c.syscall),
+	}
+}
+
+func (c *Coverage) Detach() {
+	for _, id := range c.ids {
+		c.vm.RemoveHook(id)
+	}
+	c.ids = nil
+}
+
+func isBranch(mn Mnemonic) bool {
+	return isConditionalJump(mn) || conditionalCycles[mn] != [2]int{}
+}
+
+func (c *Coverage) before(vm *VM, op *Opcode) error {
+	c.ip = vm.ip
+	c.current.Hits[c.ip]++
+	return nil
+}
+
+func (c *Coverage) after(vm *VM, op *Opcode) error {
+	if !isBranch(op.mn) {
+		return nil
+	}
+	if vm.ip != c.ip+uint16(len(op.bytes)) {
+		c.current.Taken[c.ip]++
+	} else {
+		c.current.NotTaken[c.ip]++
+	}
+	return nil
+}
+
+func (c *Coverage) syscall(vm *VM, ev *SyscallEvent) (err error) {
+	switch {
+	case !ev.Done && ev.Call == MINIX_exec:
+		// The name is gone from memory once the new image is loaded.
+		name := SyscallArgs(vm, ev.Call, ev.Message)[0]
+		c.exec = WithMinixPathPrefix(name.Text)
+	case ev.Done && ev.Call == MINIX_exec && ev.Err == nil:
+		c.Start(c.exec)
+	case ev.Done && ev.Call == MINIX_fork && ev.Result == 0:
+		// The parent adds what ran before the fork; the child only its own.
+		c.Profile = &CoverageProfile{}
+		c.Start(c.current.Program)
+	}
+	return
+}
+
+// Close adds the counts to the coverage file, if there is one. The file
+// is locked so processes exiting together do not lose each other's counts.
+func (c *Coverage) Close() (err error) {
+	if c.file == "" {
+		return
+	}
+	f, err := os.OpenFile(c.file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	cp, err := ReadCoverageProfile(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.file, err)
+	}
+	cp.Merge(c.Profile)
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if err = f.Truncate(0); err != nil {
+		return
+	}
+	if err = cp.Write(f); err != nil {
+		return
+	}
+	// Counts are in the file now; do not add them twice.
+	c.Profile = &CoverageProfile{}
+	c.Start(c.current.Program)
+	return
+}
@@ -0,0 +1,284 @@
+package go8086
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CoverageLine is one instruction of an annotated disassembly.
+type CoverageLine struct {
+	Address  uint16
+	Bytes    Bytes
+	Asm      string
+	Label    string
+	Count    uint64
+	Branch   bool
+	Taken    uint64
+	NotTaken uint64
+}
+
+func (l *CoverageLine) Partial() bool {
+	return l.Branch && l.Count > 0 && (l.Taken == 0 || l.NotTaken == 0)
+}
+
+// Missing tells which direction of a branch never happened.
+func (l *CoverageLine) Missing() string {
+	switch {
+	case !l.Branch:
+		return ""
+	case l.Count == 0:
+		return "never executed"
+	case l.Taken == 0:
+		return "never taken"
+	case l.NotTaken == 0:
+		return "always taken"
+	}
+	return ""
+}
+
+type FunctionCoverage struct {
+	Name            string
+	Address         uint16
+	Instructions    int
+	Covered         int
+	Branches        int
+	BranchesCovered int
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func (f *FunctionCoverage) add(l *CoverageLine) {
+	f.Instructions++
+	if l.Count > 0 {
+		f.Covered++
+	}
+	if l.Branch {
+		f.Branches += 2
+		if l.Taken > 0 {
+			f.BranchesCovered++
+		}
+		if l.NotTaken > 0 {
+			f.BranchesCovered++
+		}
+	}
+}
+
+func (f *FunctionCoverage) InstructionPercent() float64 {
+	return percent(f.Covered, f.Instructions)
+}
+
+func (f *FunctionCoverage) BranchPercent() float64 {
+	return percent(f.BranchesCovered, f.Branches)
+}
+
+// CoverageReport annotates the disassembly of a program with its counts.
+// The total is kept as a FunctionCoverage named after the program.
+type CoverageReport struct {
+	Program   string
+	Lines     []*CoverageLine
+	Functions []*FunctionCoverage
+	Total     *FunctionCoverage
+}
+
+// NewCoverageReport disassembles text linearly, resynchronizing on any
+// executed address that falls inside an instruction, so code reached only
+// past data is still shown right. Functions are the text symbols, or the
+// entry and the targets of direct calls when there are none.
+func NewCoverageReport(pc *ProgramCoverage, text Bytes, symbols *SymbolTable) (r *CoverageReport) {
+	r = &CoverageReport{
+		Program: pc.Program,
+		Total:   &FunctionCoverage{Name: pc.Program},
+	}
+	hits := []int{}
+	for ip := range pc.Hits {
+		if int(ip) < len(text) {
+			hits = append(hits, int(ip))
+		}
+	}
+	sort.Ints(hits)
+	padded := append(append(Bytes{}, text...), make(Bytes, 8)...)
+	entries := map[uint16]bool{0: true}
+	for addr, h := 0, 0; addr < len(text); {
+		op := getOpcode(nil, uint16(addr), padded[addr:]).WithSymbols(symbols)
+		size := len(op.bytes)
+		for h < len(hits) && hits[h] <= addr {
+			h++
+		}
+		if h < len(hits) && hits[h] < addr+size {
+			// Only the bytes up to the executed address are data here.
+			size = hits[h] - addr
+			op = &Opcode{mn: DB, bytes: padded[addr:hits[h]], address: uint16(addr)}
+		}
+		if addr+size > len(text) {
+			size = len(text) - addr
+		}
+		ip := uint16(addr)
+		l := &CoverageLine{
+			Address:  ip,
+			Bytes:    padded[addr : addr+size],
+			Asm:      op.Disasm(),
+			Count:    pc.Hits[ip],
+			Branch:   isBranch(op.mn),
+			Taken:    pc.Taken[ip],
+			NotTaken: pc.NotTaken[ip],
+		}
+		if op.mn == DB && size > 1 {
+			l.Asm = fmt.Sprintf("db %d bytes", size)
+		}
+		if op.mn == CALL && isImmediate(op.opr1) {
+			entries[ip+uint16(size)+op.opr1.(*Immediate).value] = true
+		}
+		r.Lines = append(r.Lines, l)
+		addr += size
+	}
+
+	var f *FunctionCoverage
+	for _, l := range r.Lines {
+		name := ""
+		if sym, ok := symbols.At(l.Address); ok {
+			name = sym.Name
+		} else if symbols.Len() == 0 && entries[l.Address] {
+			name = fmt.Sprintf("sub_%04x", l.Address)
+		}
+		if name != "" {
+			l.Label = name
+			f = &FunctionCoverage{Name: name, Address: l.Address}
+			r.Functions = append(r.Functions, f)
+		}
+		if f != nil {
+			f.add(l)
+		}
+		r.Total.add(l)
+	}
+	return
+}
+
+// UncoveredBranches returns the branches missing a direction.
+func (r *CoverageReport) UncoveredBranches() (ls []*CoverageLine) {
+	for _, l := range r.Lines {
+		if l.Missing() != "" {
+			ls = append(ls, l)
+		}
+	}
+	return
+}
+
+func (r *CoverageReport) function(ip uint16) string {
+	name := ""
+	for _, f := range r.Functions {
+		if f.Address > ip {
+			break
+		}
+		name = f.Name
+		if ip > f.Address {
+			name += fmt.Sprintf("+%#x", ip-f.Address)
+		}
+	}
+	return name
+}
+
+func (l *CoverageLine) count() string {
+	if l.Count == 0 {
+		return "-"
+	}
+	return fmt.Sprint(l.Count)
+}
+
+func (l *CoverageLine) branch() string {
+	if !l.Branch || l.Count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("taken %d, not taken %d", l.Taken, l.NotTaken)
+}
+
+func (r *CoverageReport) WriteText(w io.Writer) (err error) {
+	t := r.Total
+	fmt.Fprintf(w, "%s: %.1f%% of %d instructions, %.1f%% of %d branch directions\n\n",
+		r.Program, t.InstructionPercent(), t.Instructions, t.BranchPercent(), t.Branches)
+	fmt.Fprintf(w, "%-24s %22s %22s\n", "function", "instructions", "branches")
+	for _, f := range r.Functions {
+		fmt.Fprintf(w, "%-24s %11d/%-4d%5.1f%% %11d/%-4d%5.1f%%\n", f.Name,
+			f.Covered, f.Instructions, f.InstructionPercent(), f.BranchesCovered, f.Branches, f.BranchPercent())
+	}
+	if ls := r.UncoveredBranches(); len(ls) > 0 {
+		fmt.Fprintf(w, "\nuncovered branches:\n")
+		for _, l := range ls {
+			fmt.Fprintf(w, "  %04x %-20s %-24s %s\n", l.Address, r.function(l.Address), l.Asm, l.Missing())
+		}
+	}
+	fmt.Fprintln(w)
+	for _, l := range r.Lines {
+		if l.Label != "" {
+			fmt.Fprintf(w, "%s:\n", l.Label)
+		}
+		note := l.branch()
+		if m := l.Missing(); m != "" && l.Count > 0 {
+			note += "; " + m
+		}
+		line := fmt.Sprintf("%10s  %04x  %-14s %-28s %s", l.count(), l.Address, hexBytes(l.Bytes), l.Asm, note)
+		if _, err = fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func hexBytes(bs Bytes) string {
+	if len(bs) > 6 {
+		return fmt.Sprintf("%x..", []byte(bs[:6]))
+	}
+	return fmt.Sprintf("%x", []byte(bs))
+}
+
+var coverageHTML = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"hex": hexBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Program}} coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 1px 8px; text-align: left; }
+td.n { text-align: right; }
+pre, .asm td { font-family: monospace; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.partial { background: #ffc; }
+.label td { font-weight: bold; padding-top: 8px; }
+</style>
+</head>
+<body>
+<h1>{{.Program}}</h1>
+<p>{{printf "%.1f" .Total.InstructionPercent}}% of {{.Total.Instructions}} instructions,
+{{printf "%.1f" .Total.BranchPercent}}% of {{.Total.Branches}} branch directions covered.</p>
+<h2>Functions</h2>
+<table>
+<tr><th>function</th><th>instructions</th><th></th><th>branches</th><th></th></tr>
+{{range .Functions}}<tr><td><a href="#{{.Name}}">{{.Name}}</a></td>
+<td class="n">{{.Covered}}/{{.Instructions}}</td><td class="n">{{printf "%.1f" .InstructionPercent}}%</td>
+<td class="n">{{.BranchesCovered}}/{{.Branches}}</td><td class="n">{{printf "%.1f" .BranchPercent}}%</td></tr>
+{{end}}</table>
+<h2>Disassembly</h2>
+<table class="asm">
+{{range .Lines}}{{if .Label}}<tr class="label" id="{{.Label}}"><td colspan="5">{{.Label}}:</td></tr>
+{{end}}<tr class="{{if .Partial}}partial{{else if .Count}}hit{{else}}miss{{end}}">
+<td class="n">{{if .Count}}{{.Count}}{{else}}-{{end}}</td><td>{{printf "%04x" .Address}}</td><td>{{hex .Bytes}}</td><td>{{.Asm}}</td>
+<td>{{if and .Branch .Count}}taken {{.Taken}}, not taken {{.NotTaken}}{{end}}{{if .Partial}}; {{.Missing}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (r *CoverageReport) WriteHTML(w io.Writer) error {
+	return coverageHTML.Execute(w, r)
+}
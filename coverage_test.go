@@ -0,0 +1,122 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var coverageTestText = Bytes{
+	0xb9, 0x02, 0x00, // mov cx,0x2
+	0xe8, 0x04, 0x00, // call 0xa
+	0xe2, 0xfb, // loop 0x3
+	0xeb, 0xfe, // jmp 0x8
+	0x83, 0xf9, 0x01, // cmp cx,byte +0x1
+	0x74, 0x01, // jz 0x10
+	0x42, // inc dx
+	0xc3, // ret
+	0x40, // inc ax
+}
+
+func runCoverageTest(t *testing.T, c *Coverage) {
+	vm := NewVM()
+	vm.CS(0).write(coverageTestText)
+	c.Attach(vm)
+	for i := 0; i < 13; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	c.Detach()
+}
+
+func TestCoverage(t *testing.T) {
+	c := NewCoverage()
+	runCoverageTest(t, c)
+	pc := c.current
+	assert.Equal(t, uint64(1), pc.Hits[0x0])
+	assert.Equal(t, uint64(2), pc.Hits[0xa])
+	assert.Equal(t, uint64(1), pc.Hits[0xf])
+	assert.Equal(t, uint64(0), pc.Hits[0x11])
+	assert.Equal(t, uint64(1), pc.Taken[0xd])
+	assert.Equal(t, uint64(1), pc.NotTaken[0xd])
+	assert.Equal(t, uint64(1), pc.Taken[0x6])
+	assert.Equal(t, uint64(1), pc.NotTaken[0x6])
+
+	r := NewCoverageReport(pc, coverageTestText, nil)
+	assert.Equal(t, 9, r.Total.Instructions)
+	assert.Equal(t, 8, r.Total.Covered)
+	assert.Equal(t, 4, r.Total.BranchesCovered)
+	assert.Equal(t, 2, len(r.Functions))
+	assert.Equal(t, "sub_000a", r.Functions[1].Name)
+	assert.Equal(t, 4, r.Functions[1].Covered)
+	assert.Equal(t, 0, len(r.UncoveredBranches()))
+
+	delete(pc.Taken, 0xd)
+	symbols := NewSymbolTable()
+	symbols.Add(&Symbol{Name: "_main", Value: 0x0, Section: SectionText})
+	symbols.Add(&Symbol{Name: "_f", Value: 0xa, Section: SectionText})
+	r = NewCoverageReport(pc, coverageTestText, symbols)
+	assert.Equal(t, "_f", r.Functions[1].Name)
+	assert.Equal(t, 50.0, r.Functions[1].BranchPercent())
+	ls := r.UncoveredBranches()
+	assert.Equal(t, 1, len(ls))
+	assert.Equal(t, "never taken", ls[0].Missing())
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, r.WriteText(buf))
+	assert.Contains(t, buf.String(), "000d _f+0x3")
+	assert.Contains(t, buf.String(), "jz 0x10")
+	buf.Reset()
+	assert.Nil(t, r.WriteHTML(buf))
+	assert.Contains(t, buf.String(), `<tr class="partial">`)
+}
+
+func TestCoverageReportResync(t *testing.T) {
+	// Execution enters the middle of the mov, so its first byte is data.
+	text := Bytes{0xb8, 0x90, 0xc3}
+	pc := NewProgramCoverage("p", text)
+	pc.Hits[0x1] = 1
+	r := NewCoverageReport(pc, text, nil)
+	assert.Equal(t, 3, len(r.Lines))
+	assert.Equal(t, "db 0xb8", r.Lines[0].Asm)
+	assert.Equal(t, "nop", r.Lines[1].Asm)
+}
+
+func TestCoverageProfile(t *testing.T) {
+	f, err := ioutil.TempFile("", "go8086-coverage")
+	assert.Nil(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	for i := 0; i < 2; i++ {
+		c := CoverageFile(f.Name())
+		c.Start("my program")
+		runCoverageTest(t, c)
+		assert.Nil(t, c.Close())
+		assert.Nil(t, c.Close())
+	}
+	cp, err := LoadCoverageProfile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cp.Programs))
+	pc := cp.Programs[0]
+	assert.Contains(t, pc.Program, "my program")
+	assert.Equal(t, uint64(4), pc.Hits[0xa])
+	assert.Equal(t, uint64(2), pc.Taken[0xd])
+
+	// A rebuilt program starts over.
+	other := &CoverageProfile{}
+	o := NewProgramCoverage(pc.Program, Bytes{0x90})
+	o.Hits[0x0] = 1
+	other.Programs = append(other.Programs, o)
+	cp.Merge(other)
+	assert.Equal(t, 1, len(cp.Programs[0].Hits))
+
+	_, err = ReadCoverageProfile(bytes.NewBufferString("mode: set\n"))
+	assert.Equal(t, ErrCoverageFormat, err)
+
+	// Only the offset is hex, even when a count reads the same.
+	cp, err = ReadCoverageProfile(bytes.NewBufferString(coverageHeader + "\nprogram 0 1 p\n1000 1000 0 0\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), cp.Programs[0].Hits[0x1000])
+}
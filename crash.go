@@ -0,0 +1,354 @@
+package go8086
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/riywo/go8086/trace"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CrashHistory is how many of the last instructions a crash report shows.
+var CrashHistory = 32
+
+// CrashSyscalls is how many of the last syscalls a crash report shows.
+var CrashSyscalls = 8
+
+// Fault stops the VM at an instruction it cannot run. Like a CPU fault it
+// leaves IP at the instruction.
+type Fault struct {
+	Reason string
+	CS, IP uint16
+	Op     *Opcode
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%s at %04x:%04x", f.Reason, f.CS, f.IP)
+}
+
+func (vm *VM) fault(op *Opcode, format string, a ...interface{}) {
+	vm.stop(&Fault{Reason: fmt.Sprintf(format, a...), CS: vm.sreg["cs"], IP: vm.ip, Op: op})
+}
+
+type crashEntry struct {
+	count  uint64
+	cs, ip uint16
+	op     *Opcode
+}
+
+// CrashRecorder keeps what a crash report needs and the VM does not: the
+// last instructions, in a ring buffer, and the last syscalls.
+type CrashRecorder struct {
+	vm       *VM
+	ids      []HookID
+	ring     []crashEntry
+	next     int
+	full     bool
+	syscalls []*trace.Record
+	pending  *trace.Record
+}
+
+func NewCrashRecorder(history int) *CrashRecorder {
+	return &CrashRecorder{ring: make([]crashEntry, history)}
+}
+
+func (r *CrashRecorder) Attach(vm *VM) {
+	r.vm = vm
+	r.ids = []HookID{vm.AddSyscallHook(r.syscall)}
+	if len(r.ring) > 0 {
+		r.ids = append(r.ids, vm.AddBeforeInstructionHook(r.before))
+	}
+}
+
+func (r *CrashRecorder) Detach() {
+	for _, id := range r.ids {
+		r.vm.RemoveHook(id)
+	}
+	r.ids = nil
+}
+
+func (r *CrashRecorder) before(vm *VM, op *Opcode) error {
+	r.ring[r.next] = crashEntry{vm.count, vm.sreg["cs"], vm.ip, op}
+	if r.next++; r.next == len(r.ring) {
+		r.next, r.full = 0, true
+	}
+	return nil
+}
+
+func (r *CrashRecorder) syscall(vm *VM, ev *SyscallEvent) error {
+	if !ev.Done {
+		r.pending = &trace.Record{
+			Kind:  trace.Syscall,
+			Count: vm.count - 1,
+			CS:    vm.sreg["cs"],
+			IP:    vm.ip - 2,
+			Syscall: &trace.SyscallInfo{
+				Call: int(ev.Call),
+				Name: ev.Call.String(),
+				Args: SyscallArgs(vm, ev.Call, ev.Message),
+			},
+		}
+		return nil
+	}
+	if r.pending == nil {
+		return nil
+	}
+	result := ev.Result
+	r.pending.Syscall.Result = &result
+	if ev.Err != nil {
+		r.pending.Syscall.Err = ev.Err.Error()
+	}
+	r.syscalls = append(r.syscalls, r.pending)
+	if len(r.syscalls) > CrashSyscalls {
+		r.syscalls = r.syscalls[1:]
+	}
+	r.pending = nil
+	return nil
+}
+
+// history returns the recorded instructions, oldest first.
+func (r *CrashRecorder) history() (entries []crashEntry) {
+	if r.full {
+		entries = append(entries, r.ring[r.next:]...)
+	}
+	return append(entries, r.ring[:r.next]...)
+}
+
+// CrashReport describes the state of a VM that stopped with an error.
+type CrashReport struct {
+	Reason   string
+	Program  string
+	vm       *VM
+	recorder *CrashRecorder
+}
+
+// NewCrashReport reports err, which stopped vm. vm may be nil when the
+// program could not even be loaded, and recorder nil when nothing was
+// recorded.
+func NewCrashReport(vm *VM, err error, recorder *CrashRecorder) *CrashReport {
+	return &CrashReport{Reason: err.Error(), Program: runProgram, vm: vm, recorder: recorder}
+}
+
+func (r *CrashReport) Write(w io.Writer) (err error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "=== go8086 crash report ===")
+	fmt.Fprintf(buf, "reason:  %s\n", r.Reason)
+	if r.Program != "" {
+		fmt.Fprintf(buf, "program: %s\n", r.Program)
+	}
+	fmt.Fprintf(buf, "pid:     %d\n", Pid())
+	if vm := r.vm; vm != nil {
+		fmt.Fprintf(buf, "count:   %d\n", vm.count)
+		r.registers(buf)
+		r.code(buf)
+		r.history(buf)
+		r.stack(buf)
+		fmt.Fprintln(buf, "\nbacktrace:")
+		vm.PrintBacktrace(buf, BacktraceArgs)
+		r.syscalls(buf)
+		r.memory(buf, "DS:SI", vm.sreg["ds"], vm.reg["si"])
+		r.memory(buf, "ES:DI", vm.sreg["es"], vm.reg["di"])
+	}
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+func (r *CrashReport) registers(w io.Writer) {
+	vm := r.vm
+	fmt.Fprintln(w, "\nregisters:")
+	fmt.Fprintf(w, "  AX=%04x BX=%04x CX=%04x DX=%04x SP=%04x BP=%04x SI=%04x DI=%04x\n",
+		vm.reg["ax"], vm.reg["bx"], vm.reg["cx"], vm.reg["dx"], vm.reg["sp"], vm.reg["bp"], vm.reg["si"], vm.reg["di"])
+	fmt.Fprintf(w, "  CS=%04x DS=%04x SS=%04x ES=%04x IP=%04x\n",
+		vm.sreg["cs"], vm.sreg["ds"], vm.sreg["ss"], vm.sreg["es"], vm.ip)
+	set := []string{}
+	for _, f := range []Flag{OF, DF, IF, TF, SF, ZF, AF, PF, CF} {
+		if vm.GetFlag(f) == 1 {
+			set = append(set, f.String())
+		}
+	}
+	fmt.Fprintf(w, "  flags=%04x [%s]\n", archFlags(vm.flag), strings.Join(set, " "))
+}
+
+func (r *CrashReport) line(w io.Writer, cs uint16, op *Opcode, mark string) {
+	fmt.Fprintf(w, "  %2s %04x:%04x  %-14s %s", mark, cs, op.address, fmt.Sprintf("%x", []byte(op.bytes)), op.Disasm())
+	if desc := r.vm.symbols.Describe(op.address); desc != "" {
+		fmt.Fprintf(w, "  <%s>", desc)
+	}
+	fmt.Fprintln(w)
+}
+
+// code disassembles around CS:IP. Going backwards is a guess: it starts
+// from the farthest point whose instructions line up with IP.
+func (r *CrashReport) code(w io.Writer) {
+	vm := r.vm
+	cs, ip := vm.sreg["cs"], vm.ip
+	fmt.Fprintln(w, "\ncode:")
+	var before []*Opcode
+	k := uint16(16)
+	if ip < k {
+		k = ip
+	}
+	for ; k > 0 && before == nil; k-- {
+		ops := []*Opcode{}
+		for a := ip - k; a < ip; {
+			op := vm.OpcodeAt(cs, a)
+			ops = append(ops, op)
+			a += uint16(len(op.bytes))
+			if a == ip {
+				before = ops
+			}
+		}
+	}
+	if len(before) > 4 {
+		before = before[len(before)-4:]
+	}
+	for _, op := range before {
+		r.line(w, cs, op, "")
+	}
+	for i, a := 0, ip; i < 4; i++ {
+		op := vm.OpcodeAt(cs, a)
+		mark := ""
+		if i == 0 {
+			mark = "=>"
+		}
+		r.line(w, cs, op, mark)
+		a += uint16(len(op.bytes))
+	}
+}
+
+func (r *CrashReport) history(w io.Writer) {
+	if r.recorder == nil {
+		return
+	}
+	entries := r.recorder.history()
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nlast %d instructions:\n", len(entries))
+	for _, e := range entries {
+		fmt.Fprintf(w, "  %8d %04x:%04x  %s\n", e.count, e.cs, e.ip, e.op.WithSymbols(r.vm.symbols).Disasm())
+	}
+}
+
+func (r *CrashReport) stack(w io.Writer) {
+	vm := r.vm
+	ss, sp := vm.sreg["ss"], vm.reg["sp"]
+	fmt.Fprintln(w, "\nstack:")
+	for i := 0; i < 8 && uint32(sp)+uint32(i*8) < 0x10000; i++ {
+		off := sp + uint16(i*8)
+		words := []string{}
+		for j := uint16(0); j < 8 && uint32(off)+uint32(j) < 0x10000; j += 2 {
+			words = append(words, fmt.Sprintf("%04x", vm.Read16(ss, off+j)))
+		}
+		fmt.Fprintf(w, "  %04x:%04x  %s\n", ss, off, strings.Join(words, " "))
+	}
+}
+
+func (r *CrashReport) syscalls(w io.Writer) {
+	if r.recorder == nil || len(r.recorder.syscalls) == 0 && r.recorder.pending == nil {
+		return
+	}
+	fmt.Fprintln(w, "\nlast syscalls:")
+	for _, rec := range r.recorder.syscalls {
+		fmt.Fprintf(w, "  %s\n", rec)
+	}
+	if rec := r.recorder.pending; rec != nil {
+		fmt.Fprintf(w, "  %s\n", rec)
+	}
+}
+
+func (r *CrashReport) memory(w io.Writer, name string, seg, off uint16) {
+	fmt.Fprintf(w, "\nmemory at %s (%04x:%04x):\n", name, seg, off)
+	start := off &^ 0xf
+	if start >= 0x10 {
+		start -= 0x10
+	}
+	for i := uint16(0); i < 4; i++ {
+		a := start + i*16
+		bs := r.vm.ReadMem(seg, a, 16)
+		text := []byte{}
+		for _, b := range bs {
+			if b < 0x20 || b > 0x7e {
+				b = '.'
+			}
+			text = append(text, b)
+		}
+		fmt.Fprintf(w, "  %04x:%04x  % x  %s\n", seg, a, []byte(bs), text)
+	}
+}
+
+// archFlags converts the VM's flag word to the 8086 FLAGS layout.
+func archFlags(flag uint16) (v uint16) {
+	bits := map[Flag]uint{CF: 0, PF: 2, AF: 4, ZF: 6, SF: 7, TF: 8, IF: 9, DF: 10, OF: 11}
+	for f, b := range bits {
+		if flag>>f&1 == 1 {
+			v |= 1 << b
+		}
+	}
+	return
+}
+
+// WriteCore writes a core file the way MINIX's memory manager does: the
+// memory map of the text, data and stack segments, as virtual address,
+// physical address and length in 16 byte clicks, then the registers in
+// the order of the kernel's stack frame, then the segments themselves.
+func (vm *VM) WriteCore(w io.Writer) (err error) {
+	click := func(n uint32) uint16 { return uint16((n + 15) >> 4) }
+	text := uint32(vm.minix.Text)
+	if text == 0 {
+		text = 0x10000
+	}
+	data := uint32(vm.minix.Brk)
+	sp := uint32(vm.reg["sp"]) &^ 0xf
+	segs := []struct {
+		seg       uint16
+		vir, size uint32
+	}{
+		{vm.sreg["cs"], 0, text},
+		{vm.sreg["ds"], 0, data},
+		{vm.sreg["ss"], sp, 0x10000 - sp},
+	}
+	buf := new(bytes.Buffer)
+	for _, s := range segs {
+		for _, v := range []uint16{click(s.vir), s.seg + click(s.vir), click(s.size)} {
+			buf.Write([]byte{byte(v), byte(v >> 8)})
+		}
+	}
+	for _, v := range []uint16{
+		vm.sreg["es"], vm.sreg["ds"], vm.reg["di"], vm.reg["si"], vm.reg["bp"], 0,
+		vm.reg["bx"], vm.reg["dx"], vm.reg["cx"], vm.reg["ax"], 0,
+		vm.ip, vm.sreg["cs"], archFlags(vm.flag), vm.reg["sp"], vm.sreg["ss"],
+	} {
+		buf.Write([]byte{byte(v), byte(v >> 8)})
+	}
+	for _, s := range segs {
+		buf.Write(vm.ReadMem(s.seg, uint16(s.vir), int(click(s.size))<<4))
+	}
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// ReportCrash writes the report of err to stderr and to file, and a core
+// file of vm to core, skipping either file when its name is empty.
+func ReportCrash(vm *VM, err error, recorder *CrashRecorder, file, core string) {
+	r := NewCrashReport(vm, err, recorder)
+	r.Write(os.Stderr)
+	if file != "" {
+		buf := new(bytes.Buffer)
+		r.Write(buf)
+		if werr := ioutil.WriteFile(file, buf.Bytes(), 0644); werr != nil {
+			ErrorLog("%v", werr)
+		}
+	}
+	if core != "" && vm != nil {
+		buf := new(bytes.Buffer)
+		vm.WriteCore(buf)
+		if werr := ioutil.WriteFile(core, buf.Bytes(), 0644); werr != nil {
+			ErrorLog("%v", werr)
+		} else {
+			fmt.Fprintf(os.Stderr, "core dumped to %s\n", core)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package go8086
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+var crashTestCode = Bytes{
+	0xb8, 0x01, 0x00, // mov ax,0x1
+	0xbe, 0x10, 0x00, // mov si,0x10
+	0x40, // inc ax
+	0xf4, // hlt
+}
+
+func TestFault(t *testing.T) {
+	vm := newTestVM(crashTestCode)
+	vm.DS(0x10).write(Bytes("crash"))
+	r := NewCrashRecorder(2)
+	r.Attach(vm)
+	err := vm.Run()
+	f, ok := err.(*Fault)
+	assert.True(t, ok)
+	assert.Equal(t, "halted at 1000:0007", f.Error())
+	assert.Equal(t, uint16(0x7), vm.IP())
+	assert.Equal(t, uint64(3), vm.InstructionCount())
+	assert.Equal(t, HLT, f.Op.Mnemonic())
+
+	entries := r.history()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, uint16(0x6), entries[0].ip)
+	assert.Equal(t, uint16(0x7), entries[1].ip)
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, NewCrashReport(vm, err, r).Write(buf))
+	out := buf.String()
+	assert.Contains(t, out, "reason:  halted at 1000:0007")
+	assert.Contains(t, out, "AX=0002")
+	assert.Contains(t, out, "=> 1000:0007  f4             hlt")
+	assert.Contains(t, out, "1000:0006  40             inc ax")
+	assert.Contains(t, out, "last 2 instructions:")
+	assert.Contains(t, out, "memory at DS:SI (0000:0010):")
+	assert.Contains(t, out, "63 72 61 73 68")
+}
+
+func TestSyscallFault(t *testing.T) {
+	code, _, err := Assemble(strings.NewReader(fmt.Sprintf(`
+	mov ax,0x1234
+	mov bx,msg
+	int 0x20
+msg:	dw 0, 5, 0, %d, 0, 0 ; open(name, O_CREAT)`, syscall.O_CREAT)))
+	assert.Nil(t, err)
+	vm := NewVM()
+	vm.CS(0).write(code)
+	vm.DS(0).write(code)
+	assert.Nil(t, vm.Step())
+	assert.Nil(t, vm.Step())
+	err = vm.Step()
+	f, ok := err.(*Fault)
+	assert.True(t, ok)
+	assert.Equal(t, "not implemented: open with O_CREAT at 1000:0006", f.Error())
+	assert.Equal(t, uint16(0x1234), vm.Reg(AX))
+	assert.Equal(t, int32(MINIX_open), MinixMessage(vm.DS(8)).Get(m_type))
+}
+
+func TestCrashRecorderSyscalls(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	r := NewCrashRecorder(0)
+	r.Attach(vm)
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, 1, len(r.syscalls))
+	assert.Equal(t, "3 1000:000c time() = 0", r.syscalls[0].String())
+}
+
+func TestWriteCore(t *testing.T) {
+	vm := newTestVM(crashTestCode)
+	vm.minix.Text, vm.minix.Brk = 8, 0x20
+	vm.reg["sp"] = 0xfff0
+	buf := new(bytes.Buffer)
+	assert.Nil(t, vm.WriteCore(buf))
+	core := Bytes(buf.Bytes())
+	// Text: 1 click at 0x1000, data: 2 clicks, stack: the top click.
+	assert.Equal(t, Bytes{0, 0, 0x00, 0x10, 1, 0}, core[0:6])
+	assert.Equal(t, Bytes{0, 0, 0, 0, 2, 0}, core[6:12])
+	assert.Equal(t, Bytes{0xff, 0x0f, 0xff, 0x0f, 1, 0}, core[12:18])
+	assert.Equal(t, 18+32+16+0x20+0x10, len(core))
+	assert.Equal(t, Bytes{0xb8, 0x01, 0x00}, core[50:53])
+}
+
+func TestArchFlags(t *testing.T) {
+	vm := NewVM()
+	vm.FlagON(CF)
+	vm.FlagON(ZF)
+	vm.FlagON(OF)
+	assert.Equal(t, uint16(0x0841), archFlags(vm.flag))
+}
+
+func TestLoadMinixAoutErrors(t *testing.T) {
+	_, err := LoadMinixAout("/nonexistent/a.out")
+	assert.True(t, os.IsNotExist(err))
+	assert.Panics(t, func() { NewMinixAout("/nonexistent/a.out") })
+
+	f, err := ioutil.TempFile("", "go8086-aout")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.Write(make([]byte, 40))
+	f.Close()
+	_, err = LoadMinixAout(f.Name())
+	assert.Contains(t, err.Error(), "not a MINIX a.out")
+}
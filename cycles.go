@@ -0,0 +1,239 @@
+package go8086
+
+// Clock counts from the 8086 manual. Where the manual gives a range (MUL,
+// DIV) the middle is used, and word memory accesses are assumed aligned.
+
+// eaCycles is the effective address calculation time of a memory operand.
+func eaCycles(m *Memory) (n int) {
+	switch m.regad {
+	case RegAdd_Direct:
+		n = 6
+	case RegAdd_SI, RegAdd_DI, RegAdd_BP, RegAdd_BX:
+		n = 5
+	case RegAdd_BP_DI, RegAdd_BX_SI:
+		n = 7
+	case RegAdd_BP_SI, RegAdd_BX_DI:
+		n = 8
+	}
+	if m.regad != RegAdd_Direct && m.disp != nil {
+		n += 4
+	}
+	if m.sreg != RegAddressSegment[m.regad] {
+		n += 2
+	}
+	return
+}
+
+func memoryOf(op *Opcode) *Memory {
+	for _, opr := range []Operand{op.opr1, op.opr2} {
+		switch m := opr.(type) {
+		case *Memory:
+			return m
+		case *IndirectFarAddress:
+			return m.memory
+		}
+	}
+	return nil
+}
+
+// aluCycles covers the two operand ALU instructions: reg,reg / reg,mem /
+// mem,reg / reg,imm / mem,imm.
+var aluCycles = map[Mnemonic][5]int{
+	ADD:  {3, 9, 16, 4, 17},
+	ADC:  {3, 9, 16, 4, 17},
+	SUB:  {3, 9, 16, 4, 17},
+	SBB:  {3, 9, 16, 4, 17},
+	AND:  {3, 9, 16, 4, 17},
+	OR:   {3, 9, 16, 4, 17},
+	XOR:  {3, 9, 16, 4, 17},
+	CMP:  {3, 9, 9, 4, 10},
+	TEST: {3, 9, 9, 5, 11},
+	MOV:  {2, 8, 9, 4, 10},
+}
+
+// Byte and word forms of MUL, IMUL, DIV and IDIV.
+var mulDivCycles = map[Mnemonic][2]int{
+	MUL:  {73, 128},
+	IMUL: {89, 141},
+	DIV:  {85, 153},
+	IDIV: {106, 174},
+}
+
+var conditionalCycles = map[Mnemonic][2]int{
+	LOOP:   {17, 5},
+	LOOPE:  {18, 6},
+	LOOPNE: {19, 5},
+	JCXZ:   {18, 6},
+}
+
+// Single and repeated cost of the string instructions.
+var stringCycles = map[Mnemonic][2]int{
+	MOVSB: {18, 17}, MOVSW: {18, 17},
+	CMPSB: {22, 22}, CMPSW: {22, 22},
+	SCASB: {15, 15}, SCASW: {15, 15},
+	LODSB: {12, 13}, LODSW: {12, 13},
+	STOSB: {11, 10}, STOSW: {11, 10},
+}
+
+var fixedCycles = map[Mnemonic]int{
+	LEA: 2, LDS: 16, LES: 16,
+	RET: 16, RETF: 26, IRET: 24, INT: 51, INT3: 52, INTO: 4,
+	XLAT: 11, LAHF: 4, SAHF: 4, PUSHF: 10, POPF: 8,
+	AAM: 83, AAD: 60, AAA: 4, DAA: 4, AAS: 4, DAS: 4, CBW: 2, CWD: 5,
+	CLC: 2, CMC: 2, STC: 2, CLD: 2, STD: 2, CLI: 2, STI: 2,
+	HLT: 2, NOP: 3, WAIT: 3, LOCK: 2, DB: 2,
+}
+
+func isConditionalJump(mn Mnemonic) bool {
+	return mn >= JZ && mn <= JNS
+}
+
+// Cycles estimates the clocks an instruction takes. taken tells whether a
+// conditional jump or loop jumped; n is the repeat count of a rep string
+// instruction or the count of a shift by cl.
+func Cycles(op *Opcode, taken bool, n int) (c int) {
+	if op.following != nil {
+		s := stringCycles[op.following.mn]
+		return 9 + s[1]*n
+	}
+	m := memoryOf(op)
+	ea := 0
+	if m != nil {
+		ea = eaCycles(m)
+	}
+	if op.sreg != nil && m == nil {
+		c += 2
+	}
+	mn := op.mn
+	switch {
+	case aluCycles[mn] != [5]int{}:
+		t := aluCycles[mn]
+		switch {
+		case isMemory(op.opr1) && isImmediate(op.opr2):
+			c += t[4] + ea
+		case isMemory(op.opr1):
+			c += t[2] + ea
+		case isMemory(op.opr2):
+			c += t[1] + ea
+		case isImmediate(op.opr2):
+			c += t[3]
+		default:
+			c += t[0]
+		}
+	case mulDivCycles[mn] != [2]int{}:
+		t := mulDivCycles[mn]
+		if isBit8(op.opr1) {
+			c += t[0]
+		} else {
+			c += t[1]
+		}
+		if m != nil {
+			c += 6 + ea
+		}
+	case mn == INC || mn == DEC:
+		switch {
+		case m != nil:
+			c += 15 + ea
+		case isBit16(op.opr1):
+			c += 2
+		default:
+			c += 3
+		}
+	case mn == NEG || mn == NOT:
+		if m != nil {
+			c += 16 + ea
+		} else {
+			c += 3
+		}
+	case mn >= SHL && mn <= RCR:
+		byCL := isCounter(op.opr2) && op.opr2.(*Counter).v == CountCL
+		switch {
+		case m != nil && byCL:
+			c += 20 + ea + 4*n
+		case m != nil:
+			c += 15 + ea
+		case byCL:
+			c += 8 + 4*n
+		default:
+			c += 2
+		}
+	case mn == PUSH:
+		switch {
+		case m != nil:
+			c += 16 + ea
+		case isSegmentRegister(op.opr1):
+			c += 10
+		default:
+			c += 11
+		}
+	case mn == POP:
+		if m != nil {
+			c += 17 + ea
+		} else {
+			c += 8
+		}
+	case mn == XCHG:
+		switch {
+		case m != nil:
+			c += 17 + ea
+		case op.opr1 == AX || op.opr2 == AX:
+			c += 3
+		default:
+			c += 4
+		}
+	case mn == IN || mn == OUT:
+		if isImmediate(op.opr1) || isImmediate(op.opr2) {
+			c += 10
+		} else {
+			c += 8
+		}
+	case mn == CALL:
+		switch {
+		case isIndirectFarAddress(op.opr1):
+			c += 37 + ea
+		case m != nil:
+			c += 21 + ea
+		case isDirectFarAddress(op.opr1):
+			c += 28
+		case isRegister(op.opr1):
+			c += 16
+		default:
+			c += 19
+		}
+	case mn == JMP:
+		switch {
+		case isIndirectFarAddress(op.opr1):
+			c += 24 + ea
+		case m != nil:
+			c += 18 + ea
+		case isRegister(op.opr1):
+			c += 11
+		default:
+			c += 15
+		}
+	case isConditionalJump(mn):
+		if taken {
+			c += 16
+		} else {
+			c += 4
+		}
+	case conditionalCycles[mn] != [2]int{}:
+		if taken {
+			c += conditionalCycles[mn][0]
+		} else {
+			c += conditionalCycles[mn][1]
+		}
+	case stringCycles[mn] != [2]int{}:
+		c += stringCycles[mn][0]
+	default:
+		if f, ok := fixedCycles[mn]; ok {
+			c += f + ea
+		} else {
+			c += 4
+		}
+	}
+	if mn == RET && op.opr1 != nil {
+		c += 4
+	}
+	return
+}
@@ -0,0 +1,782 @@
+package go8086
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name            string `json:"name"`
+	Path            string `json:"path,omitempty"`
+	SourceReference int    `json:"sourceReference,omitempty"`
+}
+
+type dapBreakpoint struct {
+	ID                   int    `json:"id"`
+	Verified             bool   `json:"verified"`
+	Line                 int    `json:"line,omitempty"`
+	InstructionReference string `json:"instructionReference,omitempty"`
+	Message              string `json:"message,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type dapInstruction struct {
+	Address          string     `json:"address"`
+	InstructionBytes string     `json:"instructionBytes"`
+	Instruction      string     `json:"instruction"`
+	Location         *dapSource `json:"location,omitempty"`
+	Line             int        `json:"line,omitempty"`
+}
+
+type DAPLaunchArguments struct {
+	Program     string   `json:"program"`
+	Raw         bool     `json:"raw"`
+	Origin      uint16   `json:"origin"`
+	Args        []string `json:"args"`
+	Env         []string `json:"env"`
+	PathPrefix  string   `json:"pathPrefix"`
+	SymbolMap   string   `json:"symbolMap"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+}
+
+const (
+	dapThreadID         = 1
+	dapSourceReference  = 1
+	dapRegistersRef     = 1
+	dapFlagsRef         = 2
+	dapStackRef         = 3
+	dapSegmentRegsRef   = 4
+	dapMaxStackVariable = 256
+)
+
+var (
+	errDAPNotLaunched = errors.New("no program is launched")
+	errDAPRunning     = errors.New("the program is running")
+)
+
+// DAPServer speaks the Debug Adapter Protocol. The guest has no source
+// code, so the disassembly of its text segment is served as a virtual
+// source with one instruction per line.
+type DAPServer struct {
+	r           *bufio.Reader
+	w           io.Writer
+	seq         int
+	requests    chan *dapRequest
+	vm          *VM
+	program     string
+	lines       []uint16
+	breakpoints map[uint32]*Breakpoint
+	sourceBPs   []uint32
+	nextID      int
+	resume      func()
+	running     bool
+	stopOnEntry bool
+	quit        bool
+}
+
+func NewDAPServer(r io.Reader, w io.Writer) *DAPServer {
+	return &DAPServer{
+		r:           bufio.NewReader(r),
+		w:           w,
+		requests:    make(chan *dapRequest, 16),
+		breakpoints: make(map[uint32]*Breakpoint),
+	}
+}
+
+// ListenDAP serves a single client on host:port, or on stdin/stdout when
+// address is "-". Guest output on stdout is then sent to stderr so that it
+// does not corrupt the protocol stream.
+func ListenDAP(address string) (err error) {
+	if address == "-" {
+		fd, err := syscall.Dup(1)
+		if err != nil {
+			return err
+		}
+		if err = syscall.Dup2(2, 1); err != nil {
+			return err
+		}
+		return NewDAPServer(os.Stdin, os.NewFile(uintptr(fd), "dap")).Serve()
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	ErrorLog("waiting for DAP client on %s", address)
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	return NewDAPServer(conn, conn).Serve()
+}
+
+func (s *DAPServer) read() {
+	defer close(s.requests)
+	tp := textproto.NewReader(s.r)
+	for {
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return
+		}
+		body := make([]byte, n)
+		if _, err = io.ReadFull(s.r, body); err != nil {
+			return
+		}
+		req := new(dapRequest)
+		if err = json.Unmarshal(body, req); err != nil {
+			continue
+		}
+		s.requests <- req
+	}
+}
+
+func (s *DAPServer) send(v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		ErrorLog("%v", err)
+		return
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(bs), bs)
+}
+
+func (s *DAPServer) event(name string, body interface{}) {
+	s.seq++
+	s.send(&dapEvent{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+func (s *DAPServer) respond(req *dapRequest, body interface{}, err error) {
+	s.seq++
+	res := &dapResponse{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		res.Message = err.Error()
+	}
+	s.send(res)
+}
+
+func (s *DAPServer) Serve() error {
+	go s.read()
+	for req := range s.requests {
+		s.handle(req)
+		if s.resume != nil {
+			resume := s.resume
+			s.resume = nil
+			resume()
+		}
+		if s.quit {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *DAPServer) handle(req *dapRequest) {
+	f, ok := dapCommands[req.Command]
+	if !ok {
+		s.respond(req, nil, fmt.Errorf("unsupported request: %s", req.Command))
+		return
+	}
+	if s.running && dapResumeCommands[req.Command] {
+		s.respond(req, nil, errDAPRunning)
+		return
+	}
+	body, err := f(s, req.Arguments)
+	s.respond(req, body, err)
+	if err == nil && req.Command == "launch" {
+		s.event("initialized", nil)
+	}
+}
+
+var dapCommands map[string]func(*DAPServer, json.RawMessage) (interface{}, error)
+
+func init() {
+	dapCommands = map[string]func(*DAPServer, json.RawMessage) (interface{}, error){
+		"initialize":                dapInitialize,
+		"launch":                    dapLaunch,
+		"setBreakpoints":            dapSetBreakpoints,
+		"setInstructionBreakpoints": dapSetInstructionBreakpoints,
+		"setExceptionBreakpoints":   dapNothing,
+		"configurationDone":         dapConfigurationDone,
+		"threads":                   dapThreads,
+		"stackTrace":                dapStackTrace,
+		"scopes":                    dapScopes,
+		"variables":                 dapVariables,
+		"source":                    dapSourceContent,
+		"disassemble":               dapDisassemble,
+		"readMemory":                dapReadMemory,
+		"continue":                  dapContinue,
+		"next":                      dapNext,
+		"stepIn":                    dapStepIn,
+		"stepOut":                   dapStepOut,
+		"pause":                     dapNothing,
+		"disconnect":                dapDisconnect,
+		"terminate":                 dapDisconnect,
+	}
+}
+
+// dapResumeCommands set s.resume, which only Serve runs, so they are
+// refused while run is already executing the guest.
+var dapResumeCommands = map[string]bool{
+	"configurationDone": true,
+	"continue":          true,
+	"next":              true,
+	"stepIn":            true,
+	"stepOut":           true,
+}
+
+func dapNothing(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func dapInitialize(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"supportsConfigurationDoneRequest": true,
+		"supportsConditionalBreakpoints":   true,
+		"supportsLogPoints":                true,
+		"supportsDisassembleRequest":       true,
+		"supportsInstructionBreakpoints":   true,
+		"supportsReadMemoryRequest":        true,
+		"supportsSteppingGranularity":      true,
+		"supportsTerminateRequest":         true,
+	}, nil
+}
+
+func dapLaunch(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	var la DAPLaunchArguments
+	if err := json.Unmarshal(args, &la); err != nil {
+		return nil, err
+	}
+	bs, err := ioutil.ReadFile(la.Program)
+	if err != nil {
+		return nil, err
+	}
+	if la.PathPrefix != "" {
+		MinixPathPrefix = la.PathPrefix
+	}
+	vm := NewVM()
+	start, end := int(la.Origin), int(la.Origin)+len(bs)
+	if la.Raw {
+		vm.ip = la.Origin
+		vm.CS(la.Origin).write(bs)
+	} else {
+		aout, err := LoadMinixAout(la.Program)
+		if err != nil {
+			return nil, err
+		}
+		aout.InitVM(vm, append([]string{la.Program}, la.Args...), la.Env)
+		start, end = 0, int(aout.a_text)
+	}
+	if la.SymbolMap != "" {
+		t, err := LoadSymbolMap(la.SymbolMap)
+		if err != nil {
+			return nil, err
+		}
+		if vm.symbols == nil {
+			vm.symbols = NewSymbolTable()
+		}
+		vm.symbols.Merge(t)
+	}
+	s.vm, s.program, s.stopOnEntry = vm, la.Program, la.StopOnEntry
+	s.lines = s.lines[:0]
+	for offset := start; offset < end && offset < 0x10000; {
+		s.lines = append(s.lines, uint16(offset))
+		op := vm.OpcodeAt(vm.sreg["cs"], uint16(offset))
+		offset += len(op.bytes)
+	}
+	if Debug {
+		vm.AddBeforeInstructionHook(DebugHook)
+	}
+	return nil, nil
+}
+
+func (s *DAPServer) source() *dapSource {
+	return &dapSource{Name: filepath.Base(s.program) + ".s", SourceReference: dapSourceReference}
+}
+
+func (s *DAPServer) lineOf(offset uint16) int {
+	for i, o := range s.lines {
+		if o == offset {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (s *DAPServer) linear(offset uint16) uint32 {
+	return Physical(s.vm.sreg["cs"], offset)
+}
+
+type dapBreakpointArguments struct {
+	Line                 int    `json:"line"`
+	InstructionReference string `json:"instructionReference"`
+	Offset               int    `json:"offset"`
+	Condition            string `json:"condition"`
+	LogMessage           string `json:"logMessage"`
+}
+
+func (s *DAPServer) addBreakpoint(addr uint32, a dapBreakpointArguments) (b dapBreakpoint) {
+	bp := s.breakpoints[addr]
+	if bp == nil {
+		s.nextID++
+		bp = &Breakpoint{ID: s.nextID, Segment: s.vm.sreg["cs"], Offset: uint16(addr - uint32(s.vm.sreg["cs"])<<4)}
+		s.breakpoints[addr] = bp
+	}
+	b = dapBreakpoint{ID: bp.ID, InstructionReference: fmt.Sprintf("0x%x", addr)}
+	var err error
+	bp.Condition, bp.Log = nil, nil
+	if a.Condition != "" {
+		if bp.Condition, err = ParseExpr(a.Condition); err != nil {
+			b.Message = err.Error()
+			return
+		}
+	}
+	if a.LogMessage != "" {
+		if bp.Log, err = ParseLogFormat(a.LogMessage); err != nil {
+			b.Message = err.Error()
+			return
+		}
+	}
+	b.Verified = true
+	return
+}
+
+func dapSetBreakpoints(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	var a struct {
+		Breakpoints []dapBreakpointArguments `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	for _, addr := range s.sourceBPs {
+		delete(s.breakpoints, addr)
+	}
+	s.sourceBPs = s.sourceBPs[:0]
+	bps := []dapBreakpoint{}
+	for _, b := range a.Breakpoints {
+		if b.Line < 1 || b.Line > len(s.lines) {
+			bps = append(bps, dapBreakpoint{Line: b.Line, Message: "no instruction at this line"})
+			continue
+		}
+		addr := s.linear(s.lines[b.Line-1])
+		s.sourceBPs = append(s.sourceBPs, addr)
+		bp := s.addBreakpoint(addr, b)
+		bp.Line = b.Line
+		bps = append(bps, bp)
+	}
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+func parseMemoryReference(ref string, offset int) (uint32, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(ref, "0x"), 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(int64(v)+int64(offset)) % MemorySize, nil
+}
+
+func dapSetInstructionBreakpoints(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	var a struct {
+		Breakpoints []dapBreakpointArguments `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	source := make(map[uint32]bool)
+	for _, addr := range s.sourceBPs {
+		source[addr] = true
+	}
+	for addr := range s.breakpoints {
+		if !source[addr] {
+			delete(s.breakpoints, addr)
+		}
+	}
+	bps := []dapBreakpoint{}
+	for _, b := range a.Breakpoints {
+		addr, err := parseMemoryReference(b.InstructionReference, b.Offset)
+		if err != nil {
+			bps = append(bps, dapBreakpoint{Message: err.Error()})
+			continue
+		}
+		bps = append(bps, s.addBreakpoint(addr, b))
+	}
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+func dapConfigurationDone(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	if s.stopOnEntry {
+		s.resume = func() { s.stopped("entry", "") }
+	} else {
+		s.resume = func() { s.run(nil) }
+	}
+	return nil, nil
+}
+
+func dapThreads(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"threads": []map[string]interface{}{{"id": dapThreadID, "name": "main"}}}, nil
+}
+
+func dapStackTrace(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	frames := []interface{}{}
+	for i, f := range s.vm.Backtrace(BacktraceArgs) {
+		frame := map[string]interface{}{
+			"id":                          i,
+			"name":                        s.frameName(f),
+			"line":                        s.lineOf(f.IP),
+			"column":                      1,
+			"instructionPointerReference": fmt.Sprintf("0x%x", Physical(f.Segment, f.IP)),
+		}
+		if frame["line"] != 0 {
+			frame["source"] = s.source()
+		}
+		if f.Heuristic {
+			frame["presentationHint"] = "subtle"
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *DAPServer) frameName(f *Frame) string {
+	name := fmt.Sprintf("%04x:%04x  %s", f.Segment, f.IP, s.vm.OpcodeAt(f.Segment, f.IP).Disasm())
+	if f.Function != "" {
+		name = f.Function + "  " + name
+	}
+	return name
+}
+
+func dapScopes(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	scope := func(name string, ref int) map[string]interface{} {
+		return map[string]interface{}{"name": name, "variablesReference": ref, "expensive": false}
+	}
+	return map[string]interface{}{"scopes": []interface{}{
+		scope("Registers", dapRegistersRef),
+		scope("Segment Registers", dapSegmentRegsRef),
+		scope("Flags", dapFlagsRef),
+		scope("Stack", dapStackRef),
+	}}, nil
+}
+
+func dapVariables(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	var a struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	vm := s.vm
+	vs := []dapVariable{}
+	switch a.VariablesReference {
+	case dapRegistersRef:
+		for _, r := range regs[Bit16] {
+			vs = append(vs, dapVariable{Name: strings.ToUpper(r.name), Value: fmt.Sprintf("%04x", vm.reg[r.name])})
+		}
+		vs = append(vs, dapVariable{Name: "IP", Value: fmt.Sprintf("%04x", vm.ip),
+			MemoryReference: fmt.Sprintf("0x%x", s.linear(vm.ip))})
+		vs = append(vs, dapVariable{Name: "FLAGS", Value: fmt.Sprintf("%04x", vm.flag)})
+	case dapSegmentRegsRef:
+		for _, r := range sregs {
+			vs = append(vs, dapVariable{Name: strings.ToUpper(r.name), Value: fmt.Sprintf("%04x", vm.sreg[r.name])})
+		}
+	case dapFlagsRef:
+		for _, f := range []Flag{OF, DF, IF, TF, SF, ZF, AF, PF, CF} {
+			vs = append(vs, dapVariable{Name: f.String() + "F", Value: fmt.Sprint(vm.GetFlag(f))})
+		}
+	case dapStackRef:
+		for i, v := range vm.stackSlice() {
+			if i == dapMaxStackVariable {
+				break
+			}
+			offset := vm.reg["sp"] + uint16(2*i)
+			vs = append(vs, dapVariable{Name: fmt.Sprintf("%04x:%04x", vm.sreg["ss"], offset), Value: fmt.Sprintf("%04x", v),
+				MemoryReference: fmt.Sprintf("0x%x", Physical(vm.sreg["ss"], offset))})
+		}
+	default:
+		return nil, fmt.Errorf("unknown variablesReference: %d", a.VariablesReference)
+	}
+	return map[string]interface{}{"variables": vs}, nil
+}
+
+func (s *DAPServer) disasLine(offset uint16) string {
+	vm := s.vm
+	op := vm.OpcodeAt(vm.sreg["cs"], offset)
+	return fmt.Sprintf("%04x:%04x  %-14x %s", vm.sreg["cs"], offset, []byte(op.bytes), op.Disasm())
+}
+
+func dapSourceContent(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	lines := make([]string, len(s.lines))
+	for i, offset := range s.lines {
+		lines[i] = s.disasLine(offset)
+	}
+	return map[string]interface{}{"content": strings.Join(lines, "\n") + "\n", "mimeType": "text/x-asm"}, nil
+}
+
+func dapDisassemble(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	var a struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	addr, err := parseMemoryReference(a.MemoryReference, a.Offset)
+	if err != nil {
+		return nil, err
+	}
+	vm := s.vm
+	cs := uint32(vm.sreg["cs"]) << 4
+	offset := uint16(addr - cs)
+	// Instructions have no fixed length, so moving backwards is only
+	// possible along the line table of the text segment.
+	if line := s.lineOf(offset); line > 0 {
+		i := line - 1 + a.InstructionOffset
+		if i < 0 {
+			i = 0
+		}
+		if i < len(s.lines) {
+			offset = s.lines[i]
+		}
+	} else if a.InstructionOffset > 0 {
+		for i := 0; i < a.InstructionOffset; i++ {
+			offset += uint16(len(vm.OpcodeAt(vm.sreg["cs"], offset).bytes))
+		}
+	}
+	ins := []dapInstruction{}
+	for i := 0; i < a.InstructionCount; i++ {
+		op := vm.OpcodeAt(vm.sreg["cs"], offset)
+		in := dapInstruction{
+			Address:          fmt.Sprintf("0x%x", s.linear(offset)),
+			InstructionBytes: fmt.Sprintf("%x", []byte(op.bytes)),
+			Instruction:      op.Disasm(),
+		}
+		if line := s.lineOf(offset); line > 0 {
+			in.Location, in.Line = s.source(), line
+		}
+		ins = append(ins, in)
+		offset += uint16(len(op.bytes))
+	}
+	return map[string]interface{}{"instructions": ins}, nil
+}
+
+func dapReadMemory(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	var a struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	addr, err := parseMemoryReference(a.MemoryReference, a.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"address": fmt.Sprintf("0x%x", addr),
+		"data":    base64.StdEncoding.EncodeToString(s.vm.ReadPhysMem(addr, a.Count)),
+	}, nil
+}
+
+func (s *DAPServer) stopped(reason, text string) {
+	body := map[string]interface{}{"reason": reason, "threadId": dapThreadID, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+	}
+	s.event("stopped", body)
+}
+
+// hit evaluates the condition of bp and prints its log message, and
+// reports whether the guest should stop.
+func (s *DAPServer) hit(bp *Breakpoint) bool {
+	if bp.Condition != nil {
+		v, err := bp.Condition.Eval(s.vm)
+		if err != nil {
+			s.event("output", map[string]string{"category": "stderr",
+				"output": fmt.Sprintf("breakpoint %d: %v\n", bp.ID, err)})
+			return true
+		}
+		if v == 0 {
+			return false
+		}
+	}
+	if bp.Log != nil {
+		s.event("output", map[string]string{"category": "console", "output": bp.Log.Format(s.vm) + "\n"})
+		return false
+	}
+	return true
+}
+
+// run executes the guest until a breakpoint, a pause request, an error, or
+// until done reports that the requested step has finished.
+func (s *DAPServer) run(done func(op *Opcode) bool) {
+	vm := s.vm
+	s.running = true
+	defer func() { s.running = false }()
+	for first := true; ; first = false {
+		if bp := s.breakpoints[s.linear(vm.ip)]; bp != nil && !first && s.hit(bp) {
+			s.event("stopped", map[string]interface{}{"reason": "breakpoint", "threadId": dapThreadID,
+				"allThreadsStopped": true, "hitBreakpointIds": []int{bp.ID}})
+			return
+		}
+		select {
+		case req, ok := <-s.requests:
+			if !ok {
+				s.quit = true
+				return
+			}
+			s.handle(req)
+			if req.Command == "pause" {
+				s.stopped("pause", "")
+				return
+			}
+			if s.quit {
+				return
+			}
+		default:
+		}
+		op := vm.getOpcode()
+		if err := vm.Step(); err != nil {
+			if e, ok := err.(*Exit); ok {
+				s.event("exited", map[string]int{"exitCode": e.Status})
+				s.event("terminated", nil)
+				// The client still disconnects; until then the guest is gone.
+				s.vm = nil
+				return
+			}
+			bt := new(bytes.Buffer)
+			vm.PrintBacktrace(bt, BacktraceArgs)
+			s.event("output", map[string]string{"category": "stderr", "output": err.Error() + "\n" + bt.String()})
+			s.stopped("exception", err.Error())
+			return
+		}
+		if done != nil && done(op) {
+			s.stopped("step", "")
+			return
+		}
+	}
+}
+
+func dapContinue(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	s.resume = func() { s.run(nil) }
+	return map[string]interface{}{"allThreadsContinued": true}, nil
+}
+
+func dapStepIn(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	s.resume = func() { s.run(func(op *Opcode) bool { return true }) }
+	return nil, nil
+}
+
+func dapNext(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	vm := s.vm
+	op := vm.getOpcode()
+	if op.mn != CALL {
+		return dapStepIn(s, args)
+	}
+	cs, next, sp := vm.sreg["cs"], vm.ip+uint16(len(op.bytes)), vm.reg["sp"]
+	s.resume = func() {
+		s.run(func(op *Opcode) bool {
+			return vm.sreg["cs"] == cs && vm.ip == next && vm.reg["sp"] == sp
+		})
+	}
+	return nil, nil
+}
+
+func dapStepOut(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	if s.vm == nil {
+		return nil, errDAPNotLaunched
+	}
+	vm := s.vm
+	sp := vm.reg["sp"]
+	s.resume = func() {
+		s.run(func(op *Opcode) bool {
+			return (op.mn == RET || op.mn == RETF || op.mn == IRET) && vm.reg["sp"] > sp
+		})
+	}
+	return nil, nil
+}
+
+func dapDisconnect(s *DAPServer, args json.RawMessage) (interface{}, error) {
+	s.quit = true
+	return nil, nil
+}
@@ -0,0 +1,182 @@
+package go8086
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type dapTestMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type dapTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	seq    int
+	events []*dapTestMessage
+}
+
+func (c *dapTestClient) receive() *dapTestMessage {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	assert.Nil(c.t, err)
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, n)
+	_, err = io.ReadFull(c.r, body)
+	assert.Nil(c.t, err)
+	m := new(dapTestMessage)
+	assert.Nil(c.t, json.Unmarshal(body, m))
+	return m
+}
+
+func (c *dapTestClient) request(command string, args interface{}, body interface{}) {
+	c.seq++
+	bs, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(bs), bs)
+	for {
+		m := c.receive()
+		if m.Type == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		assert.Equal(c.t, c.seq, m.RequestSeq)
+		assert.True(c.t, m.Success, command+": "+m.Message)
+		if body != nil {
+			assert.Nil(c.t, json.Unmarshal(m.Body, body))
+		}
+		return
+	}
+}
+
+func (c *dapTestClient) waitEvent(name string) *dapTestMessage {
+	for len(c.events) > 0 {
+		m := c.events[0]
+		c.events = c.events[1:]
+		if m.Event == name {
+			return m
+		}
+	}
+	for {
+		if m := c.receive(); m.Event == name {
+			return m
+		}
+	}
+}
+
+func (c *dapTestClient) variables(ref int) map[string]string {
+	var body struct {
+		Variables []dapVariable
+	}
+	c.request("variables", map[string]int{"variablesReference": ref}, &body)
+	vs := make(map[string]string)
+	for _, v := range body.Variables {
+		vs[v.Name] = v.Value
+	}
+	return vs
+}
+
+func TestDAPServer(t *testing.T) {
+	f, err := ioutil.TempFile("", "go8086-dap")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.Write(historyTestCode)
+	f.Close()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go NewDAPServer(server, server).Serve()
+	c := &dapTestClient{t: t, conn: client, r: bufio.NewReader(client)}
+
+	var caps map[string]bool
+	c.request("initialize", map[string]string{"adapterID": "go8086"}, &caps)
+	assert.True(t, caps["supportsDisassembleRequest"])
+	c.request("launch", map[string]interface{}{"program": f.Name(), "raw": true, "stopOnEntry": true}, nil)
+	c.waitEvent("initialized")
+
+	var bps struct {
+		Breakpoints []dapBreakpoint
+	}
+	c.request("setBreakpoints", map[string]interface{}{"source": map[string]int{"sourceReference": 1},
+		"breakpoints": []map[string]int{{"line": 4}, {"line": 99}}}, &bps)
+	assert.True(t, bps.Breakpoints[0].Verified)
+	assert.Equal(t, "0x1000c", bps.Breakpoints[0].InstructionReference)
+	assert.False(t, bps.Breakpoints[1].Verified)
+
+	c.request("configurationDone", nil, nil)
+	assert.Contains(t, string(c.waitEvent("stopped").Body), `"reason":"entry"`)
+
+	var trace struct {
+		StackFrames []struct {
+			Name string
+			Line int
+		}
+	}
+	c.request("stackTrace", map[string]int{"threadId": 1}, &trace)
+	assert.Equal(t, "1000:0000  mov bx,0x100", trace.StackFrames[0].Name)
+	assert.Equal(t, 1, trace.StackFrames[0].Line)
+
+	c.request("stepIn", map[string]int{"threadId": 1}, nil)
+	assert.Contains(t, string(c.waitEvent("stopped").Body), `"reason":"step"`)
+	assert.Equal(t, "0003", c.variables(dapRegistersRef)["IP"])
+
+	c.request("continue", map[string]int{"threadId": 1}, nil)
+	assert.Contains(t, string(c.waitEvent("stopped").Body), `"reason":"breakpoint"`)
+	regs := c.variables(dapRegistersRef)
+	assert.Equal(t, "000c", regs["IP"])
+	assert.Equal(t, "0100", regs["BX"])
+	assert.Equal(t, "1000", c.variables(dapSegmentRegsRef)["CS"])
+	assert.Equal(t, "1", c.variables(dapFlagsRef)["PF"])
+
+	assert.Equal(t, "0000", c.variables(dapStackRef)["0000:fffe"])
+
+	c.request("setInstructionBreakpoints", map[string]interface{}{"breakpoints": []map[string]interface{}{{"instructionReference": "0x10003", "offset": 4}}}, &bps)
+	assert.Equal(t, "0x10007", bps.Breakpoints[0].InstructionReference)
+
+	var dis struct {
+		Instructions []dapInstruction
+	}
+	c.request("disassemble", map[string]interface{}{"memoryReference": "0x10007", "instructionOffset": -1, "instructionCount": 3}, &dis)
+	assert.Equal(t, 3, len(dis.Instructions))
+	assert.Equal(t, "0x10003", dis.Instructions[0].Address)
+	assert.Equal(t, "inc word [0x10]", dis.Instructions[0].Instruction)
+	assert.Equal(t, "c747020d00", dis.Instructions[1].InstructionBytes)
+	assert.Equal(t, 4, dis.Instructions[2].Line)
+
+	var src struct {
+		Content string
+	}
+	c.request("source", map[string]int{"sourceReference": 1}, &src)
+	assert.Contains(t, src.Content, "1000:000e  ebf3           jmp short 0x3\n")
+
+	c.request("disconnect", nil, nil)
+}
+
+func TestDAPServerResumeWhileRunning(t *testing.T) {
+	out := new(bytes.Buffer)
+	s := NewDAPServer(strings.NewReader(""), out)
+	s.vm = newTestVM(historyTestCode)
+	s.requests <- &dapRequest{Seq: 1, Type: "request", Command: "next"}
+	s.requests <- &dapRequest{Seq: 2, Type: "request", Command: "pause"}
+	s.run(nil)
+	assert.Nil(t, s.resume)
+	assert.False(t, s.running)
+	assert.Contains(t, out.String(), `"request_seq":1,"success":false,"command":"next","message":"the program is running"`)
+	assert.Contains(t, out.String(), `"reason":"pause"`)
+}
@@ -21,18 +21,6 @@ func DebugLog(format string, a ...interface{}) {
 	}
 }
 
-type TraceLogger func(format string, a ...interface{})
-
-func TraceLog(syscallType MINIXSyscall) TraceLogger {
-	return func(format string, a ...interface{}) {
-		if Trace {
-			log := fmt.Sprintf("%d [Trace] %-10s ", Pid(), syscallType)
-			log += fmt.Sprintf(format, a...)
-			fmt.Fprintln(os.Stderr, log)
-		}
-	}
-}
-
 func (vm *VM) Debug(op *Opcode) {
 	if !Debug {
 		return
@@ -45,9 +33,13 @@ func (vm *VM) Debug(op *Opcode) {
 			return fl.String()
 		}
 	}
-	fmt.Fprintf(os.Stderr, "%d %04x AX:%s CX:%s DX:%s BX:%s SP:%s BP:%s SI:%s DI:%s %s%s%s%s%s%s%s%s%s %-30s %s\n",
+	ip := fmt.Sprintf("%04x", vm.ip)
+	if desc := vm.symbols.Describe(vm.ip); desc != "" {
+		ip += " <" + desc + ">"
+	}
+	fmt.Fprintf(os.Stderr, "%d %s AX:%s CX:%s DX:%s BX:%s SP:%s BP:%s SI:%s DI:%s %s%s%s%s%s%s%s%s%s %-30s %s\n",
 		Pid(),
-		vm.ip,
+		ip,
 		axString(vm.reg["ax"]),
 		cxString(vm.reg["cx"]),
 		dxString(vm.reg["dx"]),
@@ -57,7 +49,7 @@ func (vm *VM) Debug(op *Opcode) {
 		siString(vm.reg["si"]),
 		diString(vm.reg["di"]),
 		f(OF), f(DF), f(IF), f(TF), f(SF), f(ZF), f(AF), f(PF), f(CF),
-		op.Disasm(),
+		op.DisasmStyle(&TraceStyle),
 		vm.DebugStack(),
 	)
 }
@@ -68,7 +60,7 @@ func (vm *VM) stackSlice() (s []uint16) {
 		if top < vm.reg["sp"] {
 			return
 		}
-		s = append(s, vm.SS(top).read16())
+		s = append(s, vm.SS(top).Read16())
 		top += 2
 	}
 }
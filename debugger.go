@@ -0,0 +1,779 @@
+package go8086
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Breakpoint struct {
+	ID        int
+	Segment   uint16
+	Offset    uint16
+	Anywhere  bool
+	Condition *Expr
+	Log       *LogFormat
+}
+
+func (bp *Breakpoint) String() (s string) {
+	s = fmt.Sprintf("%04x:%04x", bp.Segment, bp.Offset)
+	if bp.Anywhere {
+		s = "anywhere"
+	}
+	if bp.Condition != nil {
+		s += " if " + bp.Condition.String()
+	}
+	if bp.Log != nil {
+		s += " log"
+	}
+	return
+}
+
+type Debugger struct {
+	vm          *VM
+	in          *bufio.Scanner
+	out         io.Writer
+	History     *History
+	breakpoints map[int]*Breakpoint
+	watcher     *Watcher
+	nextID      int
+	steps       int
+	finishSP    uint16
+	finishing   bool
+	nextCS      uint16
+	nextIP      uint16
+	nextSP      uint16
+	nexting     bool
+	recent      []uint16
+	last        string
+	quit        bool
+}
+
+func NewDebugger(vm *VM, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		vm:          vm,
+		in:          bufio.NewScanner(in),
+		out:         out,
+		breakpoints: make(map[int]*Breakpoint),
+		steps:       1,
+	}
+}
+
+func (d *Debugger) AddBreakpoint(seg, offset uint16) *Breakpoint {
+	d.nextID++
+	bp := &Breakpoint{ID: d.nextID, Segment: seg, Offset: offset}
+	d.breakpoints[bp.ID] = bp
+	return bp
+}
+
+func (d *Debugger) DeleteBreakpoint(id int) bool {
+	_, ok := d.breakpoints[id]
+	delete(d.breakpoints, id)
+	return ok
+}
+
+func (d *Debugger) breakpointAt(seg, offset uint16) *Breakpoint {
+	for _, bp := range d.breakpoints {
+		if !bp.Anywhere && bp.Segment == seg && bp.Offset == offset {
+			return bp
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) shouldStop() (stop bool) {
+	if d.steps > 0 {
+		d.steps--
+		if d.steps == 0 {
+			stop = true
+		}
+	}
+	ids := []int{}
+	for id := range d.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		bp := d.breakpoints[id]
+		if !bp.Anywhere && (bp.Segment != d.vm.sreg["cs"] || bp.Offset != d.vm.ip) {
+			continue
+		}
+		if bp.Condition != nil {
+			v, err := bp.Condition.Eval(d.vm)
+			if err != nil {
+				fmt.Fprintf(d.out, "Error in condition of breakpoint %d: %v\n", bp.ID, err)
+			} else if v == 0 {
+				continue
+			}
+		}
+		if bp.Log != nil {
+			fmt.Fprintf(d.out, "Log %d: %s\n", bp.ID, bp.Log.Format(d.vm))
+			continue
+		}
+		fmt.Fprintf(d.out, "Breakpoint %d at %04x:%04x\n", bp.ID, d.vm.sreg["cs"], d.vm.ip)
+		d.steps = 0
+		stop = true
+	}
+	// next returns to its frame, not to a recursive call of the same
+	// function, and is over whatever stops first.
+	if d.nexting && d.vm.sreg["cs"] == d.nextCS && d.vm.ip == d.nextIP && d.vm.reg["sp"] >= d.nextSP {
+		stop = true
+	}
+	if stop {
+		d.nexting = false
+	}
+	return
+}
+
+func (d *Debugger) Run() (err error) {
+	for !d.quit {
+		if d.shouldStop() {
+			d.prompt()
+			if d.quit {
+				break
+			}
+		}
+		op := d.vm.getOpcode()
+		d.recent = append(d.recent, d.vm.ip)
+		if len(d.recent) > 4 {
+			d.recent = d.recent[1:]
+		}
+		if err = d.vm.Step(); err != nil {
+			if _, ok := err.(*Exit); ok {
+				return
+			}
+			fmt.Fprintf(d.out, "Stopped: %v\n", err)
+			d.vm.PrintBacktrace(d.out, BacktraceArgs)
+			d.steps = 1
+			continue
+		}
+		if d.watcher != nil {
+			for _, hit := range d.watcher.Check() {
+				fmt.Fprintln(d.out, hit)
+				d.steps = 1
+			}
+		}
+		if d.finishing && (op.mn == RET || op.mn == RETF || op.mn == IRET) && d.vm.reg["sp"] > d.finishSP {
+			d.finishing = false
+			d.steps = 1
+		}
+	}
+	return
+}
+
+func (d *Debugger) prompt() {
+	d.where()
+	for {
+		fmt.Fprint(d.out, "(go8086) ")
+		if !d.in.Scan() {
+			d.quit = true
+			return
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		if d.Exec(line) {
+			return
+		}
+	}
+}
+
+func (d *Debugger) where() {
+	loc := fmt.Sprintf("%04x:%04x", d.vm.sreg["cs"], d.vm.ip)
+	if desc := d.vm.symbols.Describe(d.vm.ip); desc != "" {
+		loc += " <" + desc + ">"
+	}
+	fmt.Fprintf(d.out, "%s  %s\n", loc, d.vm.getOpcode().DisasmStyle(&TraceStyle))
+}
+
+// Exec runs one debugger command and reports whether the guest should
+// resume.
+func (d *Debugger) Exec(line string) (resume bool) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	if strings.HasPrefix(args[0], "x/") {
+		resume, err := d.examine(args[0][2:], args[1:])
+		if err != nil {
+			fmt.Fprintf(d.out, "Error: %v\n", err)
+		}
+		return resume
+	}
+	f := debuggerCommands[args[0]]
+	if f == nil {
+		fmt.Fprintf(d.out, "Unknown command: %s (try \"help\")\n", args[0])
+		return
+	}
+	resume, err := f(d, args[1:])
+	if err != nil {
+		fmt.Fprintf(d.out, "Error: %v\n", err)
+		return false
+	}
+	return
+}
+
+type debuggerCommand func(d *Debugger, args []string) (bool, error)
+
+var debuggerCommands map[string]debuggerCommand
+
+func init() {
+	debuggerCommands = map[string]debuggerCommand{
+		"help":      debugHelp,
+		"h":         debugHelp,
+		"break":     debugBreak,
+		"b":         debugBreak,
+		"condition": debugCondition,
+		"log":       debugLog,
+		"watch":     debugWatch,
+		"print":     debugPrint,
+		"p":         debugPrint,
+		"delete":    debugDelete,
+		"d":         debugDelete,
+		"info":      debugInfo,
+		"i":         debugInfo,
+		"step":      debugStep,
+		"s":         debugStep,
+		"next":      debugNext,
+		"n":         debugNext,
+		"finish":    debugFinish,
+		"fin":       debugFinish,
+		"continue":  debugContinue,
+		"c":         debugContinue,
+		"rstep":     debugReverseStep,
+		"rs":        debugReverseStep,
+		"rcontinue": debugReverseContinue,
+		"rc":        debugReverseContinue,
+		"regs":      debugRegs,
+		"r":         debugRegs,
+		"set":       debugSet,
+		"x":         debugExamine,
+		"disas":     debugDisas,
+		"u":         debugDisas,
+		"stack":     debugStack,
+		"backtrace": debugBacktrace,
+		"bt":        debugBacktrace,
+		"quit":      debugQuit,
+		"q":         debugQuit,
+	}
+}
+
+var debugHelpText = `break ADDR [if EXPR] set breakpoint (ADDR: offset in CS, SEG:OFF)
+break if EXPR       stop at any instruction where EXPR holds
+condition N [EXPR]  set or clear the condition of breakpoint N
+log ADDR FORMAT     print FORMAT at ADDR without stopping ({EXPR} is
+                    replaced with its value)
+watch [-r|-w|-a] LOC [LEN]
+                    stop when LOC changes, or is read, written or
+                    accessed (LOC: SEG:OFF or physical address)
+print EXPR          evaluate EXPR (registers, flags, ip, count, syscall,
+                    memory as [ds:si], word [bp+0x4], byte es:[di])
+delete N            delete breakpoint or watchpoint N
+info break          list breakpoints and watchpoints
+info symbols [TEXT] list symbols (ADDR may also be NAME or NAME+OFF)
+step [N]            execute N instructions
+next                step over CALL
+finish              run until the current procedure returns
+continue            run until a breakpoint
+rstep, rcontinue    step or continue backwards (needs history)
+regs                show registers and flags
+set REG VALUE       set register (ax..di, es cs ss ds, ip, flags)
+set flag F 0|1      set flag (O D I T S Z A P C)
+set byte|word ADDR VALUE
+                    modify memory (ADDR: SEG:OFF, offset in DS)
+x/b|x/w|x/s ADDR [N]
+                    examine memory
+disas [ADDR] [N]    disassemble (default around IP)
+stack               print the raw stack words
+backtrace [N]       print the call stack with N argument words
+quit                exit
+`
+
+func debugHelp(d *Debugger, args []string) (bool, error) {
+	fmt.Fprint(d.out, debugHelpText)
+	return false, nil
+}
+
+func (d *Debugger) parseValue(s string) (v uint16, err error) {
+	if r := RegisterByName(s); r != nil {
+		return r.Read(d.vm), nil
+	}
+	if r := SegmentRegisterByName(s); r != nil {
+		return r.Read(d.vm), nil
+	}
+	if s == "ip" {
+		return d.vm.ip, nil
+	}
+	if sym, ok := d.vm.symbols.Lookup(s); ok {
+		return sym.Value, nil
+	}
+	if i := strings.LastIndex(s, "+"); i > 0 {
+		base, err := d.parseValue(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		off, err := d.parseValue(s[i+1:])
+		return base + off, err
+	}
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad value: %s", s)
+	}
+	return uint16(n), nil
+}
+
+func (d *Debugger) parseAddress(s string, sreg *SegmentRegister) (seg, offset uint16, err error) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		if seg, err = d.parseValue(s[:i]); err != nil {
+			return
+		}
+		offset, err = d.parseValue(s[i+1:])
+		return
+	}
+	seg = sreg.Read(d.vm)
+	offset, err = d.parseValue(s)
+	return
+}
+
+func splitCondition(args []string) (rest []string, cond *Expr, err error) {
+	for i, a := range args {
+		if a == "if" {
+			cond, err = ParseExpr(strings.Join(args[i+1:], " "))
+			return args[:i], cond, err
+		}
+	}
+	return args, nil, nil
+}
+
+func debugBreak(d *Debugger, args []string) (bool, error) {
+	args, cond, err := splitCondition(args)
+	if err != nil {
+		return false, err
+	}
+	seg, offset := d.vm.sreg["cs"], d.vm.ip
+	if len(args) > 0 {
+		if seg, offset, err = d.parseAddress(args[0], CS); err != nil {
+			return false, err
+		}
+	}
+	bp := d.AddBreakpoint(seg, offset)
+	bp.Condition = cond
+	if len(args) == 0 && cond != nil {
+		bp.Anywhere = true
+	}
+	fmt.Fprintf(d.out, "Breakpoint %d at %s\n", bp.ID, bp)
+	return false, nil
+}
+
+func debugCondition(d *Debugger, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, fmt.Errorf("usage: condition N [EXPR]")
+	}
+	id, err := strconv.Atoi(args[0])
+	bp := d.breakpoints[id]
+	if err != nil || bp == nil {
+		return false, fmt.Errorf("no breakpoint %s", args[0])
+	}
+	if len(args) == 1 {
+		bp.Condition = nil
+		return false, nil
+	}
+	cond, err := ParseExpr(strings.Join(args[1:], " "))
+	if err != nil {
+		return false, err
+	}
+	bp.Condition = cond
+	return false, nil
+}
+
+func debugLog(d *Debugger, args []string) (bool, error) {
+	if len(args) < 2 {
+		return false, fmt.Errorf("usage: log ADDR FORMAT")
+	}
+	seg, offset, err := d.parseAddress(args[0], CS)
+	if err != nil {
+		return false, err
+	}
+	format := strings.Join(args[1:], " ")
+	if s, err := strconv.Unquote(format); err == nil {
+		format = s
+	}
+	l, err := ParseLogFormat(format)
+	if err != nil {
+		return false, err
+	}
+	bp := d.AddBreakpoint(seg, offset)
+	bp.Log = l
+	fmt.Fprintf(d.out, "Logpoint %d at %s\n", bp.ID, bp)
+	return false, nil
+}
+
+func debugWatch(d *Debugger, args []string) (bool, error) {
+	kind := WatchChange
+	if len(args) > 0 {
+		switch args[0] {
+		case "-r":
+			kind = WatchRead
+		case "-w":
+			kind = WatchWrite
+		case "-a":
+			kind = WatchAccess
+		}
+		if kind != WatchChange {
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return false, fmt.Errorf("usage: watch [-r|-w|-a] LOC [LEN]")
+	}
+	var addr uint32
+	if strings.Contains(args[0], ":") {
+		seg, offset, err := d.parseAddress(args[0], DS)
+		if err != nil {
+			return false, err
+		}
+		addr = Physical(seg, offset)
+	} else {
+		n, err := strconv.ParseUint(args[0], 0, 32)
+		if err != nil || n >= MemorySize {
+			return false, fmt.Errorf("bad address: %s", args[0])
+		}
+		addr = uint32(n)
+	}
+	n := 1
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return false, fmt.Errorf("bad length: %s", args[1])
+		}
+	}
+	if d.watcher == nil {
+		d.watcher = NewWatcher(d.vm)
+	}
+	d.nextID++
+	wp := &Watchpoint{ID: d.nextID, Address: addr, Len: n, Kind: kind}
+	d.watcher.Add(wp)
+	fmt.Fprintf(d.out, "Watchpoint %d: %s\n", wp.ID, wp)
+	return false, nil
+}
+
+func debugPrint(d *Debugger, args []string) (bool, error) {
+	e, err := ParseExpr(strings.Join(args, " "))
+	if err != nil {
+		return false, err
+	}
+	v, err := e.Eval(d.vm)
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintf(d.out, "%s = %#x (%d)\n", e, v, v)
+	return false, nil
+}
+
+func debugDelete(d *Debugger, args []string) (bool, error) {
+	if len(args) == 0 {
+		d.breakpoints = make(map[int]*Breakpoint)
+		if d.watcher != nil {
+			d.watcher.Detach()
+			d.watcher = nil
+		}
+		return false, nil
+	}
+	id, err := strconv.Atoi(args[0])
+	if err == nil && d.watcher != nil && d.watcher.Remove(id) {
+		return false, nil
+	}
+	if err != nil || !d.DeleteBreakpoint(id) {
+		return false, fmt.Errorf("no breakpoint %s", args[0])
+	}
+	return false, nil
+}
+
+func debugInfo(d *Debugger, args []string) (bool, error) {
+	if len(args) > 0 && (args[0] == "registers" || args[0] == "r") {
+		return debugRegs(d, nil)
+	}
+	if len(args) > 0 && (args[0] == "symbols" || args[0] == "s") {
+		for _, sym := range d.vm.symbols.Symbols() {
+			if len(args) < 2 || strings.Contains(sym.Name, args[1]) {
+				fmt.Fprintf(d.out, "%04x %s %s\n", sym.Value, sym.Section, sym.Name)
+			}
+		}
+		return false, nil
+	}
+	ids := []int{}
+	for id := range d.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(d.out, "%-3d %s\n", id, d.breakpoints[id])
+	}
+	if d.watcher != nil {
+		for _, wp := range d.watcher.Watchpoints() {
+			fmt.Fprintf(d.out, "%-3d watch %s\n", wp.ID, wp)
+		}
+	}
+	return false, nil
+}
+
+func debugStep(d *Debugger, args []string) (bool, error) {
+	d.steps = 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return false, fmt.Errorf("bad count: %s", args[0])
+		}
+		d.steps = n
+	}
+	return true, nil
+}
+
+func debugNext(d *Debugger, args []string) (bool, error) {
+	op := d.vm.getOpcode()
+	if op.mn != CALL {
+		return debugStep(d, nil)
+	}
+	d.nexting, d.nextCS, d.nextIP, d.nextSP = true, d.vm.sreg["cs"], d.vm.ip+uint16(len(op.bytes)), d.vm.reg["sp"]
+	d.steps = 0
+	return true, nil
+}
+
+func debugFinish(d *Debugger, args []string) (bool, error) {
+	d.finishing, d.finishSP, d.steps = true, d.vm.reg["sp"], 0
+	return true, nil
+}
+
+func debugContinue(d *Debugger, args []string) (bool, error) {
+	d.steps = 0
+	return true, nil
+}
+
+func debugReverseStep(d *Debugger, args []string) (bool, error) {
+	if d.History == nil {
+		return false, fmt.Errorf("history is not enabled")
+	}
+	if err := d.History.ReverseStep(); err != nil {
+		return false, err
+	}
+	d.where()
+	return false, nil
+}
+
+func debugReverseContinue(d *Debugger, args []string) (bool, error) {
+	if d.History == nil {
+		return false, fmt.Errorf("history is not enabled")
+	}
+	err := d.History.ReverseContinue(func(vm *VM) bool {
+		return d.breakpointAt(vm.sreg["cs"], vm.ip) != nil
+	})
+	d.where()
+	return false, err
+}
+
+func debugRegs(d *Debugger, args []string) (bool, error) {
+	vm := d.vm
+	for i, r := range regs[Bit16] {
+		fmt.Fprintf(d.out, "%s=%04x ", strings.ToUpper(r.name), vm.reg[r.name])
+		if i == 3 {
+			fmt.Fprintln(d.out)
+		}
+	}
+	fmt.Fprintln(d.out)
+	for _, r := range sregs {
+		fmt.Fprintf(d.out, "%s=%04x ", strings.ToUpper(r.name), vm.sreg[r.name])
+	}
+	fmt.Fprintf(d.out, "IP=%04x FLAGS=%04x ", vm.ip, vm.flag)
+	for _, f := range []Flag{OF, DF, IF, TF, SF, ZF, AF, PF, CF} {
+		if vm.GetFlag(f) == 1 {
+			fmt.Fprint(d.out, f.String())
+		} else {
+			fmt.Fprint(d.out, "-")
+		}
+	}
+	fmt.Fprintln(d.out)
+	return false, nil
+}
+
+func debugSet(d *Debugger, args []string) (bool, error) {
+	if len(args) < 2 {
+		return false, fmt.Errorf("usage: set REG VALUE | set flag F 0|1 | set byte|word ADDR VALUE")
+	}
+	vm := d.vm
+	switch args[0] {
+	case "flag":
+		if len(args) < 3 {
+			return false, fmt.Errorf("usage: set flag F 0|1")
+		}
+		for f, name := range flagMap {
+			if strings.EqualFold(name, args[1]) || strings.EqualFold(name+"F", args[1]) {
+				vm.SetFlag(f, args[2] != "0")
+				return false, nil
+			}
+		}
+		return false, fmt.Errorf("no flag %s", args[1])
+	case "byte", "word":
+		if len(args) < 3 {
+			return false, fmt.Errorf("usage: set %s ADDR VALUE", args[0])
+		}
+		seg, offset, err := d.parseAddress(args[1], DS)
+		if err != nil {
+			return false, err
+		}
+		v, err := d.parseValue(args[2])
+		if err != nil {
+			return false, err
+		}
+		if args[0] == "byte" {
+			vm.Write8(seg, offset, uint8(v))
+		} else {
+			vm.Write16(seg, offset, v)
+		}
+		return false, nil
+	}
+	v, err := d.parseValue(args[1])
+	if err != nil {
+		return false, err
+	}
+	if r := RegisterByName(args[0]); r != nil {
+		r.Write(vm, v)
+	} else if r := SegmentRegisterByName(args[0]); r != nil {
+		r.Write(vm, v)
+	} else if args[0] == "ip" {
+		vm.ip = v
+	} else if args[0] == "flags" {
+		vm.flag = v
+	} else {
+		return false, fmt.Errorf("no register %s", args[0])
+	}
+	return false, nil
+}
+
+func debugExamine(d *Debugger, args []string) (bool, error) {
+	return d.examine("b", args)
+}
+
+func (d *Debugger) examine(format string, args []string) (bool, error) {
+	if len(args) == 0 || (format != "b" && format != "w" && format != "s") {
+		return false, fmt.Errorf("usage: x/b|x/w|x/s ADDR [N]")
+	}
+	seg, offset, err := d.parseAddress(args[0], DS)
+	if err != nil {
+		return false, err
+	}
+	n := 16
+	if len(args) > 1 {
+		if n, err = strconv.Atoi(args[1]); err != nil {
+			return false, fmt.Errorf("bad count: %s", args[1])
+		}
+	}
+	vm := d.vm
+	switch format {
+	case "s":
+		fmt.Fprintf(d.out, "%04x:%04x  %s\n", seg, offset, strconv.Quote(vm.ReadString(seg, offset)))
+	case "b":
+		for i := 0; i < n; i++ {
+			if i%16 == 0 {
+				if i > 0 {
+					fmt.Fprintln(d.out)
+				}
+				fmt.Fprintf(d.out, "%04x:%04x ", seg, offset+uint16(i))
+			}
+			fmt.Fprintf(d.out, " %02x", vm.Read8(seg, offset+uint16(i)))
+		}
+		fmt.Fprintln(d.out)
+	case "w":
+		for i := 0; i < n; i++ {
+			if i%8 == 0 {
+				if i > 0 {
+					fmt.Fprintln(d.out)
+				}
+				fmt.Fprintf(d.out, "%04x:%04x ", seg, offset+uint16(2*i))
+			}
+			fmt.Fprintf(d.out, " %04x", vm.Read16(seg, offset+uint16(2*i)))
+		}
+		fmt.Fprintln(d.out)
+	}
+	return false, nil
+}
+
+func debugDisas(d *Debugger, args []string) (bool, error) {
+	vm := d.vm
+	seg, offset := vm.sreg["cs"], vm.ip
+	n := 8
+	start := []uint16{}
+	if len(args) > 0 {
+		var err error
+		if seg, offset, err = d.parseAddress(args[0], CS); err != nil {
+			return false, err
+		}
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil {
+				return false, fmt.Errorf("bad count: %s", args[1])
+			}
+		}
+	} else {
+		start = d.recent
+	}
+	for _, a := range start {
+		if a != offset {
+			d.disasOne(seg, a, "  ")
+		}
+	}
+	for i := 0; i < n; i++ {
+		mark := "  "
+		if seg == vm.sreg["cs"] && offset == vm.ip {
+			mark = "=>"
+		}
+		offset += d.disasOne(seg, offset, mark)
+	}
+	return false, nil
+}
+
+func (d *Debugger) disasOne(seg, offset uint16, mark string) uint16 {
+	op := d.vm.OpcodeAt(seg, offset)
+	if sym, ok := d.vm.symbols.At(offset); ok && seg == d.vm.sreg["cs"] {
+		fmt.Fprintf(d.out, "%s:\n", sym.Name)
+	}
+	fmt.Fprintf(d.out, "%s %04x:%04x  %-14x %s\n", mark, seg, offset, []byte(op.bytes), op.DisasmStyle(&TraceStyle))
+	return uint16(len(op.bytes))
+}
+
+func debugStack(d *Debugger, args []string) (bool, error) {
+	fmt.Fprintln(d.out, d.vm.DebugStack())
+	return false, nil
+}
+
+func debugBacktrace(d *Debugger, args []string) (bool, error) {
+	n := BacktraceArgs
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 0 {
+			return false, fmt.Errorf("bad count: %s", args[0])
+		}
+	}
+	d.vm.PrintBacktrace(d.out, n)
+	return false, nil
+}
+
+func debugQuit(d *Debugger, args []string) (bool, error) {
+	d.quit = true
+	return true, nil
+}
+
+func OpenDebuggerTerminal(path string) (in io.Reader, out io.Writer, err error) {
+	if path == "-" {
+		return os.Stdin, os.Stdout, nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	return f, f, nil
+}
@@ -0,0 +1,112 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func runDebuggerScript(vm *VM, script ...string) string {
+	out := new(bytes.Buffer)
+	d := NewDebugger(vm, strings.NewReader(strings.Join(script, "\n")+"\n"), out)
+	d.History = NewHistory(vm, DefaultHistoryConfig)
+	d.Run()
+	return out.String()
+}
+
+var debuggerRecursionCode = Bytes{
+	0xb9, 0x03, 0x00, // mov cx,0x3
+	0xe8, 0x02, 0x00, // call 0x8
+	0x40,       // inc ax
+	0xf4,       // hlt
+	0x49,       // dec cx
+	0x74, 0x03, // jz 0xe
+	0xe8, 0xfa, 0xff, // call 0x8
+	0xc3, // ret
+}
+
+func TestDebuggerNext(t *testing.T) {
+	// The inner calls return to 0xe too, but on a deeper stack.
+	vm := newTestVM(debuggerRecursionCode)
+	runDebuggerScript(vm, "b 0xb", "c", "d 1", "n", "q")
+	assert.Equal(t, uint16(0x000e), vm.IP())
+	assert.Equal(t, uint16(0xfffc), vm.Reg(SP))
+
+	// A breakpoint stopping first ends the next.
+	vm = newTestVM(debuggerRecursionCode)
+	out := runDebuggerScript(vm, "b 0xb", "c", "n", "d 1", "c", "q")
+	assert.Equal(t, 3, strings.Count(out, "Breakpoint 1 at 1000:000b"))
+	assert.Contains(t, out, "Stopped: halted at 1000:0007")
+}
+
+func TestDebuggerBreakContinue(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	out := runDebuggerScript(vm, "b 0xc", "c", "x/w 0:0x10 1", "q")
+	assert.Contains(t, out, "Breakpoint 1 at 1000:000c")
+	assert.Contains(t, out, "1000:000c  int 0x20")
+	assert.Contains(t, out, "0000:0010  0001")
+	assert.Equal(t, uint16(0x000c), vm.IP())
+}
+
+func TestDebuggerStepAndModify(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	out := runDebuggerScript(vm, "s 2", "set ax 0x55", "set flag Z 1", "set byte 0:0x20 0x41", "x/s 0:0x20", "regs", "rs", "q")
+	assert.Contains(t, out, "1000:0007  mov word [bx+0x2],0xd")
+	assert.Contains(t, out, "0000:0020  \"A\"")
+	assert.Contains(t, out, "AX=0055")
+	assert.Contains(t, out, "IP=0007")
+	assert.Contains(t, out, "FLAGS=0022 -----Z-P-")
+	assert.Equal(t, uint16(0x0003), vm.IP())
+}
+
+func TestDebuggerDisas(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	out := runDebuggerScript(vm, "disas 0 2", "q")
+	assert.Contains(t, out, "=> 1000:0000  bb0001         mov bx,0x100")
+	assert.Contains(t, out, "   1000:0003  ff061000       inc word [0x10]")
+}
+
+func TestDebuggerConditionAndLog(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	out := runDebuggerScript(vm, "log 0x3 \"inc {word [0x10]} at {count}\"", "b 0xc if [0x10] == 3", "c", "print word [bx+0x2] + 1", "q")
+	assert.Contains(t, out, "Log 1: inc 0x0 at 0x1")
+	assert.Contains(t, out, "Log 1: inc 0x2 at 0x9")
+	assert.NotContains(t, out, "Log 1: inc 0x3")
+	assert.Contains(t, out, "Breakpoint 2 at 1000:000c")
+	assert.Contains(t, out, "word [bx+0x2] + 1 = 0xe (14)")
+	assert.Equal(t, uint16(3), vm.Read16(0, 0x10))
+
+	vm = newTestVM(historyTestCode)
+	out = runDebuggerScript(vm, "b if count == 4", "info break", "c", "q")
+	assert.Contains(t, out, "1   anywhere if count == 4")
+	assert.Equal(t, uint64(4), vm.InstructionCount())
+}
+
+func TestDebuggerWatch(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	out := runDebuggerScript(vm, "watch 0:0x10 2", "watch -r 0x10", "info break", "c", "d 2", "c", "q")
+	assert.Contains(t, out, "1   watch change 00010-00011")
+	assert.Contains(t, out, "2   watch read   00010-00010")
+	assert.Contains(t, out, "Watchpoint 2: read 0000:0010 (00010) = 0000")
+	assert.Contains(t, out, "Watchpoint 1: 00010 changed 0000 -> 0100")
+	assert.Equal(t, uint16(0x0007), vm.IP())
+
+	vm = newTestVM(historyTestCode)
+	out = runDebuggerScript(vm, "watch -w 0:0x102", "c", "q")
+	assert.Contains(t, out, "Watchpoint 1: write 0000:0102 (00102) = 000d")
+	assert.Equal(t, uint16(0x000c), vm.IP())
+}
+
+func TestDebuggerSymbols(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	st, _ := ReadSymbolMap(strings.NewReader("0003 T _loop\n000c T _syscall\n"))
+	vm.SetSymbols(st)
+	out := runDebuggerScript(vm, "b _syscall", "b _loop+4", "c", "disas _loop 2", "info symbols sys", "q")
+	assert.Contains(t, out, "Breakpoint 1 at 1000:000c")
+	assert.Contains(t, out, "Breakpoint 2 at 1000:0007")
+	assert.Contains(t, out, "1000:0007 <_loop+0x4>  mov word [bx+0x2],0xd")
+	assert.Contains(t, out, "_loop:\n   1000:0003")
+	assert.Contains(t, out, "000c T _syscall\n")
+	assert.NotContains(t, out, "0003 T _loop\n")
+}
@@ -97,13 +97,11 @@ var disasmAddressWithPrefix = func(op *Opcode) (asm string) {
 			pfx = "word "
 		}
 	}
-	if isImmediate(op.opr1) {
-		disp := op.opr1.(*Immediate).value
-		if isBit8(op.opr1) {
-			disp = uint16(int8(op.opr1.(*Immediate).value))
+	if realAddress, ok := op.Target(); ok {
+		if _, ok := op.symbols.At(realAddress); ok && pfx == "word " {
+			pfx = ""
 		}
-		realAddress := uint16(op.address) + uint16(len(op.bytes)) + disp
-		asm += " " + pfx + fmt.Sprintf("%#x", realAddress)
+		asm += " " + pfx + op.target(realAddress)
 	} else {
 		asm += " " + pfx + op.opr1.Disasm()
 	}
@@ -111,11 +109,32 @@ var disasmAddressWithPrefix = func(op *Opcode) (asm string) {
 }
 
 var disasmAddress = func(op *Opcode) (asm string) {
-	realAddress := uint16(op.address) + uint16(len(op.bytes)) + op.opr1.(*Immediate).value
-	asm = op.mn.String() + " " + fmt.Sprintf("%#x", realAddress)
+	realAddress, _ := op.Target()
+	asm = op.mn.String() + " " + op.target(realAddress)
 	return
 }
 
+// Target returns where a relative JMP, CALL, conditional jump or LOOP
+// goes. ok is false for the indirect and far forms.
+func (op *Opcode) Target() (address uint16, ok bool) {
+	imm, ok := op.opr1.(*Immediate)
+	if !ok {
+		return
+	}
+	disp := imm.value
+	if imm.w == Bit8 {
+		disp = uint16(int8(imm.value))
+	}
+	return op.address + uint16(len(op.bytes)) + disp, true
+}
+
+func (op *Opcode) target(address uint16) string {
+	if sym, ok := op.symbols.At(address); ok {
+		return sym.Name
+	}
+	return fmt.Sprintf("%#x", address)
+}
+
 var disasmDb = func(op *Opcode) string {
 	return fmt.Sprintf("db %#02x", op.bytes[0])
 }
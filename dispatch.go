@@ -7,9 +7,9 @@ func setOpcodeImm(mn Mnemonic, w Bit, signed Signed) setOpcodeFunc {
 		op.mn = mn
 		switch w {
 		case Bit8:
-			op.opr1, bs = NewImmediate(xs.read8(), signed, Bit8), xs[0:1]
+			op.opr1, bs = NewImmediate(xs.Read8(), signed, Bit8), xs[0:1]
 		case Bit16:
-			op.opr1, bs = NewImmediate(xs.read16(), signed, Bit16), xs[0:2]
+			op.opr1, bs = NewImmediate(xs.Read16(), signed, Bit16), xs[0:2]
 		}
 		return
 	}
@@ -18,8 +18,8 @@ func setOpcodeImm(mn Mnemonic, w Bit, signed Signed) setOpcodeFunc {
 func setOpcodeDirectFarAddress(mn Mnemonic) setOpcodeFunc {
 	return func(xs Bytes, op *Opcode) (bs Bytes) {
 		op.mn = mn
-		offset := xs.read16()
-		segment := xs[2:].read16()
+		offset := xs.Read16()
+		segment := xs[2:].Read16()
 		op.opr1 = NewDirectFarAddress(segment, offset)
 		bs = xs[0:4]
 		return
@@ -0,0 +1,431 @@
+package go8086
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr is a C-like expression over the guest state. Memory uses the same
+// notation Memory.Disasm prints: [bx+si+0x4], es:[di], word [bp-0x2],
+// byte [ds:si]. A bare [...] reads a word from DS, or from SS when the
+// address involves bp. Besides registers it knows the flags (cf, zf, ...),
+// ip, flags, count (instructions executed), syscall (the MINIX call number
+// when stopped at int 0x20, -1 otherwise) and MINIX call names (READ,
+// WRITE, ...).
+type Expr struct {
+	src  string
+	eval exprFunc
+}
+
+type exprFunc func(vm *VM) (int64, error)
+
+var ErrDivideByZero = errors.New("division by zero")
+
+func ParseExpr(s string) (e *Expr, err error) {
+	p := &exprParser{src: s}
+	if err = p.tokenize(); err != nil {
+		return
+	}
+	f, err := p.expr()
+	if err != nil {
+		return
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos], s)
+	}
+	return &Expr{src: s, eval: f}, nil
+}
+
+func (e *Expr) Eval(vm *VM) (int64, error) {
+	return e.eval(vm)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+type exprParser struct {
+	src    string
+	tokens []string
+	pos    int
+	bp     bool
+}
+
+var exprOperators = []string{"<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "<", ">", "(", ")", "[", "]", ":"}
+
+func (p *exprParser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isExprIdent(c):
+			j := i + 1
+			for j < len(s) && isExprIdent(s[j]) {
+				j++
+			}
+			p.tokens = append(p.tokens, s[i:j])
+			i = j
+		default:
+			op := ""
+			for _, o := range exprOperators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected %q in %q", c, s)
+			}
+			p.tokens = append(p.tokens, op)
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func isExprIdent(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("expected %q, got %q in %q", t, got, p.src)
+	}
+	return nil
+}
+
+var exprBinaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) expr() (exprFunc, error) {
+	return p.binary(0)
+}
+
+func (p *exprParser) binary(level int) (f exprFunc, err error) {
+	if level == len(exprBinaryLevels) {
+		return p.unary()
+	}
+	if f, err = p.binary(level + 1); err != nil {
+		return
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range exprBinaryLevels[level] {
+			found = found || o == op
+		}
+		if !found {
+			return
+		}
+		p.next()
+		rhs, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		f = exprBinary(op, f, rhs)
+	}
+}
+
+func exprBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func exprBinary(op string, lhs, rhs exprFunc) exprFunc {
+	return func(vm *VM) (v int64, err error) {
+		a, err := lhs(vm)
+		if err != nil {
+			return
+		}
+		switch op {
+		case "&&":
+			if a == 0 {
+				return 0, nil
+			}
+		case "||":
+			if a != 0 {
+				return 1, nil
+			}
+		}
+		b, err := rhs(vm)
+		if err != nil {
+			return
+		}
+		switch op {
+		case "&&", "||":
+			v = exprBool(b != 0)
+		case "|":
+			v = a | b
+		case "^":
+			v = a ^ b
+		case "&":
+			v = a & b
+		case "==":
+			v = exprBool(a == b)
+		case "!=":
+			v = exprBool(a != b)
+		case "<":
+			v = exprBool(a < b)
+		case "<=":
+			v = exprBool(a <= b)
+		case ">":
+			v = exprBool(a > b)
+		case ">=":
+			v = exprBool(a >= b)
+		case "<<":
+			v = a << uint64(b)
+		case ">>":
+			v = a >> uint64(b)
+		case "+":
+			v = a + b
+		case "-":
+			v = a - b
+		case "*":
+			v = a * b
+		case "/", "%":
+			if b == 0 {
+				return 0, ErrDivideByZero
+			}
+			if op == "/" {
+				v = a / b
+			} else {
+				v = a % b
+			}
+		}
+		return
+	}
+}
+
+func (p *exprParser) unary() (exprFunc, error) {
+	switch op := p.peek(); op {
+	case "-", "!", "~":
+		p.next()
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vm *VM) (v int64, err error) {
+			if v, err = f(vm); err != nil {
+				return
+			}
+			switch op {
+			case "-":
+				v = -v
+			case "!":
+				v = exprBool(v == 0)
+			case "~":
+				v = ^v
+			}
+			return
+		}, nil
+	}
+	return p.primary()
+}
+
+func exprConst(v int64) exprFunc {
+	return func(vm *VM) (int64, error) { return v, nil }
+}
+
+var exprFlags = map[string]Flag{
+	"of": OF, "df": DF, "if": IF, "tf": TF, "sf": SF, "zf": ZF, "af": AF, "pf": PF, "cf": CF,
+}
+
+func (p *exprParser) primary() (exprFunc, error) {
+	t := p.next()
+	switch t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of %q", p.src)
+	case "(":
+		f, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case "byte", "word":
+		w := Bit8
+		if t == "word" {
+			w = Bit16
+		}
+		return p.memory(w)
+	case "[":
+		p.pos--
+		return p.memory(Bit16)
+	}
+	if r := SegmentRegisterByName(t); r != nil && p.peek() == ":" {
+		p.pos--
+		return p.memory(Bit16)
+	}
+	return p.value(t)
+}
+
+// memory parses [sreg:ea] or sreg:[ea] after an optional size keyword.
+func (p *exprParser) memory(w Bit) (f exprFunc, err error) {
+	var sreg *SegmentRegister
+	if r := SegmentRegisterByName(p.peek()); r != nil {
+		p.next()
+		if err = p.expect(":"); err != nil {
+			return
+		}
+		sreg = r
+	}
+	if err = p.expect("["); err != nil {
+		return
+	}
+	if r := SegmentRegisterByName(p.peek()); r != nil && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] == ":" {
+		p.pos += 2
+		sreg = r
+	}
+	bp := p.bp
+	p.bp = false
+	ea, err := p.expr()
+	if err != nil {
+		return
+	}
+	if err = p.expect("]"); err != nil {
+		return
+	}
+	if sreg == nil {
+		sreg = DS
+		if p.bp {
+			sreg = SS
+		}
+	}
+	p.bp = bp
+	return func(vm *VM) (v int64, err error) {
+		offset, err := ea(vm)
+		if err != nil {
+			return
+		}
+		if w == Bit8 {
+			return int64(vm.Read8(sreg.Read(vm), uint16(offset))), nil
+		}
+		return int64(vm.Read16(sreg.Read(vm), uint16(offset))), nil
+	}, nil
+}
+
+func (p *exprParser) value(t string) (exprFunc, error) {
+	if c := t[0]; c >= '0' && c <= '9' {
+		n, err := strconv.ParseInt(t, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q in %q", t, p.src)
+		}
+		return exprConst(n), nil
+	}
+	name := strings.ToLower(t)
+	if r := RegisterByName(name); r != nil {
+		if r == BP {
+			p.bp = true
+		}
+		return func(vm *VM) (int64, error) { return int64(r.Read(vm)), nil }, nil
+	}
+	if r := SegmentRegisterByName(name); r != nil {
+		return func(vm *VM) (int64, error) { return int64(r.Read(vm)), nil }, nil
+	}
+	if f, ok := exprFlags[name]; ok {
+		return func(vm *VM) (int64, error) { return int64(vm.GetFlag(f)), nil }, nil
+	}
+	switch name {
+	case "ip":
+		return func(vm *VM) (int64, error) { return int64(vm.ip), nil }, nil
+	case "flags":
+		return func(vm *VM) (int64, error) { return int64(vm.flag), nil }, nil
+	case "count":
+		return func(vm *VM) (int64, error) { return int64(vm.count), nil }, nil
+	case "syscall":
+		return func(vm *VM) (int64, error) { return int64(PendingSyscall(vm)), nil }, nil
+	}
+	for call, s := range minixSyscallString {
+		if t == strings.ToUpper(s) {
+			return exprConst(int64(call)), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown name %q in %q", t, p.src)
+}
+
+// PendingSyscall returns the MINIX call the next instruction is about to
+// make, or -1 when it is not int 0x20.
+func PendingSyscall(vm *VM) MINIXSyscall {
+	if vm.Read8(vm.sreg["cs"], vm.ip) != 0xcd || vm.Read8(vm.sreg["cs"], vm.ip+1) != 0x20 {
+		return -1
+	}
+	return MINIXSyscall(vm.Read16(vm.sreg["ss"], vm.reg["bx"]+2))
+}
+
+// LogFormat is the message of a logpoint: text with {expression}
+// placeholders, printed in hex. "{{" and "}}" stand for braces.
+type LogFormat struct {
+	text  []string
+	exprs []*Expr
+}
+
+func ParseLogFormat(s string) (l *LogFormat, err error) {
+	l = new(LogFormat)
+	text := ""
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			text += s[i : i+1]
+			i++
+		case s[i] == '{':
+			j := strings.IndexByte(s[i:], '}')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated { in %q", s)
+			}
+			e, err := ParseExpr(s[i+1 : i+j])
+			if err != nil {
+				return nil, err
+			}
+			l.text = append(l.text, text)
+			l.exprs = append(l.exprs, e)
+			text = ""
+			i += j
+		default:
+			text += s[i : i+1]
+		}
+	}
+	l.text = append(l.text, text)
+	return
+}
+
+func (l *LogFormat) Format(vm *VM) string {
+	s := l.text[0]
+	for i, e := range l.exprs {
+		if v, err := e.Eval(vm); err != nil {
+			s += "<" + err.Error() + ">"
+		} else {
+			s += fmt.Sprintf("%#x", v)
+		}
+		s += l.text[i+1]
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+package go8086
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// setExprTestState gives the registers and memory the expressions read.
+func setExprTestState(vm *VM) {
+	vm.SetReg(AX, 0x1234)
+	vm.SetReg(SI, 0x0010)
+	vm.SetReg(BP, 0x0100)
+	vm.SetReg(BX, 0x0200)
+	vm.SetSReg(DS, 0x0020)
+	vm.SetSReg(SS, 0x0030)
+	vm.SetSReg(ES, 0x0040)
+	vm.SetFlag(ZF, true)
+	vm.Write16(0x0020, 0x0010, 0xbeef)
+	vm.Write16(0x0030, 0x0104, 0x0042)
+	vm.Write8(0x0040, 0x0000, 0x7f)
+	vm.Write16(0x0030, 0x0202, uint16(MINIX_read))
+}
+
+func TestExprEval(t *testing.T) {
+	vm := newTestVM(Bytes{0xcd, 0x20}) // int 0x20
+	setExprTestState(vm)
+	tests := []struct {
+		expr  string
+		value int64
+	}{
+		{"ax", 0x1234},
+		{"AH", 0x12},
+		{"al + 1", 0x35},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"-1 < 0", 1},
+		{"ax == 0x1234 && zf", 1},
+		{"ax != 0x1234 || !cf", 1},
+		{"~0 & 0xff", 0xff},
+		{"1 << 4 | 1", 0x11},
+		{"[ds:si]", 0xbeef},
+		{"[si]", 0xbeef},
+		{"byte [si]", 0xef},
+		{"word [bp+0x4]", 0x42},
+		{"[bp+si-0xc]", 0x42},
+		{"ds:[bp+4]", 0},
+		{"byte es:[0]", 0x7f},
+		{"byte [es:di]", 0x7f},
+		{"es", 0x40},
+		{"ip", 0},
+		{"count", 0},
+		{"syscall", int64(MINIX_read)},
+		{"syscall == READ", 1},
+		{"7 % 4", 3},
+	}
+	for _, test := range tests {
+		e, err := ParseExpr(test.expr)
+		if !assert.Nil(t, err, test.expr) {
+			continue
+		}
+		v, err := e.Eval(vm)
+		assert.Nil(t, err, test.expr)
+		assert.Equal(t, test.value, v, test.expr)
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, s := range []string{"", "ax +", "[si", "foo", "1 $ 2", "(ax", "es:si"} {
+		_, err := ParseExpr(s)
+		assert.NotNil(t, err, s)
+	}
+	e, _ := ParseExpr("1 / (ax - ax)")
+	_, err := e.Eval(NewVM())
+	assert.Equal(t, ErrDivideByZero, err)
+	vm := NewVM()
+	vm.Step()
+	e, _ = ParseExpr("syscall")
+	v, _ := e.Eval(vm)
+	assert.Equal(t, int64(-1), v)
+}
+
+func TestLogFormat(t *testing.T) {
+	vm := newTestVM(Bytes{0xcd, 0x20}) // int 0x20
+	setExprTestState(vm)
+	l, err := ParseLogFormat("ax={ax} [si]={byte [si]} {{literal}}")
+	assert.Nil(t, err)
+	assert.Equal(t, "ax=0x1234 [si]=0xef {literal}", l.Format(vm))
+	_, err = ParseLogFormat("{ax")
+	assert.NotNil(t, err)
+}
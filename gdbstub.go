@@ -0,0 +1,495 @@
+package go8086
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// gdb sees a flat 20-bit address space: memory and breakpoint addresses
+// are physical, and eip is the linear CS:IP. ip itself is writable through
+// eip only. gdb knows no 8086 registers, so they are the low halves of the
+// ones of its i386 core feature; fs, gs and the x87 registers read as 0
+// and ignore writes.
+var gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <architecture>i8086</architecture>
+  <feature name="org.gnu.gdb.i386.core">
+    <flags id="i386_eflags" size="4">
+      <field name="CF" start="0" end="0"/>
+      <field name="" start="1" end="1"/>
+      <field name="PF" start="2" end="2"/>
+      <field name="AF" start="4" end="4"/>
+      <field name="ZF" start="6" end="6"/>
+      <field name="SF" start="7" end="7"/>
+      <field name="TF" start="8" end="8"/>
+      <field name="IF" start="9" end="9"/>
+      <field name="DF" start="10" end="10"/>
+      <field name="OF" start="11" end="11"/>
+    </flags>
+    <reg name="eax" bitsize="32" type="int32" regnum="0"/>
+    <reg name="ecx" bitsize="32" type="int32"/>
+    <reg name="edx" bitsize="32" type="int32"/>
+    <reg name="ebx" bitsize="32" type="int32"/>
+    <reg name="esp" bitsize="32" type="data_ptr"/>
+    <reg name="ebp" bitsize="32" type="data_ptr"/>
+    <reg name="esi" bitsize="32" type="int32"/>
+    <reg name="edi" bitsize="32" type="int32"/>
+    <reg name="eip" bitsize="32" type="code_ptr"/>
+    <reg name="eflags" bitsize="32" type="i386_eflags"/>
+    <reg name="cs" bitsize="32" type="int32"/>
+    <reg name="ss" bitsize="32" type="int32"/>
+    <reg name="ds" bitsize="32" type="int32"/>
+    <reg name="es" bitsize="32" type="int32"/>
+    <reg name="fs" bitsize="32" type="int32"/>
+    <reg name="gs" bitsize="32" type="int32"/>
+    <reg name="st0" bitsize="80" type="i387_ext"/>
+    <reg name="st1" bitsize="80" type="i387_ext"/>
+    <reg name="st2" bitsize="80" type="i387_ext"/>
+    <reg name="st3" bitsize="80" type="i387_ext"/>
+    <reg name="st4" bitsize="80" type="i387_ext"/>
+    <reg name="st5" bitsize="80" type="i387_ext"/>
+    <reg name="st6" bitsize="80" type="i387_ext"/>
+    <reg name="st7" bitsize="80" type="i387_ext"/>
+    <reg name="fctrl" bitsize="32" type="int" group="float"/>
+    <reg name="fstat" bitsize="32" type="int" group="float"/>
+    <reg name="ftag" bitsize="32" type="int" group="float"/>
+    <reg name="fiseg" bitsize="32" type="int" group="float"/>
+    <reg name="fioff" bitsize="32" type="int" group="float"/>
+    <reg name="foseg" bitsize="32" type="int" group="float"/>
+    <reg name="fooff" bitsize="32" type="int" group="float"/>
+    <reg name="fop" bitsize="32" type="int" group="float"/>
+  </feature>
+</target>
+`
+
+const (
+	gdbRegPC    = 8
+	gdbRegFlags = 9
+	gdbRegST0   = 16
+	gdbRegCount = 32
+)
+
+var gdbSRegs = []*SegmentRegister{CS, SS, DS, ES}
+
+type GDBServer struct {
+	vm          *VM
+	rw          io.ReadWriter
+	packets     chan string
+	interrupts  chan bool
+	breakpoints map[uint32]bool
+	watcher     *Watcher
+	watchID     int
+	exit        *Exit
+	killed      bool
+	detached    bool
+}
+
+func NewGDBServer(vm *VM, rw io.ReadWriter) *GDBServer {
+	return &GDBServer{vm: vm, rw: rw, packets: make(chan string, 16), interrupts: make(chan bool, 1), breakpoints: make(map[uint32]bool)}
+}
+
+func ListenGDB(vm *VM, address string) (err error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	ErrorLog("waiting for gdb on %s %s", network, address)
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	return NewGDBServer(vm, conn).Serve()
+}
+
+func (s *GDBServer) read() {
+	r := bufio.NewReader(s.rw)
+	defer close(s.packets)
+	defer close(s.interrupts)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch c {
+		case 0x03:
+			// Ctrl-C is taken out of turn, so a continue can see it
+			// without eating the packets queued after it.
+			select {
+			case s.interrupts <- true:
+			default:
+			}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			sum := make([]byte, 2)
+			if _, err = io.ReadFull(r, sum); err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			if want, _ := strconv.ParseUint(string(sum), 16, 8); uint8(want) != gdbChecksum(data) {
+				s.rw.Write([]byte("-"))
+				continue
+			}
+			s.rw.Write([]byte("+"))
+			s.packets <- data
+		}
+	}
+}
+
+func gdbChecksum(data string) (sum uint8) {
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return
+}
+
+func (s *GDBServer) send(data string) {
+	fmt.Fprintf(s.rw, "$%s#%02x", data, gdbChecksum(data))
+}
+
+func (s *GDBServer) Serve() error {
+	go s.read()
+	for {
+		var data string
+		select {
+		case _, ok := <-s.interrupts:
+			if ok {
+				s.send("S02")
+			} else {
+				s.interrupts = nil
+			}
+			continue
+		case d, ok := <-s.packets:
+			if !ok {
+				return nil
+			}
+			data = d
+		}
+		reply, resume := s.handle(data)
+		if resume != nil {
+			reply = resume()
+		}
+		if s.detached {
+			return s.vm.Run()
+		}
+		if s.killed {
+			return nil
+		}
+		if reply != "\x00" {
+			s.send(reply)
+		}
+		if s.exit != nil {
+			return s.exit
+		}
+	}
+}
+
+func (s *GDBServer) linearPC() uint32 {
+	return Physical(s.vm.sreg["cs"], s.vm.ip)
+}
+
+func (s *GDBServer) readRegister(n int) (v uint32, size int) {
+	vm := s.vm
+	switch {
+	case n < 8:
+		return uint32(vm.reg[regs[Bit16][n].name]), 4
+	case n == gdbRegPC:
+		return s.linearPC(), 4
+	case n == gdbRegFlags:
+		return uint32(vm.flag), 4
+	case n < 10+len(gdbSRegs):
+		return uint32(gdbSRegs[n-10].Read(vm)), 4
+	case n >= gdbRegST0 && n < gdbRegST0+8:
+		return 0, 10
+	default:
+		return 0, 4
+	}
+}
+
+func (s *GDBServer) writeRegister(n int, v uint32) {
+	vm := s.vm
+	switch {
+	case n < 8:
+		vm.reg[regs[Bit16][n].name] = uint16(v)
+	case n == gdbRegPC:
+		vm.ip = uint16(v - uint32(vm.sreg["cs"])<<4)
+	case n == gdbRegFlags:
+		vm.flag = uint16(v)
+	case n < 10+len(gdbSRegs):
+		gdbSRegs[n-10].Write(vm, uint16(v))
+	}
+}
+
+func gdbHex(v uint32, size int) string {
+	bs := make([]byte, size)
+	for i := range bs {
+		bs[i] = byte(v >> (8 * uint(i)))
+	}
+	return hex.EncodeToString(bs)
+}
+
+func gdbUnhex(s string) (v uint32, size int, err error) {
+	bs, err := hex.DecodeString(s)
+	if err != nil {
+		return
+	}
+	for i, b := range bs {
+		v |= uint32(b) << (8 * uint(i))
+	}
+	return v, len(bs), nil
+}
+
+func parseHexPair(s, sep string) (a, b uint64, err error) {
+	i := strings.Index(s, sep)
+	if i < 0 {
+		return 0, 0, fmt.Errorf("malformed: %s", s)
+	}
+	if a, err = strconv.ParseUint(s[:i], 16, 32); err != nil {
+		return
+	}
+	b, err = strconv.ParseUint(s[i+1:], 16, 32)
+	return
+}
+
+// handle answers one packet. Packets that run the guest return a resume
+// function instead, which produces the stop reply once the guest stops.
+func (s *GDBServer) handle(data string) (reply string, resume func() string) {
+	vm := s.vm
+	switch {
+	case data == "?":
+		return "S05", nil
+	case strings.HasPrefix(data, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;swbreak+;hwbreak+", nil
+	case strings.HasPrefix(data, "qXfer:features:read:target.xml:"):
+		off, length, err := parseHexPair(strings.TrimPrefix(data, "qXfer:features:read:target.xml:"), ",")
+		if err != nil {
+			return "E01", nil
+		}
+		if off >= uint64(len(gdbTargetXML)) {
+			return "l", nil
+		}
+		end := off + length
+		if end >= uint64(len(gdbTargetXML)) {
+			return "l" + gdbTargetXML[off:], nil
+		}
+		return "m" + gdbTargetXML[off:end], nil
+	case data == "qAttached":
+		return "1", nil
+	case data == "qC":
+		return "QC1", nil
+	case data == "qfThreadInfo":
+		return "m1", nil
+	case data == "qsThreadInfo":
+		return "l", nil
+	case strings.HasPrefix(data, "H"), strings.HasPrefix(data, "T"):
+		return "OK", nil
+	case data == "g":
+		reply := ""
+		for n := 0; n < gdbRegCount; n++ {
+			reply += gdbHex(s.readRegister(n))
+		}
+		return reply, nil
+	case strings.HasPrefix(data, "G"):
+		hexs := data[1:]
+		for n := 0; n < gdbRegCount; n++ {
+			_, size := s.readRegister(n)
+			if len(hexs) < 2*size {
+				return "E01", nil
+			}
+			v, _, err := gdbUnhex(hexs[:2*size])
+			if err != nil {
+				return "E01", nil
+			}
+			s.writeRegister(n, v)
+			hexs = hexs[2*size:]
+		}
+		return "OK", nil
+	case strings.HasPrefix(data, "p"):
+		n, err := strconv.ParseUint(data[1:], 16, 8)
+		if err != nil || n >= gdbRegCount {
+			return "E01", nil
+		}
+		return gdbHex(s.readRegister(int(n))), nil
+	case strings.HasPrefix(data, "P"):
+		i := strings.Index(data, "=")
+		if i < 0 {
+			return "E01", nil
+		}
+		n, err := strconv.ParseUint(data[1:i], 16, 8)
+		if err != nil || n >= gdbRegCount {
+			return "E01", nil
+		}
+		v, _, err := gdbUnhex(data[i+1:])
+		if err != nil {
+			return "E01", nil
+		}
+		s.writeRegister(int(n), v)
+		return "OK", nil
+	case strings.HasPrefix(data, "m"):
+		addr, length, err := parseHexPair(data[1:], ",")
+		if err != nil {
+			return "E01", nil
+		}
+		return hex.EncodeToString(vm.ReadPhysMem(uint32(addr), int(length))), nil
+	case strings.HasPrefix(data, "M"):
+		i := strings.Index(data, ":")
+		if i < 0 {
+			return "E01", nil
+		}
+		addr, _, err := parseHexPair(data[1:i], ",")
+		if err != nil {
+			return "E01", nil
+		}
+		bs, err := hex.DecodeString(data[i+1:])
+		if err != nil {
+			return "E01", nil
+		}
+		vm.WritePhysMem(uint32(addr), bs)
+		return "OK", nil
+	case strings.HasPrefix(data, "Z0,"), strings.HasPrefix(data, "Z1,"):
+		addr, _, err := parseHexPair(data[3:], ",")
+		if err != nil {
+			return "E01", nil
+		}
+		s.breakpoints[uint32(addr)] = true
+		return "OK", nil
+	case strings.HasPrefix(data, "z0,"), strings.HasPrefix(data, "z1,"):
+		addr, _, err := parseHexPair(data[3:], ",")
+		if err != nil {
+			return "E01", nil
+		}
+		delete(s.breakpoints, uint32(addr))
+		return "OK", nil
+	case strings.HasPrefix(data, "Z2,"), strings.HasPrefix(data, "Z3,"), strings.HasPrefix(data, "Z4,"):
+		addr, length, err := parseHexPair(data[3:], ",")
+		if err != nil {
+			return "E01", nil
+		}
+		if s.watcher == nil {
+			s.watcher = NewWatcher(vm)
+		}
+		s.watchID++
+		s.watcher.Add(&Watchpoint{ID: s.watchID, Address: uint32(addr), Len: int(length), Kind: gdbWatchKinds[data[1]]})
+		return "OK", nil
+	case strings.HasPrefix(data, "z2,"), strings.HasPrefix(data, "z3,"), strings.HasPrefix(data, "z4,"):
+		addr, length, err := parseHexPair(data[3:], ",")
+		if err != nil {
+			return "E01", nil
+		}
+		if s.watcher != nil {
+			for _, wp := range s.watcher.Watchpoints() {
+				if wp.Address == uint32(addr) && wp.Len == int(length) && wp.Kind == gdbWatchKinds[data[1]] {
+					s.watcher.Remove(wp.ID)
+					break
+				}
+			}
+		}
+		return "OK", nil
+	case strings.HasPrefix(data, "s"):
+		if err := s.setResumeAddress(data[1:]); err != nil {
+			return "E01", nil
+		}
+		return "", func() string {
+			if err := vm.Step(); err != nil {
+				return s.stopReply(err)
+			}
+			if reply := s.watchReply(); reply != "" {
+				return reply
+			}
+			return "S05"
+		}
+	case strings.HasPrefix(data, "c"):
+		if err := s.setResumeAddress(data[1:]); err != nil {
+			return "E01", nil
+		}
+		return "", s.cont
+	case data == "k":
+		s.killed = true
+		return "\x00", nil
+	case strings.HasPrefix(data, "D"):
+		s.detached = true
+		s.send("OK")
+		return "\x00", nil
+	}
+	return "", nil
+}
+
+func (s *GDBServer) setResumeAddress(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	v, err := strconv.ParseUint(addr, 16, 32)
+	if err != nil {
+		return err
+	}
+	s.writeRegister(gdbRegPC, uint32(v))
+	return nil
+}
+
+func (s *GDBServer) cont() string {
+	first := true
+	for {
+		if !first && s.breakpoints[s.linearPC()] {
+			return "T05swbreak:;"
+		}
+		first = false
+		select {
+		case <-s.interrupts:
+			return "S02"
+		default:
+		}
+		if err := s.vm.Step(); err != nil {
+			return s.stopReply(err)
+		}
+		if reply := s.watchReply(); reply != "" {
+			return reply
+		}
+	}
+}
+
+// stopReply is the stop reply to an error of Step. An exit ends the
+// session, and a fault is an illegal instruction to gdb, so it does not
+// take it for a trap it set.
+func (s *GDBServer) stopReply(err error) string {
+	if e, ok := err.(*Exit); ok {
+		s.exit = e
+		return fmt.Sprintf("W%02x", uint8(e.Status))
+	}
+	ErrorLog("%v", err)
+	s.vm.PrintBacktrace(os.Stderr, BacktraceArgs)
+	if _, ok := err.(*Fault); ok {
+		return "S04"
+	}
+	return "S05"
+}
+
+var gdbWatchKinds = map[byte]WatchKind{'2': WatchWrite, '3': WatchRead, '4': WatchAccess}
+
+var gdbWatchReasons = map[WatchKind]string{WatchWrite: "watch", WatchRead: "rwatch", WatchAccess: "awatch"}
+
+func (s *GDBServer) watchReply() string {
+	if s.watcher == nil {
+		return ""
+	}
+	hits := s.watcher.Check()
+	if len(hits) == 0 {
+		return ""
+	}
+	wp := hits[0].Watchpoint
+	return fmt.Sprintf("T05%s:%x;", gdbWatchReasons[wp.Kind], hits[0].Access.Address)
+}
@@ -0,0 +1,116 @@
+package go8086
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type gdbTestClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *gdbTestClient) call(t *testing.T, data string) string {
+	fmt.Fprintf(c.conn, "$%s#%02x", data, gdbChecksum(data))
+	ack, err := c.r.ReadByte()
+	assert.Nil(t, err)
+	assert.Equal(t, byte('+'), ack)
+	if data == "k" {
+		return ""
+	}
+	_, err = c.r.ReadString('$')
+	assert.Nil(t, err)
+	reply, err := c.r.ReadString('#')
+	assert.Nil(t, err)
+	sum := make([]byte, 2)
+	io.ReadFull(c.r, sum)
+	reply = reply[:len(reply)-1]
+	assert.Equal(t, fmt.Sprintf("%02x", gdbChecksum(reply)), string(sum))
+	return reply
+}
+
+func TestGDBServer(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	server, client := net.Pipe()
+	done := make(chan error)
+	go func() { done <- NewGDBServer(vm, server).Serve() }()
+	c := &gdbTestClient{client, bufio.NewReader(client)}
+
+	assert.Contains(t, c.call(t, "qSupported:swbreak+"), "qXfer:features:read+")
+	xml := c.call(t, "qXfer:features:read:target.xml:0,20")
+	assert.Equal(t, "m"+gdbTargetXML[0:0x20], xml)
+	assert.True(t, strings.HasPrefix(c.call(t, "qXfer:features:read:target.xml:0,1000"), "l<?xml"))
+	assert.Equal(t, "S05", c.call(t, "?"))
+
+	regs := c.call(t, "g")
+	assert.Equal(t, 2*(gdbRegCount*4+8*6), len(regs))
+	assert.Equal(t, "00000100", regs[64:72])
+
+	assert.Equal(t, "OK", c.call(t, "Z0,1000c,1"))
+	assert.Equal(t, "T05swbreak:;", c.call(t, "c"))
+	assert.Equal(t, uint16(0x000c), vm.IP())
+	assert.Equal(t, "0c000100", c.call(t, "p8"))
+	assert.Equal(t, "00010000", c.call(t, "p3"))
+	assert.Equal(t, "00000000000000000000", c.call(t, "p10"))
+	assert.Equal(t, "0100", c.call(t, "m10,2"))
+
+	assert.Equal(t, "OK", c.call(t, "M20,2:4142"))
+	assert.Equal(t, "AB", vm.ReadString(0, 0x20))
+	assert.Equal(t, "OK", c.call(t, "P0=55000000"))
+	assert.Equal(t, uint16(0x55), vm.Reg(AX))
+
+	assert.Equal(t, "OK", c.call(t, "z0,1000c,1"))
+	assert.Equal(t, "S05", c.call(t, "s10003"))
+	assert.Equal(t, uint16(0x0007), vm.IP())
+	assert.Equal(t, uint16(2), vm.Read16(0, 0x10))
+
+	assert.Equal(t, "OK", c.call(t, "Z2,102,2"))
+	assert.Equal(t, "T05watch:102;", c.call(t, "c"))
+	assert.Equal(t, uint16(0x000c), vm.IP())
+	assert.Equal(t, "OK", c.call(t, "z2,102,2"))
+
+	c.call(t, "k")
+	assert.Nil(t, <-done)
+	client.Close()
+}
+
+func TestGDBServerContinue(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	s := NewGDBServer(vm, new(bytes.Buffer))
+	s.breakpoints[Physical(vm.sreg["cs"], vm.ip+uint16(len(vm.CurrentOpcode().bytes)))] = true
+	s.packets <- "g"
+	assert.Equal(t, "T05swbreak:;", s.cont())
+	assert.Equal(t, "g", <-s.packets)
+
+	s.interrupts <- true
+	assert.Equal(t, "S02", s.cont())
+}
+
+func TestGDBServerFault(t *testing.T) {
+	vm := newTestVM(Bytes{0xf4, 0xf4}) // hlt
+	s := NewGDBServer(vm, new(bytes.Buffer))
+	_, resume := s.handle("s")
+	assert.Equal(t, "S04", resume())
+	assert.Equal(t, uint16(0), vm.IP())
+	assert.Equal(t, "S04", s.cont())
+}
+
+func TestGDBServerExit(t *testing.T) {
+	vm := newTestVM(replayTestCode)
+	m := MinixMessage(vm.SS(0x100))
+	m.Set(m_type, int32(MINIX_exit))
+	m.Set(m1_i1, 3)
+	server, client := net.Pipe()
+	done := make(chan error)
+	go func() { done <- NewGDBServer(vm, server).Serve() }()
+	c := &gdbTestClient{client, bufio.NewReader(client)}
+	assert.Equal(t, "W03", c.call(t, "c"))
+	assert.Equal(t, &Exit{Status: 3}, <-done)
+	client.Close()
+}
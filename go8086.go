@@ -33,10 +33,220 @@ const (
 	ToReg
 )
 
+var SnapshotFile = ""
+var SnapshotAt uint64 = 0
+var RecordLog = ""
+var ReplayLog = ""
+var DebuggerTerminal = ""
+var GDBAddress = ""
+var SymbolMap = ""
+var TraceOutput = ""
+var TraceFormat = "json"
+var ProfileOutput = ""
+var ProfilePeriod uint64 = 1
+var CoverageOutput = ""
+var StraceOutput = ""
+var StraceFilter = ""
+var StraceTiming = false
+var CrashReportFile = ""
+var CoreFile = ""
+var CheckMemory = false
+var TaintSources = ""
+var TaintTrace = false
+var CFGOutput = ""
+
+var runProgram = ""
+
 func Run(file string, args, env []string) {
-	aout := NewMinixAout(file)
+	runProgram = file
+	aout, err := LoadMinixAout(file)
+	if err != nil {
+		ReportCrash(nil, err, nil, CrashReportFile, "")
+		os.Exit(1)
+	}
 	vm := aout.NewVM(args, env)
-	vm.Run()
+	RunVM(vm)
+}
+
+func RunSnapshot(file string) {
+	vm, err := LoadSnapshot(file)
+	if err != nil {
+		ErrorLog("%v", err)
+		os.Exit(1)
+	}
+	runProgram = file
+	RunVM(vm)
+}
+
+func RunDAP(address string) {
+	if err := ListenDAP(address); err != nil {
+		ErrorLog("%v", err)
+		os.Exit(1)
+	}
+}
+
+func RunVM(vm *VM) {
+	if SymbolMap != "" {
+		t, err := LoadSymbolMap(SymbolMap)
+		if err != nil {
+			ErrorLog("%v", err)
+			os.Exit(1)
+		}
+		if vm.symbols == nil {
+			vm.symbols = NewSymbolTable()
+		}
+		vm.symbols.Merge(t)
+	}
+	if RecordLog != "" {
+		r, err := RecordFile(RecordLog)
+		if err != nil {
+			ErrorLog("%v", err)
+			os.Exit(1)
+		}
+		r.Attach(vm)
+	}
+	if ReplayLog != "" {
+		r, err := ReplayFile(ReplayLog)
+		if err != nil {
+			ErrorLog("%v", err)
+			os.Exit(1)
+		}
+		r.Attach(vm)
+	}
+	var tracer *Tracer
+	if TraceOutput != "" {
+		var err error
+		if tracer, err = TraceFile(TraceOutput, TraceFormat); err != nil {
+			ErrorLog("%v", err)
+			os.Exit(1)
+		}
+		tracer.Attach(vm)
+	}
+	var profiler *Profiler
+	if ProfileOutput != "" {
+		profiler = ProfileFile(ProfileOutput)
+		profiler.Period = ProfilePeriod
+		profiler.Program = runProgram
+		profiler.Attach(vm)
+	}
+	var strace *Strace
+	if Trace || StraceOutput != "" {
+		var err error
+		if strace, err = openStrace(); err != nil {
+			ErrorLog("%v", err)
+			os.Exit(1)
+		}
+		strace.Attach(vm)
+	}
+	var coverage *Coverage
+	if CoverageOutput != "" {
+		coverage = CoverageFile(CoverageOutput)
+		coverage.Start(runProgram)
+		coverage.Attach(vm)
+	}
+	var memcheck *Memcheck
+	if CheckMemory {
+		memcheck = NewMemcheck(os.Stderr)
+		memcheck.Attach(vm)
+	}
+	var taint *Taint
+	if TaintSources != "" {
+		spec, err := ParseTaintSources(TaintSources)
+		if err != nil {
+			ErrorLog("%v", err)
+			os.Exit(1)
+		}
+		taint = NewTaint(os.Stderr, spec)
+		if TaintTrace {
+			taint.Log = os.Stderr
+		}
+		taint.Attach(vm)
+	}
+	var flow *FlowRecorder
+	if CFGOutput != "" {
+		flow = FlowFile(CFGOutput)
+		flow.Attach(vm)
+	}
+	var recorder *CrashRecorder
+	if CrashHistory > 0 {
+		recorder = NewCrashRecorder(CrashHistory)
+		recorder.Attach(vm)
+	}
+	if SnapshotFile != "" {
+		vm.AddBeforeInstructionHook(SnapshotHook(SnapshotFile, SnapshotAt))
+	}
+	if Debug {
+		vm.AddBeforeInstructionHook(DebugHook)
+	}
+	var err error
+	if GDBAddress != "" {
+		err = ListenGDB(vm, GDBAddress)
+	} else if DebuggerTerminal != "" {
+		err = runDebugger(vm)
+	} else {
+		err = vm.Run()
+	}
+	if tracer != nil {
+		if cerr := tracer.Close(); cerr != nil {
+			ErrorLog("%v", cerr)
+		}
+	}
+	if profiler != nil {
+		if cerr := profiler.Close(); cerr != nil {
+			ErrorLog("%v", cerr)
+		}
+	}
+	if strace != nil {
+		if cerr := strace.Close(); cerr != nil {
+			ErrorLog("%v", cerr)
+		}
+	}
+	if coverage != nil {
+		if cerr := coverage.Close(); cerr != nil {
+			ErrorLog("%v", cerr)
+		}
+	}
+	if memcheck != nil {
+		if cerr := memcheck.Close(); cerr != nil {
+			ErrorLog("%v", cerr)
+		}
+	}
+	if taint != nil {
+		if cerr := taint.Close(); cerr != nil {
+			ErrorLog("%v", cerr)
+		}
+	}
+	if flow != nil {
+		if cerr := flow.Close(); cerr != nil {
+			ErrorLog("%v", cerr)
+		}
+	}
+	if e, ok := err.(*Exit); ok {
+		os.Exit(e.Status)
+	} else if _, ok := err.(*Fault); ok {
+		ReportCrash(vm, err, recorder, CrashReportFile, CoreFile)
+		os.Exit(1)
+	} else if err != nil {
+		ErrorLog("%v", err)
+		vm.PrintBacktrace(os.Stderr, BacktraceArgs)
+		os.Exit(1)
+	}
+}
+
+func openStrace() (s *Strace, err error) {
+	filter, err := ParseSyscallFilter(StraceFilter)
+	if err != nil {
+		return
+	}
+	if StraceOutput != "" {
+		if s, err = StraceFile(StraceOutput); err != nil {
+			return
+		}
+	} else {
+		s = NewStrace(os.Stderr)
+	}
+	s.Filter, s.Timing = filter, StraceTiming
+	return
 }
 
 func ErrorLog(format string, a ...interface{}) {
@@ -44,3 +254,13 @@ func ErrorLog(format string, a ...interface{}) {
 	log += fmt.Sprintf(format, a...)
 	fmt.Fprintln(os.Stderr, log)
 }
+
+func runDebugger(vm *VM) error {
+	in, out, err := OpenDebuggerTerminal(DebuggerTerminal)
+	if err != nil {
+		return err
+	}
+	d := NewDebugger(vm, in, out)
+	d.History = NewHistory(vm, DefaultHistoryConfig)
+	return d.Run()
+}
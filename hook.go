@@ -0,0 +1,261 @@
+package go8086
+
+import (
+	"errors"
+)
+
+var ErrStop = errors.New("stopped by hook")
+
+type InstructionHook func(vm *VM, op *Opcode) error
+
+type MemoryAccess struct {
+	Address uint32
+	Segment uint16
+	Offset  uint16
+	W       Bit
+	Value   uint16
+	Write   bool
+}
+
+type MemoryHook func(vm *VM, access *MemoryAccess) error
+
+type PortAccess struct {
+	Port  uint16
+	W     Bit
+	Value uint16
+	Out   bool
+}
+
+type PortHook func(vm *VM, access *PortAccess) error
+
+type InterruptHook func(vm *VM, n uint8) error
+
+type SyscallEvent struct {
+	Call    MINIXSyscall
+	Message MinixMessage
+	Result  int
+	Err     error
+	Done    bool
+	Skip    bool
+}
+
+type SyscallHook func(vm *VM, ev *SyscallEvent) error
+
+type HookID int
+
+type hooks struct {
+	nextID      HookID
+	before      map[HookID]InstructionHook
+	after       map[HookID]InstructionHook
+	memory      map[HookID]MemoryHook
+	port        map[HookID]PortHook
+	interrupt   map[HookID]InterruptHook
+	syscall     map[HookID]SyscallHook
+	beforeOrder []HookID
+	afterOrder  []HookID
+	memoryOrder []HookID
+	portOrder   []HookID
+	intOrder    []HookID
+	sysOrder    []HookID
+}
+
+func newHooks() *hooks {
+	return &hooks{
+		before:    make(map[HookID]InstructionHook),
+		after:     make(map[HookID]InstructionHook),
+		memory:    make(map[HookID]MemoryHook),
+		port:      make(map[HookID]PortHook),
+		interrupt: make(map[HookID]InterruptHook),
+		syscall:   make(map[HookID]SyscallHook),
+	}
+}
+
+func (h *hooks) id() HookID {
+	h.nextID++
+	return h.nextID
+}
+
+func (h *hooks) empty() bool {
+	return len(h.before)+len(h.after)+len(h.memory)+len(h.port)+len(h.interrupt)+len(h.syscall) == 0
+}
+
+func (vm *VM) ensureHooks() *hooks {
+	if vm.hooks == nil {
+		vm.hooks = newHooks()
+	}
+	return vm.hooks
+}
+
+func (vm *VM) AddBeforeInstructionHook(f InstructionHook) HookID {
+	h := vm.ensureHooks()
+	id := h.id()
+	h.before[id], h.beforeOrder = f, append(h.beforeOrder, id)
+	return id
+}
+
+func (vm *VM) AddAfterInstructionHook(f InstructionHook) HookID {
+	h := vm.ensureHooks()
+	id := h.id()
+	h.after[id], h.afterOrder = f, append(h.afterOrder, id)
+	return id
+}
+
+func (vm *VM) AddMemoryHook(f MemoryHook) HookID {
+	h := vm.ensureHooks()
+	id := h.id()
+	h.memory[id], h.memoryOrder = f, append(h.memoryOrder, id)
+	return id
+}
+
+func (vm *VM) AddPortHook(f PortHook) HookID {
+	h := vm.ensureHooks()
+	id := h.id()
+	h.port[id], h.portOrder = f, append(h.portOrder, id)
+	return id
+}
+
+func (vm *VM) AddInterruptHook(f InterruptHook) HookID {
+	h := vm.ensureHooks()
+	id := h.id()
+	h.interrupt[id], h.intOrder = f, append(h.intOrder, id)
+	return id
+}
+
+func (vm *VM) AddSyscallHook(f SyscallHook) HookID {
+	h := vm.ensureHooks()
+	id := h.id()
+	h.syscall[id], h.sysOrder = f, append(h.sysOrder, id)
+	return id
+}
+
+func (vm *VM) RemoveHook(id HookID) {
+	h := vm.hooks
+	if h == nil {
+		return
+	}
+	delete(h.before, id)
+	delete(h.after, id)
+	delete(h.memory, id)
+	delete(h.port, id)
+	delete(h.interrupt, id)
+	delete(h.syscall, id)
+	h.beforeOrder = removeHookID(h.beforeOrder, id)
+	h.afterOrder = removeHookID(h.afterOrder, id)
+	h.memoryOrder = removeHookID(h.memoryOrder, id)
+	h.portOrder = removeHookID(h.portOrder, id)
+	h.intOrder = removeHookID(h.intOrder, id)
+	h.sysOrder = removeHookID(h.sysOrder, id)
+	if h.empty() {
+		vm.hooks = nil
+	}
+}
+
+func removeHookID(ids []HookID, id HookID) []HookID {
+	for i, x := range ids {
+		if x == id {
+			return append(ids[:i:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+// A hook that returns an error while an instruction is half done cannot
+// abort it, so the first error is kept and Step returns it afterwards.
+func (vm *VM) stop(err error) {
+	if err != nil && vm.stopErr == nil {
+		vm.stopErr = err
+	}
+}
+
+func (vm *VM) runBeforeHooks(op *Opcode) {
+	for _, id := range vm.hooks.beforeOrder {
+		vm.stop(vm.hooks.before[id](vm, op))
+	}
+}
+
+func (vm *VM) runAfterHooks(op *Opcode) {
+	for _, id := range vm.hooks.afterOrder {
+		vm.stop(vm.hooks.after[id](vm, op))
+	}
+}
+
+func (vm *VM) runMemoryHooks(access *MemoryAccess) {
+	for _, id := range vm.hooks.memoryOrder {
+		vm.stop(vm.hooks.memory[id](vm, access))
+	}
+}
+
+func (vm *VM) runPortHooks(access *PortAccess) {
+	for _, id := range vm.hooks.portOrder {
+		vm.stop(vm.hooks.port[id](vm, access))
+	}
+}
+
+func (vm *VM) runInterruptHooks(n uint8) {
+	for _, id := range vm.hooks.intOrder {
+		vm.stop(vm.hooks.interrupt[id](vm, n))
+	}
+}
+
+func (vm *VM) runSyscallHooks(ev *SyscallEvent) {
+	for _, id := range vm.hooks.sysOrder {
+		vm.stop(vm.hooks.syscall[id](vm, ev))
+	}
+}
+
+func (vm *VM) readMem(sreg *SegmentRegister, offset uint16, w Bit) (value uint16) {
+	seg := sreg.Read(vm)
+	switch w {
+	case Bit8:
+		value = uint16(vm.Read8(seg, offset))
+	case Bit16:
+		value = vm.Read16(seg, offset)
+	}
+	if vm.hooks != nil && len(vm.hooks.memoryOrder) > 0 {
+		access := &MemoryAccess{Address: Physical(seg, offset), Segment: seg, Offset: offset, W: w, Value: value}
+		vm.runMemoryHooks(access)
+		value = access.Value
+	}
+	return
+}
+
+func (vm *VM) writeMem(sreg *SegmentRegister, offset uint16, w Bit, value uint16) {
+	seg := sreg.Read(vm)
+	if vm.hooks != nil && len(vm.hooks.memoryOrder) > 0 {
+		access := &MemoryAccess{Address: Physical(seg, offset), Segment: seg, Offset: offset, W: w, Value: value, Write: true}
+		vm.runMemoryHooks(access)
+		value = access.Value
+	}
+	switch w {
+	case Bit8:
+		vm.Write8(seg, offset, uint8(value))
+	case Bit16:
+		vm.Write16(seg, offset, value)
+	}
+}
+
+// Nothing is attached to the I/O bus, so reads float high unless a hook
+// supplies a value.
+func (vm *VM) in(port uint16, w Bit) (value uint16) {
+	value = 0xffff
+	if w == Bit8 {
+		value = 0xff
+	}
+	if vm.hooks != nil && len(vm.hooks.portOrder) > 0 {
+		access := &PortAccess{Port: port, W: w, Value: value}
+		vm.runPortHooks(access)
+		value = access.Value
+	}
+	return
+}
+
+func (vm *VM) out(port uint16, w Bit, value uint16) {
+	if vm.hooks != nil && len(vm.hooks.portOrder) > 0 {
+		vm.runPortHooks(&PortAccess{Port: port, W: w, Value: value, Out: true})
+	}
+}
+
+func DebugHook(vm *VM, op *Opcode) error {
+	vm.Debug(op)
+	return nil
+}
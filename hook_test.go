@@ -0,0 +1,84 @@
+package go8086
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newTestVM returns a VM with code at the start of its text segment.
+func newTestVM(code Bytes) *VM {
+	vm := NewVM()
+	vm.CS(0).write(code)
+	return vm
+}
+
+var hookTestCode = Bytes{
+	0xb8, 0x34, 0x12, // mov ax,0x1234
+	0xa3, 0x10, 0x00, // mov [0x10],ax
+	0x8b, 0x1e, 0x10, 0x00, // mov bx,[0x10]
+	0xe6, 0x60, // out 0x60,al
+	0xe4, 0x61, // in al,0x61
+	0xf4, // hlt
+}
+
+func TestHookInstruction(t *testing.T) {
+	vm := newTestVM(hookTestCode)
+	mns := []Mnemonic{}
+	vm.AddBeforeInstructionHook(func(vm *VM, op *Opcode) error {
+		mns = append(mns, op.Mnemonic())
+		if op.Mnemonic() == HLT {
+			return ErrStop
+		}
+		return nil
+	})
+	assert.Equal(t, ErrStop, vm.Run())
+	assert.Equal(t, []Mnemonic{MOV, MOV, MOV, OUT, IN, HLT}, mns)
+	assert.Equal(t, uint16(0x000e), vm.IP())
+}
+
+func TestHookMemoryAndPort(t *testing.T) {
+	vm := newTestVM(hookTestCode)
+	accesses := []MemoryAccess{}
+	vm.AddMemoryHook(func(vm *VM, access *MemoryAccess) error {
+		accesses = append(accesses, *access)
+		if !access.Write {
+			access.Value = 0xbeef
+		}
+		return nil
+	})
+	ports := []PortAccess{}
+	vm.AddPortHook(func(vm *VM, access *PortAccess) error {
+		ports = append(ports, *access)
+		if !access.Out {
+			access.Value = 0x42
+		}
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, []MemoryAccess{
+		{Address: 0x10, Offset: 0x10, W: Bit16, Value: 0x1234, Write: true},
+		{Address: 0x10, Offset: 0x10, W: Bit16, Value: 0x1234},
+	}, accesses)
+	assert.Equal(t, uint16(0xbeef), vm.Reg(BX))
+	assert.Equal(t, []PortAccess{
+		{Port: 0x60, W: Bit8, Value: 0x34, Out: true},
+		{Port: 0x61, W: Bit8, Value: 0xff},
+	}, ports)
+	assert.Equal(t, uint16(0x1242), vm.Reg(AX))
+}
+
+func TestHookRemove(t *testing.T) {
+	vm := newTestVM(hookTestCode)
+	called := 0
+	id := vm.AddAfterInstructionHook(func(vm *VM, op *Opcode) error {
+		called++
+		return nil
+	})
+	assert.Nil(t, vm.Step())
+	vm.RemoveHook(id)
+	assert.Nil(t, vm.hooks)
+	assert.Nil(t, vm.Step())
+	assert.Equal(t, 1, called)
+}
@@ -0,0 +1,136 @@
+package go8086
+
+import (
+	"fmt"
+)
+
+// Object is an assembled unit for Link: its sections, its symbols with
+// values relative to their section (external ones it uses are
+// SectionUndefined) and the words that need a linked address.
+type Object struct {
+	Name        string
+	Text        Bytes
+	Data        Bytes
+	Bss         uint16
+	Symbols     *SymbolTable
+	Relocations []Relocation
+}
+
+// Relocation is a word in the text or data of an object to which the
+// linked address of Target in the same object is added, or of Symbol when
+// Target is SectionUndefined. A Relative word is an offset from the end
+// of an instruction, so the linked address of its own section is
+// subtracted.
+type Relocation struct {
+	Section  SymbolSection
+	Offset   uint16
+	Target   SymbolSection
+	Symbol   string
+	Relative bool
+}
+
+type linkedObject struct {
+	*Object
+	bases map[SymbolSection]int64
+}
+
+func (obj *linkedObject) name() string {
+	if obj.Name == "" {
+		return "object"
+	}
+	return obj.Name
+}
+
+// Link lays out objects one after the other in a MINIX executable and
+// resolves the external symbols of each to the global symbols of the
+// others. With separate I&D, data starts at 0 in its own segment,
+// otherwise it follows text. Bss follows all data. The entry point is
+// the symbol entry, or the start of text when it is "".
+func Link(objects []*Object, entry string, separate bool) (aout *MinixAout, err error) {
+	linked := []*linkedObject{}
+	text, data := Bytes{}, Bytes{}
+	bss := int64(0)
+	for _, obj := range objects {
+		linked = append(linked, &linkedObject{obj, map[SymbolSection]int64{
+			SectionAbsolute: 0,
+			SectionText:     int64(len(text)),
+			SectionData:     int64(len(data)),
+			SectionBss:      bss,
+		}})
+		text = append(text, obj.Text...)
+		data = append(data, obj.Data...)
+		bss += int64(obj.Bss)
+	}
+	dataBase := int64(0)
+	if !separate {
+		dataBase = int64(len(text))
+	}
+	if len(text) > 0x10000 || dataBase+int64(len(data))+bss > 0x10000 {
+		return nil, fmt.Errorf("program is larger than 64K")
+	}
+	for _, obj := range linked {
+		obj.bases[SectionData] += dataBase
+		obj.bases[SectionBss] += dataBase + int64(len(data))
+	}
+
+	symbols := NewSymbolTable()
+	defined := map[string]*linkedObject{}
+	for _, obj := range linked {
+		for _, sym := range obj.Symbols.Symbols() {
+			if !sym.External || sym.Section == SectionUndefined {
+				continue
+			}
+			if other, ok := defined[sym.Name]; ok {
+				return nil, fmt.Errorf("%s: symbol %q already defined in %s", obj.name(), sym.Name, other.name())
+			}
+			defined[sym.Name] = obj
+			symbols.Add(&Symbol{Name: sym.Name, Value: uint16(obj.bases[sym.Section] + int64(sym.Value)), Section: sym.Section, External: true})
+		}
+	}
+	for _, obj := range linked {
+		for _, sym := range obj.Symbols.Symbols() {
+			if _, ok := symbols.Lookup(sym.Name); ok || sym.External {
+				continue
+			}
+			symbols.Add(&Symbol{Name: sym.Name, Value: uint16(obj.bases[sym.Section] + int64(sym.Value)), Section: sym.Section})
+		}
+	}
+
+	for _, obj := range linked {
+		for _, r := range obj.Relocations {
+			value := obj.bases[r.Target]
+			if r.Target == SectionUndefined {
+				sym, ok := symbols.Lookup(r.Symbol)
+				if !ok || !sym.External {
+					return nil, fmt.Errorf("%s: undefined symbol %q", obj.name(), r.Symbol)
+				}
+				value = int64(sym.Value)
+			}
+			section := text
+			if r.Section == SectionData {
+				section = data
+			}
+			at := obj.bases[r.Section] + int64(r.Offset)
+			if r.Section == SectionData {
+				at -= dataBase
+			}
+			if at+2 > int64(len(section)) {
+				return nil, fmt.Errorf("%s: relocation at %s:%04x is out of its section", obj.name(), r.Section, r.Offset)
+			}
+			if r.Relative {
+				value -= obj.bases[r.Section]
+			}
+			section[at:].Write16(section[at:].Read16() + uint16(value))
+		}
+	}
+
+	start := uint16(0)
+	if entry != "" {
+		sym, ok := symbols.Lookup(entry)
+		if !ok || sym.Section != SectionText {
+			return nil, fmt.Errorf("entry point %q is not in text", entry)
+		}
+		start = sym.Value
+	}
+	return BuildMinixAout(text, data, uint16(bss), start, separate, symbols), nil
+}
@@ -0,0 +1,196 @@
+package go8086
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func assembleTestObjects(t *testing.T, srcs ...string) (objects []*Object) {
+	for i, src := range srcs {
+		obj, err := AssembleObject(strings.NewReader(src))
+		if !assert.Nil(t, err, src) {
+			t.FailNow()
+		}
+		obj.Name = fmt.Sprintf("unit%d", i)
+		objects = append(objects, obj)
+	}
+	return
+}
+
+func linkTestProgram(t *testing.T, separate bool, srcs ...string) *MinixAout {
+	aout, err := Link(assembleTestObjects(t, srcs...), "start", separate)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return aout
+}
+
+// runLinkTestProgram runs the program until it loops at done, and returns
+// what it wrote.
+func runLinkTestProgram(t *testing.T, separate bool) (vm *VM, out string) {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+	aout := linkTestProgram(t, separate, linkTestMain, fmt.Sprintf(linkTestPrint, w.Fd()))
+	vm = aout.NewVM([]string{"a.out"}, []string{})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	w.Close()
+	bs, _ := ioutil.ReadAll(r)
+	return vm, string(bs)
+}
+
+const linkTestMain = `
+global start
+extern print, len
+section .data
+flag: db 1
+section .text
+start:	mov cx,len
+	call print
+done:	jmp done
+`
+
+const linkTestPrint = `
+global print, len
+len equ end-text
+section .text
+print:	mov [msg+6],cx
+	mov bx,msg
+	int 0x20
+	ret
+section .data
+msg:	dw 0, 4, %d, 0, 0, text ; write(fd, text, count)
+text:	db 'hi', 0xa
+end:
+section .bss
+buf:	resw 2
+`
+
+func TestLink(t *testing.T) {
+	aout := linkTestProgram(t, true, linkTestMain, fmt.Sprintf(linkTestPrint, 1))
+	assert.True(t, aout.Separate())
+	assert.Equal(t, int32(1+12+3), aout.a_data)
+	assert.Equal(t, int32(4), aout.a_bss)
+	assert.Equal(t, int32(0x10000), aout.a_total)
+
+	syms := map[string]string{}
+	for _, sym := range aout.Symbols.Symbols() {
+		syms[sym.Name] = fmt.Sprintf("%s %04x %v", sym.Section, sym.Value, sym.External)
+	}
+	assert.Equal(t, map[string]string{
+		"start": "T 0000 true",
+		"print": "T 0008 true",
+		"len":   "A 0003 true",
+		"flag":  "D 0000 false",
+		"done":  "T 0006 false",
+		"msg":   "D 0001 false",
+		"text":  "D 000d false",
+		"end":   "D 0010 false",
+		"buf":   "B 0010 false",
+	}, syms)
+
+	f, err := ioutil.TempFile("", "go8086-aout")
+	assert.Nil(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+	assert.Nil(t, aout.Save(f.Name()))
+	loaded, err := LoadMinixAout(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, aout.Bytes(), loaded.Bytes())
+
+	vm, out := runLinkTestProgram(t, true)
+	assert.Equal(t, "hi\n", out)
+	assert.Equal(t, uint16(0x0006), vm.IP())
+	assert.Equal(t, "jmp short done", vm.CurrentOpcode().Disasm())
+}
+
+func TestLinkCombined(t *testing.T) {
+	aout := linkTestProgram(t, false, linkTestMain, fmt.Sprintf(linkTestPrint, 1))
+	assert.False(t, aout.Separate())
+	msg, _ := aout.Symbols.Lookup("msg")
+	assert.Equal(t, uint16(aout.a_text+1), msg.Value)
+	op := getOpcode(nil, 8, aout.Text()[8:])
+	assert.Equal(t, fmt.Sprintf("mov [%#x],cx", aout.a_text+1+6), op.Disasm())
+	assert.Equal(t, uint16(aout.a_text+0xd), aout.Data()[1+10:].Read16())
+
+	vm, out := runLinkTestProgram(t, false)
+	assert.Equal(t, "hi\n", out)
+	assert.Equal(t, uint16(0x0006), vm.IP())
+	assert.Equal(t, vm.SReg(CS), vm.SReg(DS))
+	assert.Equal(t, uint16(aout.a_text+1+12+3+4), vm.minix.Brk)
+}
+
+func TestAssembleObject(t *testing.T) {
+	obj, err := AssembleObject(strings.NewReader(`
+extern f
+global g
+	call f
+g:	jmp g
+	mov ax,x+2
+	jmp 0x100
+section .data
+x:	dw g, f, 5`))
+	assert.Nil(t, err)
+	assert.Equal(t, Bytes{0xe8, 0xfd, 0xff, 0xeb, 0xfe, 0xb8, 0x02, 0x00, 0xe9, 0xf5, 0x00}, obj.Text)
+	assert.Equal(t, Bytes{0x03, 0x00, 0x00, 0x00, 0x05, 0x00}, obj.Data)
+	assert.Equal(t, []Relocation{
+		{Section: SectionText, Offset: 1, Target: SectionUndefined, Symbol: "f", Relative: true},
+		{Section: SectionText, Offset: 6, Target: SectionData},
+		{Section: SectionText, Offset: 9, Target: SectionAbsolute, Relative: true},
+		{Section: SectionData, Offset: 0, Target: SectionText},
+		{Section: SectionData, Offset: 2, Target: SectionUndefined, Symbol: "f"},
+	}, obj.Relocations)
+	g, _ := obj.Symbols.Lookup("g")
+	assert.Equal(t, &Symbol{Name: "g", Value: 3, Section: SectionText, External: true}, g)
+	x, _ := obj.Symbols.Lookup("x")
+	assert.Equal(t, &Symbol{Name: "x", Value: 0, Section: SectionData}, x)
+	f, _ := obj.Symbols.Lookup("f")
+	assert.Equal(t, SectionUndefined, f.Section)
+}
+
+func TestAssembleObjectErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"org 0x100", "asm line 1: org in an object"},
+		{"x: db x", "asm line 1: address x does not fit in a byte"},
+		{"x: mov ax,x*2", `asm line 1: "x*2" needs a constant or an address plus a constant`},
+		{"extern f\njmp short f", "asm line 2: invalid operands: jmp short f"},
+		{"global g\nnop", `global symbol "g" is not defined`},
+		{"section .bss\nnop", "asm line 2: only resb and resw can be in .bss"},
+		{"section .rodata", `asm line 1: unknown section ".rodata"`},
+	}
+	for _, test := range tests {
+		_, err := AssembleObject(strings.NewReader(test.src))
+		if assert.NotNil(t, err, test.src) {
+			assert.Equal(t, test.err, err.Error())
+		}
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	tests := []struct {
+		srcs  []string
+		entry string
+		err   string
+	}{
+		{[]string{"extern f\ncall f"}, "", `unit0: undefined symbol "f"`},
+		{[]string{"extern f\ncall f", "f: ret"}, "", `unit0: undefined symbol "f"`},
+		{[]string{"global f\nf: ret", "global f\nf: ret"}, "", `unit1: symbol "f" already defined in unit0`},
+		{[]string{"nop"}, "start", `entry point "start" is not in text`},
+		{[]string{"section .bss\nresb 0x8000", "section .bss\nresb 0x8001"}, "", "program is larger than 64K"},
+	}
+	for _, test := range tests {
+		_, err := Link(assembleTestObjects(t, test.srcs...), test.entry, true)
+		if assert.NotNil(t, err, test.err) {
+			assert.Equal(t, test.err, err.Error())
+		}
+	}
+}
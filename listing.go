@@ -0,0 +1,55 @@
+package go8086
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ListingBytes is how many instruction bytes a listing line shows before
+// continuing on the next line, as in ndisasm.
+const ListingBytes = 8
+
+// ListingLine formats an instruction the way ndisasm does and test/data.s
+// is written: the address, the bytes in hex padded to a column and the
+// disassembly, with bytes that do not fit on continuation lines.
+func ListingLine(address uint32, bs Bytes, asm string) string {
+	n := len(bs)
+	if n > ListingBytes {
+		n = ListingBytes
+	}
+	s := fmt.Sprintf("%08X  %X%s%s\n", address, []byte(bs[:n]), strings.Repeat(" ", (ListingBytes+1-n)*2), asm)
+	for bs = bs[n:]; len(bs) > 0; bs = bs[n:] {
+		if n = len(bs); n > ListingBytes {
+			n = ListingBytes
+		}
+		s += fmt.Sprintf("         -%X\n", []byte(bs[:n]))
+	}
+	return s
+}
+
+// WriteListing disassembles code[start:end] as loaded at origin. An
+// instruction running past end is shown as db, one byte at a time, like
+// ndisasm does at the end of its input. Symbols, when not nil, label
+// their addresses and name jump and call targets. style, when not nil,
+// picks another syntax than ndisasm's.
+func WriteListing(w io.Writer, code Bytes, start, end int, origin uint32, symbols *SymbolTable, style *DisasmStyle) (err error) {
+	padded := append(append(Bytes{}, code[:end]...), make(Bytes, ListingBytes)...)
+	for addr := start; addr < end; {
+		ip := uint16(origin + uint32(addr))
+		if sym, ok := symbols.At(ip); ok {
+			if _, err = fmt.Fprintf(w, "%s:\n", sym.Name); err != nil {
+				return
+			}
+		}
+		op := getOpcode(nil, ip, padded[addr:]).WithSymbols(symbols)
+		if addr+len(op.bytes) > end {
+			op = &Opcode{mn: DB, bytes: padded[addr : addr+1], address: ip}
+		}
+		if _, err = io.WriteString(w, ListingLine(origin+uint32(addr), op.bytes, op.DisasmStyle(style))); err != nil {
+			return
+		}
+		addr += len(op.bytes)
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriteListingData(t *testing.T) {
+	code, err := ioutil.ReadFile("test/data")
+	assert.Nil(t, err)
+	expected, err := ioutil.ReadFile("test/data.s")
+	assert.Nil(t, err)
+	buf := new(bytes.Buffer)
+	assert.Nil(t, WriteListing(buf, Bytes(code), 0, len(code), 0, nil, nil))
+	assert.Equal(t, string(expected), buf.String())
+}
+
+func TestWriteListing(t *testing.T) {
+	symbols := NewSymbolTable()
+	symbols.Add(&Symbol{Name: "_start", Value: 0x100, Section: SectionText})
+	symbols.Add(&Symbol{Name: "_loop", Value: 0x103, Section: SectionText})
+	code := Bytes{
+		0xb8, 0x00, 0x00, // mov ax,0x0
+		0x40,       // inc ax
+		0xeb, 0xfd, // jmp short _loop
+		0xb8, 0x01, // truncated mov ax
+	}
+	buf := new(bytes.Buffer)
+	assert.Nil(t, WriteListing(buf, code, 0, len(code), 0x100, symbols, nil))
+	assert.Equal(t, "_start:\n"+
+		"00000100  B80000            mov ax,0x0\n"+
+		"_loop:\n"+
+		"00000103  40                inc ax\n"+
+		"00000104  EBFD              jmp short _loop\n"+
+		"00000106  B8                db 0xb8\n"+
+		"00000107  01                db 0x01\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, WriteListing(buf, code, 3, 6, 0, nil, nil))
+	assert.Equal(t, "00000003  40                inc ax\n"+
+		"00000004  EBFD              jmp short 0x3\n", buf.String())
+}
+
+func TestListingLine(t *testing.T) {
+	bs := Bytes{0x26, 0xc7, 0x84, 0x23, 0x01, 0xff, 0xff, 0x2e, 0x3e, 0x90}
+	assert.Equal(t, "00001234  26C7842301FFFF2E  x\n"+
+		"         -3E90\n", ListingLine(0x1234, bs, "x"))
+}
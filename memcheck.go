@@ -0,0 +1,312 @@
+package go8086
+
+import (
+	"fmt"
+	"io"
+)
+
+type MemcheckKind int
+
+const (
+	UninitializedRead MemcheckKind = iota
+	InvalidRead
+	InvalidWrite
+	StackBelowBrk
+	StringWrap
+	UninitializedSyscall
+)
+
+var memcheckKindNames = map[MemcheckKind]string{
+	UninitializedRead:    "uninitialized read",
+	InvalidRead:          "invalid read",
+	InvalidWrite:         "invalid write",
+	StackBelowBrk:        "stack below brk",
+	StringWrap:           "string wrap",
+	UninitializedSyscall: "uninitialized syscall buffer",
+}
+
+func (k MemcheckKind) String() string {
+	return memcheckKindNames[k]
+}
+
+// MemcheckError is one finding. Findings of the same kind at the same
+// instruction are reported once and counted.
+type MemcheckError struct {
+	Kind      MemcheckKind
+	Detail    string
+	CS, IP    uint16
+	Op        *Opcode
+	Backtrace []*Frame
+	Count     uint64
+}
+
+func (e *MemcheckError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Detail)
+}
+
+type memcheckKey struct {
+	kind   MemcheckKind
+	cs, ip uint16
+}
+
+// Memcheck keeps a bit per byte of guest memory telling whether the byte
+// has been written, and checks every access of the guest against it and
+// against the regions the MINIX loader set up: text, data up to brk and
+// the stack from SP up.
+type Memcheck struct {
+	Errors []*MemcheckError
+	w      io.Writer
+	vm     *VM
+	ids    []HookID
+	shadow []byte
+	seen   map[memcheckKey]*MemcheckError
+	cs, ip uint16
+	op     *Opcode
+	si, di uint16
+	below  bool
+}
+
+func NewMemcheck(w io.Writer) *Memcheck {
+	return &Memcheck{
+		w:      w,
+		shadow: make([]byte, MemorySize/8),
+		seen:   make(map[memcheckKey]*MemcheckError),
+	}
+}
+
+// Attach treats what the loader left in memory as initialized: text,
+// data and bss, and the stack from SP up. Attached to a restored snapshot
+// the live stack counts as initialized too.
+func (m *Memcheck) Attach(vm *VM) {
+	m.vm = vm
+	m.loaded(vm)
+	m.ids = []HookID{
+		vm.AddBeforeInstructionHook(m.before),
+		vm.AddAfterInstructionHook(m.after),
+		vm.AddMemoryHook(m.memory),
+		vm.AddSyscallHook(m.syscall),
+	}
+}
+
+func (m *Memcheck) Detach() {
+	for _, id := range m.ids {
+		m.vm.RemoveHook(id)
+	}
+	m.ids = nil
+}
+
+// Close writes the summary.
+func (m *Memcheck) Close() (err error) {
+	n := uint64(0)
+	for _, e := range m.Errors {
+		n += e.Count
+	}
+	_, err = fmt.Fprintf(m.w, "==%d== ERROR SUMMARY: %d errors from %d contexts\n", Pid(), n, len(m.Errors))
+	return
+}
+
+func (m *Memcheck) loaded(vm *VM) {
+	for i := range m.shadow {
+		m.shadow[i] = 0
+	}
+	m.mark(vm.sreg["cs"], 0, int(vm.minix.Text))
+	m.mark(vm.sreg["ds"], 0, int(vm.minix.Brk))
+	m.mark(vm.sreg["ss"], vm.reg["sp"], 0x10000-int(vm.reg["sp"]))
+}
+
+func (m *Memcheck) mark(seg, offset uint16, n int) {
+	for i := 0; i < n; i++ {
+		addr := Physical(seg, offset+uint16(i))
+		m.shadow[addr/8] |= 1 << (addr % 8)
+	}
+}
+
+// Initialized reports whether the byte at seg:offset has been written.
+func (m *Memcheck) Initialized(seg, offset uint16) bool {
+	addr := Physical(seg, offset)
+	return m.shadow[addr/8]&(1<<(addr%8)) != 0
+}
+
+// uninitialized returns the index of the first byte of n at seg:offset
+// that has not been written, or -1.
+func (m *Memcheck) uninitialized(seg, offset uint16, n int) int {
+	for i := 0; i < n; i++ {
+		if !m.Initialized(seg, offset+uint16(i)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func within(addr uint32, seg, offset uint16, n uint32) bool {
+	base := Physical(seg, offset)
+	return addr >= base && addr < base+n
+}
+
+// invalid says why seg:offset is outside the program, or returns "".
+func (m *Memcheck) invalid(vm *VM, seg, offset uint16, write bool) string {
+	addr := Physical(seg, offset)
+	if within(addr, vm.sreg["cs"], 0, uint32(vm.minix.Text)) {
+		if write {
+			return "in text"
+		}
+		return ""
+	}
+	sp := vm.reg["sp"]
+	if within(addr, vm.sreg["ds"], 0, uint32(vm.minix.Brk)) || within(addr, vm.sreg["ss"], sp, 0x10000-uint32(sp)) {
+		return ""
+	}
+	if within(addr, vm.sreg["ds"], 0, 0x10000) {
+		return fmt.Sprintf("between brk (%04x) and sp (%04x)", vm.minix.Brk, sp)
+	}
+	return "outside the program"
+}
+
+func (m *Memcheck) before(vm *VM, op *Opcode) error {
+	m.cs, m.ip, m.op = vm.sreg["cs"], vm.ip, op
+	m.si, m.di = vm.reg["si"], vm.reg["di"]
+	return nil
+}
+
+func (m *Memcheck) after(vm *VM, op *Opcode) error {
+	if sp := vm.reg["sp"]; vm.sreg["ss"] == vm.sreg["ds"] && sp < vm.minix.Brk {
+		if !m.below {
+			m.report(vm, StackBelowBrk, "sp %04x is below brk %04x", sp, vm.minix.Brk)
+		}
+		m.below = true
+	} else {
+		m.below = false
+	}
+	m.stringWrap(vm, op)
+	return nil
+}
+
+func (m *Memcheck) memory(vm *VM, a *MemoryAccess) error {
+	n := 1
+	if a.W == Bit16 {
+		n = 2
+	}
+	kind := InvalidRead
+	if a.Write {
+		kind = InvalidWrite
+	}
+	for i := 0; i < n; i++ {
+		if why := m.invalid(vm, a.Segment, a.Offset+uint16(i), a.Write); why != "" {
+			m.report(vm, kind, "%d bytes at %04x:%04x, %s", n, a.Segment, a.Offset+uint16(i), why)
+			break
+		}
+	}
+	if a.Write {
+		m.mark(a.Segment, a.Offset, n)
+	} else if i := m.uninitialized(a.Segment, a.Offset, n); i >= 0 {
+		m.report(vm, UninitializedRead, "%d bytes at %04x:%04x", n, a.Segment, a.Offset+uint16(i))
+	}
+	return nil
+}
+
+type stringOp struct {
+	size   uint32
+	si, di bool
+}
+
+var stringOps = map[Mnemonic]stringOp{
+	MOVSB: {1, true, true},
+	MOVSW: {2, true, true},
+	CMPSB: {1, true, true},
+	CMPSW: {2, true, true},
+	SCASB: {1, false, true},
+	SCASW: {2, false, true},
+	LODSB: {1, true, false},
+	LODSW: {2, true, false},
+	STOSB: {1, false, true},
+	STOSW: {2, false, true},
+}
+
+// wraps reports whether the elements a string instruction went through
+// while moving a pointer from start to end cross the end of the segment.
+func wraps(start, end uint16, size uint32, down bool) bool {
+	if down {
+		moved := uint32(start - end)
+		return moved > uint32(start)+size || (moved > 0 && uint32(start)+size > 0x10000)
+	}
+	return uint32(start)+uint32(end-start) > 0x10000
+}
+
+func (m *Memcheck) stringWrap(vm *VM, op *Opcode) {
+	mn := op.mn
+	if op.following != nil {
+		mn = op.following.mn
+	}
+	s, ok := stringOps[mn]
+	if !ok {
+		return
+	}
+	down := vm.GetFlag(DF) == 1
+	if s.si && wraps(m.si, vm.reg["si"], s.size, down) {
+		m.report(vm, StringWrap, "%s: si wraps around segment %04x from %04x", mn, vm.sreg["ds"], m.si)
+	}
+	if s.di && wraps(m.di, vm.reg["di"], s.size, down) {
+		m.report(vm, StringWrap, "%s: di wraps around segment %04x from %04x", mn, vm.sreg["es"], m.di)
+	}
+}
+
+func (m *Memcheck) syscall(vm *VM, ev *SyscallEvent) error {
+	msg := ev.Message
+	if !ev.Done {
+		switch ev.Call {
+		case MINIX_write:
+			buffer, n := uint16(msg.Get(m1_p1)), int(msg.Get(m1_i2))
+			if i := m.uninitialized(vm.sreg["ds"], buffer, n); i >= 0 {
+				m.report(vm, UninitializedSyscall, "write buffer %04x:%04x, byte %d of %d", vm.sreg["ds"], buffer, i, n)
+			}
+		}
+		return nil
+	}
+	if ev.Err != nil {
+		return nil
+	}
+	switch ev.Call {
+	case MINIX_exec:
+		m.loaded(vm)
+		return nil
+	case MINIX_fork:
+		if ev.Result == 0 {
+			m.Errors, m.seen = nil, make(map[memcheckKey]*MemcheckError)
+		}
+	case MINIX_read:
+		m.mark(vm.sreg["ds"], uint16(msg.Get(m1_p1)), ev.Result)
+	case MINIX_stat:
+		m.mark(vm.sreg["ss"], uint16(msg.Get(m1_p2)), 30)
+	case MINIX_fstat:
+		m.mark(vm.sreg["ss"], uint16(msg.Get(m1_p1)), 30)
+	}
+	// The reply fills fields of the message the guest may not have set.
+	m.mark(vm.sreg["ss"], vm.reg["bx"], 24)
+	return nil
+}
+
+func (m *Memcheck) report(vm *VM, kind MemcheckKind, format string, a ...interface{}) {
+	key := memcheckKey{kind, m.cs, m.ip}
+	if e := m.seen[key]; e != nil {
+		e.Count++
+		return
+	}
+	e := &MemcheckError{Kind: kind, Detail: fmt.Sprintf(format, a...), CS: m.cs, IP: m.ip, Op: m.op, Count: 1}
+	// Walk the stack from the instruction being checked, not the next one.
+	ip := vm.ip
+	vm.ip = m.ip
+	e.Backtrace = vm.Backtrace(BacktraceArgs)
+	vm.ip = ip
+	m.seen[key] = e
+	m.Errors = append(m.Errors, e)
+
+	pid := Pid()
+	fmt.Fprintf(m.w, "==%d== %s\n", pid, e)
+	if e.Op != nil {
+		fmt.Fprintf(m.w, "==%d==    at %04x:%04x  %s\n", pid, e.CS, e.IP, e.Op.Disasm())
+	}
+	for i, f := range e.Backtrace {
+		fmt.Fprintf(m.w, "==%d==    #%-2d %s\n", pid, i, f)
+	}
+	fmt.Fprintf(m.w, "==%d==\n", pid)
+}
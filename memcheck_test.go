@@ -0,0 +1,94 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// runMemcheckTest runs code to its end under a Memcheck.
+func runMemcheckTest(code Bytes, brk, sp uint16) (*VM, *Memcheck, *bytes.Buffer) {
+	vm := newTestVM(code)
+	vm.minix.Text, vm.minix.Brk = uint16(len(code)), brk
+	vm.reg["sp"] = sp
+	buf := new(bytes.Buffer)
+	m := NewMemcheck(buf)
+	m.Attach(vm)
+	for i := 0; i < len(code); i++ {
+		if vm.ip >= uint16(len(code)) {
+			break
+		}
+		vm.Step()
+	}
+	return vm, m, buf
+}
+
+func TestMemcheckAccesses(t *testing.T) {
+	_, m, buf := runMemcheckTest(Bytes{
+		0x83, 0xec, 0x04, // sub sp,0x4
+		0x89, 0xe5, // mov bp,sp
+		0x8b, 0x46, 0x00, // mov ax,[bp+0x0]
+		0xa3, 0x00, 0x08, // mov [0x800],ax
+		0xa3, 0x10, 0x00, // mov [0x10],ax
+		0x8b, 0x1e, 0x10, 0x00, // mov bx,[0x10]
+	}, 0x20, 0xfffe)
+	assert.Equal(t, 2, len(m.Errors))
+	assert.Equal(t, UninitializedRead, m.Errors[0].Kind)
+	assert.Equal(t, "uninitialized read: 2 bytes at 0000:fffa", m.Errors[0].Error())
+	assert.Equal(t, uint16(0x5), m.Errors[0].IP)
+	assert.Equal(t, InvalidWrite, m.Errors[1].Kind)
+	assert.Equal(t, "invalid write: 2 bytes at 0000:0800, between brk (0020) and sp (fffa)", m.Errors[1].Error())
+	assert.True(t, m.Initialized(0, 0x10))
+	assert.False(t, m.Initialized(0, 0x30))
+
+	assert.Nil(t, m.Close())
+	out := buf.String()
+	assert.Contains(t, out, "uninitialized read: 2 bytes at 0000:fffa\n")
+	assert.Contains(t, out, "   at 1000:0005  mov ax,[bp+0x0]\n")
+	assert.Contains(t, out, "#0  1000:0005")
+	assert.Contains(t, out, "ERROR SUMMARY: 2 errors from 2 contexts\n")
+}
+
+func TestMemcheckStackBelowBrk(t *testing.T) {
+	_, m, _ := runMemcheckTest(Bytes{
+		0x50, // push ax
+		0x50, // push ax
+		0x50, // push ax
+	}, 0x20, 0x22)
+	assert.Equal(t, 1, len(m.Errors))
+	assert.Equal(t, "stack below brk: sp 001e is below brk 0020", m.Errors[0].Error())
+	assert.Equal(t, uint16(0x1), m.Errors[0].IP)
+}
+
+func TestMemcheckStringWrap(t *testing.T) {
+	_, m, _ := runMemcheckTest(Bytes{
+		0xbf, 0xfe, 0xff, // mov di,0xfffe
+		0xb9, 0x04, 0x00, // mov cx,0x4
+		0xf2, 0xaa, // repne stosb
+	}, 0x20, 0xfff0)
+	assert.Equal(t, 1, len(m.Errors))
+	assert.Equal(t, "string wrap: stosb: di wraps around segment 0000 from fffe", m.Errors[0].Error())
+}
+
+func TestWraps(t *testing.T) {
+	tests := []struct {
+		start, end uint16
+		size       uint32
+		down       bool
+		wraps      bool
+	}{
+		{0xfffe, 0x0000, 2, false, false},
+		{0xffff, 0x0001, 2, false, true},
+		{0xfffe, 0x0002, 1, false, true},
+		{0x0010, 0x0020, 1, false, false},
+		{0x0000, 0xffff, 1, true, false},
+		{0x0000, 0xfffe, 1, true, true},
+		{0x0000, 0xfffc, 2, true, true},
+		{0xffff, 0xfffd, 2, true, true},
+		{0x0020, 0x0010, 1, true, false},
+		{0x0005, 0x0005, 1, true, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.wraps, wraps(test.start, test.end, test.size, test.down), "%+v", test)
+	}
+}
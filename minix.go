@@ -1,10 +1,11 @@
 package go8086
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -12,22 +13,32 @@ import (
 func CallMINIXSyscall(vm *VM) {
 	m := MinixMessage(vm.SS(vm.reg["bx"]))
 	syscallType := MINIXSyscall(m.Get(m_type))
-	f := minixSyscallFuncMap[syscallType]
-	if f == nil {
-		TraceLog(syscallType)("called   message: %02x", m[0:24])
-		ErrorLog("Not implemented syscall: %d", syscallType)
-		os.Exit(1)
-	} else {
-		TraceLog(syscallType)("called   message: %02x", m[0:24])
-		result, err := f(vm, m, TraceLog(syscallType))
-		if err != nil {
-			result = -1
-			TraceLog(syscallType)("error: %v", err)
+	ev := &SyscallEvent{Call: syscallType, Message: m}
+	if vm.hooks != nil {
+		vm.runSyscallHooks(ev)
+	}
+	if !ev.Skip {
+		f := minixSyscallFuncMap[syscallType]
+		if f == nil {
+			vm.fault(nil, "not implemented syscall: %d", syscallType)
+			return
+		}
+		ev.Result, ev.Err = f(vm, m)
+		switch vm.stopErr.(type) {
+		case *Fault, *Exit:
+			// exit does not return, so no Done event follows.
+			return
 		}
-		m.Set(m_type, int32(result))
-		vm.reg["ax"] = uint16(result)
-		TraceLog(syscallType)("finished message: %02x result: %d", m[0:24], result)
 	}
+	if ev.Err != nil {
+		ev.Result = -1
+	}
+	ev.Done = true
+	if vm.hooks != nil {
+		vm.runSyscallHooks(ev)
+	}
+	m.Set(m_type, int32(ev.Result))
+	vm.reg["ax"] = uint16(ev.Result)
 }
 
 type MINIXSyscall int16
@@ -92,16 +103,31 @@ func (s MINIXSyscall) String() string {
 	return minixSyscallString[s]
 }
 
-type MINIXSyscallFunc func(*VM, MinixMessage, TraceLogger) (int, error)
+// Exit is what the VM stops with when the guest exits, so the tools
+// attached to it are closed before the process exits with Status.
+type Exit struct {
+	Status int
+}
+
+func (e *Exit) Error() string {
+	return fmt.Sprintf("exit status %d", e.Status)
+}
+
+// childFile is the file a forked child writes instead of the file of its
+// parent: the same name with the MINIX pid before the extension.
+func childFile(file string) string {
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(file, ext), Pid(), ext)
+}
+
+type MINIXSyscallFunc func(*VM, MinixMessage) (int, error)
 
 var minixSyscallFuncMap = map[MINIXSyscall]MINIXSyscallFunc{
-	MINIX_exit: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
-		status := m.Get(m1_i1)
-		logger("status: %d", status)
-		syscall.Exit(int(status))
+	MINIX_exit: func(vm *VM, m MinixMessage) (result int, err error) {
+		vm.stop(&Exit{Status: int(m.Get(m1_i1))})
 		return
 	},
-	MINIX_fork: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_fork: func(vm *VM, m MinixMessage) (result int, err error) {
 		ret, _, _ := syscall.Syscall(syscall.SYS_FORK, 0, 0, 0)
 		pid := syscall.Getpid()
 		if pid != int(ret) {
@@ -111,187 +137,180 @@ var minixSyscallFuncMap = map[MINIXSyscall]MINIXSyscallFunc{
 		}
 		return
 	},
-	MINIX_read: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_read: func(vm *VM, m MinixMessage) (result int, err error) {
 		fd := m.Get(m1_i1)
 		nbytes := m.Get(m1_i2)
 		buffer := uint16(m.Get(m1_p1))
 		data := vm.DS(buffer)[0:nbytes]
 		result, err = syscall.Read(int(fd), data)
-		logger("fd: %d nbytes: %d buffer: %04x data: %s", fd, nbytes, buffer, strconv.Quote(string(data[0:100])))
 		return
 	},
-	MINIX_write: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_write: func(vm *VM, m MinixMessage) (result int, err error) {
 		fd := m.Get(m1_i1)
 		nbytes := m.Get(m1_i2)
 		buffer := uint16(m.Get(m1_p1))
 		data := vm.DS(buffer)[0:nbytes]
 		result, err = syscall.Write(int(fd), data)
-		logger("fd: %d nbytes: %d buffer: %04x data: %s", fd, nbytes, buffer, strconv.Quote(string(data)))
 		return
 	},
-	MINIX_open: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_open: func(vm *VM, m MinixMessage) (result int, err error) {
 		names := ""
 		flags := m.Get(m1_i2)
 		if flags&syscall.O_CREAT != 0 {
-			os.Exit(1)
-		} else {
-			names = WithMinixPathPrefix(m.Get_m3_name(vm))
+			vm.fault(nil, "not implemented: open with O_CREAT")
+			return
 		}
+		names = WithMinixPathPrefix(m.Get_m3_name(vm))
 
 		result, err = syscall.Open(names, int(flags), 0)
-		logger("flags: %d names: %s", flags, names)
+		if err == nil {
+			vm.minix.open(result, names, int(flags))
+		}
 		return
 	},
-	MINIX_close: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_close: func(vm *VM, m MinixMessage) (result int, err error) {
 		fd := m.Get(m1_i1)
 		result = 0
-		logger("fd: %d", fd)
 		err = syscall.Close(int(fd))
+		vm.minix.close(int(fd))
 		return
 	},
-	MINIX_wait: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_wait: func(vm *VM, m MinixMessage) (result int, err error) {
 		status := syscall.WaitStatus(0)
 		result, err = syscall.Wait4(-1, &status, 0, nil)
 		result = (result << 4) % 30000
 		m.Set(m2_i1, int32(status))
-		logger("status: %d", status)
 		return
 	},
-	MINIX_creat: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_creat: func(vm *VM, m MinixMessage) (result int, err error) {
 		mode := m.Get(m3_i2)
 		names := WithMinixPathPrefix(m.Get_m3_name(vm))
 		result, err = syscall.Open(names, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_TRUNC, uint32(mode))
-		logger("mode: %d names: %s", mode, names)
+		if err == nil {
+			vm.minix.open(result, names, syscall.O_WRONLY)
+		}
 		return
 	},
-	MINIX_unlink: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_unlink: func(vm *VM, m MinixMessage) (result int, err error) {
 		names := WithMinixPathPrefix(m.Get_m3_name(vm))
 		err = syscall.Unlink(names)
-		logger("names: %s", names)
 		return
 	},
-	MINIX_time: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_time: func(vm *VM, m MinixMessage) (result int, err error) {
 		time_t := time.Now().Unix()
 		m.Set(m2_l1, int32(time_t))
-		logger("time_t : %d", time_t)
 		return
 	},
-	MINIX_chmod: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_chmod: func(vm *VM, m MinixMessage) (result int, err error) {
 		mode := m.Get(m3_i2)
 		names := WithMinixPathPrefix(m.Get_m3_name(vm))
 		err = syscall.Chmod(names, uint32(mode))
-		logger("mode: %d names: %s", mode, names)
 		return
 	},
-	MINIX_brk: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_brk: func(vm *VM, m MinixMessage) (result int, err error) {
 		nd := m.Get(m1_p1)
 		if nd > 0x10000 || uint16(nd) >= vm.reg["sp"] {
 			result = -1
 		} else {
 			m.Set(m2_p1, nd)
+			vm.minix.Brk = uint16(nd)
 		}
-		logger("nd: %04x", nd)
 		return
 	},
-	MINIX_stat: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_stat: func(vm *VM, m MinixMessage) (result int, err error) {
 		bytes := m.Get(m1_i1)
 		name := m.Get(m1_p1)
 		buf := m.Get(m1_p2)
 		names := WithMinixPathPrefix(string(vm.SS(uint16(name))[0 : bytes-1]))
 		stat := syscall.Stat_t{}
 		err = syscall.Stat(names, &stat)
-		vm.SS(uint16(buf))[0:].write16(uint16(stat.Dev))
-		vm.SS(uint16(buf))[2:].write16(uint16(stat.Ino))
-		vm.SS(uint16(buf))[4:].write16(uint16(stat.Mode))
-		vm.SS(uint16(buf))[6:].write16(uint16(stat.Nlink))
-		vm.SS(uint16(buf))[8:].write16(uint16(stat.Uid))
-		vm.SS(uint16(buf))[10:].write16(uint16(stat.Gid))
-		vm.SS(uint16(buf))[12:].write16(uint16(stat.Rdev))
-		vm.SS(uint16(buf))[14:].write32(uint32(stat.Size))
-		vm.SS(uint16(buf))[18:].write32(uint32(stat.Atimespec.Sec))
-		vm.SS(uint16(buf))[22:].write32(uint32(stat.Mtimespec.Sec))
-		vm.SS(uint16(buf))[26:].write32(uint32(stat.Ctimespec.Sec))
-		logger("names: %s stat: %+v", names, stat)
+		vm.SS(uint16(buf))[0:].Write16(uint16(stat.Dev))
+		vm.SS(uint16(buf))[2:].Write16(uint16(stat.Ino))
+		vm.SS(uint16(buf))[4:].Write16(uint16(stat.Mode))
+		vm.SS(uint16(buf))[6:].Write16(uint16(stat.Nlink))
+		vm.SS(uint16(buf))[8:].Write16(uint16(stat.Uid))
+		vm.SS(uint16(buf))[10:].Write16(uint16(stat.Gid))
+		vm.SS(uint16(buf))[12:].Write16(uint16(stat.Rdev))
+		vm.SS(uint16(buf))[14:].Write32(uint32(stat.Size))
+		vm.SS(uint16(buf))[18:].Write32(uint32(stat.Atimespec.Sec))
+		vm.SS(uint16(buf))[22:].Write32(uint32(stat.Mtimespec.Sec))
+		vm.SS(uint16(buf))[26:].Write32(uint32(stat.Ctimespec.Sec))
 		return
 	},
-	MINIX_lseek: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_lseek: func(vm *VM, m MinixMessage) (result int, err error) {
 		fd := m.Get(m2_i1)
 		offset := m.Get(m2_l1)
 		whence := m.Get(m2_i2)
 		new_offset, err := syscall.Seek(int(fd), int64(offset), int(whence))
 		m.Set(m2_l1, int32(new_offset))
-		logger("fd: %d, offset: %d, whence: %d, new_offset: %d", fd, offset, whence, new_offset)
 		return
 	},
-	MINIX_getpid: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_getpid: func(vm *VM, m MinixMessage) (result int, err error) {
 		pid := syscall.Getpid()
 		result = (pid << 4) % 30000
 		return
 	},
-	MINIX_getuid: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_getuid: func(vm *VM, m MinixMessage) (result int, err error) {
 		result = syscall.Getuid()
 		return
 	},
-	MINIX_fstat: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_fstat: func(vm *VM, m MinixMessage) (result int, err error) {
 		fd := m.Get(m1_i1)
 		buf := m.Get(m1_p1)
 		stat := syscall.Stat_t{}
 		err = syscall.Fstat(int(fd), &stat)
-		vm.SS(uint16(buf))[0:].write16(uint16(stat.Dev))
-		vm.SS(uint16(buf))[2:].write16(uint16(stat.Ino))
-		vm.SS(uint16(buf))[4:].write16(uint16(stat.Mode))
-		vm.SS(uint16(buf))[6:].write16(uint16(stat.Nlink))
-		vm.SS(uint16(buf))[8:].write16(uint16(stat.Uid))
-		vm.SS(uint16(buf))[10:].write16(uint16(stat.Gid))
-		vm.SS(uint16(buf))[12:].write16(uint16(stat.Rdev))
-		vm.SS(uint16(buf))[14:].write32(uint32(stat.Size))
-		vm.SS(uint16(buf))[18:].write32(uint32(stat.Atimespec.Sec))
-		vm.SS(uint16(buf))[22:].write32(uint32(stat.Mtimespec.Sec))
-		vm.SS(uint16(buf))[26:].write32(uint32(stat.Ctimespec.Sec))
-		logger("fd: %d stat: %+v", fd, stat)
+		vm.SS(uint16(buf))[0:].Write16(uint16(stat.Dev))
+		vm.SS(uint16(buf))[2:].Write16(uint16(stat.Ino))
+		vm.SS(uint16(buf))[4:].Write16(uint16(stat.Mode))
+		vm.SS(uint16(buf))[6:].Write16(uint16(stat.Nlink))
+		vm.SS(uint16(buf))[8:].Write16(uint16(stat.Uid))
+		vm.SS(uint16(buf))[10:].Write16(uint16(stat.Gid))
+		vm.SS(uint16(buf))[12:].Write16(uint16(stat.Rdev))
+		vm.SS(uint16(buf))[14:].Write32(uint32(stat.Size))
+		vm.SS(uint16(buf))[18:].Write32(uint32(stat.Atimespec.Sec))
+		vm.SS(uint16(buf))[22:].Write32(uint32(stat.Mtimespec.Sec))
+		vm.SS(uint16(buf))[26:].Write32(uint32(stat.Ctimespec.Sec))
 		return
 	},
-	MINIX_access: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_access: func(vm *VM, m MinixMessage) (result int, err error) {
 		mode := m.Get(m3_i2)
 		names := WithMinixPathPrefix(m.Get_m3_name(vm))
 		err = syscall.Access(names, uint32(mode))
-		logger("mode: %d names: %s", mode, names)
 		return
 	},
-	MINIX_pipe: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_pipe: func(vm *VM, m MinixMessage) (result int, err error) {
 		fields := []int{0, 0}
 		err = syscall.Pipe(fields)
 		m.Set(m1_i1, int32(fields[0]))
 		m.Set(m1_i2, int32(fields[1]))
-		logger("fields: %d", fields)
 		return
 	},
-	MINIX_getgid: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_getgid: func(vm *VM, m MinixMessage) (result int, err error) {
 		result = syscall.Getgid()
 		return
 	},
-	MINIX_signal: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_signal: func(vm *VM, m MinixMessage) (result int, err error) {
 		return
 	},
-	MINIX_ioctl: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_ioctl: func(vm *VM, m MinixMessage) (result int, err error) {
 		result = -1
 		return
 	},
-	MINIX_fcntl: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_fcntl: func(vm *VM, m MinixMessage) (result int, err error) {
 		result = -1
 		return
 	},
-	MINIX_exec: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_exec: func(vm *VM, m MinixMessage) (result int, err error) {
 		bytes := m.Get(m1_i1)
 		name := m.Get(m1_p1)
 		names := WithMinixPathPrefix(string(vm.SS(uint16(name))[0 : bytes-1]))
 		frame_size := m.Get(m1_i2)
 		frame := vm.SS(uint16(m.Get(m1_p2)))[0:frame_size]
 
-		argc := frame[0:].read16()
+		argc := frame[0:].Read16()
 		args := []string{}
 		for i := 0; i < int(argc); i++ {
-			ptr := frame[i*2+2:].read16()
+			ptr := frame[i*2+2:].Read16()
 			for j, c := range frame[ptr:] {
 				if c == 0x0 {
 					args = append(args, string(frame[ptr:int(ptr)+j]))
@@ -300,10 +319,10 @@ var minixSyscallFuncMap = map[MINIXSyscall]MINIXSyscallFunc{
 			}
 		}
 
-		envc := (frame[2:].read16() - (argc+3)*2) / 2
+		envc := (frame[2:].Read16() - (argc+3)*2) / 2
 		envs := []string{}
 		for i := 0; i < int(envc); i++ {
-			ptr := frame[i*2+2+int(argc)*2+2:].read16()
+			ptr := frame[i*2+2+int(argc)*2+2:].Read16()
 			for j, c := range frame[ptr:] {
 				if c == 0x0 {
 					envs = append(envs, string(frame[ptr:int(ptr)+j]))
@@ -312,62 +331,259 @@ var minixSyscallFuncMap = map[MINIXSyscall]MINIXSyscallFunc{
 			}
 		}
 
-		logger("names: %s args: %v envs: %v", names, args, envs)
-		aout := NewMinixAout(names)
+		aout, err := LoadMinixAout(names)
+		if err != nil {
+			// Like MINIX, a program that cannot be loaded fails the exec.
+			if os.IsNotExist(err) {
+				return -1, syscall.ENOENT
+			}
+			return -1, syscall.ENOEXEC
+		}
 		aout.InitVM(vm, args, envs)
 		return
 	},
-	MINIX_sigaction: func(vm *VM, m MinixMessage, logger TraceLogger) (result int, err error) {
+	MINIX_sigaction: func(vm *VM, m MinixMessage) (result int, err error) {
 		return
 	},
 }
 
 var MinixPathPrefix = ""
 
+type MinixFile struct {
+	Path   string
+	Flags  int
+	Offset int64
+}
+
+type MinixState struct {
+	Brk   uint16
+	Text  uint16
+	Files map[int]MinixFile
+}
+
+func (ms *MinixState) open(fd int, path string, flags int) {
+	if ms.Files == nil {
+		ms.Files = make(map[int]MinixFile)
+	}
+	ms.Files[fd] = MinixFile{Path: path, Flags: flags &^ (syscall.O_CREAT | syscall.O_TRUNC | syscall.O_EXCL)}
+}
+
+func (ms *MinixState) close(fd int) {
+	delete(ms.Files, fd)
+}
+
+func (ms *MinixState) save() (s MinixState) {
+	s.Brk, s.Text = ms.Brk, ms.Text
+	s.Files = make(map[int]MinixFile)
+	for fd, f := range ms.Files {
+		f.Offset, _ = syscall.Seek(fd, 0, 1)
+		s.Files[fd] = f
+	}
+	return
+}
+
+// Only files opened by path can be reopened; pipes and inherited
+// descriptors are left as the host provides them.
+func (ms *MinixState) restore(s MinixState) (err error) {
+	ms.Brk, ms.Text = s.Brk, s.Text
+	ms.Files = make(map[int]MinixFile)
+	for fd, f := range s.Files {
+		var nfd int
+		if nfd, err = syscall.Open(f.Path, f.Flags, 0); err != nil {
+			return
+		}
+		if nfd != fd {
+			if err = syscall.Dup2(nfd, fd); err != nil {
+				return
+			}
+			syscall.Close(nfd)
+		}
+		if _, err = syscall.Seek(fd, f.Offset, 0); err != nil {
+			return
+		}
+		ms.Files[fd] = f
+	}
+	return
+}
+
 func WithMinixPathPrefix(path string) string {
 	return filepath.Join(MinixPathPrefix, path)
 }
 
+const (
+	minixAoutHeader = 32
+	minixAoutExec   = 0x10 // A_EXEC
+	minixAoutSep    = 0x20 // A_SEP, separate I&D
+	minixAoutI8086  = 0x04 // A_I8086
+	minixAoutTotal  = 0x10000
+)
+
 type MinixAout struct {
+	a_flags  uint8
+	a_cpu    uint8
 	a_hdrlen uint8
 	a_text   int32
 	a_data   int32
 	a_bss    int32
 	a_entry  int32
+	a_total  int32
+	a_syms   int32
 	text     Bytes
 	data     Bytes
+	Symbols  *SymbolTable
 }
 
-func NewMinixAout(file string) (aout *MinixAout) {
-	aout = new(MinixAout)
+// LoadMinixAout reads a MINIX executable, checking that its header and
+// segment sizes fit the file.
+func LoadMinixAout(file string) (aout *MinixAout, err error) {
 	bs, err := ioutil.ReadFile(file)
 	if err != nil {
-		panic(err)
+		return
 	}
+	if len(bs) < 32 || bs[0] != 0x01 || bs[1] != 0x03 {
+		return nil, fmt.Errorf("%s: not a MINIX a.out", file)
+	}
+	aout = new(MinixAout)
+	aout.a_flags = uint8(Bytes(bs)[2])
+	aout.a_cpu = uint8(Bytes(bs)[3])
 	aout.a_hdrlen = uint8(Bytes(bs)[4])
-	aout.a_text = int32(Bytes(bs)[8:].read32())
-	aout.a_data = int32(Bytes(bs)[12:].read32())
-	aout.a_bss = int32(Bytes(bs)[16:].read32())
-	aout.a_entry = int32(Bytes(bs)[20:].read32())
+	aout.a_text = int32(Bytes(bs)[8:].Read32())
+	aout.a_data = int32(Bytes(bs)[12:].Read32())
+	aout.a_bss = int32(Bytes(bs)[16:].Read32())
+	aout.a_entry = int32(Bytes(bs)[20:].Read32())
+	aout.a_total = int32(Bytes(bs)[24:].Read32())
+	end := int64(aout.a_hdrlen) + int64(aout.a_text) + int64(aout.a_data)
+	if aout.a_text < 0 || aout.a_data < 0 || end > int64(len(bs)) {
+		return nil, fmt.Errorf("%s: a.out is truncated", file)
+	}
 	aout.text = Bytes(bs)[int32(aout.a_hdrlen) : int32(aout.a_hdrlen)+aout.a_text]
 	aout.data = Bytes(bs)[int32(aout.a_hdrlen)+aout.a_text : int32(aout.a_hdrlen)+aout.a_text+aout.a_data]
+	if aout.a_hdrlen >= 32 {
+		aout.a_syms = int32(Bytes(bs)[28:].Read32())
+	}
+	if aout.a_syms > 0 {
+		start := int32(aout.a_hdrlen) + aout.a_text + aout.a_data
+		if int(start+aout.a_syms) > len(bs) {
+			DebugLog("%s: symbol table is truncated", file)
+		} else if aout.Symbols, err = ParseMinixSymbols(Bytes(bs)[start : start+aout.a_syms]); err != nil {
+			DebugLog("%s: %v", file, err)
+			err = nil
+		}
+	}
+	return
+}
+
+// NewMinixAout loads a MINIX executable like LoadMinixAout, but panics
+// when it cannot.
+func NewMinixAout(file string) (aout *MinixAout) {
+	aout, err := LoadMinixAout(file)
+	if err != nil {
+		panic(err)
+	}
 	return
 }
 
+// BuildMinixAout makes an executable of text and data, with bss bytes of
+// zeros after data. Data has its own segment with separate I&D, otherwise
+// it follows text in one. The program gets all of its 64K for data and
+// stack.
+func BuildMinixAout(text, data Bytes, bss, entry uint16, separate bool, symbols *SymbolTable) *MinixAout {
+	aout := &MinixAout{
+		a_flags:  minixAoutExec,
+		a_cpu:    minixAoutI8086,
+		a_hdrlen: minixAoutHeader,
+		a_text:   int32(len(text)),
+		a_data:   int32(len(data)),
+		a_bss:    int32(bss),
+		a_entry:  int32(entry),
+		a_total:  minixAoutTotal,
+		text:     text,
+		data:     data,
+		Symbols:  symbols,
+	}
+	if separate {
+		aout.a_flags |= minixAoutSep
+	}
+	aout.a_syms = int32(len(MinixSymbolBytes(symbols)))
+	return aout
+}
+
+// Bytes returns the executable file: the header, text, data and the
+// symbol table.
+func (aout *MinixAout) Bytes() Bytes {
+	header := make(Bytes, aout.a_hdrlen)
+	header[0], header[1], header[2], header[3], header[4] = 0x01, 0x03, aout.a_flags, aout.a_cpu, aout.a_hdrlen
+	header[8:].Write32(uint32(aout.a_text))
+	header[12:].Write32(uint32(aout.a_data))
+	header[16:].Write32(uint32(aout.a_bss))
+	header[20:].Write32(uint32(aout.a_entry))
+	header[24:].Write32(uint32(aout.a_total))
+	if aout.a_hdrlen >= 32 {
+		header[28:].Write32(uint32(aout.a_syms))
+	}
+	bs := append(append(header, aout.text...), aout.data...)
+	if aout.a_syms > 0 {
+		bs = append(bs, MinixSymbolBytes(aout.Symbols)...)
+	}
+	return bs
+}
+
+// Save writes the executable to file.
+func (aout *MinixAout) Save(file string) error {
+	return ioutil.WriteFile(file, aout.Bytes(), 0755)
+}
+
+// Separate tells whether text and data have separate I&D segments.
+func (aout *MinixAout) Separate() bool {
+	return aout.a_flags&minixAoutSep != 0
+}
+
+// Text returns the text segment.
+func (aout *MinixAout) Text() Bytes {
+	return aout.text
+}
+
+// Data returns the initialized data segment.
+func (aout *MinixAout) Data() Bytes {
+	return aout.data
+}
+
+func (aout *MinixAout) Entry() uint16 {
+	return uint16(aout.a_entry)
+}
+
 func (aout *MinixAout) NewVM(args, env []string) (vm *VM) {
 	vm = NewVM()
 	aout.InitVM(vm, args, env)
 	return
 }
 
+// InitVM loads the executable into vm. Without separate I&D, data follows
+// text and all segment registers point at the same segment.
 func (aout *MinixAout) InitVM(vm *VM, args, envs []string) {
 	vm.Init()
 	vm.ip = uint16(aout.a_entry)
 	vm.CS(0x0).write(aout.text)
-	vm.DS(0x0).write(aout.data)
+	brk := aout.a_data + aout.a_bss
+	if aout.Separate() {
+		vm.DS(0x0).write(aout.data)
+	} else {
+		for _, sreg := range []*SegmentRegister{DS, ES, SS} {
+			sreg.Write(vm, CS.Read(vm))
+		}
+		vm.CS(uint16(aout.a_text)).write(aout.data)
+		brk += aout.a_text
+	}
+	vm.minix.Brk = uint16(brk)
+	vm.minix.Text = uint16(aout.a_text)
+	vm.symbols = aout.Symbols
 	aout.StackArgsEnv(vm, args, envs)
 	vm.initSP = vm.reg["sp"]
-	DebugLog("%02x", aout.data[0:100])
+	n := len(aout.data)
+	if n > 100 {
+		n = 100
+	}
+	DebugLog("%02x", aout.data[0:n])
 }
 
 func (aout *MinixAout) StackArgsEnv(vm *VM, args, envs []string) {
@@ -397,17 +613,17 @@ func (aout *MinixAout) StackArgsEnv(vm *VM, args, envs []string) {
 	stack := make(Bytes, stack_len)
 	top := sp - uint16(stack_len)
 
-	stack.write16(uint16(len(args)))
+	stack.Write16(uint16(len(args)))
 
 	for i, _ := range args {
 		ptr := arg_ptrs[i] + 2 + 2*uint16(len(args)+len(envs)+2) + top
-		stack[2+2*i:].write16(ptr)
+		stack[2+2*i:].Write16(ptr)
 	}
 	stack = append(stack, 0x0)
 
 	for i, _ := range envs {
 		ptr := env_ptrs[i] + 2 + 2*uint16(len(args)+len(envs)+2) + top
-		stack[2+2*len(args)+2+2*i:].write16(ptr)
+		stack[2+2*len(args)+2+2*i:].Write16(ptr)
 	}
 	stack = append(stack, 0x0)
 
@@ -463,57 +679,57 @@ const (
 func (m MinixMessage) Get(accessor MinixMessageAccessor) (v int32) {
 	switch accessor {
 	case m_source:
-		v = int32(Bytes(m[0:]).read16())
+		v = int32(Bytes(m[0:]).Read16())
 	case m_type:
-		v = int32(Bytes(m[2:]).read16())
+		v = int32(Bytes(m[2:]).Read16())
 	case m1_i1, m2_i1, m3_i1, m6_i1:
-		v = int32(Bytes(m[4:]).read16())
+		v = int32(Bytes(m[4:]).Read16())
 	case m1_i2, m2_i2, m3_i2, m6_i2:
-		v = int32(Bytes(m[6:]).read16())
+		v = int32(Bytes(m[6:]).Read16())
 	case m1_i3, m2_i3, m6_i3:
-		v = int32(Bytes(m[8:]).read16())
+		v = int32(Bytes(m[8:]).Read16())
 	case m1_p1:
-		v = int32(Bytes(m[10:]).read16())
+		v = int32(Bytes(m[10:]).Read16())
 	case m1_p2:
-		v = int32(Bytes(m[12:]).read16())
+		v = int32(Bytes(m[12:]).Read16())
 	case m1_p3:
-		v = int32(Bytes(m[14:]).read16())
+		v = int32(Bytes(m[14:]).Read16())
 	case m2_l1:
-		v = int32(Bytes(m[10:]).read32())
+		v = int32(Bytes(m[10:]).Read32())
 	case m2_l2:
-		v = int32(Bytes(m[14:]).read32())
+		v = int32(Bytes(m[14:]).Read32())
 	case m2_p1:
-		v = int32(Bytes(m[18:]).read16())
+		v = int32(Bytes(m[18:]).Read16())
 	case m3_p1:
-		v = int32(Bytes(m[8:]).read16())
+		v = int32(Bytes(m[8:]).Read16())
 	case m4_l1:
-		v = int32(Bytes(m[4:]).read32())
+		v = int32(Bytes(m[4:]).Read32())
 	case m4_l2:
-		v = int32(Bytes(m[8:]).read32())
+		v = int32(Bytes(m[8:]).Read32())
 	case m4_l3:
-		v = int32(Bytes(m[12:]).read32())
+		v = int32(Bytes(m[12:]).Read32())
 	case m4_l4:
-		v = int32(Bytes(m[16:]).read32())
+		v = int32(Bytes(m[16:]).Read32())
 	case m4_l5:
-		v = int32(Bytes(m[20:]).read32())
+		v = int32(Bytes(m[20:]).Read32())
 	case m5_c1:
-		v = int32(Bytes(m[4:]).read8())
+		v = int32(Bytes(m[4:]).Read8())
 	case m5_c2:
-		v = int32(Bytes(m[5:]).read8())
+		v = int32(Bytes(m[5:]).Read8())
 	case m5_i1:
-		v = int32(Bytes(m[6:]).read16())
+		v = int32(Bytes(m[6:]).Read16())
 	case m5_i2:
-		v = int32(Bytes(m[8:]).read16())
+		v = int32(Bytes(m[8:]).Read16())
 	case m5_l1:
-		v = int32(Bytes(m[10:]).read32())
+		v = int32(Bytes(m[10:]).Read32())
 	case m5_l2:
-		v = int32(Bytes(m[14:]).read32())
+		v = int32(Bytes(m[14:]).Read32())
 	case m5_l3:
-		v = int32(Bytes(m[18:]).read32())
+		v = int32(Bytes(m[18:]).Read32())
 	case m6_l1:
-		v = int32(Bytes(m[10:]).read32())
+		v = int32(Bytes(m[10:]).Read32())
 	case m6_f1:
-		v = int32(Bytes(m[14:]).read16())
+		v = int32(Bytes(m[14:]).Read16())
 	}
 	return
 }
@@ -521,57 +737,57 @@ func (m MinixMessage) Get(accessor MinixMessageAccessor) (v int32) {
 func (m MinixMessage) Set(accessor MinixMessageAccessor, v int32) {
 	switch accessor {
 	case m_source:
-		Bytes(m[0:]).write16(uint16(v))
+		Bytes(m[0:]).Write16(uint16(v))
 	case m_type:
-		Bytes(m[2:]).write16(uint16(v))
+		Bytes(m[2:]).Write16(uint16(v))
 	case m1_i1, m2_i1, m3_i1, m6_i1:
-		Bytes(m[4:]).write16(uint16(v))
+		Bytes(m[4:]).Write16(uint16(v))
 	case m1_i2, m2_i2, m3_i2, m6_i2:
-		Bytes(m[6:]).write16(uint16(v))
+		Bytes(m[6:]).Write16(uint16(v))
 	case m1_i3, m2_i3, m6_i3:
-		Bytes(m[8:]).write16(uint16(v))
+		Bytes(m[8:]).Write16(uint16(v))
 	case m1_p1:
-		Bytes(m[10:]).write16(uint16(v))
+		Bytes(m[10:]).Write16(uint16(v))
 	case m1_p2:
-		Bytes(m[12:]).write16(uint16(v))
+		Bytes(m[12:]).Write16(uint16(v))
 	case m1_p3:
-		Bytes(m[14:]).write16(uint16(v))
+		Bytes(m[14:]).Write16(uint16(v))
 	case m2_l1:
-		Bytes(m[10:]).write32(uint32(v))
+		Bytes(m[10:]).Write32(uint32(v))
 	case m2_l2:
-		Bytes(m[14:]).write32(uint32(v))
+		Bytes(m[14:]).Write32(uint32(v))
 	case m2_p1:
-		Bytes(m[18:]).write16(uint16(v))
+		Bytes(m[18:]).Write16(uint16(v))
 	case m3_p1:
-		Bytes(m[8:]).write16(uint16(v))
+		Bytes(m[8:]).Write16(uint16(v))
 	case m4_l1:
-		Bytes(m[4:]).write32(uint32(v))
+		Bytes(m[4:]).Write32(uint32(v))
 	case m4_l2:
-		Bytes(m[8:]).write32(uint32(v))
+		Bytes(m[8:]).Write32(uint32(v))
 	case m4_l3:
-		Bytes(m[12:]).write32(uint32(v))
+		Bytes(m[12:]).Write32(uint32(v))
 	case m4_l4:
-		Bytes(m[16:]).write32(uint32(v))
+		Bytes(m[16:]).Write32(uint32(v))
 	case m4_l5:
-		Bytes(m[20:]).write32(uint32(v))
+		Bytes(m[20:]).Write32(uint32(v))
 	case m5_c1:
-		Bytes(m[4:]).write8(uint16(v))
+		Bytes(m[4:]).Write8(uint16(v))
 	case m5_c2:
-		Bytes(m[5:]).write8(uint16(v))
+		Bytes(m[5:]).Write8(uint16(v))
 	case m5_i1:
-		Bytes(m[6:]).write16(uint16(v))
+		Bytes(m[6:]).Write16(uint16(v))
 	case m5_i2:
-		Bytes(m[8:]).write16(uint16(v))
+		Bytes(m[8:]).Write16(uint16(v))
 	case m5_l1:
-		Bytes(m[10:]).write32(uint32(v))
+		Bytes(m[10:]).Write32(uint32(v))
 	case m5_l2:
-		Bytes(m[14:]).write32(uint32(v))
+		Bytes(m[14:]).Write32(uint32(v))
 	case m5_l3:
-		Bytes(m[18:]).write32(uint32(v))
+		Bytes(m[18:]).Write32(uint32(v))
 	case m6_l1:
-		Bytes(m[10:]).write32(uint32(v))
+		Bytes(m[10:]).Write32(uint32(v))
 	case m6_f1:
-		Bytes(m[14:]).write16(uint16(v))
+		Bytes(m[14:]).Write16(uint16(v))
 	}
 }
 
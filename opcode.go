@@ -2,7 +2,6 @@ package go8086
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 )
 
@@ -222,6 +221,34 @@ type Opcode struct {
 	address   uint16
 	sreg      *SegmentRegister
 	following *Opcode
+	symbols   *SymbolTable
+}
+
+func (op *Opcode) WithSymbols(t *SymbolTable) *Opcode {
+	for o := op; o != nil; o = o.following {
+		o.symbols = t
+	}
+	return op
+}
+
+func (op *Opcode) Mnemonic() Mnemonic {
+	return op.mn
+}
+
+func (op *Opcode) Operands() (Operand, Operand) {
+	return op.opr1, op.opr2
+}
+
+func (op *Opcode) Bytes() Bytes {
+	return op.bytes
+}
+
+func (op *Opcode) Address() uint16 {
+	return op.address
+}
+
+func (op *Opcode) Following() *Opcode {
+	return op.following
 }
 
 func (op *Opcode) Disasm() (asm string) {
@@ -261,8 +288,7 @@ func (op *Opcode) Run(vm *VM) {
 		if f != nil {
 			f(op, vm)
 		} else {
-			fmt.Fprintf(os.Stderr, "Not implemented: %s\n", op.Disasm())
-			os.Exit(1)
+			vm.fault(op, "not implemented: %s", op.Disasm())
 		}
 	}
 	return
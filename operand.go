@@ -261,22 +261,11 @@ func (m *Memory) Disasm() string {
 }
 
 func (m *Memory) Read(vm *VM) (value uint16) {
-	switch m.w {
-	case Bit8:
-		value = m.Mem(vm).read8()
-	case Bit16:
-		value = m.Mem(vm).read16()
-	}
-	return
+	return vm.readMem(m.sreg, m.EffectiveAddress(vm), m.w)
 }
 
 func (m *Memory) Write(vm *VM, value uint16) {
-	switch m.w {
-	case Bit8:
-		m.Mem(vm).write8(value)
-	case Bit16:
-		m.Mem(vm).write16(value)
-	}
+	vm.writeMem(m.sreg, m.EffectiveAddress(vm), m.w, value)
 	return
 }
 
@@ -382,3 +371,31 @@ func isBit8(opr Operand) bool {
 func isBit16(opr Operand) bool {
 	return opr.Bit() == Bit16
 }
+
+func RegisterByName(name string) *Register {
+	for _, rs := range regs {
+		for _, r := range rs {
+			if r.name == name {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+func SegmentRegisterByName(name string) *SegmentRegister {
+	for _, r := range sregs {
+		if r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *Register) Name() string {
+	return r.name
+}
+
+func (r *SegmentRegister) Name() string {
+	return r.name
+}
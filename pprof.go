@@ -0,0 +1,175 @@
+package go8086
+
+// A minimal encoder for the pprof profile.proto format, so profiles can be
+// written without depending on the protobuf runtime. Field numbers follow
+// github.com/google/pprof/proto/profile.proto.
+
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *protoBuffer) key(field int, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v != 0 {
+		b.key(field, 0)
+		b.varint(v)
+	}
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.uint64(field, 1)
+	}
+}
+
+func (b *protoBuffer) bytes(field int, bs []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(bs)))
+	*b = append(*b, bs...)
+}
+
+func (b *protoBuffer) message(field int, f func(m *protoBuffer)) {
+	m := protoBuffer{}
+	f(&m)
+	b.bytes(field, m)
+}
+
+func (b *protoBuffer) packed(field int, vs []uint64) {
+	if len(vs) == 0 {
+		return
+	}
+	m := protoBuffer{}
+	for _, v := range vs {
+		m.varint(v)
+	}
+	b.bytes(field, m)
+}
+
+type pprofValueType struct {
+	typ, unit int64
+}
+
+type pprofSample struct {
+	locations []uint64
+	values    []int64
+}
+
+type pprofMapping struct {
+	id, start, limit uint64
+	filename         int64
+}
+
+type pprofLocation struct {
+	id, mapping, address uint64
+	function             uint64
+	line                 int64
+}
+
+type pprofFunction struct {
+	id             uint64
+	name, filename int64
+}
+
+type pprofProfile struct {
+	sampleTypes   []pprofValueType
+	samples       []pprofSample
+	mappings      []pprofMapping
+	locations     []pprofLocation
+	functions     []pprofFunction
+	strings       []string
+	stringIndex   map[string]int64
+	timeNanos     int64
+	durationNanos int64
+	periodType    pprofValueType
+	period        int64
+	defaultType   int64
+}
+
+func newPprofProfile() *pprofProfile {
+	p := &pprofProfile{stringIndex: make(map[string]int64)}
+	p.str("")
+	return p
+}
+
+func (p *pprofProfile) str(s string) int64 {
+	if i, ok := p.stringIndex[s]; ok {
+		return i
+	}
+	i := int64(len(p.strings))
+	p.strings = append(p.strings, s)
+	p.stringIndex[s] = i
+	return i
+}
+
+func (v pprofValueType) encode(m *protoBuffer) {
+	m.int64(1, v.typ)
+	m.int64(2, v.unit)
+}
+
+func (p *pprofProfile) encode() []byte {
+	b := protoBuffer{}
+	for _, t := range p.sampleTypes {
+		b.message(1, t.encode)
+	}
+	for _, s := range p.samples {
+		b.message(2, func(m *protoBuffer) {
+			m.packed(1, s.locations)
+			values := []uint64{}
+			for _, v := range s.values {
+				values = append(values, uint64(v))
+			}
+			m.packed(2, values)
+		})
+	}
+	for _, mp := range p.mappings {
+		b.message(3, func(m *protoBuffer) {
+			m.uint64(1, mp.id)
+			m.uint64(2, mp.start)
+			m.uint64(3, mp.limit)
+			m.int64(5, mp.filename)
+			m.bool(7, true)
+			m.bool(9, true)
+		})
+	}
+	for _, l := range p.locations {
+		b.message(4, func(m *protoBuffer) {
+			m.uint64(1, l.id)
+			m.uint64(2, l.mapping)
+			m.uint64(3, l.address)
+			m.message(4, func(line *protoBuffer) {
+				line.uint64(1, l.function)
+				line.int64(2, l.line)
+			})
+		})
+	}
+	for _, f := range p.functions {
+		b.message(5, func(m *protoBuffer) {
+			m.uint64(1, f.id)
+			m.int64(2, f.name)
+			m.int64(3, f.name)
+			m.int64(4, f.filename)
+		})
+	}
+	for _, s := range p.strings {
+		b.bytes(6, []byte(s))
+	}
+	b.int64(9, p.timeNanos)
+	b.int64(10, p.durationNanos)
+	b.message(11, p.periodType.encode)
+	b.int64(12, p.period)
+	b.int64(14, p.defaultType)
+	return b
+}
@@ -0,0 +1,245 @@
+package go8086
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// profileFrame is one level of the profiler's shadow call stack, kept from
+// the CALL and RET instructions actually executed rather than by reading
+// the guest stack.
+type profileFrame struct {
+	entry          uint32
+	siteCS, siteIP uint16
+	retCS, retIP   uint16
+}
+
+type profileKey struct {
+	stack string
+	cs    uint16
+	ip    uint16
+}
+
+type profileCount struct {
+	cs, ip       uint16
+	frames       []profileFrame
+	instructions int64
+	cycles       int64
+}
+
+// Profiler counts the instructions and estimated clock cycles of every
+// address under its call stack, and writes them as a pprof profile. With
+// Period n only every n-th instruction is recorded, weighted by n; a
+// Period of 0 is taken as 1.
+type Profiler struct {
+	Period       uint64
+	Instructions uint64
+	Cycles       uint64
+	Program      string
+	vm           *VM
+	ids          []HookID
+	frames       []profileFrame
+	stack        string
+	counts       map[profileKey]*profileCount
+	order        []profileKey
+	op           *Opcode
+	cs, ip, cx   uint16
+	n            uint64
+	start        time.Time
+	file         string
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{Period: 1, counts: make(map[profileKey]*profileCount)}
+}
+
+// ProfileFile returns a profiler that writes to file on Close.
+func ProfileFile(file string) *Profiler {
+	p := NewProfiler()
+	p.file = file
+	return p
+}
+
+func (p *Profiler) Attach(vm *VM) {
+	p.vm = vm
+	if p.Period == 0 {
+		p.Period = 1
+	}
+	p.start = time.Now()
+	p.reset()
+	p.ids = []HookID{
+		vm.AddBeforeInstructionHook(p.before),
+		vm.AddAfterInstructionHook(p.after),
+		vm.AddSyscallHook(p.syscall),
+	}
+}
+
+func (p *Profiler) Detach() {
+	for _, id := range p.ids {
+		p.vm.RemoveHook(id)
+	}
+	p.ids = nil
+}
+
+func (p *Profiler) reset() {
+	p.frames = []profileFrame{{entry: Physical(p.vm.sreg["cs"], p.vm.ip)}}
+	p.restack()
+}
+
+func (p *Profiler) restack() {
+	p.stack = fmt.Sprint(p.frames)
+}
+
+func (p *Profiler) before(vm *VM, op *Opcode) error {
+	p.op, p.cs, p.ip, p.cx = op, vm.sreg["cs"], vm.ip, vm.reg["cx"]
+	return nil
+}
+
+func (p *Profiler) after(vm *VM, op *Opcode) error {
+	next := p.ip + uint16(len(op.bytes))
+	taken := vm.ip != next || vm.sreg["cs"] != p.cs
+	n := 0
+	switch {
+	case op.following != nil:
+		n = int(p.cx - vm.reg["cx"])
+	case op.mn >= SHL && op.mn <= RCR:
+		n = int(p.cx & 0xff)
+	}
+	cycles := uint64(Cycles(op, taken, n))
+	p.Instructions++
+	p.Cycles += cycles
+	if p.n++; p.n%p.Period == 0 {
+		p.record(cycles)
+	}
+	switch op.mn {
+	case CALL:
+		p.frames = append(p.frames, profileFrame{
+			entry:  Physical(vm.sreg["cs"], vm.ip),
+			siteCS: p.cs, siteIP: p.ip,
+			retCS: p.cs, retIP: next,
+		})
+		p.restack()
+	case RET, RETF, IRET:
+		// Unwind to the frame returned to; a RET that matches no CALL, like
+		// a hand-made jump table, leaves the stack alone.
+		for k := len(p.frames) - 1; k > 0; k-- {
+			if f := p.frames[k]; f.retCS == vm.sreg["cs"] && f.retIP == vm.ip {
+				p.frames = p.frames[:k]
+				p.restack()
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Profiler) record(cycles uint64) {
+	key := profileKey{p.stack, p.cs, p.ip}
+	c, ok := p.counts[key]
+	if !ok {
+		c = &profileCount{cs: p.cs, ip: p.ip, frames: append([]profileFrame{}, p.frames...)}
+		p.counts[key] = c
+		p.order = append(p.order, key)
+	}
+	c.instructions += int64(p.Period)
+	c.cycles += int64(cycles * p.Period)
+}
+
+func (p *Profiler) syscall(vm *VM, ev *SyscallEvent) (err error) {
+	switch {
+	case ev.Done && ev.Call == MINIX_fork && ev.Result == 0:
+		// The child profiles only itself, into a file of its own.
+		p.counts, p.order = make(map[profileKey]*profileCount), nil
+		p.Instructions, p.Cycles, p.start = 0, 0, time.Now()
+		if p.file != "" {
+			p.file = childFile(p.file)
+		}
+	case ev.Done && ev.Call == MINIX_exec && ev.Err == nil:
+		p.reset()
+	}
+	return
+}
+
+func (p *Profiler) function(ip uint16, entry uint32) string {
+	if sym, ok := p.vm.symbols.Nearest(ip); ok {
+		return sym.Name
+	}
+	return fmt.Sprintf("sub_%05x", entry)
+}
+
+// Write encodes the profile in gzipped pprof format. Locations are
+// physical addresses and, since there is no line table, the line number
+// of a location is its offset, so pprof -lines shows every instruction.
+func (p *Profiler) Write(w io.Writer) (err error) {
+	pp := newPprofProfile()
+	program := p.Program
+	if program == "" {
+		program = "guest"
+	}
+	file := pp.str(filepath.Base(program))
+	pp.sampleTypes = []pprofValueType{
+		{pp.str("instructions"), pp.str("count")},
+		{pp.str("cycles"), pp.str("count")},
+	}
+	pp.periodType = pprofValueType{pp.str("instructions"), pp.str("count")}
+	pp.period = int64(p.Period)
+	pp.defaultType = pp.str("cycles")
+	pp.timeNanos = p.start.UnixNano()
+	pp.durationNanos = int64(time.Since(p.start))
+	pp.mappings = []pprofMapping{{id: 1, start: 0, limit: MemorySize, filename: file}}
+
+	functions := map[string]uint64{}
+	locations := map[string]uint64{}
+	location := func(cs, ip uint16, entry uint32) uint64 {
+		name := p.function(ip, entry)
+		key := fmt.Sprintf("%04x:%04x %s", cs, ip, name)
+		if id, ok := locations[key]; ok {
+			return id
+		}
+		fid, ok := functions[name]
+		if !ok {
+			fid = uint64(len(functions) + 1)
+			functions[name] = fid
+			pp.functions = append(pp.functions, pprofFunction{id: fid, name: pp.str(name), filename: file})
+		}
+		id := uint64(len(locations) + 1)
+		locations[key] = id
+		pp.locations = append(pp.locations, pprofLocation{
+			id: id, mapping: 1, address: uint64(Physical(cs, ip)), function: fid, line: int64(ip),
+		})
+		return id
+	}
+	for _, key := range p.order {
+		c := p.counts[key]
+		top := len(c.frames) - 1
+		ids := []uint64{location(c.cs, c.ip, c.frames[top].entry)}
+		for k := top; k > 0; k-- {
+			f := c.frames[k]
+			ids = append(ids, location(f.siteCS, f.siteIP, c.frames[k-1].entry))
+		}
+		pp.samples = append(pp.samples, pprofSample{locations: ids, values: []int64{c.instructions, c.cycles}})
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err = zw.Write(pp.encode()); err != nil {
+		return
+	}
+	return zw.Close()
+}
+
+// Close writes the profile file, if there is one.
+func (p *Profiler) Close() (err error) {
+	if p.file == "" {
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err = p.Write(buf); err != nil {
+		return
+	}
+	return ioutil.WriteFile(p.file, buf.Bytes(), 0644)
+}
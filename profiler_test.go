@@ -0,0 +1,115 @@
+package go8086
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+var cyclesTests = []struct {
+	code  Bytes
+	taken bool
+	n     int
+	out   int
+}{
+	{Bytes{0x89, 0xd8}, false, 0, 2},              // mov ax,bx
+	{Bytes{0x8b, 0x47, 0x04}, false, 0, 8 + 9},    // mov ax,[bx+0x4]
+	{Bytes{0x01, 0x06, 0x10, 0x00}, false, 0, 22}, // add [0x10],ax
+	{Bytes{0x26, 0x8b, 0x00}, false, 0, 8 + 9},    // mov ax,[es:bx+si]
+	{Bytes{0x74, 0x02}, true, 0, 16},              // jz
+	{Bytes{0x74, 0x02}, false, 0, 4},              // jz
+	{Bytes{0xd3, 0xe0}, false, 3, 8 + 12},         // shl ax,cl
+	{Bytes{0xf3, 0xa4}, false, 4, 9 + 4*17},       // rep movsb
+	{Bytes{0xf7, 0xe3}, false, 0, 128},            // mul bx
+	{Bytes{0xe8, 0x00, 0x00}, false, 0, 19},       // call
+	{Bytes{0xc2, 0x02, 0x00}, false, 0, 20},       // ret 0x2
+	{Bytes{0x50}, false, 0, 11},                   // push ax
+}
+
+func TestCycles(t *testing.T) {
+	for _, c := range cyclesTests {
+		op := getOpcode(nil, 0, c.code)
+		assert.Equal(t, c.out, Cycles(op, c.taken, c.n), op.Disasm())
+	}
+}
+
+var profilerTestCode = Bytes{
+	0xe8, 0x05, 0x00, // call 0x8
+	0xe8, 0x02, 0x00, // call 0x8
+	0xeb, 0xfe, // jmp 0x6
+	0xb8, 0x01, 0x00, // mov ax,0x1
+	0xc3, // ret
+}
+
+func TestProfiler(t *testing.T) {
+	vm := newTestVM(profilerTestCode)
+	p := NewProfiler()
+	p.Attach(vm)
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, uint64(7), p.Instructions)
+	assert.Equal(t, uint64(2*19+2*4+2*16+15), p.Cycles)
+	assert.Equal(t, 1, len(p.frames))
+
+	inner := []*profileCount{}
+	for _, key := range p.order {
+		if c := p.counts[key]; len(c.frames) == 2 {
+			inner = append(inner, c)
+		}
+	}
+	// mov and ret run in f, called from two sites.
+	assert.Equal(t, 4, len(inner))
+	assert.Equal(t, uint16(0x8), inner[0].ip)
+	assert.Equal(t, int64(1), inner[0].instructions)
+	assert.Equal(t, uint16(0x0), inner[0].frames[1].siteIP)
+	assert.Equal(t, uint32(0x10008), inner[0].frames[1].entry)
+	assert.Equal(t, uint16(0x3), inner[2].frames[1].siteIP)
+
+	vm.SetSymbols(NewSymbolTable())
+	vm.symbols.Add(&Symbol{Name: "_f", Value: 0x8, Section: SectionText})
+	assert.Equal(t, "_f", p.function(0xb, 0x10008))
+	assert.Equal(t, "sub_10000", p.function(0x0, 0x10000))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, p.Write(buf))
+	zr, err := gzip.NewReader(buf)
+	assert.Nil(t, err)
+	raw, err := ioutil.ReadAll(zr)
+	assert.Nil(t, err)
+	assert.Contains(t, string(raw), "cycles")
+	assert.Contains(t, string(raw), "_f")
+}
+
+func TestProfilerPeriod(t *testing.T) {
+	vm := newTestVM(profilerTestCode)
+	p := NewProfiler()
+	p.Period = 3
+	p.Attach(vm)
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	total := int64(0)
+	for _, c := range p.counts {
+		total += c.instructions
+	}
+	assert.Equal(t, int64(6), total)
+
+	vm = newTestVM(profilerTestCode)
+	p = NewProfiler()
+	p.Period = 0
+	p.Attach(vm)
+	assert.Nil(t, vm.Step())
+	assert.Equal(t, uint64(1), p.Period)
+}
+
+func TestProtoBuffer(t *testing.T) {
+	b := protoBuffer{}
+	b.uint64(1, 300)
+	b.bytes(2, []byte("hi"))
+	b.packed(3, []uint64{1, 2})
+	b.uint64(4, 0)
+	assert.Equal(t, protoBuffer{0x08, 0xac, 0x02, 0x12, 0x02, 'h', 'i', 0x1a, 0x02, 0x01, 0x02}, b)
+}
@@ -0,0 +1,195 @@
+package go8086
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+type ReplayWrite struct {
+	Address uint32
+	Data    Bytes
+}
+
+type ReplayEvent struct {
+	Count  uint64
+	IP     uint16
+	Call   MINIXSyscall
+	Input  Bytes
+	Output Bytes
+	Result int
+	Err    string        `json:",omitempty"`
+	Writes []ReplayWrite `json:",omitempty"`
+}
+
+type ReplayDivergence struct {
+	Expected *ReplayEvent
+	Actual   *ReplayEvent
+}
+
+func (d *ReplayDivergence) Error() string {
+	if d.Expected == nil {
+		return fmt.Sprintf("replay diverged: unexpected %s syscall at %04x (count %d), log is exhausted", d.Actual.Call, d.Actual.IP, d.Actual.Count)
+	}
+	return fmt.Sprintf("replay diverged: expected %s syscall at %04x (count %d) message %02x, got %s at %04x (count %d) message %02x",
+		d.Expected.Call, d.Expected.IP, d.Expected.Count, d.Expected.Input,
+		d.Actual.Call, d.Actual.IP, d.Actual.Count, d.Actual.Input)
+}
+
+// exit and exec have no nondeterministic result, and exec needs the new
+// image from the host anyway, so replay lets them run for real.
+var replayPassthrough = map[MINIXSyscall]bool{
+	MINIX_exit: true,
+	MINIX_exec: true,
+}
+
+type syscallRegion struct {
+	sreg   *SegmentRegister
+	offset uint16
+	n      int
+}
+
+func syscallRegions(call MINIXSyscall, in MinixMessage, n int) (rs []syscallRegion) {
+	switch call {
+	case MINIX_read:
+		rs = append(rs, syscallRegion{DS, uint16(in.Get(m1_p1)), n})
+	case MINIX_stat:
+		rs = append(rs, syscallRegion{SS, uint16(in.Get(m1_p2)), 30})
+	case MINIX_fstat:
+		rs = append(rs, syscallRegion{SS, uint16(in.Get(m1_p1)), 30})
+	}
+	return
+}
+
+func syscallOutputs(vm *VM, call MINIXSyscall, in MinixMessage, result int) (ws []ReplayWrite) {
+	for _, r := range syscallRegions(call, in, result) {
+		if r.n > 0 {
+			addr := Physical(r.sreg.Read(vm), r.offset)
+			ws = append(ws, ReplayWrite{addr, vm.ReadPhysMem(addr, r.n)})
+		}
+	}
+	return
+}
+
+func newReplayEvent(vm *VM, ev *SyscallEvent) *ReplayEvent {
+	return &ReplayEvent{
+		Count: vm.count,
+		IP:    vm.ip,
+		Call:  ev.Call,
+		Input: append(Bytes{}, ev.Message[0:24]...),
+	}
+}
+
+type Recorder struct {
+	emit    func(*ReplayEvent) error
+	pending *ReplayEvent
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	enc := json.NewEncoder(w)
+	return &Recorder{emit: func(re *ReplayEvent) error { return enc.Encode(re) }}
+}
+
+func (r *Recorder) Attach(vm *VM) HookID {
+	return vm.AddSyscallHook(r.hook)
+}
+
+func (r *Recorder) hook(vm *VM, ev *SyscallEvent) (err error) {
+	if !ev.Done {
+		r.pending = newReplayEvent(vm, ev)
+		return
+	}
+	re := r.pending
+	r.pending = nil
+	re.Output = append(Bytes{}, ev.Message[0:24]...)
+	re.Result = ev.Result
+	if ev.Err != nil {
+		re.Err = ev.Err.Error()
+	} else {
+		re.Writes = syscallOutputs(vm, ev.Call, MinixMessage(re.Input), ev.Result)
+	}
+	if err = r.emit(re); err != nil {
+		return
+	}
+	// Replay does not fork, so it runs only the parent, and a forked child
+	// would interleave its events with the parent's: it records nothing.
+	if ev.Call == MINIX_fork && ev.Result == 0 {
+		r.emit = func(*ReplayEvent) error { return nil }
+	}
+	return
+}
+
+func RecordFile(file string) (r *Recorder, err error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	return NewRecorder(f), nil
+}
+
+type Replayer struct {
+	next func() (*ReplayEvent, error)
+}
+
+func NewReplayer(r io.Reader) *Replayer {
+	dec := json.NewDecoder(r)
+	return &Replayer{next: func() (re *ReplayEvent, err error) {
+		re = new(ReplayEvent)
+		if err = dec.Decode(re); err == io.EOF {
+			return nil, nil
+		}
+		return
+	}}
+}
+
+func newReplayerEvents(events []*ReplayEvent) *Replayer {
+	return &Replayer{next: func() (re *ReplayEvent, err error) {
+		if len(events) > 0 {
+			re, events = events[0], events[1:]
+		}
+		return
+	}}
+}
+
+func ReplayFile(file string) (r *Replayer, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	return NewReplayer(f), nil
+}
+
+func (r *Replayer) Attach(vm *VM) HookID {
+	return vm.AddSyscallHook(r.hook)
+}
+
+func (r *Replayer) hook(vm *VM, ev *SyscallEvent) (err error) {
+	if ev.Done {
+		return
+	}
+	actual := newReplayEvent(vm, ev)
+	expected, err := r.next()
+	if err != nil {
+		return
+	}
+	if expected == nil || expected.Count != actual.Count || expected.IP != actual.IP ||
+		expected.Call != actual.Call || string(expected.Input) != string(actual.Input) {
+		ev.Skip = true
+		return &ReplayDivergence{expected, actual}
+	}
+	if replayPassthrough[ev.Call] {
+		return
+	}
+	ev.Skip = true
+	for _, w := range expected.Writes {
+		vm.WritePhysMem(w.Address, w.Data)
+	}
+	copy(ev.Message[0:24], expected.Output)
+	ev.Result = expected.Result
+	if expected.Err != "" {
+		ev.Err = errors.New(expected.Err)
+	}
+	return
+}
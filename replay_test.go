@@ -0,0 +1,51 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var replayTestCode = Bytes{
+	0xbb, 0x00, 0x01, // mov bx,0x100
+	0xcd, 0x20, // int 0x20
+	0xcd, 0x20, // int 0x20
+}
+
+func TestRecordReplay(t *testing.T) {
+	log := new(bytes.Buffer)
+	vm := newTestVM(replayTestCode)
+	vm.SS(0x102).Write16(uint16(MINIX_time))
+	NewRecorder(log).Attach(vm)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	recorded := MinixMessage(vm.SS(0x100)).Get(m2_l1)
+
+	vm = newTestVM(replayTestCode)
+	vm.SS(0x102).Write16(uint16(MINIX_time))
+	NewReplayer(bytes.NewReader(log.Bytes())).Attach(vm)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, recorded, MinixMessage(vm.SS(0x100)).Get(m2_l1))
+	assert.Equal(t, uint16(0), vm.Reg(AX))
+
+	err := vm.Step()
+	assert.IsType(t, &ReplayDivergence{}, err)
+}
+
+func TestRecordForkChild(t *testing.T) {
+	log := new(bytes.Buffer)
+	vm := newTestVM(replayTestCode)
+	r := NewRecorder(log)
+	for _, call := range []MINIXSyscall{MINIX_fork, MINIX_time} {
+		ev := &SyscallEvent{Call: call, Message: make(MinixMessage, 24)}
+		assert.Nil(t, r.hook(vm, ev))
+		ev.Done = true
+		assert.Nil(t, r.hook(vm, ev))
+	}
+	// Only the fork that returned 0 in the child is in the log.
+	assert.Equal(t, 1, strings.Count(log.String(), "\n"))
+}
@@ -1,10 +1,5 @@
 package go8086
 
-import (
-	"fmt"
-	"os"
-)
-
 type opcodeRunFunc func(*Opcode, *VM)
 
 //http://stackoverflow.com/a/8037485/2052892
@@ -361,7 +356,7 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 		vm.FlagOFF(DF)
 	},
 	SCASB: func(op *Opcode, vm *VM) {
-		a, b := AL.Read(vm), vm.ES(vm.reg["di"]).read8()
+		a, b := AL.Read(vm), vm.readMem(ES, vm.reg["di"], Bit8)
 		w := Bit8
 		res, cf, of := CalcADC(a, ^b, 1, w)
 		vm.SetFlag(CF, cf == 0)
@@ -376,7 +371,7 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 		}
 	},
 	CMPSB: func(op *Opcode, vm *VM) {
-		a, b := vm.DS(vm.reg["si"]).read8(), vm.ES(vm.reg["di"]).read8()
+		a, b := vm.readMem(DS, vm.reg["si"], Bit8), vm.readMem(ES, vm.reg["di"], Bit8)
 		w := Bit8
 		res, cf, of := CalcADC(a, ^b, 1, w)
 		vm.SetFlag(CF, cf == 0)
@@ -393,7 +388,7 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 		}
 	},
 	STOSB: func(op *Opcode, vm *VM) {
-		vm.ES(vm.reg["di"]).write8(AL.Read(vm))
+		vm.writeMem(ES, vm.reg["di"], Bit8, AL.Read(vm))
 		if vm.GetFlag(DF) == 1 {
 			vm.reg["di"] -= 1
 		} else {
@@ -401,7 +396,7 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 		}
 	},
 	MOVSB: func(op *Opcode, vm *VM) {
-		vm.ES(vm.reg["di"]).write8(vm.DS(vm.reg["si"]).read8())
+		vm.writeMem(ES, vm.reg["di"], Bit8, vm.readMem(DS, vm.reg["si"], Bit8))
 		if vm.GetFlag(DF) == 1 {
 			vm.reg["di"] -= 1
 			vm.reg["si"] -= 1
@@ -411,7 +406,7 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 		}
 	},
 	MOVSW: func(op *Opcode, vm *VM) {
-		vm.ES(vm.reg["di"]).write16(vm.DS(vm.reg["si"]).read16())
+		vm.writeMem(ES, vm.reg["di"], Bit16, vm.readMem(DS, vm.reg["si"], Bit16))
 		if vm.GetFlag(DF) == 1 {
 			vm.reg["di"] -= 2
 			vm.reg["si"] -= 2
@@ -432,18 +427,30 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 		vm.reg["dx"] = uint16(dst >> 16)
 	},
 	HLT: func(op *Opcode, vm *VM) {
-		panic("HLT")
+		vm.fault(op, "halted")
 	},
 	INT: func(op *Opcode, vm *VM) {
 		n := op.opr1.(ReadableOperand).Read(vm)
+		if vm.hooks != nil {
+			vm.runInterruptHooks(uint8(n))
+		}
 		switch n {
 		case 32:
 			CallMINIXSyscall(vm)
 		default:
-			fmt.Fprintf(os.Stderr, "Not implemented: %s\n", op.Disasm())
-			os.Exit(1)
+			vm.fault(op, "not implemented: %s", op.Disasm())
 		}
 	},
+	IN: func(op *Opcode, vm *VM) {
+		opr1 := op.opr1.(WritableOperand)
+		port := op.opr2.(ReadableOperand).Read(vm)
+		opr1.Write(vm, vm.in(port, opr1.Bit()))
+	},
+	OUT: func(op *Opcode, vm *VM) {
+		port := op.opr1.(ReadableOperand).Read(vm)
+		opr2 := op.opr2.(ReadableOperand)
+		vm.out(port, opr2.Bit(), opr2.Read(vm))
+	},
 	SHL: func(op *Opcode, vm *VM) {
 		opr1 := op.opr1.(ReadWritableOperand)
 		w := opr1.Bit()
@@ -528,8 +535,7 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 			vm.SetFlag(SF, SignOf(res, opr1.Bit()) == 1)
 			vm.SetFlag(PF, ParityOf(res) == 1)
 		} else {
-			fmt.Fprintf(os.Stderr, "Not implemented: %s\n", op.Disasm())
-			os.Exit(1)
+			vm.fault(op, "not implemented: %s", op.Disasm())
 		}
 	},
 	RCR: func(op *Opcode, vm *VM) {
@@ -547,8 +553,7 @@ var opcodeRunFuncMap = map[Mnemonic]opcodeRunFunc{
 			vm.SetFlag(SF, SignOf(res, opr1.Bit()) == 1)
 			vm.SetFlag(PF, ParityOf(res) == 1)
 		} else {
-			fmt.Fprintf(os.Stderr, "Not implemented: %s\n", op.Disasm())
-			os.Exit(1)
+			vm.fault(op, "not implemented: %s", op.Disasm())
 		}
 	},
 }
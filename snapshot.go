@@ -0,0 +1,169 @@
+package go8086
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const snapshotMagic = "GO8086SS"
+const SnapshotVersion = 1
+const CPUModel = "8086"
+
+var ErrSnapshotFormat = errors.New("not a go8086 snapshot")
+
+type Snapshot struct {
+	Version    uint16
+	CPU        string
+	Reg        map[string]uint16
+	SReg       map[string]uint16
+	IP         uint16
+	Flag       uint16
+	Mem        Bytes
+	InitSP     uint16
+	Count      uint64
+	PathPrefix string
+	Minix      MinixState
+	Symbols    []Symbol
+}
+
+func (vm *VM) Snapshot() (s *Snapshot) {
+	s = &Snapshot{
+		Version:    SnapshotVersion,
+		CPU:        CPUModel,
+		Reg:        make(map[string]uint16),
+		SReg:       make(map[string]uint16),
+		IP:         vm.ip,
+		Flag:       vm.flag,
+		Mem:        make(Bytes, len(vm.mem)),
+		InitSP:     vm.initSP,
+		Count:      vm.count,
+		PathPrefix: MinixPathPrefix,
+		Minix:      vm.minix.save(),
+	}
+	for k, v := range vm.reg {
+		s.Reg[k] = v
+	}
+	for k, v := range vm.sreg {
+		s.SReg[k] = v
+	}
+	copy(s.Mem, vm.mem)
+	for _, sym := range vm.symbols.Symbols() {
+		s.Symbols = append(s.Symbols, *sym)
+	}
+	return
+}
+
+func (vm *VM) Restore(s *Snapshot) (err error) {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", s.Version)
+	}
+	if s.CPU != CPUModel {
+		return fmt.Errorf("unsupported snapshot CPU: %s", s.CPU)
+	}
+	if len(s.Mem) != MemorySize {
+		return fmt.Errorf("snapshot memory size %#x, want %#x", len(s.Mem), MemorySize)
+	}
+	vm.restore(s)
+	if MinixPathPrefix == "" {
+		MinixPathPrefix = s.PathPrefix
+	}
+	return vm.minix.restore(s.Minix)
+}
+
+func (vm *VM) restore(s *Snapshot) {
+	vm.Init()
+	for k, v := range s.Reg {
+		vm.reg[k] = v
+	}
+	for k, v := range s.SReg {
+		vm.sreg[k] = v
+	}
+	vm.ip = s.IP
+	vm.flag = s.Flag
+	copy(vm.mem, s.Mem)
+	vm.initSP = s.InitSP
+	vm.count = s.Count
+	vm.minix.Brk, vm.minix.Text = s.Minix.Brk, s.Minix.Text
+	vm.symbols = nil
+	if len(s.Symbols) > 0 {
+		vm.symbols = NewSymbolTable()
+		for i := range s.Symbols {
+			sym := s.Symbols[i]
+			vm.symbols.Add(&sym)
+		}
+	}
+}
+
+func (s *Snapshot) Write(w io.Writer) (err error) {
+	if _, err = io.WriteString(w, snapshotMagic); err != nil {
+		return
+	}
+	if _, err = w.Write([]byte{byte(s.Version), byte(s.Version >> 8)}); err != nil {
+		return
+	}
+	zw := gzip.NewWriter(w)
+	if err = gob.NewEncoder(zw).Encode(s); err != nil {
+		return
+	}
+	return zw.Close()
+}
+
+func ReadSnapshot(r io.Reader) (s *Snapshot, err error) {
+	header := make([]byte, len(snapshotMagic)+2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	if string(header[0:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrSnapshotFormat
+	}
+	version := Bytes(header[len(snapshotMagic):]).Read16()
+	if version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %d", version)
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	s = new(Snapshot)
+	err = gob.NewDecoder(zr).Decode(s)
+	return
+}
+
+func (vm *VM) SaveSnapshot(file string) (err error) {
+	buf := new(bytes.Buffer)
+	if err = vm.Snapshot().Write(buf); err != nil {
+		return
+	}
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+func LoadSnapshot(file string) (vm *VM, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s, err := ReadSnapshot(f)
+	if err != nil {
+		return
+	}
+	vm = NewVM()
+	err = vm.Restore(s)
+	return
+}
+
+func SnapshotHook(file string, at uint64) InstructionHook {
+	return func(vm *VM, op *Opcode) error {
+		if vm.count != at {
+			return nil
+		}
+		DebugLog("snapshot at %d: %s", at, file)
+		return vm.SaveSnapshot(file)
+	}
+}
@@ -0,0 +1,38 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	vm := NewVM()
+	vm.SetReg(AX, 0x1234)
+	vm.SetReg(SP, 0xff00)
+	vm.SetSReg(DS, 0x2000)
+	vm.SetIP(0x0042)
+	vm.SetFlag(ZF, true)
+	vm.WriteString(0x2000, 0x0010, "snapshot")
+	vm.minix.Brk = 0x0800
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, vm.Snapshot().Write(buf))
+	s, err := ReadSnapshot(buf)
+	assert.Nil(t, err)
+
+	restored := NewVM()
+	assert.Nil(t, restored.Restore(s))
+	assert.Equal(t, uint16(0x1234), restored.Reg(AX))
+	assert.Equal(t, uint16(0xff00), restored.Reg(SP))
+	assert.Equal(t, uint16(0x2000), restored.SReg(DS))
+	assert.Equal(t, uint16(0x0042), restored.IP())
+	assert.Equal(t, uint16(1), restored.GetFlag(ZF))
+	assert.Equal(t, "snapshot", restored.ReadString(0x2000, 0x0010))
+	assert.Equal(t, uint16(0x0800), restored.minix.Brk)
+}
+
+func TestSnapshotBadMagic(t *testing.T) {
+	_, err := ReadSnapshot(bytes.NewBufferString("NOTASNAPSHOT"))
+	assert.Equal(t, ErrSnapshotFormat, err)
+}
@@ -0,0 +1,373 @@
+package go8086
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StraceStringMax caps the strings and buffers a line shows, like strace -s.
+var StraceStringMax = 32
+
+var openAccessNames = []string{"O_RDONLY", "O_WRONLY", "O_RDWR", "O_ACCMODE"}
+
+// MINIX's values, which are not necessarily the host's.
+var openFlagNames = []struct {
+	bit  int64
+	name string
+}{
+	{0100, "O_CREAT"},
+	{0200, "O_EXCL"},
+	{0400, "O_NOCTTY"},
+	{01000, "O_TRUNC"},
+	{02000, "O_APPEND"},
+	{04000, "O_NONBLOCK"},
+}
+
+var whenceNames = []string{"SEEK_SET", "SEEK_CUR", "SEEK_END"}
+
+var errnoNames = map[syscall.Errno]string{
+	syscall.EPERM:        "EPERM",
+	syscall.ENOENT:       "ENOENT",
+	syscall.ESRCH:        "ESRCH",
+	syscall.EINTR:        "EINTR",
+	syscall.EIO:          "EIO",
+	syscall.ENXIO:        "ENXIO",
+	syscall.E2BIG:        "E2BIG",
+	syscall.ENOEXEC:      "ENOEXEC",
+	syscall.EBADF:        "EBADF",
+	syscall.ECHILD:       "ECHILD",
+	syscall.EAGAIN:       "EAGAIN",
+	syscall.ENOMEM:       "ENOMEM",
+	syscall.EACCES:       "EACCES",
+	syscall.EFAULT:       "EFAULT",
+	syscall.EBUSY:        "EBUSY",
+	syscall.EEXIST:       "EEXIST",
+	syscall.EXDEV:        "EXDEV",
+	syscall.ENODEV:       "ENODEV",
+	syscall.ENOTDIR:      "ENOTDIR",
+	syscall.EISDIR:       "EISDIR",
+	syscall.EINVAL:       "EINVAL",
+	syscall.ENFILE:       "ENFILE",
+	syscall.EMFILE:       "EMFILE",
+	syscall.ENOTTY:       "ENOTTY",
+	syscall.ETXTBSY:      "ETXTBSY",
+	syscall.EFBIG:        "EFBIG",
+	syscall.ENOSPC:       "ENOSPC",
+	syscall.ESPIPE:       "ESPIPE",
+	syscall.EROFS:        "EROFS",
+	syscall.EMLINK:       "EMLINK",
+	syscall.EPIPE:        "EPIPE",
+	syscall.EDOM:         "EDOM",
+	syscall.ERANGE:       "ERANGE",
+	syscall.ENAMETOOLONG: "ENAMETOOLONG",
+	syscall.ENOSYS:       "ENOSYS",
+	syscall.ENOTEMPTY:    "ENOTEMPTY",
+}
+
+func openFlagsString(v int64) string {
+	names := []string{openAccessNames[v&3]}
+	v &^= 3
+	for _, f := range openFlagNames {
+		if v&f.bit != 0 {
+			names = append(names, f.name)
+			v &^= f.bit
+		}
+	}
+	if v != 0 {
+		names = append(names, fmt.Sprintf("%#o", v))
+	}
+	return strings.Join(names, "|")
+}
+
+func accessModeString(v int64) string {
+	if v == 0 {
+		return "F_OK"
+	}
+	names := []string{}
+	for _, f := range []struct {
+		bit  int64
+		name string
+	}{{4, "R_OK"}, {2, "W_OK"}, {1, "X_OK"}} {
+		if v&f.bit != 0 {
+			names = append(names, f.name)
+			v &^= f.bit
+		}
+	}
+	if v != 0 {
+		names = append(names, fmt.Sprintf("%#x", v))
+	}
+	return strings.Join(names, "|")
+}
+
+func errnoString(err error) string {
+	if errno, ok := err.(syscall.Errno); ok {
+		if name, ok := errnoNames[errno]; ok {
+			return fmt.Sprintf("%s (%s)", name, errno.Error())
+		}
+		return fmt.Sprintf("errno %d (%s)", int(errno), errno.Error())
+	}
+	return fmt.Sprintf("(%v)", err)
+}
+
+// quoteString quotes s, cut to max, adding "..." when it or the data it
+// came from, of length n, was longer.
+func quoteString(s string, n, max int) string {
+	cut := len(s) > max
+	if cut {
+		s = s[:max]
+	}
+	q := strconv.Quote(s)
+	if cut || n > len(s) {
+		q += "..."
+	}
+	return q
+}
+
+// Strace prints a line for every MINIX syscall a guest makes, like strace:
+//
+//	open("/etc/passwd", O_RDONLY) = 3 <0.000021>
+type Strace struct {
+	Filter    map[MINIXSyscall]bool
+	Timing    bool
+	StringMax int
+	vm        *VM
+	id        HookID
+	w         *bufio.Writer
+	closer    func() error
+	file      string
+	pid       bool
+	line      string
+	start     time.Time
+}
+
+// NewStrace writes to w, prefixing lines with the pid since processes
+// share it.
+func NewStrace(w io.Writer) *Strace {
+	return &Strace{StringMax: StraceStringMax, w: bufio.NewWriter(w), pid: true}
+}
+
+// StraceFile writes to file; like the tracer, a forked child continues in
+// a file of its own named after its MINIX pid.
+func StraceFile(file string) (s *Strace, err error) {
+	s = &Strace{StringMax: StraceStringMax}
+	err = s.reopen(file)
+	return
+}
+
+func (s *Strace) reopen(file string) (err error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	if s.closer != nil {
+		s.closer()
+	}
+	s.w, s.file, s.closer = bufio.NewWriter(f), file, f.Close
+	return
+}
+
+// ParseSyscallFilter reads a comma separated list of calls to show, or,
+// starting with "!", to hide.
+func ParseSyscallFilter(spec string) (filter map[MINIXSyscall]bool, err error) {
+	if spec == "" || spec == "all" {
+		return nil, nil
+	}
+	exclude := strings.HasPrefix(spec, "!")
+	spec = strings.TrimPrefix(spec, "!")
+	filter = make(map[MINIXSyscall]bool)
+	if exclude {
+		for call := range SyscallTable {
+			filter[call] = true
+		}
+	}
+	for _, name := range strings.Split(spec, ",") {
+		call, ok := LookupSyscall(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown syscall %q", name)
+		}
+		filter[call] = !exclude
+	}
+	return
+}
+
+func (s *Strace) Attach(vm *VM) {
+	s.vm = vm
+	s.id = vm.AddSyscallHook(s.hook)
+}
+
+func (s *Strace) Detach() {
+	s.vm.RemoveHook(s.id)
+}
+
+func (s *Strace) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *Strace) Close() (err error) {
+	err = s.Flush()
+	if s.closer != nil {
+		if cerr := s.closer(); err == nil {
+			err = cerr
+		}
+		s.closer = nil
+	}
+	return
+}
+
+func (s *Strace) shown(call MINIXSyscall) bool {
+	return s.Filter == nil || s.Filter[call]
+}
+
+func (s *Strace) printf(format string, a ...interface{}) {
+	if s.pid {
+		fmt.Fprintf(s.w, "%d ", Pid())
+	}
+	fmt.Fprintf(s.w, format, a...)
+}
+
+func (s *Strace) hook(vm *VM, ev *SyscallEvent) (err error) {
+	if !s.shown(ev.Call) {
+		switch {
+		case !ev.Done && ev.Call == MINIX_fork:
+			return s.Flush()
+		case ev.Done && ev.Call == MINIX_fork && ev.Result == 0 && s.file != "":
+			return s.reopen(childFile(s.file))
+		}
+		return
+	}
+	d, ok := SyscallTable[ev.Call]
+	if !ev.Done {
+		// Arguments are read now, before the call overwrites them.
+		if ok {
+			s.line = fmt.Sprintf("%s(%s)", d.Name, s.args(d, ev.Message))
+		} else {
+			s.line = fmt.Sprintf("syscall_%d(% x)", ev.Call, []byte(ev.Message[4:24]))
+		}
+		s.start = time.Now()
+		switch {
+		case ev.Call == MINIX_exit && !ev.Skip:
+			s.printf("%s = ?\n", s.line)
+			s.printf("+++ exited with %d +++\n", ev.Message.Get(m1_i1))
+		case minixSyscallFuncMap[ev.Call] == nil && !ev.Skip:
+			s.printf("%s = ? (not implemented)\n", s.line)
+			return s.Flush()
+		case ev.Call == MINIX_fork:
+			return s.Flush()
+		}
+		return
+	}
+	elapsed := time.Since(s.start)
+	if ev.Call == MINIX_fork && ev.Result == 0 {
+		// The parent reports the fork.
+		if s.file != "" {
+			err = s.reopen(childFile(s.file))
+		}
+		return
+	}
+	note := ""
+	switch {
+	case ev.Err != nil:
+		note = " " + errnoString(ev.Err)
+	case ok && len(d.Out) > 0:
+		if out := s.outputs(d, ev.Message, ev.Result); out != "" {
+			note = " (" + out + ")"
+		}
+	}
+	line := fmt.Sprintf("%s = %d%s", s.line, ev.Result, note)
+	if ev.Skip {
+		line += " (skipped)"
+	}
+	if s.Timing {
+		line += fmt.Sprintf(" <%.6f>", elapsed.Seconds())
+	}
+	s.printf("%s\n", line)
+	if s.pid {
+		// Shared with the guest's own output, so keep them in order.
+		return s.Flush()
+	}
+	return
+}
+
+func (s *Strace) args(d *SyscallDesc, m MinixMessage) string {
+	args := []string{}
+	for _, a := range d.Args {
+		args = append(args, s.format(a, m, 0))
+	}
+	return strings.Join(args, ", ")
+}
+
+// outputs shows what the call returned; data read into a buffer replaces
+// the buffer's address in the arguments, as strace shows it.
+func (s *Strace) outputs(d *SyscallDesc, m MinixMessage, result int) string {
+	out := []string{}
+	for _, o := range d.Out {
+		v := s.format(o, m, result)
+		if o.Type == ArgData {
+			for _, a := range d.Args {
+				if a.Field == o.Field {
+					s.line = fmt.Sprintf("%s(%s)", d.Name, s.replace(d, m, a.Name, v))
+				}
+			}
+			continue
+		}
+		out = append(out, o.Name+"="+v)
+	}
+	return strings.Join(out, ", ")
+}
+
+func (s *Strace) replace(d *SyscallDesc, m MinixMessage, name, v string) string {
+	args := []string{}
+	for _, a := range d.Args {
+		if a.Name == name {
+			args = append(args, v)
+		} else {
+			args = append(args, s.format(a, m, 0))
+		}
+	}
+	return strings.Join(args, ", ")
+}
+
+func (s *Strace) format(a SyscallArg, m MinixMessage, result int) string {
+	v := a.Decode(s.vm, m, result)
+	switch a.Type {
+	case ArgPath, ArgString, ArgBuffer, ArgData:
+		return quoteString(v.Text, a.Length(m, result), s.StringMax)
+	case ArgArgv:
+		argv := []string{}
+		if v.Text != "" {
+			for _, arg := range strings.Split(v.Text, "\x00") {
+				argv = append(argv, quoteString(arg, 0, s.StringMax))
+			}
+		}
+		return "[" + strings.Join(argv, ", ") + "]"
+	case ArgPointer:
+		return fmt.Sprintf("%#x", uint16(v.Value))
+	case ArgOpenFlags:
+		return openFlagsString(v.Value)
+	case ArgMode:
+		return fmt.Sprintf("%#o", v.Value)
+	case ArgAccessMode:
+		return accessModeString(v.Value)
+	case ArgWhence:
+		if v.Value >= 0 && int(v.Value) < len(whenceNames) {
+			return whenceNames[v.Value]
+		}
+	}
+	return fmt.Sprint(v.Value)
+}
+
+// SyscallNames lists the calls a filter can name.
+func SyscallNames() (names []string) {
+	for _, d := range SyscallTable {
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,109 @@
+package go8086
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func straceTestCall(vm *VM, call MINIXSyscall, set func(m MinixMessage)) MinixMessage {
+	vm.reg["bx"] = 0x100
+	m := MinixMessage(vm.SS(0x100))
+	for i := range m[:24] {
+		m[i] = 0
+	}
+	m.Set(m_type, int32(call))
+	set(m)
+	CallMINIXSyscall(vm)
+	return m
+}
+
+func TestStrace(t *testing.T) {
+	vm := NewVM()
+	buf := new(bytes.Buffer)
+	s := NewStrace(buf)
+	s.StringMax = 8
+	s.Attach(vm)
+
+	vm.DS(0x200).write(Bytes("/nonexistent/x\x00"))
+	straceTestCall(vm, MINIX_open, func(m MinixMessage) {
+		m.Set(m3_i1, 15)
+		m.Set(m3_p1, 0x200)
+	})
+	m := straceTestCall(vm, MINIX_pipe, func(m MinixMessage) {})
+	r, w := m.Get(m1_i1), m.Get(m1_i2)
+	defer syscall.Close(int(r))
+	defer syscall.Close(int(w))
+	vm.DS(0x300).write(Bytes("hello world"))
+	straceTestCall(vm, MINIX_write, func(m MinixMessage) {
+		m.Set(m1_i1, w)
+		m.Set(m1_i2, 11)
+		m.Set(m1_p1, 0x300)
+	})
+	straceTestCall(vm, MINIX_read, func(m MinixMessage) {
+		m.Set(m1_i1, r)
+		m.Set(m1_i2, 5)
+		m.Set(m1_p1, 0x400)
+	})
+	assert.Nil(t, s.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	pid := fmt.Sprintf("%d ", Pid())
+	for i, l := range lines {
+		assert.True(t, strings.HasPrefix(l, pid))
+		lines[i] = strings.TrimPrefix(l, pid)
+	}
+	assert.Equal(t, `open("/nonexis"..., O_RDONLY) = -1 ENOENT (no such file or directory)`, lines[0])
+	assert.Equal(t, fmt.Sprintf("pipe() = 0 (fd0=%d, fd1=%d)", r, w), lines[1])
+	assert.Equal(t, fmt.Sprintf(`write(%d, "hello wo"..., 11) = 11`, w), lines[2])
+	assert.Equal(t, fmt.Sprintf(`read(%d, "hello", 5) = 5`, r), lines[3])
+
+	buf.Reset()
+	s.Filter, s.Timing = map[MINIXSyscall]bool{MINIX_brk: true}, true
+	straceTestCall(vm, MINIX_getpid, func(m MinixMessage) {})
+	straceTestCall(vm, MINIX_brk, func(m MinixMessage) { m.Set(m1_p1, 0x800) })
+	assert.Regexp(t, `^\d+ brk\(0x800\) = 0 \(addr=0x800\) <\d+\.\d{6}>\n$`, buf.String())
+}
+
+func TestParseSyscallFilter(t *testing.T) {
+	filter, err := ParseSyscallFilter("read, write")
+	assert.Nil(t, err)
+	assert.Equal(t, map[MINIXSyscall]bool{MINIX_read: true, MINIX_write: true}, filter)
+
+	filter, err = ParseSyscallFilter("!write")
+	assert.Nil(t, err)
+	assert.False(t, filter[MINIX_write])
+	assert.True(t, filter[MINIX_read])
+
+	filter, err = ParseSyscallFilter("")
+	assert.Nil(t, err)
+	assert.Nil(t, filter)
+
+	_, err = ParseSyscallFilter("mmap")
+	assert.NotNil(t, err)
+}
+
+func TestSyscallArgFormats(t *testing.T) {
+	assert.Equal(t, "O_WRONLY|O_CREAT|O_TRUNC", openFlagsString(01|0100|01000))
+	assert.Equal(t, "O_RDWR|0100000", openFlagsString(02|0100000))
+	assert.Equal(t, "F_OK", accessModeString(0))
+	assert.Equal(t, "R_OK|X_OK", accessModeString(5))
+	assert.Equal(t, `"abc"...`, quoteString("abc", 10, 8))
+	assert.Equal(t, `"ab\n"`, quoteString("ab\n", 3, 8))
+}
+
+func TestStraceExit(t *testing.T) {
+	vm := NewVM()
+	buf := new(bytes.Buffer)
+	s := NewStrace(buf)
+	s.Attach(vm)
+	straceTestCall(vm, MINIX_exit, func(m MinixMessage) { m.Set(m1_i1, 3) })
+	assert.Equal(t, &Exit{Status: 3}, vm.takeStop())
+	assert.Nil(t, s.Close())
+	assert.Contains(t, buf.String(), "exit(3) = ?\n")
+	assert.Contains(t, buf.String(), "+++ exited with 3 +++\n")
+}
@@ -0,0 +1,264 @@
+package go8086
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SymbolSection uint8
+
+const (
+	SectionUndefined SymbolSection = 0
+	SectionAbsolute  SymbolSection = 1
+	SectionText      SymbolSection = 2
+	SectionData      SymbolSection = 3
+	SectionBss       SymbolSection = 4
+	SectionCommon    SymbolSection = 5
+)
+
+var symbolSectionLetter = map[SymbolSection]string{
+	SectionUndefined: "U",
+	SectionAbsolute:  "A",
+	SectionText:      "T",
+	SectionData:      "D",
+	SectionBss:       "B",
+	SectionCommon:    "C",
+}
+
+func (s SymbolSection) String() string {
+	return symbolSectionLetter[s]
+}
+
+const (
+	nlistSize      = 16
+	nlistSect      = 07
+	nlistClass     = 0370
+	nlistClassEx   = 0020
+	nlistClassStat = 0030
+)
+
+type Symbol struct {
+	Name     string
+	Value    uint16
+	Section  SymbolSection
+	External bool
+}
+
+type SymbolTable struct {
+	symbols []*Symbol
+	byName  map[string]*Symbol
+	text    []*Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{byName: make(map[string]*Symbol)}
+}
+
+func (t *SymbolTable) Add(sym *Symbol) {
+	if old, ok := t.byName[sym.Name]; ok {
+		*old = *sym
+	} else {
+		t.byName[sym.Name] = sym
+		t.symbols = append(t.symbols, sym)
+	}
+	t.text = nil
+}
+
+func (t *SymbolTable) Merge(other *SymbolTable) {
+	for _, sym := range other.symbols {
+		s := *sym
+		t.Add(&s)
+	}
+}
+
+func (t *SymbolTable) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.symbols)
+}
+
+func (t *SymbolTable) Symbols() []*Symbol {
+	if t == nil {
+		return nil
+	}
+	return t.symbols
+}
+
+func (t *SymbolTable) Lookup(name string) (*Symbol, bool) {
+	if t == nil {
+		return nil, false
+	}
+	sym, ok := t.byName[name]
+	return sym, ok
+}
+
+func (t *SymbolTable) textSymbols() []*Symbol {
+	if t.text == nil {
+		t.text = []*Symbol{}
+		for _, sym := range t.symbols {
+			if sym.Section == SectionText {
+				t.text = append(t.text, sym)
+			}
+		}
+		// External names win over local ones at the same address.
+		sort.SliceStable(t.text, func(i, j int) bool {
+			if t.text[i].Value != t.text[j].Value {
+				return t.text[i].Value < t.text[j].Value
+			}
+			return t.text[i].External && !t.text[j].External
+		})
+	}
+	return t.text
+}
+
+// At returns the text symbol at exactly addr.
+func (t *SymbolTable) At(addr uint16) (*Symbol, bool) {
+	if t == nil {
+		return nil, false
+	}
+	text := t.textSymbols()
+	i := sort.Search(len(text), func(i int) bool { return text[i].Value >= addr })
+	if i < len(text) && text[i].Value == addr {
+		return text[i], true
+	}
+	return nil, false
+}
+
+// Nearest returns the text symbol at or below addr.
+func (t *SymbolTable) Nearest(addr uint16) (*Symbol, bool) {
+	if t == nil {
+		return nil, false
+	}
+	text := t.textSymbols()
+	i := sort.Search(len(text), func(i int) bool { return text[i].Value > addr })
+	if i == 0 {
+		return nil, false
+	}
+	sym := text[i-1]
+	for i > 1 && text[i-2].Value == sym.Value {
+		i--
+		sym = text[i-1]
+	}
+	return sym, true
+}
+
+// Describe formats addr as "name" or "name+0x4", or returns "" when no
+// symbol precedes it.
+func (t *SymbolTable) Describe(addr uint16) string {
+	sym, ok := t.Nearest(addr)
+	if !ok {
+		return ""
+	}
+	if sym.Value == addr {
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+%#x", sym.Name, addr-sym.Value)
+}
+
+// ParseMinixSymbols reads a table of ACK nlist entries: an 8 byte name,
+// a 32 bit value, the storage class and section, and 3 unused bytes.
+func ParseMinixSymbols(bs Bytes) (t *SymbolTable, err error) {
+	if len(bs)%nlistSize != 0 {
+		return nil, fmt.Errorf("symbol table size %d is not a multiple of %d", len(bs), nlistSize)
+	}
+	t = NewSymbolTable()
+	for i := 0; i < len(bs); i += nlistSize {
+		e := bs[i : i+nlistSize]
+		name := string(e[0:8])
+		if n := strings.IndexByte(name, 0); n >= 0 {
+			name = name[:n]
+		}
+		if name == "" {
+			continue
+		}
+		sclass := e[12]
+		t.Add(&Symbol{
+			Name:     name,
+			Value:    uint16(e[8:].Read32()),
+			Section:  SymbolSection(sclass & nlistSect),
+			External: sclass&nlistClass == nlistClassEx,
+		})
+	}
+	return
+}
+
+// MinixSymbolBytes writes t as ACK nlist entries for ParseMinixSymbols.
+// Names are cut to 8 bytes.
+func MinixSymbolBytes(t *SymbolTable) (bs Bytes) {
+	for _, sym := range t.Symbols() {
+		e := make(Bytes, nlistSize)
+		copy(e[0:8], sym.Name)
+		e[8:].Write32(uint32(sym.Value))
+		e[12] = byte(sym.Section) | nlistClassStat
+		if sym.External {
+			e[12] = byte(sym.Section) | nlistClassEx
+		}
+		bs = append(bs, e...)
+	}
+	return
+}
+
+// ReadSymbolMap reads "VALUE [SECTION] NAME" lines as printed by nm, with
+// VALUE in hex. SECTION is one of T D B A C U (lower case for local
+// symbols) and defaults to T. Blank lines and lines starting with # are
+// skipped.
+func ReadSymbolMap(r io.Reader) (t *SymbolTable, err error) {
+	t = NewSymbolTable()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("symbol map line %d: want VALUE [SECTION] NAME", n)
+		}
+		v, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("symbol map line %d: bad value %q", n, fields[0])
+		}
+		sym := &Symbol{Name: fields[len(fields)-1], Value: uint16(v), Section: SectionText, External: true}
+		if len(fields) == 3 {
+			found := false
+			for section, letter := range symbolSectionLetter {
+				if strings.EqualFold(letter, fields[1]) {
+					sym.Section, sym.External, found = section, letter == fields[1], true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("symbol map line %d: bad section %q", n, fields[1])
+			}
+		}
+		t.Add(sym)
+	}
+	return t, scanner.Err()
+}
+
+// WriteSymbolMap writes t in the format ReadSymbolMap reads.
+func WriteSymbolMap(w io.Writer, t *SymbolTable) error {
+	for _, sym := range t.Symbols() {
+		letter := symbolSectionLetter[sym.Section]
+		if !sym.External {
+			letter = strings.ToLower(letter)
+		}
+		if _, err := fmt.Fprintf(w, "%04x %s %s\n", sym.Value, letter, sym.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func LoadSymbolMap(file string) (t *SymbolTable, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return ReadSymbolMap(f)
+}
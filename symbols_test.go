@@ -0,0 +1,122 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func nlist(name string, value uint16, sclass byte) Bytes {
+	e := make(Bytes, nlistSize)
+	copy(e, name)
+	e[8:].Write16(value)
+	e[12] = sclass
+	return e
+}
+
+func newSymbolTestTable() Bytes {
+	bs := Bytes{}
+	bs = append(bs, nlist("_main", 0x0010, 0022)...)
+	bs = append(bs, nlist("loop", 0x0014, 0032)...)
+	bs = append(bs, nlist("_exit", 0x0020, 0022)...)
+	bs = append(bs, nlist("_errno", 0x0004, 0023)...)
+	bs = append(bs, nlist("_printf", 0x0000, 0020)...)
+	return bs
+}
+
+func TestParseMinixSymbols(t *testing.T) {
+	st, err := ParseMinixSymbols(newSymbolTestTable())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, st.Len())
+	sym, ok := st.Lookup("_errno")
+	assert.True(t, ok)
+	assert.Equal(t, &Symbol{Name: "_errno", Value: 0x0004, Section: SectionData, External: true}, sym)
+	sym, _ = st.Lookup("loop")
+	assert.Equal(t, SectionText, sym.Section)
+	assert.False(t, sym.External)
+
+	tests := []struct {
+		addr uint16
+		desc string
+	}{
+		{0x000f, ""},
+		{0x0010, "_main"},
+		{0x0013, "_main+0x3"},
+		{0x0014, "loop"},
+		{0x0030, "_exit+0x10"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.desc, st.Describe(test.addr))
+	}
+
+	_, err = ParseMinixSymbols(make(Bytes, 10))
+	assert.NotNil(t, err)
+}
+
+func TestReadSymbolMap(t *testing.T) {
+	st, err := ReadSymbolMap(strings.NewReader("# comment\n0010 T _main\n0x14 loop\n\n0004 d _buf\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, st.Len())
+	assert.Equal(t, "_main+0x2", st.Describe(0x12))
+	sym, _ := st.Lookup("_buf")
+	assert.Equal(t, &Symbol{Name: "_buf", Value: 0x0004, Section: SectionData}, sym)
+
+	for _, s := range []string{"zz _main", "0010 Q _main", "0010"} {
+		_, err = ReadSymbolMap(strings.NewReader(s))
+		assert.NotNil(t, err, s)
+	}
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, WriteSymbolMap(buf, st))
+	assert.Equal(t, "0010 T _main\n0014 T loop\n0004 d _buf\n", buf.String())
+}
+
+func TestDisasmSymbols(t *testing.T) {
+	st, _ := ParseMinixSymbols(newSymbolTestTable())
+	tests := []struct {
+		address uint16
+		bytes   Bytes
+		out     string
+	}{
+		{0x0000, Bytes{0xe8, 0x0d, 0x00}, "call _main"},
+		{0x0016, Bytes{0x75, 0xfc}, "jnz loop"},
+		{0x0016, Bytes{0xeb, 0xfc}, "jmp short loop"},
+		{0x0016, Bytes{0xeb, 0xfd}, "jmp short 0x15"},
+		{0x0018, Bytes{0xff, 0xd3}, "call bx"},
+	}
+	for _, test := range tests {
+		op := getOpcode(nil, test.address, test.bytes).WithSymbols(st)
+		assert.Equal(t, test.out, op.Disasm())
+	}
+}
+
+func TestMinixAoutSymbols(t *testing.T) {
+	header := make(Bytes, 32)
+	header[0], header[1], header[3], header[4] = 0x01, 0x03, 0x04, 32
+	text := Bytes{0xe8, 0x0d, 0x00, 0xcd, 0x20}
+	data := Bytes{0x00, 0x00}
+	syms := newSymbolTestTable()
+	header[8:].Write32(uint32(len(text)))
+	header[12:].Write32(uint32(len(data)))
+	header[28:].Write32(uint32(len(syms)))
+
+	f, err := ioutil.TempFile("", "go8086-aout")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.Write(append(append(append(header, text...), data...), syms...))
+	f.Close()
+
+	aout, err := LoadMinixAout(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, aout.Symbols.Len())
+	vm := aout.NewVM([]string{"a.out"}, []string{})
+	assert.Equal(t, "call _main", vm.CurrentOpcode().Disasm())
+
+	s := vm.Snapshot()
+	vm.SetSymbols(nil)
+	vm.restore(s)
+	assert.Equal(t, 5, vm.Symbols().Len())
+}
@@ -0,0 +1,432 @@
+package go8086
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Syntax int
+
+const (
+	SyntaxNASM Syntax = iota
+	SyntaxMASM
+	SyntaxATT
+	SyntaxAS86
+)
+
+var syntaxNames = map[string]Syntax{
+	"nasm": SyntaxNASM,
+	"masm": SyntaxMASM,
+	"tasm": SyntaxMASM,
+	"att":  SyntaxATT,
+	"gas":  SyntaxATT,
+	"as86": SyntaxAS86,
+	"ack":  SyntaxAS86,
+}
+
+type NumberFormat int
+
+const (
+	NumberDefault   NumberFormat = iota // what the syntax uses: 1234h for MASM, 0x1234 otherwise
+	NumberHex                           // 0x1234
+	NumberHexSuffix                     // 1234h
+	NumberDecimal                       // 4660
+)
+
+// DisasmStyle says how Opcode.DisasmStyle writes an instruction. The zero
+// value is the ndisasm syntax of Opcode.Disasm.
+type DisasmStyle struct {
+	Syntax    Syntax
+	Numbers   NumberFormat
+	Uppercase bool
+}
+
+// TraceStyle is the syntax of the debug trace and the debugger.
+var TraceStyle DisasmStyle
+
+// ParseDisasmStyle parses a comma separated list of a syntax (nasm, masm
+// or tasm, att or gas, as86 or ack) and the options upper, hex, hexsuffix
+// and decimal.
+func ParseDisasmStyle(s string) (style DisasmStyle, err error) {
+	for _, f := range strings.Split(s, ",") {
+		if syntax, ok := syntaxNames[f]; ok {
+			style.Syntax = syntax
+			continue
+		}
+		switch f {
+		case "", "lower":
+		case "upper":
+			style.Uppercase = true
+		case "hex":
+			style.Numbers = NumberHex
+		case "hexsuffix":
+			style.Numbers = NumberHexSuffix
+		case "decimal":
+			style.Numbers = NumberDecimal
+		default:
+			return style, fmt.Errorf("unknown disassembly syntax or option: %s", f)
+		}
+	}
+	return
+}
+
+func (op *Opcode) DisasmStyle(style *DisasmStyle) (asm string) {
+	if style == nil {
+		return op.Disasm()
+	}
+	switch style.Syntax {
+	case SyntaxMASM:
+		asm = disasmMASM(op)
+	case SyntaxATT:
+		asm = disasmATT(op)
+	case SyntaxAS86:
+		asm = disasmAS86(op)
+	default:
+		asm = op.Disasm()
+	}
+	return style.format(asm, op.symbols)
+}
+
+var asmToken = regexp.MustCompile(`[A-Za-z_.][A-Za-z0-9_.]*|0x[0-9a-f]+`)
+
+// format rewrites the numbers, all written as 0x..., and the case of asm,
+// leaving symbol names alone.
+func (style *DisasmStyle) format(asm string, symbols *SymbolTable) string {
+	numbers := style.Numbers
+	if numbers == NumberDefault && style.Syntax == SyntaxMASM {
+		numbers = NumberHexSuffix
+	}
+	if (numbers == NumberDefault || numbers == NumberHex) && !style.Uppercase {
+		return asm
+	}
+	return asmToken.ReplaceAllStringFunc(asm, func(t string) string {
+		if strings.HasPrefix(t, "0x") {
+			v, _ := strconv.ParseUint(t[2:], 16, 32)
+			h := strconv.FormatUint(v, 16)
+			if style.Uppercase {
+				h = strings.ToUpper(h)
+			}
+			switch numbers {
+			case NumberDecimal:
+				return strconv.FormatUint(v, 10)
+			case NumberHexSuffix:
+				if h[0] > '9' {
+					h = "0" + h
+				}
+				if style.Uppercase {
+					return h + "H"
+				}
+				return h + "h"
+			default:
+				return "0x" + h
+			}
+		}
+		if _, ok := symbols.Lookup(t); ok || !style.Uppercase {
+			return t
+		}
+		return strings.ToUpper(t)
+	})
+}
+
+// immediateValue writes an immediate as a plain number, negative when it
+// is signed.
+func immediateValue(i *Immediate) string {
+	v := int(i.value)
+	switch {
+	case i.w == Bit8 && bool(i.signed):
+		v = int(int8(i.value))
+	case i.w == Bit8:
+		v = int(uint8(i.value))
+	case bool(i.signed):
+		v = int(int16(i.value))
+	}
+	if v < 0 {
+		return fmt.Sprintf("-%#x", -v)
+	}
+	return fmt.Sprintf("%#x", v)
+}
+
+// displacement writes the displacement of a register based address with
+// its sign, "+0x4" or "-0x4".
+func displacement(m *Memory) string {
+	if m.disp == nil {
+		return ""
+	}
+	d := immediateValue(m.disp)
+	if !strings.HasPrefix(d, "-") {
+		d = "+" + d
+	}
+	return d
+}
+
+// override returns the segment register of m when it is not the default.
+func override(m *Memory) *SegmentRegister {
+	if m.sreg != RegAddressSegment[m.regad] {
+		return m.sreg
+	}
+	return nil
+}
+
+// sizeNeeded tells whether nothing but the size keyword says how wide the
+// memory operand of op is.
+func sizeNeeded(op *Opcode) bool {
+	if !isMemory(op.opr1) && !isMemory(op.opr2) {
+		return false
+	}
+	for _, opr := range []Operand{op.opr1, op.opr2} {
+		if isRegister(opr) || isSegmentRegister(opr) {
+			return false
+		}
+	}
+	return true
+}
+
+func operandBit(op *Opcode) (w Bit, ok bool) {
+	for _, opr := range []Operand{op.opr1, op.opr2} {
+		switch o := opr.(type) {
+		case *Register:
+			return o.w, true
+		case *Memory:
+			return o.w, true
+		}
+	}
+	return
+}
+
+func sizeName(w Bit) string {
+	if w == Bit8 {
+		return "byte"
+	}
+	return "word"
+}
+
+func operands(oprs []Operand, f func(Operand) string) string {
+	s := []string{}
+	for _, opr := range oprs {
+		if opr != nil {
+			s = append(s, f(opr))
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func withOperands(mn, oprs string) string {
+	if oprs == "" {
+		return mn
+	}
+	return mn + " " + oprs
+}
+
+var masmMnemonics = map[Mnemonic]string{
+	PUSHF: "pushf",
+	POPF:  "popf",
+	XLAT:  "xlat",
+}
+
+func masmMemory(m *Memory) string {
+	ea := ""
+	if m.regad == RegAdd_Direct {
+		ea = immediateValue(m.disp)
+	} else {
+		names := []string{}
+		for _, r := range RegAddressMap[m.regad] {
+			names = append(names, r.name)
+		}
+		ea = strings.Join(names, "+") + displacement(m)
+	}
+	s := "[" + ea + "]"
+	if o := override(m); o != nil {
+		s = o.name + ":" + s
+	} else if m.regad == RegAdd_Direct {
+		s = m.sreg.name + ":" + s
+	}
+	return s
+}
+
+func disasmMASM(op *Opcode) string {
+	if op.following != nil {
+		return op.mn.String() + " " + disasmMASM(op.following)
+	}
+	if op.mn == DB {
+		return op.Disasm()
+	}
+	mn, ok := masmMnemonics[op.mn]
+	if !ok {
+		mn = op.mn.String()
+	}
+	if target, ok := op.Target(); ok {
+		if op.mn == JMP && op.opr1.Bit() == Bit8 {
+			return mn + " short " + op.target(target)
+		}
+		return mn + " " + op.target(target)
+	}
+	size := sizeNeeded(op)
+	asm := withOperands(mn, operands([]Operand{op.opr1, op.opr2}, func(opr Operand) string {
+		switch o := opr.(type) {
+		case *Immediate:
+			return immediateValue(o)
+		case *Memory:
+			if size {
+				return sizeName(o.w) + " ptr " + masmMemory(o)
+			}
+			return masmMemory(o)
+		case *DirectFarAddress:
+			return "far ptr " + o.segment.Disasm() + ":" + o.offset.Disasm()
+		case *IndirectFarAddress:
+			return "dword ptr " + masmMemory(o.memory)
+		}
+		return opr.Disasm()
+	}))
+	if op.sreg != nil && !isMemory(op.opr1) && !isMemory(op.opr2) {
+		asm = op.sreg.name + ": " + asm
+	}
+	return asm
+}
+
+var attMnemonics = map[Mnemonic]string{
+	CBW:   "cbtw",
+	CWD:   "cwtd",
+	PUSHF: "pushf",
+	POPF:  "popf",
+	XLAT:  "xlat",
+	RETF:  "lret",
+}
+
+func attMemory(m *Memory) string {
+	s := ""
+	if m.regad == RegAdd_Direct {
+		s = immediateValue(m.disp)
+	} else {
+		names := []string{}
+		for _, r := range RegAddressMap[m.regad] {
+			names = append(names, "%"+r.name)
+		}
+		s = strings.TrimPrefix(displacement(m), "+") + "(" + strings.Join(names, ",") + ")"
+	}
+	if o := override(m); o != nil {
+		s = "%" + o.name + ":" + s
+	}
+	return s
+}
+
+func attOperand(opr Operand) string {
+	switch o := opr.(type) {
+	case *Register:
+		return "%" + o.name
+	case *SegmentRegister:
+		return "%" + o.name
+	case *Immediate:
+		return "$" + immediateValue(o)
+	case *Memory:
+		return attMemory(o)
+	case *Counter:
+		return "%cl"
+	}
+	return opr.Disasm()
+}
+
+func disasmATT(op *Opcode) string {
+	if op.following != nil {
+		return op.mn.String() + " " + disasmATT(op.following)
+	}
+	if op.mn == DB {
+		return fmt.Sprintf(".byte %#02x", op.bytes[0])
+	}
+	mn, ok := attMnemonics[op.mn]
+	if !ok {
+		mn = op.mn.String()
+	}
+	if target, ok := op.Target(); ok {
+		return mn + " " + op.target(target)
+	}
+	if op.mn == CALL || op.mn == JMP {
+		switch o := op.opr1.(type) {
+		case *DirectFarAddress:
+			return "l" + mn + " $" + o.segment.Disasm() + ",$" + o.offset.Disasm()
+		case *IndirectFarAddress:
+			return "l" + mn + " *" + attMemory(o.memory)
+		}
+		return mn + " *" + attOperand(op.opr1)
+	}
+	if w, ok := operandBit(op); ok && sizeNeeded(op) {
+		mn += string(sizeName(w)[0])
+	}
+	oprs := []Operand{op.opr2, op.opr1}
+	if c, ok := op.opr2.(*Counter); ok && c.v == Count1 {
+		// A shift by one needs no count.
+		oprs[0] = nil
+	}
+	return withOperands(mn, operands(oprs, func(opr Operand) string {
+		if r, ok := opr.(*Register); ok && r == DX && (op.mn == IN || op.mn == OUT) {
+			return "(%dx)"
+		}
+		return attOperand(opr)
+	}))
+}
+
+var as86Mnemonics = map[Mnemonic]string{
+	PUSHF: "pushf",
+	POPF:  "popf",
+	XLAT:  "xlat",
+}
+
+func as86Memory(m *Memory) string {
+	if m.regad == RegAdd_Direct {
+		return immediateValue(m.disp)
+	}
+	names := []string{}
+	for _, r := range RegAddressMap[m.regad] {
+		names = append(names, r.name)
+	}
+	return strings.TrimPrefix(displacement(m), "+") + "(" + strings.Join(names, "_") + ")"
+}
+
+func as86Operand(opr Operand) string {
+	switch o := opr.(type) {
+	case *Immediate:
+		return "#" + immediateValue(o)
+	case *Memory:
+		return as86Memory(o)
+	}
+	return opr.Disasm()
+}
+
+func disasmAS86(op *Opcode) string {
+	if op.following != nil {
+		return op.mn.String() + " " + disasmAS86(op.following)
+	}
+	if op.mn == DB {
+		return fmt.Sprintf(".data1 %#02x", op.bytes[0])
+	}
+	mn, ok := as86Mnemonics[op.mn]
+	if !ok {
+		mn = op.mn.String()
+	}
+	seg := ""
+	for _, opr := range []Operand{op.opr1, op.opr2} {
+		if m, ok := opr.(*Memory); ok && override(m) != nil {
+			seg = "seg " + m.sreg.name + " "
+		}
+	}
+	if op.sreg != nil && seg == "" {
+		seg = "seg " + op.sreg.name + " "
+	}
+	if target, ok := op.Target(); ok {
+		return seg + mn + " " + op.target(target)
+	}
+	if op.mn == CALL || op.mn == JMP {
+		switch o := op.opr1.(type) {
+		case *DirectFarAddress:
+			return seg + mn + "i " + o.offset.Disasm() + "," + o.segment.Disasm()
+		case *IndirectFarAddress:
+			return seg + mn + "i " + as86Memory(o.memory)
+		}
+		return seg + mn + " " + as86Operand(op.opr1)
+	}
+	if w, ok := operandBit(op); ok && w == Bit8 {
+		mn += "b"
+	}
+	return seg + withOperands(mn, operands([]Operand{op.opr1, op.opr2}, as86Operand))
+}
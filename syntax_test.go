@@ -0,0 +1,88 @@
+package go8086
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDisasmStyle(t *testing.T) {
+	masm := &DisasmStyle{Syntax: SyntaxMASM}
+	att := &DisasmStyle{Syntax: SyntaxATT}
+	as86 := &DisasmStyle{Syntax: SyntaxAS86}
+	tests := []struct {
+		bytes Bytes
+		style *DisasmStyle
+		out   string
+	}{
+		{Bytes{0x89, 0x40, 0x04}, nil, "mov [bx+si+0x4],ax"},
+		{Bytes{0x89, 0x40, 0x04}, masm, "mov [bx+si+4h],ax"},
+		{Bytes{0x89, 0x40, 0x04}, att, "mov %ax,0x4(%bx,%si)"},
+		{Bytes{0x89, 0x40, 0x04}, as86, "mov 0x4(bx_si),ax"},
+		{Bytes{0xc7, 0x40, 0x04, 0x34, 0x12}, masm, "mov word ptr [bx+si+4h],1234h"},
+		{Bytes{0xc7, 0x40, 0x04, 0x34, 0x12}, att, "movw $0x1234,0x4(%bx,%si)"},
+		{Bytes{0xc7, 0x40, 0x04, 0x34, 0x12}, as86, "mov 0x4(bx_si),#0x1234"},
+		{Bytes{0x80, 0x7e, 0xfe, 0xff}, masm, "cmp byte ptr [bp-2h],0ffh"},
+		{Bytes{0x80, 0x7e, 0xfe, 0xff}, att, "cmpb $0xff,-0x2(%bp)"},
+		{Bytes{0x80, 0x7e, 0xfe, 0xff}, as86, "cmpb -0x2(bp),#0xff"},
+		{Bytes{0x83, 0xc4, 0xfe}, att, "add $-0x2,%sp"},
+		{Bytes{0xa1, 0x00, 0x02}, masm, "mov ax,ds:[200h]"},
+		{Bytes{0xa1, 0x00, 0x02}, att, "mov 0x200,%ax"},
+		{Bytes{0x26, 0x8b, 0x07}, masm, "mov ax,es:[bx]"},
+		{Bytes{0x26, 0x8b, 0x07}, att, "mov %es:(%bx),%ax"},
+		{Bytes{0x26, 0x8b, 0x07}, as86, "seg es mov ax,(bx)"},
+		{Bytes{0xd1, 0xe0}, att, "shl %ax"},
+		{Bytes{0xd3, 0xe0}, att, "shl %cl,%ax"},
+		{Bytes{0xec}, att, "in (%dx),%al"},
+		{Bytes{0x98}, att, "cbtw"},
+		{Bytes{0xeb, 0x10}, masm, "jmp short 12h"},
+		{Bytes{0xe8, 0x10, 0x00}, att, "call 0x13"},
+		{Bytes{0xff, 0xe3}, att, "jmp *%bx"},
+		{Bytes{0xff, 0x57, 0x02}, att, "call *0x2(%bx)"},
+		{Bytes{0xff, 0x5f, 0x02}, masm, "call dword ptr [bx+2h]"},
+		{Bytes{0xff, 0x5f, 0x02}, att, "lcall *0x2(%bx)"},
+		{Bytes{0xea, 0x00, 0x01, 0x00, 0x10}, masm, "jmp far ptr 1000h:100h"},
+		{Bytes{0xea, 0x00, 0x01, 0x00, 0x10}, att, "ljmp $0x1000,$0x100"},
+		{Bytes{0xea, 0x00, 0x01, 0x00, 0x10}, as86, "jmpi 0x100,0x1000"},
+		{Bytes{0xcb}, att, "lret"},
+		{Bytes{0xf3, 0xa4}, att, "rep movsb"},
+		{Bytes{0x9c}, masm, "pushf"},
+		{Bytes{0xc7, 0x40, 0x04, 0x34, 0x12}, &DisasmStyle{Syntax: SyntaxMASM, Uppercase: true}, "MOV WORD PTR [BX+SI+4H],1234H"},
+		{Bytes{0xc7, 0x40, 0x04, 0x34, 0x12}, &DisasmStyle{Numbers: NumberDecimal}, "mov word [bx+si+4],4660"},
+		{Bytes{0xc7, 0x40, 0x04, 0x34, 0x12}, &DisasmStyle{Numbers: NumberHexSuffix, Uppercase: true}, "MOV WORD [BX+SI+4H],1234H"},
+		{Bytes{0xb8, 0xcd, 0xab}, &DisasmStyle{Syntax: SyntaxMASM, Numbers: NumberHex}, "mov ax,0xabcd"},
+		{Bytes{0xb8, 0xcd, 0xab}, &DisasmStyle{Syntax: SyntaxMASM}, "mov ax,0abcdh"},
+	}
+	for _, test := range tests {
+		op := getOpcode(nil, 0, test.bytes)
+		assert.Equal(t, test.out, op.DisasmStyle(test.style), "% x", []byte(test.bytes))
+	}
+}
+
+func TestDisasmStyleSymbols(t *testing.T) {
+	symbols := NewSymbolTable()
+	symbols.Add(&Symbol{Name: "_main", Value: 0x13, Section: SectionText})
+	op := getOpcode(nil, 0, Bytes{0xe8, 0x10, 0x00}).WithSymbols(symbols)
+	assert.Equal(t, "CALL _main", op.DisasmStyle(&DisasmStyle{Syntax: SyntaxMASM, Uppercase: true}))
+	assert.Equal(t, "call _main", op.DisasmStyle(&DisasmStyle{Syntax: SyntaxATT}))
+}
+
+func TestDisasmStyleData(t *testing.T) {
+	for _, test := range disasmTests() {
+		op := getOpcode(nil, test.address, test.bytes)
+		assert.Equal(t, test.out, op.DisasmStyle(&DisasmStyle{}))
+		for _, syntax := range []Syntax{SyntaxMASM, SyntaxATT, SyntaxAS86} {
+			assert.NotEmpty(t, op.DisasmStyle(&DisasmStyle{Syntax: syntax, Uppercase: true, Numbers: NumberDecimal}))
+		}
+	}
+}
+
+func TestParseDisasmStyle(t *testing.T) {
+	style, err := ParseDisasmStyle("tasm,upper,decimal")
+	assert.Nil(t, err)
+	assert.Equal(t, DisasmStyle{Syntax: SyntaxMASM, Numbers: NumberDecimal, Uppercase: true}, style)
+	style, err = ParseDisasmStyle("gas")
+	assert.Nil(t, err)
+	assert.Equal(t, DisasmStyle{Syntax: SyntaxATT}, style)
+	_, err = ParseDisasmStyle("intel")
+	assert.Equal(t, "unknown disassembly syntax or option: intel", err.Error())
+}
@@ -0,0 +1,251 @@
+package go8086
+
+import (
+	"github.com/riywo/go8086/trace"
+	"strings"
+)
+
+type SyscallArgType int
+
+const (
+	ArgInt SyscallArgType = iota
+	ArgFd
+	ArgPointer
+	ArgPath       // an m3 name: inline when short, else in DS
+	ArgString     // a string in SS whose length, with the NUL, is in Len
+	ArgBuffer     // data in DS whose length is in Len
+	ArgData       // data in DS whose length is the result
+	ArgArgv       // an exec stack frame in SS of Len bytes
+	ArgOpenFlags  // O_RDONLY|O_CREAT...
+	ArgMode       // permission bits, shown in octal
+	ArgAccessMode // R_OK|W_OK...
+	ArgWhence     // SEEK_SET...
+)
+
+// SyscallArg describes one argument or output of a syscall: where it is in
+// the message and how to show it.
+type SyscallArg struct {
+	Name  string
+	Type  SyscallArgType
+	Field MinixMessageAccessor
+	Len   MinixMessageAccessor
+}
+
+// SyscallDesc describes a MINIX call following the layouts the
+// implementations in minix.go read. Out are the values a successful call
+// hands back besides its result, in guest memory or in the reply.
+type SyscallDesc struct {
+	Name   string
+	Layout int
+	Args   []SyscallArg
+	Out    []SyscallArg
+}
+
+var SyscallTable = map[MINIXSyscall]*SyscallDesc{
+	MINIX_exit: {Name: "exit", Layout: 1, Args: []SyscallArg{
+		{Name: "status", Type: ArgInt, Field: m1_i1},
+	}},
+	MINIX_fork: {Name: "fork", Layout: 1},
+	MINIX_read: {Name: "read", Layout: 1, Args: []SyscallArg{
+		{Name: "fd", Type: ArgFd, Field: m1_i1},
+		{Name: "buffer", Type: ArgPointer, Field: m1_p1},
+		{Name: "nbytes", Type: ArgInt, Field: m1_i2},
+	}, Out: []SyscallArg{
+		{Name: "data", Type: ArgData, Field: m1_p1},
+	}},
+	MINIX_write: {Name: "write", Layout: 1, Args: []SyscallArg{
+		{Name: "fd", Type: ArgFd, Field: m1_i1},
+		{Name: "buffer", Type: ArgBuffer, Field: m1_p1, Len: m1_i2},
+		{Name: "nbytes", Type: ArgInt, Field: m1_i2},
+	}},
+	MINIX_open: {Name: "open", Layout: 3, Args: []SyscallArg{
+		{Name: "name", Type: ArgPath},
+		{Name: "flags", Type: ArgOpenFlags, Field: m3_i2},
+	}},
+	MINIX_close: {Name: "close", Layout: 1, Args: []SyscallArg{
+		{Name: "fd", Type: ArgFd, Field: m1_i1},
+	}},
+	MINIX_wait: {Name: "wait", Layout: 2, Out: []SyscallArg{
+		{Name: "status", Type: ArgInt, Field: m2_i1},
+	}},
+	MINIX_creat: {Name: "creat", Layout: 3, Args: []SyscallArg{
+		{Name: "name", Type: ArgPath},
+		{Name: "mode", Type: ArgMode, Field: m3_i2},
+	}},
+	MINIX_unlink: {Name: "unlink", Layout: 3, Args: []SyscallArg{
+		{Name: "name", Type: ArgPath},
+	}},
+	MINIX_time: {Name: "time", Layout: 2, Out: []SyscallArg{
+		{Name: "time", Type: ArgInt, Field: m2_l1},
+	}},
+	MINIX_chmod: {Name: "chmod", Layout: 3, Args: []SyscallArg{
+		{Name: "name", Type: ArgPath},
+		{Name: "mode", Type: ArgMode, Field: m3_i2},
+	}},
+	MINIX_brk: {Name: "brk", Layout: 1, Args: []SyscallArg{
+		{Name: "addr", Type: ArgPointer, Field: m1_p1},
+	}, Out: []SyscallArg{
+		{Name: "addr", Type: ArgPointer, Field: m2_p1},
+	}},
+	MINIX_stat: {Name: "stat", Layout: 1, Args: []SyscallArg{
+		{Name: "name", Type: ArgString, Field: m1_p1, Len: m1_i1},
+		{Name: "buffer", Type: ArgPointer, Field: m1_p2},
+	}},
+	MINIX_lseek: {Name: "lseek", Layout: 2, Args: []SyscallArg{
+		{Name: "fd", Type: ArgFd, Field: m2_i1},
+		{Name: "offset", Type: ArgInt, Field: m2_l1},
+		{Name: "whence", Type: ArgWhence, Field: m2_i2},
+	}, Out: []SyscallArg{
+		{Name: "offset", Type: ArgInt, Field: m2_l1},
+	}},
+	MINIX_getpid: {Name: "getpid", Layout: 1},
+	MINIX_getuid: {Name: "getuid", Layout: 1},
+	MINIX_fstat: {Name: "fstat", Layout: 1, Args: []SyscallArg{
+		{Name: "fd", Type: ArgFd, Field: m1_i1},
+		{Name: "buffer", Type: ArgPointer, Field: m1_p1},
+	}},
+	MINIX_access: {Name: "access", Layout: 3, Args: []SyscallArg{
+		{Name: "name", Type: ArgPath},
+		{Name: "mode", Type: ArgAccessMode, Field: m3_i2},
+	}},
+	MINIX_pipe: {Name: "pipe", Layout: 1, Out: []SyscallArg{
+		{Name: "fd0", Type: ArgFd, Field: m1_i1},
+		{Name: "fd1", Type: ArgFd, Field: m1_i2},
+	}},
+	MINIX_getgid: {Name: "getgid", Layout: 1},
+	MINIX_signal: {Name: "signal", Layout: 6, Args: []SyscallArg{
+		{Name: "sig", Type: ArgInt, Field: m6_i1},
+		{Name: "handler", Type: ArgPointer, Field: m6_f1},
+	}},
+	MINIX_ioctl: {Name: "ioctl", Layout: 2, Args: []SyscallArg{
+		{Name: "fd", Type: ArgFd, Field: m2_i1},
+		{Name: "request", Type: ArgPointer, Field: m2_i3},
+	}},
+	MINIX_fcntl: {Name: "fcntl", Layout: 1, Args: []SyscallArg{
+		{Name: "fd", Type: ArgFd, Field: m1_i1},
+		{Name: "cmd", Type: ArgInt, Field: m1_i2},
+	}},
+	MINIX_exec: {Name: "exec", Layout: 1, Args: []SyscallArg{
+		{Name: "name", Type: ArgString, Field: m1_p1, Len: m1_i1},
+		{Name: "argv", Type: ArgArgv, Field: m1_p2, Len: m1_i2},
+	}},
+	MINIX_sigaction: {Name: "sigaction", Layout: 1},
+}
+
+// LookupSyscall finds a call by name.
+func LookupSyscall(name string) (MINIXSyscall, bool) {
+	for call, d := range SyscallTable {
+		if d.Name == name {
+			return call, true
+		}
+	}
+	return 0, false
+}
+
+func syscallText(vm *VM, sreg *SegmentRegister, offset uint16, n int) string {
+	if n > TraceStringMax {
+		n = TraceStringMax
+	}
+	if n <= 0 {
+		return ""
+	}
+	return string(vm.ReadMem(sreg.Read(vm), offset, n))
+}
+
+// syscallName decodes the path of an m3 message: short names travel in
+// the message itself, longer ones are pointed to in DS. The length counts
+// the terminating NUL.
+func syscallName(vm *VM, m MinixMessage) trace.Arg {
+	k := int(m.Get(m3_i1))
+	a := trace.Arg{Name: "name", Value: int64(m.Get(m3_p1))}
+	switch {
+	case k <= 1:
+	case k <= 14:
+		a.Value = 0
+		a.Text = string(m.Get_m3_ca1()[0 : k-1])
+	default:
+		a.Text = syscallText(vm, DS, uint16(a.Value), k-1)
+	}
+	return a
+}
+
+// syscallArgv reads the argument strings of an exec stack frame, where
+// argc is followed by pointers relative to the frame.
+func syscallArgv(vm *VM, frame uint16, size int) string {
+	bs := vm.ReadMem(SS.Read(vm), frame, size)
+	if len(bs) < 2 {
+		return ""
+	}
+	argv := []string{}
+	argc := int(bs.Read16())
+	for i := 0; i < argc && 2*i+4 <= len(bs); i++ {
+		p := int(bs[2*i+2:].Read16())
+		if p >= len(bs) {
+			break
+		}
+		s := bs[p:]
+		if n := strings.IndexByte(string(s), 0); n >= 0 {
+			s = s[:n]
+		}
+		argv = append(argv, string(s))
+	}
+	return strings.Join(argv, "\x00")
+}
+
+// Length returns how long the data of a string or buffer argument is,
+// which may be more than its decoded text holds.
+func (a SyscallArg) Length(m MinixMessage, result int) int {
+	switch a.Type {
+	case ArgPath:
+		return int(m.Get(m3_i1)) - 1
+	case ArgString:
+		return int(m.Get(a.Len)) - 1
+	case ArgBuffer:
+		return int(m.Get(a.Len))
+	case ArgData:
+		return result
+	}
+	return 0
+}
+
+func (a SyscallArg) Decode(vm *VM, m MinixMessage, result int) trace.Arg {
+	if a.Type == ArgPath {
+		v := syscallName(vm, m)
+		v.Name = a.Name
+		return v
+	}
+	v := trace.Arg{Name: a.Name, Value: int64(m.Get(a.Field))}
+	switch a.Type {
+	case ArgString:
+		v.Text = syscallText(vm, SS, uint16(v.Value), a.Length(m, result))
+	case ArgBuffer:
+		v.Text = syscallText(vm, DS, uint16(v.Value), a.Length(m, result))
+	case ArgData:
+		v.Value = int64(result)
+		v.Text = syscallText(vm, DS, uint16(m.Get(a.Field)), result)
+	case ArgArgv:
+		v.Text = syscallArgv(vm, uint16(v.Value), int(m.Get(a.Len)))
+	}
+	return v
+}
+
+// SyscallArgs decodes the arguments of a MINIX call from its message.
+func SyscallArgs(vm *VM, call MINIXSyscall, m MinixMessage) (args []trace.Arg) {
+	if d, ok := SyscallTable[call]; ok {
+		for _, a := range d.Args {
+			args = append(args, a.Decode(vm, m, 0))
+		}
+	}
+	return
+}
+
+// SyscallOutputs decodes what a successful call handed back besides its
+// result: data read into guest memory and values stored in the reply.
+func SyscallOutputs(vm *VM, call MINIXSyscall, m MinixMessage, result int) (out []trace.Arg) {
+	if d, ok := SyscallTable[call]; ok {
+		for _, a := range d.Out {
+			out = append(out, a.Decode(vm, m, result))
+		}
+	}
+	return
+}
@@ -0,0 +1,527 @@
+package go8086
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TaintPath is how many of the instructions that moved tainted data a sink
+// report shows.
+var TaintPath = 16
+
+// TaintLabel is a set of taint sources, one bit per source. Sources past
+// the 64th share the last bit.
+type TaintLabel uint64
+
+func taintBit(id int) TaintLabel {
+	if id > 63 {
+		id = 63
+	}
+	return 1 << uint(id)
+}
+
+// TaintSpec says where tainted data comes from: MINIX reads, from any fd
+// when Fds is nil, the argument and environment strings of the program,
+// and I/O ports.
+type TaintSpec struct {
+	Read  bool
+	Fds   map[int]bool
+	Argv  bool
+	Ports bool
+}
+
+// ParseTaintSources parses a comma separated list of "read", "read:FD",
+// "argv" and "in".
+func ParseTaintSources(s string) (spec TaintSpec, err error) {
+	for _, f := range strings.Split(s, ",") {
+		switch {
+		case f == "read":
+			spec.Read, spec.Fds = true, nil
+		case strings.HasPrefix(f, "read:"):
+			fd, cerr := strconv.Atoi(f[5:])
+			if cerr != nil {
+				return spec, fmt.Errorf("bad taint source: %s", f)
+			}
+			if !spec.Read {
+				spec.Read, spec.Fds = true, make(map[int]bool)
+			}
+			if spec.Fds != nil {
+				spec.Fds[fd] = true
+			}
+		case f == "argv":
+			spec.Argv = true
+		case f == "in":
+			spec.Ports = true
+		default:
+			return spec, fmt.Errorf("bad taint source: %s", f)
+		}
+	}
+	return
+}
+
+// TaintSource is one delivery of tainted data.
+type TaintSource struct {
+	ID      int
+	Kind    string
+	Count   uint64
+	Fd      int
+	Port    uint16
+	Segment uint16
+	Offset  uint16
+	Len     int
+}
+
+func (s *TaintSource) Name() string {
+	return fmt.Sprintf("%s#%d", s.Kind, s.ID)
+}
+
+func (s *TaintSource) String() string {
+	switch s.Kind {
+	case "read":
+		return fmt.Sprintf("%s: fd %d, %d bytes at %04x:%04x, instruction %d", s.Name(), s.Fd, s.Len, s.Segment, s.Offset, s.Count)
+	case "in":
+		return fmt.Sprintf("%s: port %04x, instruction %d", s.Name(), s.Port, s.Count)
+	default:
+		return fmt.Sprintf("%s: %d bytes at %04x:%04x", s.Name(), s.Len, s.Segment, s.Offset)
+	}
+}
+
+// TaintStep is an instruction that moved tainted data.
+type TaintStep struct {
+	CS, IP uint16
+	Op     *Opcode
+	Label  TaintLabel
+}
+
+// TaintSink is tainted data reaching a place that decides control flow or
+// what runs: an indirect CALL or JMP target, a RET address or an exec path.
+type TaintSink struct {
+	Kind      string
+	Value     string
+	CS, IP    uint16
+	Op        *Opcode
+	Label     TaintLabel
+	Sources   []*TaintSource
+	Path      []TaintStep
+	Backtrace []*Frame
+	Count     uint64
+}
+
+func (s *TaintSink) Error() string {
+	names := []string{}
+	for _, src := range s.Sources {
+		names = append(names, src.Name())
+	}
+	return fmt.Sprintf("tainted %s %s from %s", s.Kind, s.Value, strings.Join(names, ","))
+}
+
+type taintKey struct {
+	kind   string
+	cs, ip uint16
+}
+
+// Taint labels the bytes its sources deliver and follows them through
+// registers and memory: an instruction taints what it writes with the
+// labels of what it reads. Only data flow is followed; addresses, flags
+// and the branches taken do not propagate labels.
+type Taint struct {
+	Spec    TaintSpec
+	Sources []*TaintSource
+	Sinks   []*TaintSink
+	// Log, when set, gets a line for every instruction that moves
+	// tainted data.
+	Log    io.Writer
+	w      io.Writer
+	vm     *VM
+	ids    []HookID
+	mem    map[uint32]TaintLabel
+	regs   map[string][2]TaintLabel
+	seen   map[taintKey]*TaintSink
+	steps  []TaintStep
+	next   int
+	full   bool
+	cs, ip uint16
+	op     *Opcode
+	mn     Mnemonic
+	src    TaintLabel
+	read   TaintLabel
+}
+
+func NewTaint(w io.Writer, spec TaintSpec) *Taint {
+	return &Taint{
+		Spec:  spec,
+		w:     w,
+		mem:   make(map[uint32]TaintLabel),
+		regs:  make(map[string][2]TaintLabel),
+		seen:  make(map[taintKey]*TaintSink),
+		steps: make([]TaintStep, 256),
+	}
+}
+
+func (t *Taint) Attach(vm *VM) {
+	t.vm = vm
+	t.loaded(vm)
+	t.ids = []HookID{
+		vm.AddBeforeInstructionHook(t.before),
+		vm.AddAfterInstructionHook(t.after),
+		vm.AddMemoryHook(t.memory),
+		vm.AddSyscallHook(t.syscall),
+	}
+}
+
+func (t *Taint) Detach() {
+	for _, id := range t.ids {
+		t.vm.RemoveHook(id)
+	}
+	t.ids = nil
+}
+
+// Close writes the summary.
+func (t *Taint) Close() (err error) {
+	_, err = fmt.Fprintf(t.w, "==%d== TAINT SUMMARY: %d sinks reached from %d sources\n", Pid(), len(t.Sinks), len(t.Sources))
+	return
+}
+
+// loaded starts over for a new program image, labelling its argument and
+// environment strings when they are a source.
+func (t *Taint) loaded(vm *VM) {
+	t.mem = make(map[uint32]TaintLabel)
+	t.regs = make(map[string][2]TaintLabel)
+	if !t.Spec.Argv {
+		return
+	}
+	ss, p := vm.sreg["ss"], vm.reg["sp"]+2
+	for nulls := 0; nulls < 2 && p != 0; p += 2 {
+		if vm.Read16(ss, p) == 0 {
+			nulls++
+		}
+	}
+	if p == 0 {
+		return
+	}
+	s := t.source(vm, "argv", ss, p, 0x10000-int(p))
+	t.set(ss, p, s.Len, taintBit(s.ID))
+}
+
+func (t *Taint) source(vm *VM, kind string, seg, offset uint16, n int) *TaintSource {
+	s := &TaintSource{ID: len(t.Sources), Kind: kind, Count: vm.count, Segment: seg, Offset: offset, Len: n}
+	t.Sources = append(t.Sources, s)
+	return s
+}
+
+// Label returns the labels of n bytes at seg:offset.
+func (t *Taint) Label(seg, offset uint16, n int) (l TaintLabel) {
+	for i := 0; i < n; i++ {
+		l |= t.mem[Physical(seg, offset+uint16(i))]
+	}
+	return
+}
+
+func (t *Taint) set(seg, offset uint16, n int, l TaintLabel) {
+	for i := 0; i < n; i++ {
+		addr := Physical(seg, offset+uint16(i))
+		if l == 0 {
+			delete(t.mem, addr)
+		} else {
+			t.mem[addr] = l
+		}
+	}
+}
+
+// RegisterLabel returns the labels of r.
+func (t *Taint) RegisterLabel(r *Register) TaintLabel {
+	if r.w == Bit8 {
+		return t.regs[r.reg16.name][r.bytePos]
+	}
+	l := t.regs[r.name]
+	return l[0] | l[1]
+}
+
+func (t *Taint) setRegister(r *Register, l TaintLabel) {
+	if r.w == Bit8 {
+		b := t.regs[r.reg16.name]
+		b[r.bytePos] = l
+		t.regs[r.reg16.name] = b
+		return
+	}
+	t.regs[r.name] = [2]TaintLabel{l, l}
+}
+
+func (t *Taint) operandLabel(vm *VM, opr Operand) TaintLabel {
+	switch o := opr.(type) {
+	case *Register:
+		return t.RegisterLabel(o)
+	case *Memory:
+		n := 1
+		if o.w == Bit16 {
+			n = 2
+		}
+		return t.Label(o.sreg.Read(vm), o.EffectiveAddress(vm), n)
+	}
+	return 0
+}
+
+// operandValue reads opr without running the memory hooks.
+func (t *Taint) operandValue(vm *VM, opr Operand) uint16 {
+	if m, ok := opr.(*Memory); ok {
+		return vm.Read16(m.sreg.Read(vm), m.EffectiveAddress(vm))
+	}
+	return opr.(ReadableOperand).Read(vm)
+}
+
+func (t *Taint) registerLabel(opr Operand) TaintLabel {
+	if r, ok := opr.(*Register); ok {
+		return t.RegisterLabel(r)
+	}
+	return 0
+}
+
+func (t *Taint) setOperand(opr Operand, l TaintLabel) {
+	if r, ok := opr.(*Register); ok {
+		t.setRegister(r, l)
+	}
+}
+
+// inputs returns the labels of the registers op reads; memory it reads
+// adds its labels as the accesses happen.
+func (t *Taint) inputs(vm *VM, op *Opcode) TaintLabel {
+	switch t.mn {
+	case ADD, ADC, SUB, SBB, AND, OR, XOR, CMP, TEST:
+		if r1, ok := op.opr1.(*Register); ok && r1 == op.opr2 && (t.mn == XOR || t.mn == SUB) {
+			// xor ax,ax and sub ax,ax clear the register whatever it held.
+			return 0
+		}
+		return t.registerLabel(op.opr1) | t.registerLabel(op.opr2)
+	case MOV:
+		return t.registerLabel(op.opr2)
+	case PUSH, INC, DEC, NOT, NEG, SHL, SHR, SAR, RCL, RCR:
+		return t.registerLabel(op.opr1)
+	case LEA:
+		l := TaintLabel(0)
+		for _, r := range RegAddressMap[op.opr2.(*Memory).regad] {
+			l |= t.RegisterLabel(r)
+		}
+		return l
+	case MUL, DIV, IDIV:
+		l := t.registerLabel(op.opr1) | t.RegisterLabel(AX)
+		if op.opr1.(ReadableOperand).Bit() == Bit16 {
+			l |= t.RegisterLabel(DX)
+		}
+		return l
+	case CBW:
+		return t.RegisterLabel(AL)
+	case CWD:
+		return t.RegisterLabel(AX)
+	case STOSB:
+		return t.RegisterLabel(AL)
+	case STOSW:
+		return t.RegisterLabel(AX)
+	case IN:
+		if t.Spec.Ports {
+			s := t.source(vm, "in", 0, 0, 0)
+			s.Port = op.opr2.(ReadableOperand).Read(vm)
+			return taintBit(s.ID)
+		}
+	}
+	return 0
+}
+
+func (t *Taint) before(vm *VM, op *Opcode) error {
+	t.cs, t.ip, t.op, t.mn = vm.sreg["cs"], vm.ip, op, op.mn
+	if op.following != nil {
+		t.mn = op.following.mn
+	}
+	switch t.mn {
+	case CALL, JMP:
+		if isMemory(op.opr1) || isRegister(op.opr1) {
+			target := t.operandValue(vm, op.opr1)
+			kind := "call target"
+			if t.mn == JMP {
+				kind = "jump target"
+			}
+			t.sink(vm, kind, fmt.Sprintf("%04x", target), t.operandLabel(vm, op.opr1))
+		}
+	case RET:
+		ss, sp := vm.sreg["ss"], vm.reg["sp"]
+		t.sink(vm, "return address", fmt.Sprintf("%04x", vm.Read16(ss, sp)), t.Label(ss, sp, 2))
+	}
+	t.src, t.read = t.inputs(vm, op), 0
+	return nil
+}
+
+func (t *Taint) memory(vm *VM, a *MemoryAccess) error {
+	n := 1
+	if a.W == Bit16 {
+		n = 2
+	}
+	if !a.Write {
+		t.read = t.Label(a.Segment, a.Offset, n)
+		t.src |= t.read
+		return nil
+	}
+	l := t.src
+	switch t.mn {
+	case MOVSB, MOVSW:
+		// Each element of a copy carries its own labels.
+		l = t.read
+	case XCHG:
+		l = t.registerLabel(t.op.opr1) | t.registerLabel(t.op.opr2)
+	}
+	t.set(a.Segment, a.Offset, n, l)
+	return nil
+}
+
+func (t *Taint) after(vm *VM, op *Opcode) error {
+	switch t.mn {
+	case ADD, ADC, SUB, SBB, AND, OR, XOR, MOV, INC, DEC, NOT, NEG, SHL, SHR, SAR, RCL, RCR, LEA, POP, IN:
+		t.setOperand(op.opr1, t.src)
+	case XCHG:
+		r1, ok1 := op.opr1.(*Register)
+		r2, ok2 := op.opr2.(*Register)
+		switch {
+		case ok1 && ok2:
+			l1, l2 := t.RegisterLabel(r1), t.RegisterLabel(r2)
+			t.setRegister(r1, l2)
+			t.setRegister(r2, l1)
+		case ok1:
+			t.setRegister(r1, t.read)
+		case ok2:
+			t.setRegister(r2, t.read)
+		}
+	case MUL, DIV, IDIV:
+		t.setRegister(AX, t.src)
+		if op.opr1.(ReadableOperand).Bit() == Bit16 {
+			t.setRegister(DX, t.src)
+		}
+	case CBW:
+		t.setRegister(AX, t.src)
+	case CWD:
+		t.setRegister(DX, t.src)
+	}
+	if t.src != 0 && t.mn != CMP && t.mn != TEST {
+		t.step(TaintStep{t.cs, t.ip, op, t.src})
+	}
+	return nil
+}
+
+func (t *Taint) step(s TaintStep) {
+	t.steps[t.next] = s
+	if t.next++; t.next == len(t.steps) {
+		t.next, t.full = 0, true
+	}
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "==%d== taint %04x:%04x  %-24s %s\n", Pid(), s.CS, s.IP, s.Op.Disasm(), t.names(s.Label))
+	}
+}
+
+// path returns the last steps that moved any of l, oldest first.
+func (t *Taint) path(l TaintLabel) (steps []TaintStep) {
+	all := t.steps[:t.next]
+	if t.full {
+		all = append(append([]TaintStep{}, t.steps[t.next:]...), all...)
+	}
+	for i := len(all) - 1; i >= 0 && len(steps) < TaintPath; i-- {
+		if all[i].Label&l != 0 {
+			steps = append([]TaintStep{all[i]}, steps...)
+		}
+	}
+	return
+}
+
+func (t *Taint) sources(l TaintLabel) (sources []*TaintSource) {
+	for _, s := range t.Sources {
+		if l&taintBit(s.ID) != 0 {
+			sources = append(sources, s)
+		}
+	}
+	return
+}
+
+func (t *Taint) names(l TaintLabel) string {
+	names := []string{}
+	for _, s := range t.sources(l) {
+		names = append(names, s.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (t *Taint) syscall(vm *VM, ev *SyscallEvent) error {
+	msg := ev.Message
+	if !ev.Done {
+		switch ev.Call {
+		case MINIX_exec:
+			name, n := uint16(msg.Get(m1_p1)), int(msg.Get(m1_i1))
+			if l := t.Label(vm.sreg["ss"], name, n); l != 0 {
+				t.sink(vm, "exec path", strconv.Quote(vm.ReadString(vm.sreg["ss"], name)), l)
+			}
+		}
+		return nil
+	}
+	if ev.Call == MINIX_exec && ev.Err == nil {
+		t.loaded(vm)
+		return nil
+	}
+	if ev.Call == MINIX_fork && ev.Result == 0 {
+		t.Sinks, t.seen = nil, make(map[taintKey]*TaintSink)
+	}
+	t.setRegister(AX, 0)
+	// Data the kernel hands over replaces what was in memory.
+	t.set(vm.sreg["ss"], vm.reg["bx"], 24, 0)
+	if ev.Err != nil {
+		return nil
+	}
+	switch ev.Call {
+	case MINIX_read:
+		fd, buffer := int(msg.Get(m1_i1)), uint16(msg.Get(m1_p1))
+		l := TaintLabel(0)
+		if t.Spec.Read && (t.Spec.Fds == nil || t.Spec.Fds[fd]) && ev.Result > 0 {
+			s := t.source(vm, "read", vm.sreg["ds"], buffer, ev.Result)
+			s.Fd = fd
+			l = taintBit(s.ID)
+		}
+		t.set(vm.sreg["ds"], buffer, ev.Result, l)
+	case MINIX_stat:
+		t.set(vm.sreg["ss"], uint16(msg.Get(m1_p2)), 30, 0)
+	case MINIX_fstat:
+		t.set(vm.sreg["ss"], uint16(msg.Get(m1_p1)), 30, 0)
+	}
+	return nil
+}
+
+func (t *Taint) sink(vm *VM, kind, value string, l TaintLabel) {
+	if l == 0 {
+		return
+	}
+	key := taintKey{kind, t.cs, t.ip}
+	if s := t.seen[key]; s != nil {
+		s.Count++
+		return
+	}
+	s := &TaintSink{Kind: kind, Value: value, CS: t.cs, IP: t.ip, Op: t.op, Label: l, Count: 1}
+	s.Sources, s.Path = t.sources(l), t.path(l)
+	ip := vm.ip
+	vm.ip = t.ip
+	s.Backtrace = vm.Backtrace(BacktraceArgs)
+	vm.ip = ip
+	t.seen[key] = s
+	t.Sinks = append(t.Sinks, s)
+
+	pid := Pid()
+	fmt.Fprintf(t.w, "==%d== %s\n", pid, s.Error())
+	fmt.Fprintf(t.w, "==%d==    at %04x:%04x  %s\n", pid, s.CS, s.IP, s.Op.Disasm())
+	for _, src := range s.Sources {
+		fmt.Fprintf(t.w, "==%d==    %s\n", pid, src)
+	}
+	if len(s.Path) > 0 {
+		fmt.Fprintf(t.w, "==%d==    path:\n", pid)
+	}
+	for _, p := range s.Path {
+		fmt.Fprintf(t.w, "==%d==      %04x:%04x  %-24s %s\n", pid, p.CS, p.IP, p.Op.Disasm(), t.names(p.Label))
+	}
+	for i, f := range s.Backtrace {
+		fmt.Fprintf(t.w, "==%d==    #%-2d %s\n", pid, i, f)
+	}
+	fmt.Fprintf(t.w, "==%d==\n", pid)
+}
@@ -0,0 +1,97 @@
+package go8086
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// newTaintTest attaches a Taint to code, whose message at SS:0x100 reads
+// input from a pipe.
+func newTaintTest(t *testing.T, code Bytes, input string) (*VM, *Taint, *bytes.Buffer) {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	w.Write([]byte(input))
+	w.Close()
+	t.Cleanup(func() { r.Close() })
+
+	vm := newTestVM(code)
+	vm.SS(0x102).Write16(uint16(MINIX_read))
+	vm.SS(0x104).Write16(uint16(r.Fd()))
+	vm.SS(0x106).Write16(uint16(len(input)))
+	vm.SS(0x10a).Write16(0x200)
+	buf := new(bytes.Buffer)
+	taint := NewTaint(buf, TaintSpec{Read: true})
+	taint.Attach(vm)
+	return vm, taint, buf
+}
+
+func TestTaintReturnAddress(t *testing.T) {
+	vm, taint, buf := newTaintTest(t, Bytes{
+		0xbb, 0x00, 0x01, // mov bx,0x100
+		0xcd, 0x20, // int 0x20
+		0xa1, 0x00, 0x02, // mov ax,[0x200]
+		0x89, 0xc1, // mov cx,ax
+		0x31, 0xc0, // xor ax,ax
+		0x51, // push cx
+		0xc3, // ret
+	}, "hi")
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, uint16(0x6968), vm.IP())
+	assert.Equal(t, TaintLabel(0), taint.RegisterLabel(AX))
+	assert.Equal(t, TaintLabel(1), taint.RegisterLabel(CX))
+	assert.Equal(t, TaintLabel(1), taint.RegisterLabel(CH))
+	assert.Equal(t, TaintLabel(1), taint.Label(0, 0x200, 2))
+	assert.Equal(t, TaintLabel(0), taint.Label(0, 0x202, 1))
+
+	assert.Equal(t, 1, len(taint.Sources))
+	src := taint.Sources[0]
+	assert.Equal(t, fmt.Sprintf("read#0: fd %d, 2 bytes at 0000:0200, instruction 2", src.Fd), src.String())
+	assert.Equal(t, 1, len(taint.Sinks))
+	sink := taint.Sinks[0]
+	assert.Equal(t, "tainted return address 6968 from read#0", sink.Error())
+	assert.Equal(t, uint16(0xd), sink.IP)
+	ips := []uint16{}
+	for _, s := range sink.Path {
+		ips = append(ips, s.IP)
+	}
+	assert.Equal(t, []uint16{0x5, 0x8, 0xc}, ips)
+
+	assert.Nil(t, taint.Close())
+	out := buf.String()
+	assert.Contains(t, out, "   at 1000:000d  ret\n")
+	assert.Contains(t, out, "      1000:0008  mov cx,ax                read#0\n")
+	assert.Contains(t, out, "TAINT SUMMARY: 1 sinks reached from 1 sources\n")
+}
+
+func TestTaintJumpTarget(t *testing.T) {
+	vm, taint, _ := newTaintTest(t, Bytes{
+		0xbb, 0x00, 0x01, // mov bx,0x100
+		0xcd, 0x20, // int 0x20
+		0x8a, 0x1e, 0x00, 0x02, // mov bl,[0x200]
+		0xb7, 0x00, // mov bh,0x0
+		0xff, 0xe3, // jmp bx
+	}, "A")
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, TaintLabel(1), taint.RegisterLabel(BL))
+	assert.Equal(t, TaintLabel(0), taint.RegisterLabel(BH))
+	assert.Equal(t, 1, len(taint.Sinks))
+	assert.Equal(t, "tainted jump target 0041 from read#0", taint.Sinks[0].Error())
+}
+
+func TestParseTaintSources(t *testing.T) {
+	spec, err := ParseTaintSources("read:0,argv")
+	assert.Nil(t, err)
+	assert.Equal(t, TaintSpec{Read: true, Fds: map[int]bool{0: true}, Argv: true}, spec)
+	spec, err = ParseTaintSources("read:0,read,in")
+	assert.Nil(t, err)
+	assert.Equal(t, TaintSpec{Read: true, Ports: true}, spec)
+	_, err = ParseTaintSources("write")
+	assert.Equal(t, "bad taint source: write", err.Error())
+}
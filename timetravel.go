@@ -0,0 +1,308 @@
+package go8086
+
+import (
+	"errors"
+)
+
+var ErrHistoryStart = errors.New("reached the start of recorded history")
+
+type HistoryConfig struct {
+	Interval       uint64
+	MaxCheckpoints int
+	MaxDeltas      int
+}
+
+var DefaultHistoryConfig = HistoryConfig{
+	Interval:       100000,
+	MaxCheckpoints: 16,
+	MaxDeltas:      1 << 20,
+}
+
+type memoryUndo struct {
+	address uint32
+	old     byte
+}
+
+type historyDelta struct {
+	count  uint64
+	ip     uint16
+	flag   uint16
+	reg    [8]uint16
+	sreg   [4]uint16
+	writes []memoryUndo
+}
+
+type historyCheckpoint struct {
+	snapshot *Snapshot
+	event    int
+}
+
+// History keeps an undo record for every executed instruction and a full
+// snapshot every Interval instructions. Undo records step backwards
+// cheaply; going further back than MaxDeltas restores a checkpoint and
+// re-executes forward, feeding syscalls from the recorded events. Events
+// stepped back over are kept in future and replayed when execution goes
+// forward again, so the host sees each syscall once.
+type History struct {
+	config      HistoryConfig
+	vm          *VM
+	deltas      []*historyDelta
+	head        int
+	n           int
+	cur         *historyDelta
+	checkpoints []*historyCheckpoint
+	events      []*ReplayEvent
+	eventBase   int
+	future      []*ReplayEvent
+	replaying   bool
+	recorder    *Recorder
+	replayer    *Replayer
+	ids         []HookID
+}
+
+func NewHistory(vm *VM, config HistoryConfig) (h *History) {
+	h = &History{config: config, vm: vm}
+	h.deltas = make([]*historyDelta, config.MaxDeltas)
+	h.recorder = &Recorder{emit: func(re *ReplayEvent) error {
+		h.events = append(h.events, re)
+		return nil
+	}}
+	h.replayer = &Replayer{next: func() (re *ReplayEvent, err error) {
+		if len(h.future) > 0 {
+			re, h.future = h.future[0], h.future[1:]
+		}
+		return
+	}}
+	h.ids = append(h.ids,
+		vm.AddBeforeInstructionHook(h.before),
+		vm.AddAfterInstructionHook(h.after),
+		vm.AddMemoryHook(h.memory),
+		vm.AddSyscallHook(h.syscall),
+	)
+	return
+}
+
+func (h *History) Detach() {
+	for _, id := range h.ids {
+		h.vm.RemoveHook(id)
+	}
+	h.ids = nil
+}
+
+func (h *History) Reset() {
+	h.head, h.n, h.cur = 0, 0, nil
+	h.checkpoints = nil
+	h.events, h.eventBase = nil, 0
+	h.future, h.replaying = nil, false
+}
+
+func (h *History) before(vm *VM, op *Opcode) error {
+	if h.config.Interval > 0 && vm.count%h.config.Interval == 0 {
+		last := len(h.checkpoints) - 1
+		if last < 0 || h.checkpoints[last].snapshot.Count != vm.count {
+			h.checkpoint()
+		}
+	}
+	d := &historyDelta{count: vm.count, ip: vm.ip, flag: vm.flag}
+	for i, r := range regs[Bit16] {
+		d.reg[i] = vm.reg[r.name]
+	}
+	for i, r := range sregs {
+		d.sreg[i] = vm.sreg[r.name]
+	}
+	h.cur = d
+	return nil
+}
+
+func (h *History) checkpoint() {
+	h.checkpoints = append(h.checkpoints, &historyCheckpoint{h.vm.Snapshot(), h.eventBase + len(h.events)})
+	if len(h.checkpoints) > h.config.MaxCheckpoints {
+		h.checkpoints = h.checkpoints[1:]
+		drop := h.checkpoints[0].event - h.eventBase
+		h.events = h.events[drop:]
+		h.eventBase += drop
+	}
+}
+
+func (h *History) undo(addr uint32, n int) {
+	if h.cur == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		a := (addr + uint32(i)) % MemorySize
+		h.cur.writes = append(h.cur.writes, memoryUndo{a, h.vm.mem[a]})
+	}
+}
+
+func (h *History) memory(vm *VM, access *MemoryAccess) error {
+	if !access.Write {
+		return nil
+	}
+	switch access.W {
+	case Bit8:
+		h.undo(access.Address, 1)
+	case Bit16:
+		h.undo(access.Address, 1)
+		h.undo(Physical(access.Segment, access.Offset+1), 1)
+	}
+	return nil
+}
+
+// Syscalls write guest memory directly rather than through Memory
+// operands, so the regions they may touch are saved up front.
+func (h *History) syscall(vm *VM, ev *SyscallEvent) (err error) {
+	if !ev.Done {
+		h.undo(Physical(SS.Read(vm), vm.reg["bx"]), 24)
+		for _, r := range syscallRegions(ev.Call, ev.Message, int(ev.Message.Get(m1_i2))) {
+			h.undo(Physical(r.sreg.Read(vm), r.offset), r.n)
+		}
+		h.replaying = len(h.future) > 0
+	}
+	if ev.Done && ev.Call == MINIX_exec && ev.Err == nil {
+		h.Reset()
+		return nil
+	}
+	if !h.replaying {
+		return h.recorder.hook(vm, ev)
+	}
+	if ev.Done {
+		h.replaying = false
+		return
+	}
+	re := h.future[0]
+	if err = h.replayer.hook(vm, ev); err != nil {
+		h.future = nil
+		return
+	}
+	h.events = append(h.events, re)
+	return
+}
+
+func (h *History) after(vm *VM, op *Opcode) error {
+	if h.cur == nil || len(h.deltas) == 0 {
+		return nil
+	}
+	i := (h.head + h.n) % len(h.deltas)
+	h.deltas[i] = h.cur
+	if h.n < len(h.deltas) {
+		h.n++
+	} else {
+		h.head = (h.head + 1) % len(h.deltas)
+	}
+	h.cur = nil
+	return nil
+}
+
+func (h *History) last() *historyDelta {
+	if h.n == 0 {
+		return nil
+	}
+	return h.deltas[(h.head+h.n-1)%len(h.deltas)]
+}
+
+func (h *History) Oldest() (count uint64, ok bool) {
+	if len(h.checkpoints) > 0 {
+		return h.checkpoints[0].snapshot.Count, true
+	}
+	if h.n > 0 {
+		return h.deltas[h.head].count, true
+	}
+	return
+}
+
+func (h *History) ReverseStep() error {
+	d := h.last()
+	if d == nil {
+		if count, ok := h.Oldest(); ok && count < h.vm.count {
+			return h.ReverseTo(h.vm.count - 1)
+		}
+		return ErrHistoryStart
+	}
+	h.n--
+	vm := h.vm
+	for i := len(d.writes) - 1; i >= 0; i-- {
+		vm.mem[d.writes[i].address] = d.writes[i].old
+	}
+	for i, r := range regs[Bit16] {
+		vm.reg[r.name] = d.reg[i]
+	}
+	for i, r := range sregs {
+		vm.sreg[r.name] = d.sreg[i]
+	}
+	vm.ip, vm.flag, vm.count = d.ip, d.flag, d.count
+	h.rewind(d.count)
+	h.cur = nil
+	return nil
+}
+
+// rewind moves the events after count back to future and drops the
+// checkpoints taken after it. An event is stamped with the count after
+// its int, so the one of the instruction at count has already run.
+func (h *History) rewind(count uint64) {
+	for len(h.events) > 0 && h.events[len(h.events)-1].Count > count {
+		h.future = append([]*ReplayEvent{h.events[len(h.events)-1]}, h.future...)
+		h.events = h.events[:len(h.events)-1]
+	}
+	for len(h.checkpoints) > 0 && h.checkpoints[len(h.checkpoints)-1].snapshot.Count > count {
+		h.checkpoints = h.checkpoints[:len(h.checkpoints)-1]
+	}
+}
+
+func (h *History) ReverseContinue(stop func(vm *VM) bool) (err error) {
+	for {
+		if err = h.ReverseStep(); err != nil {
+			return
+		}
+		if stop(h.vm) {
+			return
+		}
+	}
+}
+
+func (h *History) ReverseTo(count uint64) (err error) {
+	for h.vm.count > count {
+		if d := h.last(); d == nil || d.count < count {
+			break
+		}
+		if err = h.ReverseStep(); err != nil {
+			return
+		}
+	}
+	if h.vm.count <= count {
+		return
+	}
+	var cp *historyCheckpoint
+	for _, c := range h.checkpoints {
+		if c.snapshot.Count <= count {
+			cp = c
+		}
+	}
+	if cp == nil {
+		return ErrHistoryStart
+	}
+	events := append(append([]*ReplayEvent{}, h.events[cp.event-h.eventBase:]...), h.future...)
+	saved := h.vm.hooks
+	h.vm.hooks = nil
+	h.vm.restore(cp.snapshot)
+	newReplayerEvents(events).Attach(h.vm)
+	for h.vm.count < count && err == nil {
+		err = h.vm.Step()
+	}
+	h.vm.hooks = saved
+	h.head, h.n, h.cur = 0, 0, nil
+	h.rewind(h.vm.count)
+	return
+}
+
+func (h *History) LastWrite(addr uint32) (count uint64, ip uint16, ok bool) {
+	addr %= MemorySize
+	for i := h.n - 1; i >= 0; i-- {
+		d := h.deltas[(h.head+i)%len(h.deltas)]
+		for _, w := range d.writes {
+			if w.address == addr {
+				return d.count, d.ip, true
+			}
+		}
+	}
+	return
+}
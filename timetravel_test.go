@@ -0,0 +1,126 @@
+package go8086
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var historyTestCode = Bytes{
+	0xbb, 0x00, 0x01, // mov bx,0x100
+	0xff, 0x06, 0x10, 0x00, // inc word [0x10]
+	0xc7, 0x47, 0x02, 0x0d, 0x00, // mov word [bx+0x2],0xd
+	0xcd, 0x20, // int 0x20
+	0xeb, 0xf3, // jmp short 0x3
+}
+
+func TestHistoryReverseStep(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	h := NewHistory(vm, DefaultHistoryConfig)
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, uint16(2), vm.Read16(0, 0x10))
+	assert.Equal(t, uint16(0x000c), vm.IP())
+
+	assert.Nil(t, h.ReverseStep())
+	assert.Equal(t, uint16(0x0007), vm.IP())
+	assert.Equal(t, uint64(6), vm.InstructionCount())
+
+	atInc := func(vm *VM) bool { return vm.IP() == 0x0003 }
+	assert.Nil(t, h.ReverseContinue(atInc))
+	assert.Equal(t, uint64(5), vm.InstructionCount())
+	assert.Equal(t, uint16(1), vm.Read16(0, 0x10))
+	assert.Nil(t, h.ReverseContinue(atInc))
+	assert.Equal(t, uint64(1), vm.InstructionCount())
+	assert.Equal(t, uint16(0), vm.Read16(0, 0x10))
+
+	assert.Nil(t, h.ReverseStep())
+	assert.Equal(t, uint16(0), vm.Reg(BX))
+	assert.Equal(t, ErrHistoryStart, h.ReverseStep())
+}
+
+func TestHistoryReverseStepAfterSyscall(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	h := NewHistory(vm, DefaultHistoryConfig)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Nil(t, h.ReverseStep())
+	assert.Equal(t, 0, len(h.future))
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Equal(t, uint64(8), vm.InstructionCount())
+	assert.Equal(t, 2, len(h.events))
+}
+
+func TestHistoryLastWrite(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	h := NewHistory(vm, DefaultHistoryConfig)
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	count, ip, ok := h.LastWrite(0x11)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), count)
+	assert.Equal(t, uint16(0x0003), ip)
+	_, _, ok = h.LastWrite(0x20)
+	assert.False(t, ok)
+}
+
+func TestHistoryReverseToCheckpoint(t *testing.T) {
+	vm := newTestVM(historyTestCode)
+	h := NewHistory(vm, HistoryConfig{Interval: 4, MaxCheckpoints: 4, MaxDeltas: 2})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	time := MinixMessage(vm.SS(0x100)).Get(m2_l1)
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Nil(t, h.ReverseTo(5))
+	assert.Equal(t, uint64(5), vm.InstructionCount())
+	assert.Equal(t, uint16(0x0003), vm.IP())
+	assert.Equal(t, uint16(1), vm.Read16(0, 0x10))
+	assert.Equal(t, time, MinixMessage(vm.SS(0x100)).Get(m2_l1))
+	assert.Nil(t, h.ReverseTo(0))
+	assert.Equal(t, uint16(0x0000), vm.IP())
+	assert.Equal(t, ErrHistoryStart, h.ReverseStep())
+}
+
+func TestHistoryReplayAfterReverse(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+	code, _, err := Assemble(strings.NewReader(fmt.Sprintf(`
+	mov bx,msg
+	int 0x20
+	mov cx,ax
+msg:	dw 0, 4, %d, 3, 0, text ; write(fd, text, 3)
+text:	db 'hi', 0xa`, w.Fd())))
+	assert.Nil(t, err)
+	vm := NewVM()
+	vm.CS(0).write(code)
+	vm.DS(0).write(code)
+	h := NewHistory(vm, DefaultHistoryConfig)
+	assert.Nil(t, vm.Step())
+	assert.Nil(t, vm.Step())
+	assert.Equal(t, uint16(3), vm.Reg(AX))
+
+	assert.Nil(t, h.ReverseStep())
+	assert.Equal(t, uint64(1), vm.InstructionCount())
+	assert.Equal(t, 1, len(h.future))
+	assert.Nil(t, vm.Step())
+	assert.Nil(t, vm.Step())
+	assert.Equal(t, uint16(3), vm.Reg(CX))
+	assert.Equal(t, 0, len(h.future))
+	assert.Equal(t, 1, len(h.events))
+
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hi\n", string(out))
+}
@@ -0,0 +1,40 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// Bochs prints one line per instruction with "trace on" in its debugger:
+//
+//	(0).[142] [0x000000000100] 0193:0000000000000100 (unk. ctxt): mov ax, 0x1234 ; b83412
+//
+// It has no registers, so only the instruction stream can be compared.
+var bochsLine = regexp.MustCompile(`\[0x[0-9a-fA-F]+\]\s+([0-9a-fA-F]{4}):([0-9a-fA-F]+)\s+\([^)]*\):\s+(.*?)\s*;\s*([0-9a-fA-F]+)\s*$`)
+
+func init() {
+	RegisterImporter(&Importer{Name: "bochs", Read: ReadBochs})
+}
+
+func ReadBochs(r io.Reader) (recs []*Record, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := bochsLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		cs, _ := strconv.ParseUint(m[1], 16, 16)
+		ip, _ := strconv.ParseUint(m[2], 16, 64)
+		rec := &Record{Kind: Instruction, Count: uint64(len(recs)), CS: uint16(cs), IP: uint16(ip)}
+		if bs, herr := hex.DecodeString(m[4]); herr == nil {
+			rec.Bytes = bs
+		}
+		rec.Mnemonic, rec.Operands = splitAsm(m[3])
+		recs = append(recs, rec)
+	}
+	err = scanner.Err()
+	return
+}
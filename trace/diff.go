@@ -0,0 +1,400 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type Align int
+
+const (
+	// AlignCount pairs the instructions with the same Count.
+	AlignCount Align = iota
+	// AlignAddress starts at the first address both traces execute, for
+	// traces that begin at different points, e.g. with a loader in one.
+	AlignAddress
+)
+
+type DiffOptions struct {
+	Align Align
+	// Registers, FlagMask and Memory select what is compared besides the
+	// instruction addresses and bytes.
+	Registers bool
+	FlagMask  uint16
+	Memory    bool
+	// IgnoreSegments compares offsets only, for emulators that load the
+	// program at another segment.
+	IgnoreSegments bool
+	// Resync is how far ahead to look for a common address after control
+	// flow diverged.
+	Resync int
+}
+
+var DefaultDiffOptions = DiffOptions{Registers: true, FlagMask: 0xffff, Memory: true, Resync: 256}
+
+// DiffOptionsFor compares what both importers provide.
+func DiffOptionsFor(a, b *Importer) (opts DiffOptions) {
+	opts = DefaultDiffOptions
+	opts.Registers = a.Registers && b.Registers
+	opts.FlagMask = a.FlagMask & b.FlagMask
+	opts.Memory = a.Memory && b.Memory
+	return
+}
+
+// Divergence is one difference between the traces. A and B index the
+// instruction (or syscall) lists of the report.
+type Divergence struct {
+	Kind   string
+	A, B   int
+	Detail string
+}
+
+type DiffReport struct {
+	A, B        []*Record
+	SyscallsA   []*Record
+	SyscallsB   []*Record
+	Divergences []*Divergence
+	Compared    int
+	Notes       []string
+}
+
+func split(recs []*Record) (insns, syscalls []*Record) {
+	for _, r := range recs {
+		if r.Kind == Syscall {
+			syscalls = append(syscalls, r)
+		} else {
+			insns = append(insns, r)
+		}
+	}
+	return
+}
+
+type differ struct {
+	opts   DiffOptions
+	report *DiffReport
+	regsA  map[string]uint16
+	regsB  map[string]uint16
+	flagsA *uint16
+	flagsB *uint16
+}
+
+func (d *differ) address(r *Record) uint32 {
+	if d.opts.IgnoreSegments {
+		return uint32(r.IP)
+	}
+	return uint32(r.CS)<<16 | uint32(r.IP)
+}
+
+func (d *differ) add(kind string, i, j int, format string, a ...interface{}) {
+	d.report.Divergences = append(d.report.Divergences, &Divergence{kind, i, j, fmt.Sprintf(format, a...)})
+}
+
+func Diff(a, b []*Record, opts DiffOptions) *DiffReport {
+	r := &DiffReport{}
+	r.A, r.SyscallsA = split(a)
+	r.B, r.SyscallsB = split(b)
+	d := &differ{opts: opts, report: r, regsA: map[string]uint16{}, regsB: map[string]uint16{}}
+	i, j := d.start()
+	d.instructions(i, j)
+	d.syscalls()
+	return r
+}
+
+func (d *differ) match(a, b *Record) bool {
+	if d.opts.Align == AlignCount {
+		return a.Count == b.Count
+	}
+	return d.address(a) == d.address(b)
+}
+
+// start skips the records of either trace that precede the other's first.
+func (d *differ) start() (i, j int) {
+	A, B := d.report.A, d.report.B
+	if len(A) == 0 || len(B) == 0 {
+		return
+	}
+	for j = range B {
+		if d.match(A[0], B[j]) {
+			break
+		}
+	}
+	if j == len(B)-1 && !d.match(A[0], B[j]) {
+		j = 0
+		for i = range A {
+			if d.match(A[i], B[0]) {
+				break
+			}
+		}
+		if !d.match(A[i], B[0]) {
+			i = 0
+		}
+	}
+	if i > 0 || j > 0 {
+		d.report.Notes = append(d.report.Notes, fmt.Sprintf("aligned A#%d with B#%d", i, j))
+	}
+	return
+}
+
+func (d *differ) instructions(i, j int) {
+	A, B := d.report.A, d.report.B
+	for i < len(A) && j < len(B) {
+		if d.address(A[i]) != d.address(B[j]) {
+			d.add("address", i, j, "executed %04x:%04x vs %04x:%04x", A[i].CS, A[i].IP, B[j].CS, B[j].IP)
+			k, l, ok := d.resync(i, j)
+			if !ok {
+				d.report.Notes = append(d.report.Notes, fmt.Sprintf("lost sync after A#%d B#%d", i, j))
+				return
+			}
+			i, j = k, l
+			continue
+		}
+		d.compare(i, j)
+		d.report.Compared++
+		i, j = i+1, j+1
+	}
+	if i < len(A) {
+		d.report.Notes = append(d.report.Notes, fmt.Sprintf("B ends first, A has %d more instructions", len(A)-i))
+	} else if j < len(B) {
+		d.report.Notes = append(d.report.Notes, fmt.Sprintf("A ends first, B has %d more instructions", len(B)-j))
+	}
+}
+
+// resync finds the closest pair of positions at or after i and j that are
+// at the same address again.
+func (d *differ) resync(i, j int) (int, int, bool) {
+	A, B := d.report.A, d.report.B
+	for dist := 1; dist <= 2*d.opts.Resync; dist++ {
+		for k := 0; k <= dist; k++ {
+			ii, jj := i+k, j+dist-k
+			if ii < len(A) && jj < len(B) && d.address(A[ii]) == d.address(B[jj]) {
+				return ii, jj, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func (d *differ) compare(i, j int) {
+	a, b := d.report.A[i], d.report.B[j]
+	if len(a.Bytes) > 0 && len(b.Bytes) > 0 && string(a.Bytes) != string(b.Bytes) {
+		d.add("bytes", i, j, "%x vs %x", []byte(a.Bytes), []byte(b.Bytes))
+	}
+	if d.opts.Registers {
+		d.registers(i, j, a, b)
+	}
+	if d.opts.FlagMask != 0 {
+		d.flags(i, j, a, b)
+	}
+	if d.opts.Memory {
+		d.memory(i, j, a, b)
+	}
+}
+
+// Records hold what an instruction changed, so the state is tracked on
+// both sides and compared for the registers either side wrote.
+func (d *differ) registers(i, j int, a, b *Record) {
+	changed := []string{}
+	for _, name := range Registers {
+		_, inA := a.Regs[name]
+		_, inB := b.Regs[name]
+		if inA || inB {
+			changed = append(changed, name)
+		}
+	}
+	for name, v := range a.Regs {
+		d.regsA[name] = v
+	}
+	for name, v := range b.Regs {
+		d.regsB[name] = v
+	}
+	for _, name := range changed {
+		if d.opts.IgnoreSegments && isSegment(name) {
+			continue
+		}
+		va, okA := d.regsA[name]
+		vb, okB := d.regsB[name]
+		if okA && okB && va != vb {
+			d.add("register", i, j, "%s %04x vs %04x", name, va, vb)
+		}
+	}
+}
+
+func isSegment(name string) bool {
+	return name == "cs" || name == "ss" || name == "ds" || name == "es"
+}
+
+func (d *differ) flags(i, j int, a, b *Record) {
+	if a.Flags != nil {
+		d.flagsA = a.Flags
+	}
+	if b.Flags != nil {
+		d.flagsB = b.Flags
+	}
+	if (a.Flags == nil && b.Flags == nil) || d.flagsA == nil || d.flagsB == nil {
+		return
+	}
+	fa, fb := *d.flagsA&d.opts.FlagMask, *d.flagsB&d.opts.FlagMask
+	if fa != fb {
+		d.add("flags", i, j, "%s vs %s", FlagString(fa, d.opts.FlagMask), FlagString(fb, d.opts.FlagMask))
+	}
+}
+
+var flagOrder = []string{"of", "df", "if", "tf", "sf", "zf", "af", "pf", "cf"}
+
+// FlagString lists the flags in mask, upper case when set.
+func FlagString(flags, mask uint16) string {
+	names := []string{}
+	for _, name := range flagOrder {
+		bit := FlagBits[name]
+		if mask&(1<<bit) == 0 {
+			continue
+		}
+		if flags&(1<<bit) != 0 {
+			name = strings.ToUpper(name)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, " ")
+}
+
+func (d *differ) writes(r *Record) (ws []Access) {
+	for _, m := range r.Mem {
+		if m.Write {
+			if d.opts.IgnoreSegments {
+				m.Address, m.Segment = uint32(m.Offset), 0
+			}
+			ws = append(ws, m)
+		}
+	}
+	return
+}
+
+func (d *differ) memory(i, j int, a, b *Record) {
+	wa, wb := d.writes(a), d.writes(b)
+	for k := 0; k < len(wa) || k < len(wb); k++ {
+		switch {
+		case k >= len(wa):
+			d.add("memory", i, j, "only B writes %s", accessString(wb[k]))
+		case k >= len(wb):
+			d.add("memory", i, j, "only A writes %s", accessString(wa[k]))
+		case wa[k].Address != wb[k].Address || wa[k].Size != wb[k].Size || wa[k].Value != wb[k].Value:
+			d.add("memory", i, j, "write %s vs %s", accessString(wa[k]), accessString(wb[k]))
+		default:
+			continue
+		}
+		return
+	}
+}
+
+func accessString(a Access) string {
+	if a.Size == 1 {
+		return fmt.Sprintf("[%05x]=%02x", a.Address, a.Value)
+	}
+	return fmt.Sprintf("[%05x]=%04x", a.Address, a.Value)
+}
+
+func syscallString(r *Record) string {
+	return strings.SplitN(r.String(), " ", 3)[2]
+}
+
+func (d *differ) syscalls() {
+	A, B := d.report.SyscallsA, d.report.SyscallsB
+	for k := 0; k < len(A) && k < len(B); k++ {
+		if sa, sb := syscallString(A[k]), syscallString(B[k]); sa != sb {
+			d.add("syscall", k, k, "%s vs %s", sa, sb)
+		}
+	}
+	if len(A) != len(B) {
+		d.report.Notes = append(d.report.Notes, fmt.Sprintf("A made %d syscalls, B %d", len(A), len(B)))
+	}
+}
+
+// First returns the earliest instruction divergence, or the first syscall
+// one when the instructions agree. Instruction divergences come first and
+// in order.
+func (r *DiffReport) First() *Divergence {
+	if len(r.Divergences) == 0 {
+		return nil
+	}
+	return r.Divergences[0]
+}
+
+func changes(r *Record) string {
+	s := []string{}
+	for _, name := range Registers {
+		if v, ok := r.Regs[name]; ok {
+			s = append(s, fmt.Sprintf("%s=%04x", name, v))
+		}
+	}
+	if r.Flags != nil {
+		s = append(s, fmt.Sprintf("flags=%04x", *r.Flags))
+	}
+	for _, m := range r.Mem {
+		if m.Write {
+			s = append(s, accessString(m))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func printContext(w io.Writer, side string, recs []*Record, at, context int) {
+	for k := at - context; k <= at+context; k++ {
+		if k < 0 || k >= len(recs) {
+			continue
+		}
+		mark := " "
+		if k == at {
+			mark = ">"
+		}
+		fmt.Fprintf(w, "%s %s#%-6d %-40s %s\n", mark, side, k, recs[k], changes(recs[k]))
+	}
+}
+
+// Print shows the first divergence with context lines on both sides and
+// summarises the rest, listing up to max of them.
+func (r *DiffReport) Print(w io.Writer, context, max int) {
+	for _, n := range r.Notes {
+		fmt.Fprintf(w, "note: %s\n", n)
+	}
+	first := r.First()
+	if first == nil {
+		fmt.Fprintf(w, "traces match (%d instructions, %d syscalls compared)\n", r.Compared, len(r.SyscallsA))
+		return
+	}
+	A, B := r.A, r.B
+	if first.Kind == "syscall" {
+		A, B = r.SyscallsA, r.SyscallsB
+	}
+	fmt.Fprintf(w, "first divergence: %s at A#%d B#%d: %s\n", first.Kind, first.A, first.B, first.Detail)
+	printContext(w, "A", A, first.A, context)
+	fmt.Fprintln(w)
+	printContext(w, "B", B, first.B, context)
+
+	counts := map[string]int{}
+	kinds := []string{}
+	for _, d := range r.Divergences {
+		if counts[d.Kind] == 0 {
+			kinds = append(kinds, d.Kind)
+		}
+		counts[d.Kind]++
+	}
+	sort.Strings(kinds)
+	summary := []string{}
+	for _, k := range kinds {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[k], k))
+	}
+	fmt.Fprintf(w, "\n%d divergences (%s) in %d compared instructions\n", len(r.Divergences), strings.Join(summary, ", "), r.Compared)
+	for n, d := range r.Divergences {
+		if n >= max {
+			fmt.Fprintf(w, "  ... %d more\n", len(r.Divergences)-max)
+			break
+		}
+		a := r.A
+		if d.Kind == "syscall" {
+			a = r.SyscallsA
+		}
+		fmt.Fprintf(w, "  A#%-6d B#%-6d %04x:%04x %-8s %s\n", d.A, d.B, a[d.A].CS, a[d.A].IP, d.Kind, d.Detail)
+	}
+}
@@ -0,0 +1,103 @@
+package trace
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func insn(count uint64, ip uint16, regs map[string]uint16) *Record {
+	return &Record{Kind: Instruction, Count: count, CS: 0x1000, IP: ip, Regs: regs}
+}
+
+func diffTestTrace() []*Record {
+	flags := uint16(0x0020)
+	result := 0
+	return []*Record{
+		insn(0, 0x0, map[string]uint16{"ax": 1}),
+		insn(1, 0x3, map[string]uint16{"bx": 2}),
+		{Kind: Instruction, Count: 2, CS: 0x1000, IP: 0x6, Flags: &flags},
+		{Kind: Instruction, Count: 3, CS: 0x1000, IP: 0x8, Mem: []Access{{Address: 0x10, Offset: 0x10, Size: 2, Value: 1, Write: true}}},
+		insn(4, 0xb, nil),
+		{Kind: Syscall, Count: 4, CS: 0x1000, IP: 0xb, Syscall: &SyscallInfo{Call: 17, Name: "brk", Result: &result}},
+		insn(5, 0xd, map[string]uint16{"cx": 3}),
+	}
+}
+
+func TestDiffMatch(t *testing.T) {
+	r := Diff(diffTestTrace(), diffTestTrace(), DefaultDiffOptions)
+	assert.Nil(t, r.First())
+	assert.Equal(t, 6, r.Compared)
+	buf := new(bytes.Buffer)
+	r.Print(buf, 2, 10)
+	assert.Equal(t, "traces match (6 instructions, 1 syscalls compared)\n", buf.String())
+}
+
+func TestDiffDivergences(t *testing.T) {
+	b := diffTestTrace()
+	b[1].Regs["bx"] = 4
+	f := uint16(0x0021)
+	b[2].Flags = &f
+	b[3].Mem[0].Value = 2
+	result := -1
+	b[5].Syscall.Result = &result
+	r := Diff(diffTestTrace(), b, DefaultDiffOptions)
+	assert.Equal(t, []*Divergence{
+		{"register", 1, 1, "bx 0002 vs 0004"},
+		{"flags", 2, 2, "of df if tf sf ZF af pf cf vs of df if tf sf ZF af pf CF"},
+		{"memory", 3, 3, "write [00010]=0001 vs [00010]=0002"},
+		{"syscall", 0, 0, "brk() = 0 vs brk() = -1"},
+	}, r.Divergences)
+
+	buf := new(bytes.Buffer)
+	r.Print(buf, 1, 2)
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "first divergence: register at A#1 B#1: bx 0002 vs 0004\n"), out)
+	assert.Contains(t, out, "> A#1      1 1000:0003")
+	assert.Contains(t, out, "bx=0004")
+	assert.Contains(t, out, "4 divergences (1 flags, 1 memory, 1 register, 1 syscall) in 6 compared instructions")
+	assert.Contains(t, out, "... 2 more")
+
+	opts := DefaultDiffOptions
+	opts.Registers, opts.Memory, opts.FlagMask = false, false, 1<<FlagBits["zf"]
+	assert.Equal(t, 1, len(Diff(diffTestTrace(), b, opts).Divergences))
+}
+
+func TestDiffControlFlow(t *testing.T) {
+	a := diffTestTrace()
+	// B takes a detour through 0x100 before rejoining at 0x8.
+	b := append([]*Record{}, a[:2]...)
+	b = append(b, insn(2, 0x100, nil), insn(3, 0x102, nil))
+	for _, r := range a[3:] {
+		c := *r
+		if c.Kind == Instruction {
+			c.Count++
+		}
+		b = append(b, &c)
+	}
+	r := Diff(a, b, DefaultDiffOptions)
+	assert.Equal(t, &Divergence{"address", 2, 2, "executed 1000:0006 vs 1000:0100"}, r.First())
+	assert.Equal(t, 1, len(r.Divergences))
+	assert.Equal(t, 5, r.Compared)
+}
+
+func TestDiffAlignAddress(t *testing.T) {
+	a := diffTestTrace()
+	// B starts with loader code and runs the program at another segment.
+	b := []*Record{{Kind: Instruction, CS: 0xf000, IP: 0xfff0}}
+	for _, r := range diffTestTrace() {
+		r.CS = 0x0193
+		r.Count += 1
+		b = append(b, r)
+	}
+	opts := DefaultDiffOptions
+	opts.Align, opts.IgnoreSegments = AlignAddress, true
+	r := Diff(a, b, opts)
+	assert.Nil(t, r.First())
+	assert.Equal(t, []string{"aligned A#0 with B#1"}, r.Notes)
+
+	opts.Align = AlignCount
+	r = Diff(a, b, opts)
+	assert.Equal(t, "address", r.First().Kind)
+}
@@ -0,0 +1,79 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DOSBox's debugger writes LOGCPU.TXT with LOGS/LOGL: one line per
+// instruction with CS:EIP, the disassembly and the registers and flags as
+// they were before the instruction ran:
+//
+//	0193:00000100  mov  ax,1234    EAX:00000000 EBX:00000000 ... SS:0193 CF:0 ZF:1 ...
+var dosboxLine = regexp.MustCompile(`^([0-9A-Fa-f]{4}):([0-9A-Fa-f]{4,8})\s+(.*?)\s+(EAX:.*)$`)
+var dosboxColumns = regexp.MustCompile(`\s{3,}`)
+
+var dosboxRegisters = map[string]string{
+	"EAX": "ax", "ECX": "cx", "EDX": "dx", "EBX": "bx", "ESP": "sp", "EBP": "bp", "ESI": "si", "EDI": "di",
+	"DS": "ds", "ES": "es", "SS": "ss",
+}
+
+func dosboxFlagMask() (mask uint16) {
+	for _, f := range []string{"cf", "zf", "sf", "of", "af", "pf", "if"} {
+		mask |= 1 << FlagBits[f]
+	}
+	return
+}
+
+func init() {
+	RegisterImporter(&Importer{Name: "dosbox", Registers: true, FlagMask: dosboxFlagMask(), Read: ReadDOSBox})
+}
+
+func ReadDOSBox(r io.Reader) (recs []*Record, err error) {
+	regs := []map[string]uint16{}
+	flags := []uint16{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		m := dosboxLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		cs, _ := strconv.ParseUint(m[1], 16, 16)
+		ip, _ := strconv.ParseUint(m[2], 16, 32)
+		rec := &Record{Kind: Instruction, Count: uint64(len(recs)), CS: uint16(cs), IP: uint16(ip)}
+		if asm := dosboxColumns.Split(m[3], 2)[0]; asm != "" {
+			rec.Mnemonic, rec.Operands = splitAsm(asm)
+		}
+		state := map[string]uint16{"cs": uint16(cs)}
+		f := uint16(0)
+		for _, field := range strings.Fields(m[4]) {
+			kv := strings.SplitN(field, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, perr := strconv.ParseUint(kv[1], 16, 32)
+			if perr != nil {
+				return nil, fmt.Errorf("line %d: bad value %q", n, field)
+			}
+			if name, ok := dosboxRegisters[kv[0]]; ok {
+				state[name] = uint16(v)
+			} else if bit, ok := FlagBits[strings.ToLower(kv[0])]; ok && v != 0 {
+				f |= 1 << bit
+			}
+		}
+		recs = append(recs, rec)
+		regs = append(regs, state)
+		flags = append(flags, f)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	stateDeltas(recs, regs, flags)
+	return
+}
@@ -0,0 +1,108 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FlagBits gives the bit positions of Record.Flags, which is the flag word
+// of go8086's VM rather than the architectural FLAGS layout. Importers use
+// it to convert other emulators' flags.
+var FlagBits = map[string]uint{
+	"cf": 0, "pf": 1, "af": 3, "zf": 5, "sf": 6, "tf": 7, "if": 8, "df": 9, "of": 10,
+}
+
+// Importer loads a trace written by another emulator. Registers, FlagMask
+// and Memory tell what its records carry, so a diff only compares what
+// both sides know.
+type Importer struct {
+	Name      string
+	Registers bool
+	FlagMask  uint16
+	Memory    bool
+	Read      func(r io.Reader) ([]*Record, error)
+}
+
+var importers = map[string]*Importer{}
+
+func RegisterImporter(imp *Importer) {
+	importers[imp.Name] = imp
+}
+
+func LookupImporter(name string) (*Importer, bool) {
+	imp, ok := importers[name]
+	return imp, ok
+}
+
+func Importers() (names []string) {
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func (imp *Importer) ReadFile(file string) (recs []*Record, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if recs, err = imp.Read(f); err != nil {
+		err = fmt.Errorf("%s: %v", file, err)
+	}
+	return
+}
+
+func init() {
+	RegisterImporter(&Importer{Name: "go8086", Registers: true, FlagMask: 0xffff, Memory: true, Read: ReadAll})
+}
+
+// stateDeltas turns per-instruction register snapshots, taken before each
+// instruction ran as emulator logs usually are, into the deltas a Record
+// holds: what instruction i changed is the difference between snapshots i
+// and i+1. The last instruction's effect is unknown.
+func stateDeltas(recs []*Record, regs []map[string]uint16, flags []uint16) {
+	for i := 0; i+1 < len(recs); i++ {
+		for name, v := range regs[i+1] {
+			if old, ok := regs[i][name]; !ok || old != v {
+				if recs[i].Regs == nil {
+					recs[i].Regs = make(map[string]uint16)
+				}
+				recs[i].Regs[name] = v
+			}
+		}
+		if flags[i+1] != flags[i] {
+			f := flags[i+1]
+			recs[i].Flags = &f
+		}
+	}
+}
+
+var asmPrefixes = map[string]bool{
+	"rep": true, "repe": true, "repz": true, "repne": true, "repnz": true, "lock": true,
+}
+
+// splitAsm splits the disassembly of an instruction into its mnemonic,
+// which keeps any repeat or lock prefix as go8086 writes it ("rep movsb"),
+// and its operands.
+func splitAsm(asm string) (mnemonic string, operands []string) {
+	fields := strings.Fields(asm)
+	if len(fields) == 0 {
+		return
+	}
+	i := 0
+	for i < len(fields)-1 && asmPrefixes[strings.ToLower(fields[i])] {
+		i++
+	}
+	mnemonic = strings.Join(fields[:i+1], " ")
+	if rest := strings.Join(fields[i+1:], " "); rest != "" {
+		for _, o := range strings.Split(rest, ",") {
+			operands = append(operands, strings.TrimSpace(o))
+		}
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package trace
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const dosboxLog = `0193:00000100  mov  ax,0005                                          EAX:00000000 EBX:00000000 ECX:000000FF EDX:00000193 ESI:00000100 EDI:0000FFFE EBP:0000091C ESP:0000FFFE DS:0193 ES:0193 FS:0000 GS:0000 SS:0193 CF:0 ZF:0 SF:0 OF:0 AF:0 PF:0 IF:1
+0193:00000103  sub  ax,0005                                          EAX:00000005 EBX:00000000 ECX:000000FF EDX:00000193 ESI:00000100 EDI:0000FFFE EBP:0000091C ESP:0000FFFE DS:0193 ES:0193 FS:0000 GS:0000 SS:0193 CF:0 ZF:0 SF:0 OF:0 AF:0 PF:0 IF:1
+0193:00000106  int  20                                               EAX:00000000 EBX:00000000 ECX:000000FF EDX:00000193 ESI:00000100 EDI:0000FFFE EBP:0000091C ESP:0000FFFE DS:0193 ES:0193 FS:0000 GS:0000 SS:0193 CF:0 ZF:1 SF:0 OF:0 AF:0 PF:1 IF:1
+`
+
+func TestReadDOSBox(t *testing.T) {
+	recs, err := ReadDOSBox(strings.NewReader(dosboxLog))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, "0 0193:0100 mov ax,0005", recs[0].String())
+	assert.Equal(t, map[string]uint16{"ax": 5}, recs[0].Regs)
+	assert.Nil(t, recs[0].Flags)
+	assert.Equal(t, map[string]uint16{"ax": 0}, recs[1].Regs)
+	assert.Equal(t, "of df IF tf sf ZF af PF cf", FlagString(*recs[1].Flags, 0xffff))
+	assert.Nil(t, recs[2].Regs)
+}
+
+const bochsLog = `(0).[142] [0x000000001000] 0100:0000000000000000 (unk. ctxt): mov ax, 0x0005           ; b80500
+(0).[143] [0x000000001003] 0100:0000000000000003 (unk. ctxt): rep movsb byte ptr es:[di], byte ptr ds:[si] ; f3a4
+Next at t=144
+`
+
+func TestReadBochs(t *testing.T) {
+	recs, err := ReadBochs(strings.NewReader(bochsLog))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(recs))
+	assert.Equal(t, Hex{0xb8, 0x05, 0x00}, recs[0].Bytes)
+	assert.Equal(t, "0 0100:0000 mov ax,0x0005", recs[0].String())
+	assert.Equal(t, uint16(3), recs[1].IP)
+	assert.Equal(t, "rep movsb", recs[1].Mnemonic)
+	assert.Equal(t, []string{"byte ptr es:[di]", "byte ptr ds:[si]"}, recs[1].Operands)
+}
+
+func TestImporters(t *testing.T) {
+	assert.Equal(t, []string{"bochs", "dosbox", "go8086"}, Importers())
+	go8086, _ := LookupImporter("go8086")
+	bochs, _ := LookupImporter("bochs")
+	opts := DiffOptionsFor(go8086, bochs)
+	assert.False(t, opts.Registers)
+	assert.Equal(t, uint16(0), opts.FlagMask)
+}
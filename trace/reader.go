@@ -0,0 +1,299 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+type Reader struct {
+	next func() (*Record, error)
+}
+
+// NewReader reads either encoding. Next returns io.EOF after the last
+// record.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(binaryMagic))
+	if err == io.EOF && len(head) == 0 {
+		return &Reader{next: func() (*Record, error) { return nil, io.EOF }}, nil
+	}
+	if err == nil && string(head) == binaryMagic {
+		return newBinaryReader(br)
+	}
+	if len(head) > 0 && head[0] != '{' {
+		return nil, ErrFormat
+	}
+	dec := json.NewDecoder(br)
+	return &Reader{next: func() (rec *Record, err error) {
+		rec = new(Record)
+		if err = dec.Decode(rec); err != nil {
+			return nil, err
+		}
+		return
+	}}, nil
+}
+
+func (r *Reader) Next() (*Record, error) {
+	return r.next()
+}
+
+func ReadAll(r io.Reader) (recs []*Record, err error) {
+	tr, err := NewReader(r)
+	if err != nil {
+		return
+	}
+	for {
+		rec, err := tr.Next()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func ReadFile(file string) (recs []*Record, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return ReadAll(f)
+}
+
+type binaryReader struct {
+	r       *bufio.Reader
+	strings []string
+}
+
+func newBinaryReader(r *bufio.Reader) (*Reader, error) {
+	head := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if v := head[len(binaryMagic)]; v != BinaryVersion {
+		return nil, fmt.Errorf("unsupported trace version: %d", v)
+	}
+	b := &binaryReader{r: r}
+	return &Reader{next: b.record}, nil
+}
+
+// Once a record has started, running out of input is an error rather
+// than the end of the trace.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (b *binaryReader) word() (v uint16, err error) {
+	var bs [2]byte
+	if _, err = io.ReadFull(b.r, bs[:]); err != nil {
+		return 0, unexpected(err)
+	}
+	return uint16(bs[0]) | uint16(bs[1])<<8, nil
+}
+
+func (b *binaryReader) uvarint() (v uint64, err error) {
+	v, err = binary.ReadUvarint(b.r)
+	return v, unexpected(err)
+}
+
+func (b *binaryReader) varint() (v int64, err error) {
+	v, err = binary.ReadVarint(b.r)
+	return v, unexpected(err)
+}
+
+func (b *binaryReader) bytes() (bs []byte, err error) {
+	n, err := b.uvarint()
+	if err != nil {
+		return
+	}
+	bs = make([]byte, n)
+	if _, err = io.ReadFull(b.r, bs); err != nil {
+		return nil, unexpected(err)
+	}
+	return
+}
+
+func (b *binaryReader) string() (s string, err error) {
+	i, err := b.uvarint()
+	if err != nil {
+		return
+	}
+	if i == 0 {
+		bs, err := b.bytes()
+		if err != nil {
+			return "", err
+		}
+		b.strings = append(b.strings, string(bs))
+		return string(bs), nil
+	}
+	if i > uint64(len(b.strings)) {
+		return "", fmt.Errorf("trace string %d is not defined", i)
+	}
+	return b.strings[i-1], nil
+}
+
+func (b *binaryReader) args() (args []Arg, err error) {
+	n, err := b.uvarint()
+	if err != nil {
+		return
+	}
+	for i := uint64(0); i < n; i++ {
+		a := Arg{}
+		if a.Name, err = b.string(); err != nil {
+			return
+		}
+		if a.Value, err = b.varint(); err != nil {
+			return
+		}
+		text, err := b.bytes()
+		if err != nil {
+			return nil, err
+		}
+		a.Text = string(text)
+		args = append(args, a)
+	}
+	return
+}
+
+func (b *binaryReader) record() (r *Record, err error) {
+	tag, err := b.r.ReadByte()
+	if err != nil {
+		return
+	}
+	r = new(Record)
+	switch tag {
+	case tagInstruction:
+		r.Kind = Instruction
+	case tagSyscall:
+		r.Kind = Syscall
+	default:
+		return nil, fmt.Errorf("bad trace record tag %#02x", tag)
+	}
+	if r.Count, err = b.uvarint(); err != nil {
+		return
+	}
+	if r.CS, err = b.word(); err != nil {
+		return
+	}
+	if r.IP, err = b.word(); err != nil {
+		return
+	}
+	if r.Kind == Syscall {
+		err = b.syscall(r)
+	} else {
+		err = b.instruction(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (b *binaryReader) syscall(r *Record) (err error) {
+	s := new(SyscallInfo)
+	r.Syscall = s
+	call, err := b.varint()
+	if err != nil {
+		return
+	}
+	s.Call = int(call)
+	if s.Name, err = b.string(); err != nil {
+		return
+	}
+	if s.Args, err = b.args(); err != nil {
+		return
+	}
+	if s.Out, err = b.args(); err != nil {
+		return
+	}
+	done, err := b.r.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+	if done != 0 {
+		v, err := b.varint()
+		if err != nil {
+			return err
+		}
+		result := int(v)
+		s.Result = &result
+	}
+	s.Err, err = b.string()
+	return
+}
+
+func (b *binaryReader) instruction(r *Record) (err error) {
+	if r.Bytes, err = b.bytes(); err != nil {
+		return
+	}
+	if r.Mnemonic, err = b.string(); err != nil {
+		return
+	}
+	n, err := b.uvarint()
+	if err != nil {
+		return
+	}
+	for i := uint64(0); i < n; i++ {
+		o, err := b.string()
+		if err != nil {
+			return err
+		}
+		r.Operands = append(r.Operands, o)
+	}
+	mask, err := b.word()
+	if err != nil {
+		return
+	}
+	for i, name := range Registers {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		v, err := b.word()
+		if err != nil {
+			return err
+		}
+		if r.Regs == nil {
+			r.Regs = make(map[string]uint16)
+		}
+		r.Regs[name] = v
+	}
+	if mask&(1<<uint(len(Registers))) != 0 {
+		flags, err := b.word()
+		if err != nil {
+			return err
+		}
+		r.Flags = &flags
+	}
+	if n, err = b.uvarint(); err != nil {
+		return
+	}
+	for i := uint64(0); i < n; i++ {
+		flags, err := b.r.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		a := Access{Size: int(flags & 0x7f), Write: flags&0x80 != 0}
+		if a.Segment, err = b.word(); err != nil {
+			return err
+		}
+		if a.Offset, err = b.word(); err != nil {
+			return err
+		}
+		if a.Value, err = b.word(); err != nil {
+			return err
+		}
+		a.Address = ((uint32(a.Segment) << 4) + uint32(a.Offset)) % 0x100000
+		r.Mem = append(r.Mem, a)
+	}
+	return
+}
@@ -0,0 +1,146 @@
+// Package trace reads and writes the structured execution traces of
+// go8086. A trace is a sequence of records, one per executed instruction
+// and one per MINIX syscall, in either of two encodings:
+//
+// JSON Lines: one JSON object per line, meant for scripts (jq, python).
+//
+// Binary: the magic "GO8086TR", a version byte and then tagged records
+// using varints and a string table, roughly a tenth of the JSON size.
+//
+// NewReader detects the encoding by itself.
+package trace
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type Kind string
+
+const (
+	Instruction Kind = "insn"
+	Syscall     Kind = "syscall"
+)
+
+// Registers lists the names Record.Regs may use, in encoding order.
+var Registers = []string{"ax", "cx", "dx", "bx", "sp", "bp", "si", "di", "cs", "ss", "ds", "es"}
+
+var ErrFormat = errors.New("not a go8086 trace")
+
+// Hex is raw bytes, written as a hex string in JSON.
+type Hex []byte
+
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *Hex) UnmarshalJSON(data []byte) (err error) {
+	s := ""
+	if err = json.Unmarshal(data, &s); err != nil {
+		return
+	}
+	*h, err = hex.DecodeString(s)
+	return
+}
+
+// Access is one memory access of an instruction. Size is 1 or 2 bytes.
+type Access struct {
+	Address uint32
+	Segment uint16
+	Offset  uint16
+	Size    int
+	Value   uint16
+	Write   bool `json:",omitempty"`
+}
+
+// Arg is a decoded syscall argument. Value holds numbers and pointers,
+// Text the string or data a pointer refers to.
+type Arg struct {
+	Name  string
+	Value int64
+	Text  string `json:",omitempty"`
+}
+
+// SyscallInfo describes one MINIX syscall. Result is nil when the call
+// never returned, like exit.
+type SyscallInfo struct {
+	Call   int
+	Name   string
+	Args   []Arg  `json:",omitempty"`
+	Out    []Arg  `json:",omitempty"`
+	Result *int   `json:",omitempty"`
+	Err    string `json:",omitempty"`
+}
+
+// Record is one trace entry. CS:IP is the instruction, or the int 0x20
+// that made the syscall. Regs and Flags only hold what the instruction
+// changed; Flags is nil when they did not change.
+type Record struct {
+	Kind     Kind
+	Count    uint64
+	CS       uint16
+	IP       uint16
+	Bytes    Hex               `json:",omitempty"`
+	Mnemonic string            `json:",omitempty"`
+	Operands []string          `json:",omitempty"`
+	Regs     map[string]uint16 `json:",omitempty"`
+	Flags    *uint16           `json:",omitempty"`
+	Mem      []Access          `json:",omitempty"`
+	Syscall  *SyscallInfo      `json:",omitempty"`
+}
+
+// Asm joins Mnemonic and Operands back into disassembly.
+func (r *Record) Asm() string {
+	s := r.Mnemonic
+	for i, o := range r.Operands {
+		if i == 0 {
+			s += " " + o
+		} else {
+			s += "," + o
+		}
+	}
+	return s
+}
+
+func (r *Record) String() string {
+	switch r.Kind {
+	case Syscall:
+		s := fmt.Sprintf("%d %04x:%04x %s(", r.Count, r.CS, r.IP, r.Syscall.Name)
+		for i, a := range r.Syscall.Args {
+			if i > 0 {
+				s += ", "
+			}
+			s += a.String()
+		}
+		s += ") = "
+		if r.Syscall.Result == nil {
+			s += "?"
+		} else {
+			s += fmt.Sprintf("%d", *r.Syscall.Result)
+		}
+		if r.Syscall.Err != "" {
+			s += " (" + r.Syscall.Err + ")"
+		}
+		return s
+	default:
+		return fmt.Sprintf("%d %04x:%04x %s", r.Count, r.CS, r.IP, r.Asm())
+	}
+}
+
+func (a Arg) String() string {
+	if a.Text != "" {
+		return fmt.Sprintf("%s=%q", a.Name, a.Text)
+	}
+	return fmt.Sprintf("%s=%d", a.Name, a.Value)
+}
+
+func registerIndex(name string) int {
+	for i, r := range Registers {
+		if r == name {
+			return i
+		}
+	}
+	return -1
+}
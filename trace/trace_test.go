@@ -0,0 +1,100 @@
+package trace
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func testRecords() []*Record {
+	flags := uint16(0x0046)
+	result := 5
+	return []*Record{
+		{
+			Kind: Instruction, Count: 0, CS: 0x1000, IP: 0x0000,
+			Bytes: Hex{0xb8, 0x34, 0x12}, Mnemonic: "mov", Operands: []string{"ax", "0x1234"},
+			Regs: map[string]uint16{"ax": 0x1234},
+		},
+		{
+			Kind: Instruction, Count: 1, CS: 0x1000, IP: 0x0003,
+			Bytes: Hex{0x31, 0xc0}, Mnemonic: "xor", Operands: []string{"ax", "ax"},
+			Regs: map[string]uint16{"ax": 0}, Flags: &flags,
+		},
+		{
+			Kind: Instruction, Count: 2, CS: 0x1000, IP: 0x0005,
+			Bytes: Hex{0xf3, 0xa4}, Mnemonic: "rep movsb",
+			Regs: map[string]uint16{"cx": 0, "si": 0x12, "di": 0x22},
+			Mem: []Access{
+				{Address: 0x20010, Segment: 0x2000, Offset: 0x10, Size: 1, Value: 0x41},
+				{Address: 0x30020, Segment: 0x3000, Offset: 0x20, Size: 1, Value: 0x41, Write: true},
+			},
+		},
+		{
+			Kind: Syscall, Count: 3, CS: 0x1000, IP: 0x0007,
+			Syscall: &SyscallInfo{Call: 4, Name: "write", Args: []Arg{
+				{Name: "fd", Value: 1}, {Name: "buffer", Value: 0x100, Text: "hello"}, {Name: "nbytes", Value: 5},
+			}, Result: &result},
+		},
+		{
+			Kind: Syscall, Count: 4, CS: 0x1000, IP: 0x0009,
+			Syscall: &SyscallInfo{Call: 6, Name: "close", Args: []Arg{{Name: "fd", Value: 9}}, Result: &result, Err: "bad file descriptor"},
+		},
+		{
+			Kind: Syscall, Count: 5, CS: 0x1000, IP: 0x000b,
+			Syscall: &SyscallInfo{Call: 1, Name: "exit", Args: []Arg{{Name: "status", Value: 0}}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "binary"} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, format)
+		assert.Nil(t, err)
+		for _, r := range testRecords() {
+			assert.Nil(t, w.Write(r))
+		}
+		assert.Nil(t, w.Flush())
+		recs, err := ReadAll(buf)
+		assert.Nil(t, err, format)
+		assert.Equal(t, testRecords(), recs, format)
+	}
+}
+
+func TestJSONIsScriptable(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewJSONWriter(buf)
+	assert.Nil(t, w.Write(testRecords()[0]))
+	assert.Nil(t, w.Flush())
+	assert.Equal(t, `{"Kind":"insn","Count":0,"CS":4096,"IP":0,"Bytes":"b83412","Mnemonic":"mov","Operands":["ax","0x1234"],"Regs":{"ax":4660}}`+"\n", buf.String())
+}
+
+func TestString(t *testing.T) {
+	recs := testRecords()
+	assert.Equal(t, "2 1000:0005 rep movsb", recs[2].String())
+	assert.Equal(t, `3 1000:0007 write(fd=1, buffer="hello", nbytes=5) = 5`, recs[3].String())
+	assert.Equal(t, "4 1000:0009 close(fd=9) = 5 (bad file descriptor)", recs[4].String())
+	assert.Equal(t, "5 1000:000b exit(status=0) = ?", recs[5].String())
+}
+
+func TestReaderErrors(t *testing.T) {
+	_, err := NewReader(bytes.NewBufferString("not a trace"))
+	assert.Equal(t, ErrFormat, err)
+
+	recs, err := ReadAll(new(bytes.Buffer))
+	assert.Nil(t, err)
+	assert.Nil(t, recs)
+
+	buf := new(bytes.Buffer)
+	w := NewBinaryWriter(buf)
+	assert.Nil(t, w.Write(testRecords()[2]))
+	assert.Nil(t, w.Flush())
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	assert.Nil(t, err)
+	_, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = NewWriter(buf, "xml")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,196 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const binaryMagic = "GO8086TR"
+const BinaryVersion = 1
+
+const (
+	tagInstruction = 'I'
+	tagSyscall     = 'S'
+)
+
+type Writer interface {
+	Write(r *Record) error
+	Flush() error
+}
+
+type jsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func NewJSONWriter(w io.Writer) Writer {
+	bw := bufio.NewWriter(w)
+	return &jsonWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (j *jsonWriter) Write(r *Record) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonWriter) Flush() error {
+	return j.w.Flush()
+}
+
+type binaryWriter struct {
+	w       *bufio.Writer
+	strings map[string]uint64
+	header  bool
+	buf     [binary.MaxVarintLen64]byte
+	err     error
+}
+
+func NewBinaryWriter(w io.Writer) Writer {
+	return &binaryWriter{w: bufio.NewWriter(w), strings: make(map[string]uint64)}
+}
+
+// NewWriter returns the writer for format "json" or "binary".
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case "json", "jsonl", "":
+		return NewJSONWriter(w), nil
+	case "binary", "bin":
+		return NewBinaryWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown trace format %q", format)
+}
+
+func (b *binaryWriter) byte(c byte) {
+	if b.err == nil {
+		b.err = b.w.WriteByte(c)
+	}
+}
+
+func (b *binaryWriter) bytes(bs []byte) {
+	b.uvarint(uint64(len(bs)))
+	if b.err == nil {
+		_, b.err = b.w.Write(bs)
+	}
+}
+
+func (b *binaryWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(b.buf[:], v)
+	if b.err == nil {
+		_, b.err = b.w.Write(b.buf[:n])
+	}
+}
+
+func (b *binaryWriter) varint(v int64) {
+	n := binary.PutVarint(b.buf[:], v)
+	if b.err == nil {
+		_, b.err = b.w.Write(b.buf[:n])
+	}
+}
+
+func (b *binaryWriter) word(v uint16) {
+	b.byte(byte(v))
+	b.byte(byte(v >> 8))
+}
+
+// Strings repeat a lot (mnemonics, operands, syscall names), so each one
+// is written once and referred to by index afterwards. Index 0 introduces
+// a new string.
+func (b *binaryWriter) string(s string) {
+	if i, ok := b.strings[s]; ok {
+		b.uvarint(i)
+		return
+	}
+	b.strings[s] = uint64(len(b.strings) + 1)
+	b.uvarint(0)
+	b.bytes([]byte(s))
+}
+
+func (b *binaryWriter) args(args []Arg) {
+	b.uvarint(uint64(len(args)))
+	for _, a := range args {
+		b.string(a.Name)
+		b.varint(a.Value)
+		b.bytes([]byte(a.Text))
+	}
+}
+
+func (b *binaryWriter) Write(r *Record) error {
+	if !b.header {
+		b.header = true
+		b.w.WriteString(binaryMagic)
+		b.byte(BinaryVersion)
+	}
+	switch r.Kind {
+	case Instruction:
+		b.byte(tagInstruction)
+	case Syscall:
+		b.byte(tagSyscall)
+	default:
+		return fmt.Errorf("unknown trace record kind %q", r.Kind)
+	}
+	b.uvarint(r.Count)
+	b.word(r.CS)
+	b.word(r.IP)
+	if r.Kind == Syscall {
+		s := r.Syscall
+		b.varint(int64(s.Call))
+		b.string(s.Name)
+		b.args(s.Args)
+		b.args(s.Out)
+		if s.Result == nil {
+			b.byte(0)
+		} else {
+			b.byte(1)
+			b.varint(int64(*s.Result))
+		}
+		b.string(s.Err)
+		return b.err
+	}
+	b.bytes(r.Bytes)
+	b.string(r.Mnemonic)
+	b.uvarint(uint64(len(r.Operands)))
+	for _, o := range r.Operands {
+		b.string(o)
+	}
+	mask := uint16(0)
+	for name := range r.Regs {
+		i := registerIndex(name)
+		if i < 0 {
+			return fmt.Errorf("unknown register %q in trace record", name)
+		}
+		mask |= 1 << uint(i)
+	}
+	if r.Flags != nil {
+		mask |= 1 << uint(len(Registers))
+	}
+	b.word(mask)
+	for _, name := range Registers {
+		if v, ok := r.Regs[name]; ok {
+			b.word(v)
+		}
+	}
+	if r.Flags != nil {
+		b.word(*r.Flags)
+	}
+	b.uvarint(uint64(len(r.Mem)))
+	for _, a := range r.Mem {
+		flags := byte(a.Size)
+		if a.Write {
+			flags |= 0x80
+		}
+		b.byte(flags)
+		b.word(a.Segment)
+		b.word(a.Offset)
+		b.word(a.Value)
+	}
+	return b.err
+}
+
+func (b *binaryWriter) Flush() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.w.Flush()
+}
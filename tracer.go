@@ -0,0 +1,217 @@
+package go8086
+
+import (
+	"github.com/riywo/go8086/trace"
+	"os"
+	"strings"
+)
+
+// TraceStringMax caps the data of read and write recorded in a trace.
+var TraceStringMax = 256
+
+// Tracer writes a structured trace record for every instruction and every
+// MINIX syscall. A syscall is recorded right after the int 0x20 that made
+// it.
+type Tracer struct {
+	w       trace.Writer
+	vm      *VM
+	ids     []HookID
+	rec     *trace.Record
+	regs    map[string]uint16
+	flags   uint16
+	syscall *trace.Record
+	file    string
+	format  string
+	closer  func() error
+}
+
+func NewTracer(w trace.Writer) *Tracer {
+	return &Tracer{w: w}
+}
+
+func TraceFile(file, format string) (t *Tracer, err error) {
+	t = &Tracer{format: format}
+	err = t.reopen(file)
+	return
+}
+
+// A forked child continues in a file of its own named after its MINIX pid.
+func (t *Tracer) reopen(file string) (err error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	w, err := trace.NewWriter(f, t.format)
+	if err != nil {
+		f.Close()
+		return
+	}
+	if t.closer != nil {
+		t.closer()
+	}
+	t.w, t.file, t.closer = w, file, f.Close
+	return
+}
+
+func (t *Tracer) Attach(vm *VM) {
+	t.vm = vm
+	t.ids = []HookID{
+		vm.AddBeforeInstructionHook(t.before),
+		vm.AddMemoryHook(t.memory),
+		vm.AddSyscallHook(t.hook),
+		vm.AddAfterInstructionHook(t.after),
+	}
+}
+
+func (t *Tracer) Detach() {
+	for _, id := range t.ids {
+		t.vm.RemoveHook(id)
+	}
+	t.ids = nil
+}
+
+func (t *Tracer) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *Tracer) Close() (err error) {
+	err = t.Flush()
+	if t.closer != nil {
+		if cerr := t.closer(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+func (t *Tracer) registers() map[string]uint16 {
+	regs := make(map[string]uint16)
+	for k, v := range t.vm.reg {
+		regs[k] = v
+	}
+	for k, v := range t.vm.sreg {
+		regs[k] = v
+	}
+	return regs
+}
+
+// splitAsm separates the mnemonic, including prefixes such as rep or a
+// segment override, from the operands.
+func splitAsm(op *Opcode) (mnemonic string, operands []string) {
+	last := op
+	for last.following != nil {
+		last = last.following
+	}
+	asm := op.Disasm()
+	fields := strings.Fields(asm)
+	for i, f := range fields {
+		if f == last.mn.String() {
+			mnemonic = strings.Join(fields[:i+1], " ")
+			if rest := strings.Join(fields[i+1:], " "); rest != "" {
+				operands = strings.Split(rest, ",")
+			}
+			return
+		}
+	}
+	return asm, nil
+}
+
+func (t *Tracer) before(vm *VM, op *Opcode) error {
+	t.rec = &trace.Record{
+		Kind:  trace.Instruction,
+		Count: vm.count,
+		CS:    vm.sreg["cs"],
+		IP:    vm.ip,
+		Bytes: trace.Hex(append(Bytes{}, op.bytes...)),
+	}
+	t.rec.Mnemonic, t.rec.Operands = splitAsm(op)
+	t.regs, t.flags = t.registers(), vm.flag
+	return nil
+}
+
+func (t *Tracer) memory(vm *VM, access *MemoryAccess) error {
+	if t.rec == nil {
+		return nil
+	}
+	size := 1
+	if access.W == Bit16 {
+		size = 2
+	}
+	t.rec.Mem = append(t.rec.Mem, trace.Access{
+		Address: access.Address,
+		Segment: access.Segment,
+		Offset:  access.Offset,
+		Size:    size,
+		Value:   access.Value,
+		Write:   access.Write,
+	})
+	return nil
+}
+
+func (t *Tracer) hook(vm *VM, ev *SyscallEvent) (err error) {
+	if !ev.Done {
+		t.syscall = &trace.Record{
+			Kind:  trace.Syscall,
+			Count: vm.count,
+			CS:    vm.sreg["cs"],
+			IP:    vm.ip,
+			Syscall: &trace.SyscallInfo{
+				Call: int(ev.Call),
+				Name: ev.Call.String(),
+				Args: SyscallArgs(vm, ev.Call, ev.Message),
+			},
+		}
+		if t.rec != nil {
+			t.syscall.Count, t.syscall.IP = t.rec.Count, t.rec.IP
+		}
+		if ev.Call == MINIX_fork {
+			return t.Flush()
+		}
+		return
+	}
+	if t.syscall == nil {
+		return
+	}
+	s := t.syscall.Syscall
+	result := ev.Result
+	s.Result = &result
+	if ev.Err != nil {
+		s.Err = ev.Err.Error()
+	} else {
+		s.Out = SyscallOutputs(vm, ev.Call, ev.Message, ev.Result)
+	}
+	if ev.Call == MINIX_fork && ev.Result == 0 && t.file != "" {
+		err = t.reopen(childFile(t.file))
+	}
+	return
+}
+
+func (t *Tracer) after(vm *VM, op *Opcode) error {
+	return t.emit()
+}
+
+func (t *Tracer) emit() (err error) {
+	if rec := t.rec; rec != nil {
+		t.rec = nil
+		for k, v := range t.registers() {
+			if t.regs[k] != v {
+				if rec.Regs == nil {
+					rec.Regs = make(map[string]uint16)
+				}
+				rec.Regs[k] = v
+			}
+		}
+		if t.vm.flag != t.flags {
+			flags := t.vm.flag
+			rec.Flags = &flags
+		}
+		if err = t.w.Write(rec); err != nil {
+			return
+		}
+	}
+	if rec := t.syscall; rec != nil {
+		t.syscall = nil
+		err = t.w.Write(rec)
+	}
+	return
+}
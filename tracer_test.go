@@ -0,0 +1,92 @@
+package go8086
+
+import (
+	"bytes"
+	"github.com/riywo/go8086/trace"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var tracerTestCode = Bytes{
+	0xb8, 0x34, 0x12, // mov ax,0x1234
+	0xa3, 0x10, 0x00, // mov [0x10],ax
+	0xbb, 0x00, 0x01, // mov bx,0x100
+	0xcd, 0x20, // int 0x20
+}
+
+func traceTestRun(t *testing.T, w trace.Writer) {
+	vm := newTestVM(tracerTestCode)
+	vm.SS(0x102).Write16(uint16(MINIX_brk))
+	vm.SS(0x10a).Write16(0x0800)
+	tracer := NewTracer(w)
+	tracer.Attach(vm)
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, vm.Step())
+	}
+	assert.Nil(t, tracer.Flush())
+}
+
+func TestTracer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	traceTestRun(t, trace.NewJSONWriter(buf))
+	recs, err := trace.ReadAll(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(recs))
+
+	assert.Equal(t, trace.Instruction, recs[0].Kind)
+	assert.Equal(t, uint64(0), recs[0].Count)
+	assert.Equal(t, uint16(0x1000), recs[0].CS)
+	assert.Equal(t, trace.Hex{0xb8, 0x34, 0x12}, recs[0].Bytes)
+	assert.Equal(t, "mov", recs[0].Mnemonic)
+	assert.Equal(t, []string{"ax", "0x1234"}, recs[0].Operands)
+	assert.Equal(t, map[string]uint16{"ax": 0x1234}, recs[0].Regs)
+	assert.Nil(t, recs[0].Flags)
+
+	assert.Equal(t, "mov [0x10],ax", recs[1].Asm())
+	assert.Nil(t, recs[1].Regs)
+	assert.Equal(t, []trace.Access{{Address: 0x10, Offset: 0x10, Size: 2, Value: 0x1234, Write: true}}, recs[1].Mem)
+
+	assert.Equal(t, "int 0x20", recs[3].Asm())
+	assert.Equal(t, map[string]uint16{"ax": 0}, recs[3].Regs)
+
+	sys := recs[4]
+	assert.Equal(t, trace.Syscall, sys.Kind)
+	assert.Equal(t, uint16(0x0009), sys.IP)
+	assert.Equal(t, uint64(3), sys.Count)
+	assert.Equal(t, "brk", sys.Syscall.Name)
+	assert.Equal(t, []trace.Arg{{Name: "addr", Value: 0x800}}, sys.Syscall.Args)
+	assert.Equal(t, []trace.Arg{{Name: "addr", Value: 0x800}}, sys.Syscall.Out)
+	assert.Equal(t, 0, *sys.Syscall.Result)
+	assert.Equal(t, "3 1000:0009 brk(addr=2048) = 0", sys.String())
+}
+
+func TestTracerBinary(t *testing.T) {
+	jsonBuf, binBuf := new(bytes.Buffer), new(bytes.Buffer)
+	traceTestRun(t, trace.NewJSONWriter(jsonBuf))
+	traceTestRun(t, trace.NewBinaryWriter(binBuf))
+	assert.True(t, binBuf.Len() < jsonBuf.Len())
+
+	fromJSON, err := trace.ReadAll(jsonBuf)
+	assert.Nil(t, err)
+	fromBinary, err := trace.ReadAll(binBuf)
+	assert.Nil(t, err)
+	assert.Equal(t, fromJSON, fromBinary)
+}
+
+func TestSplitAsm(t *testing.T) {
+	cases := []struct {
+		code     Bytes
+		mnemonic string
+		operands []string
+	}{
+		{Bytes{0xf3, 0xa4}, "rep movsb", nil},
+		{Bytes{0x26, 0x8b, 0x07}, "mov", []string{"ax", "[es:bx]"}},
+		{Bytes{0xc6, 0x07, 0x01}, "mov", []string{"byte [bx]", "0x1"}},
+		{Bytes{0x90}, "nop", nil},
+	}
+	for _, c := range cases {
+		mnemonic, operands := splitAsm(getOpcode(nil, 0, c.code))
+		assert.Equal(t, c.mnemonic, mnemonic)
+		assert.Equal(t, c.operands, operands)
+	}
+}
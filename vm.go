@@ -31,12 +31,17 @@ func (f Flag) String() string {
 }
 
 type VM struct {
-	reg    map[string]uint16
-	sreg   map[string]uint16
-	ip     uint16
-	flag   uint16
-	mem    Bytes
-	initSP uint16 //temporary
+	reg     map[string]uint16
+	sreg    map[string]uint16
+	ip      uint16
+	flag    uint16
+	mem     Bytes
+	initSP  uint16 //temporary
+	count   uint64
+	minix   MinixState
+	hooks   *hooks
+	stopErr error
+	symbols *SymbolTable
 }
 
 func NewVM() (vm *VM) {
@@ -92,11 +97,11 @@ func (vm *VM) ES(offset uint16) Bytes {
 
 func (vm *VM) Push(value uint16) {
 	vm.reg["sp"] -= 2
-	vm.SS(vm.reg["sp"]).write16(value)
+	vm.writeMem(SS, vm.reg["sp"], Bit16, value)
 }
 
 func (vm *VM) Pop() (value uint16) {
-	value = vm.SS(vm.reg["sp"]).read16()
+	value = vm.readMem(SS, vm.reg["sp"], Bit16)
 	vm.reg["sp"] += 2
 	return
 }
@@ -122,14 +127,183 @@ func (vm *VM) SetFlag(f Flag, condition bool) {
 }
 
 func (vm *VM) getOpcode() (op *Opcode) {
-	return getOpcode(nil, vm.ip, vm.CS(vm.ip))
+	return getOpcode(nil, vm.ip, vm.CS(vm.ip)).WithSymbols(vm.symbols)
 }
 
-func (vm *VM) Run() {
+func (vm *VM) OpcodeAt(seg, offset uint16) *Opcode {
+	return getOpcode(nil, offset, vm.ReadMem(seg, offset, 8)).WithSymbols(vm.symbols)
+}
+
+func (vm *VM) Symbols() *SymbolTable {
+	return vm.symbols
+}
+
+func (vm *VM) SetSymbols(t *SymbolTable) {
+	vm.symbols = t
+}
+
+func (vm *VM) Step() (err error) {
+	op := vm.getOpcode()
+	if vm.hooks != nil {
+		vm.runBeforeHooks(op)
+		if err = vm.takeStop(); err != nil {
+			return
+		}
+	}
+	cs, ip := vm.sreg["cs"], vm.ip
+	vm.ip += uint16(len(op.bytes))
+	vm.count++
+	op.Run(vm)
+	if f, ok := vm.stopErr.(*Fault); ok {
+		// A faulting instruction has not run, as on a real CPU.
+		f.CS, f.IP, vm.ip = cs, ip, ip
+		vm.count--
+		if f.Op == nil {
+			f.Op = op
+		}
+		return vm.takeStop()
+	}
+	if vm.hooks != nil {
+		vm.runAfterHooks(op)
+	}
+	return vm.takeStop()
+}
+
+func (vm *VM) takeStop() (err error) {
+	err, vm.stopErr = vm.stopErr, nil
+	return
+}
+
+func (vm *VM) Run() (err error) {
 	for {
-		op := vm.getOpcode()
-		vm.Debug(op)
-		vm.ip += uint16(len(op.bytes))
-		op.Run(vm)
+		if err = vm.Step(); err != nil {
+			return
+		}
+	}
+}
+
+func (vm *VM) Reg(r *Register) uint16 {
+	return r.Read(vm)
+}
+
+func (vm *VM) SetReg(r *Register, value uint16) {
+	r.Write(vm, value)
+}
+
+func (vm *VM) SReg(r *SegmentRegister) uint16 {
+	return r.Read(vm)
+}
+
+func (vm *VM) SetSReg(r *SegmentRegister, value uint16) {
+	r.Write(vm, value)
+}
+
+func (vm *VM) IP() uint16 {
+	return vm.ip
+}
+
+func (vm *VM) SetIP(ip uint16) {
+	vm.ip = ip
+}
+
+func (vm *VM) InstructionCount() uint64 {
+	return vm.count
+}
+
+func (vm *VM) Flags() uint16 {
+	return vm.flag
+}
+
+func (vm *VM) SetFlags(flags uint16) {
+	vm.flag = flags
+}
+
+const MemorySize = 0x100000
+
+func Physical(seg, offset uint16) uint32 {
+	return ((uint32(seg) << 4) + uint32(offset)) % MemorySize
+}
+
+func (vm *VM) ReadPhys8(addr uint32) uint8 {
+	return vm.mem[addr%MemorySize]
+}
+
+func (vm *VM) WritePhys8(addr uint32, value uint8) {
+	vm.mem[addr%MemorySize] = value
+}
+
+func (vm *VM) ReadPhys16(addr uint32) uint16 {
+	return uint16(vm.ReadPhys8(addr)) | uint16(vm.ReadPhys8(addr+1))<<8
+}
+
+func (vm *VM) WritePhys16(addr uint32, value uint16) {
+	vm.WritePhys8(addr, uint8(value))
+	vm.WritePhys8(addr+1, uint8(value>>8))
+}
+
+func (vm *VM) Read8(seg, offset uint16) uint8 {
+	return vm.ReadPhys8(Physical(seg, offset))
+}
+
+func (vm *VM) Write8(seg, offset uint16, value uint8) {
+	vm.WritePhys8(Physical(seg, offset), value)
+}
+
+// Words wrap inside the segment like the 8086 does: the high byte of a
+// word at offset 0xffff comes from offset 0x0000.
+func (vm *VM) Read16(seg, offset uint16) uint16 {
+	return uint16(vm.Read8(seg, offset)) | uint16(vm.Read8(seg, offset+1))<<8
+}
+
+func (vm *VM) Write16(seg, offset uint16, value uint16) {
+	vm.Write8(seg, offset, uint8(value))
+	vm.Write8(seg, offset+1, uint8(value>>8))
+}
+
+func (vm *VM) ReadMem(seg, offset uint16, n int) (bs Bytes) {
+	bs = make(Bytes, n)
+	for i := range bs {
+		bs[i] = vm.Read8(seg, offset+uint16(i))
 	}
+	return
+}
+
+func (vm *VM) WriteMem(seg, offset uint16, bs Bytes) {
+	for i, b := range bs {
+		vm.Write8(seg, offset+uint16(i), b)
+	}
+}
+
+func (vm *VM) ReadPhysMem(addr uint32, n int) (bs Bytes) {
+	bs = make(Bytes, n)
+	for i := range bs {
+		bs[i] = vm.ReadPhys8(addr + uint32(i))
+	}
+	return
+}
+
+func (vm *VM) WritePhysMem(addr uint32, bs Bytes) {
+	for i, b := range bs {
+		vm.WritePhys8(addr+uint32(i), b)
+	}
+}
+
+func (vm *VM) ReadString(seg, offset uint16) string {
+	s := Bytes{}
+	for i := uint16(0); ; i++ {
+		c := vm.Read8(seg, offset+i)
+		if c == 0x0 || i == 0xffff {
+			break
+		}
+		s = append(s, c)
+	}
+	return string(s)
+}
+
+func (vm *VM) WriteString(seg, offset uint16, s string) {
+	vm.WriteMem(seg, offset, append(Bytes(s), 0x0))
+}
+
+func (vm *VM) CurrentOpcode() *Opcode {
+	return vm.getOpcode()
 }
@@ -39,3 +39,43 @@ func TestVMClearFlag(t *testing.T) {
 	assert.Equal(t, 0, vm.GetFlag(CF))
 	assert.Equal(t, 0x0000, vm.flag)
 }
+
+func TestVMRegisterAPI(t *testing.T) {
+	vm := NewVM()
+	vm.SetReg(AX, 0x1234)
+	assert.Equal(t, uint16(0x1234), vm.Reg(AX))
+	assert.Equal(t, uint16(0x12), vm.Reg(AH))
+	vm.SetReg(RegisterByName("bl"), 0xff)
+	assert.Equal(t, uint16(0x00ff), vm.Reg(BX))
+	vm.SetSReg(SegmentRegisterByName("ds"), 0x2000)
+	assert.Equal(t, uint16(0x2000), vm.SReg(DS))
+	vm.SetIP(0x0100)
+	assert.Equal(t, uint16(0x0100), vm.IP())
+	vm.SetFlags(0x0401)
+	assert.Equal(t, uint16(1), vm.GetFlag(OF))
+	assert.Equal(t, uint16(0x0401), vm.Flags())
+	assert.Nil(t, RegisterByName("ip"))
+}
+
+func TestVMMemoryAPI(t *testing.T) {
+	vm := NewVM()
+	vm.Write16(0x1000, 0x0010, 0xbeef)
+	assert.Equal(t, uint8(0xef), vm.ReadPhys8(0x10010))
+	assert.Equal(t, uint16(0xbeef), vm.ReadPhys16(0x10010))
+	assert.Equal(t, uint16(0xbeef), vm.Read16(0x1001, 0x0000))
+	vm.Write16(0x1000, 0xffff, 0x1234)
+	assert.Equal(t, uint8(0x34), vm.Read8(0x1000, 0xffff))
+	assert.Equal(t, uint8(0x12), vm.Read8(0x1000, 0x0000))
+	vm.WritePhys16(0xfffff, 0x5678)
+	assert.Equal(t, uint8(0x56), vm.ReadPhys8(0x00000))
+	vm.WriteMem(0x2000, 0x0000, Bytes{1, 2, 3})
+	assert.Equal(t, Bytes{1, 2, 3}, vm.ReadPhysMem(0x20000, 3))
+}
+
+func TestVMStringAPI(t *testing.T) {
+	vm := NewVM()
+	vm.WriteString(0x0000, 0x0100, "hello")
+	assert.Equal(t, "hello", vm.ReadString(0x0000, 0x0100))
+	assert.Equal(t, uint8(0), vm.Read8(0x0000, 0x0105))
+	assert.Equal(t, "llo", vm.ReadString(0x0010, 0x0002))
+}
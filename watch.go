@@ -0,0 +1,155 @@
+package go8086
+
+import (
+	"fmt"
+)
+
+type WatchKind int
+
+const (
+	WatchChange WatchKind = iota
+	WatchRead
+	WatchWrite
+	WatchAccess
+)
+
+var watchKindString = map[WatchKind]string{
+	WatchChange: "change",
+	WatchRead:   "read",
+	WatchWrite:  "write",
+	WatchAccess: "access",
+}
+
+func (k WatchKind) String() string {
+	return watchKindString[k]
+}
+
+type Watchpoint struct {
+	ID      int
+	Address uint32
+	Len     int
+	Kind    WatchKind
+	old     Bytes
+}
+
+func (w *Watchpoint) contains(addr uint32) bool {
+	return (addr-w.Address)%MemorySize < uint32(w.Len)
+}
+
+func (w *Watchpoint) String() string {
+	return fmt.Sprintf("%-6s %05x-%05x", w.Kind, w.Address, (w.Address+uint32(w.Len)-1)%MemorySize)
+}
+
+// WatchHit is one triggered watchpoint. Access is nil for value changes,
+// which may also come from syscalls writing guest memory directly.
+type WatchHit struct {
+	Watchpoint *Watchpoint
+	Access     *MemoryAccess
+	Old        Bytes
+	New        Bytes
+}
+
+func (h *WatchHit) String() string {
+	w := h.Watchpoint
+	if h.Access == nil {
+		return fmt.Sprintf("Watchpoint %d: %05x changed %02x -> %02x", w.ID, w.Address, []byte(h.Old), []byte(h.New))
+	}
+	a := h.Access
+	op := "read"
+	if a.Write {
+		op = "write"
+	}
+	value := fmt.Sprintf("%02x", a.Value)
+	if a.W == Bit16 {
+		value = fmt.Sprintf("%04x", a.Value)
+	}
+	return fmt.Sprintf("Watchpoint %d: %s %04x:%04x (%05x) = %s", w.ID, op, a.Segment, a.Offset, a.Address, value)
+}
+
+// Watcher checks watchpoints on every memory access of the VM. Hits are
+// collected while an instruction runs and handed out by Check afterwards.
+type Watcher struct {
+	vm     *VM
+	points []*Watchpoint
+	hits   []*WatchHit
+	id     HookID
+}
+
+func NewWatcher(vm *VM) (w *Watcher) {
+	w = &Watcher{vm: vm}
+	w.id = vm.AddMemoryHook(w.memory)
+	return
+}
+
+func (w *Watcher) Detach() {
+	w.vm.RemoveHook(w.id)
+}
+
+func (w *Watcher) Add(wp *Watchpoint) {
+	if wp.Kind == WatchChange {
+		wp.old = w.vm.ReadPhysMem(wp.Address, wp.Len)
+	}
+	w.points = append(w.points, wp)
+}
+
+func (w *Watcher) Remove(id int) bool {
+	for i, wp := range w.points {
+		if wp.ID == id {
+			w.points = append(w.points[:i], w.points[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) Watchpoints() []*Watchpoint {
+	return w.points
+}
+
+func (w *Watcher) memory(vm *VM, access *MemoryAccess) error {
+	n := 1
+	if access.W == Bit16 {
+		n = 2
+	}
+	for _, wp := range w.points {
+		switch wp.Kind {
+		case WatchChange:
+			continue
+		case WatchRead:
+			if access.Write {
+				continue
+			}
+		case WatchWrite:
+			if !access.Write {
+				continue
+			}
+		}
+		for i := 0; i < n; i++ {
+			addr := access.Address
+			if i == 1 {
+				addr = Physical(access.Segment, access.Offset+1)
+			}
+			if wp.contains(addr) {
+				a := *access
+				w.hits = append(w.hits, &WatchHit{Watchpoint: wp, Access: &a})
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) Check() (hits []*WatchHit) {
+	hits, w.hits = w.hits, nil
+	for _, wp := range w.points {
+		if wp.Kind != WatchChange {
+			continue
+		}
+		cur := w.vm.ReadPhysMem(wp.Address, wp.Len)
+		if string(cur) != string(wp.old) {
+			hits = append(hits, &WatchHit{Watchpoint: wp, Old: wp.old, New: cur})
+			wp.old = cur
+		}
+	}
+	return
+}